@@ -0,0 +1,185 @@
+// Package client is a client of the http api of the dashboard, see the
+// handlers registered by cmd/dashboard.
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options of a Client, the zero value of a field is replaced by its default.
+type Options struct {
+	// Forward is the product name if the client talks to the fe, which
+	// forwards the requests to the dashboard of the product.
+	Forward string
+	// Timeout of every attempt of a request, default 30s.
+	Timeout time.Duration
+	// Retries is the number of retries of an idempotent request which fails
+	// on the network or by a gateway, default 2. A negative value disables
+	// the retries.
+	Retries int
+	// RetryInterval is the delay before the first retry, it's doubled for
+	// every next one, default 200ms.
+	RetryInterval time.Duration
+	// HTTPClient sends the requests, default a client without timeout.
+	HTTPClient *http.Client
+}
+
+type Client struct {
+	base    string
+	xauth   string
+	options Options
+}
+
+// NewClient returns a client of the dashboard at addr, either host:port or an
+// url. The requests are authenticated as the fe does for the product.
+func NewClient(addr, product string, options *Options) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	c := &Client{
+		base:  strings.TrimRight(addr, "/"),
+		xauth: XAuth(product),
+	}
+	if options != nil {
+		c.options = *options
+	}
+	if c.options.Timeout <= 0 {
+		c.options.Timeout = 30 * time.Second
+	}
+	if c.options.Retries == 0 {
+		c.options.Retries = 2
+	}
+	if c.options.RetryInterval <= 0 {
+		c.options.RetryInterval = 200 * time.Millisecond
+	}
+	if c.options.HTTPClient == nil {
+		c.options.HTTPClient = &http.Client{}
+	}
+	return c
+}
+
+// XAuth returns the token of the product in the path of the api routes.
+func XAuth(product string) string {
+	b := sha256.Sum256([]byte("Codis-XAuth-[" + product + "]"))
+	return fmt.Sprintf("%x", b[:])[:32]
+}
+
+// Error is returned for a response which isn't 200, Message is the error
+// reported by the dashboard.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// path joins the segments escaped.
+func path(segments ...string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(s))
+	}
+	return b.String()
+}
+
+// apiPath is the path of an api route, the xauth follows the prefix.
+func (c *Client) apiPath(prefix string, segments ...string) string {
+	return prefix + path(append([]string{c.xauth}, segments...)...)
+}
+
+// do sends the request, the GETs are retried. The response is decoded into
+// out unless it's nil.
+func (c *Client) do(ctx context.Context, method, p string, out interface{}) error {
+	data, err := c.doRaw(ctx, method, p)
+	if err != nil || out == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: decode response fail. err-[%s]", method, p, err.Error())
+	}
+	return nil
+}
+
+func (c *Client) doRaw(ctx context.Context, method, p string) ([]byte, error) {
+	retries := 0
+	if method == http.MethodGet && c.options.Retries > 0 {
+		retries = c.options.Retries
+	}
+	interval := c.options.RetryInterval
+	for i := 0; ; i++ {
+		data, retry, err := c.once(ctx, method, p)
+		if err == nil || !retry || i >= retries {
+			return data, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// once sends the request once, retry tells whether a failure is worth a
+// retry.
+func (c *Client) once(ctx context.Context, method, p string) ([]byte, bool, error) {
+	u := c.base + p
+	if c.options.Forward != "" {
+		u += "?forward=" + url.QueryEscape(c.options.Forward)
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	rsp, err := c.options.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, true, err
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		e := &Error{Method: method, Path: p, StatusCode: rsp.StatusCode}
+		// the handlers report the errors as json strings.
+		if json.Unmarshal(data, &e.Message) != nil {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		switch rsp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return nil, true, e
+		}
+		return nil, false, e
+	}
+	return data, false, nil
+}
+
+// text returns the text wrapped in the html page of handler.TextPreHtml.
+func text(data []byte) string {
+	s := string(data)
+	if i := strings.Index(s, "<pre>\n"); i >= 0 {
+		s = s[i+len("<pre>\n"):]
+		if j := strings.LastIndex(s, "\n\t</pre>"); j >= 0 {
+			s = s[:j]
+		}
+	}
+	return s
+}
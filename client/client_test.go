@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/handler"
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeService implements the methods the tests call, the others panic.
+type fakeService struct {
+	handler.V2Service
+	mutex  sync.Mutex
+	groups []*protocol.Group
+}
+
+func (s *fakeService) Stats() (*protocol.Stats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := &protocol.Stats{}
+	stats.Group.Models = s.groups
+	stats.Group.Stats = map[string]*protocol.RedisStats{
+		"127.0.0.1:9221": {Stats: map[string]string{"role": "master"}},
+		"127.0.0.1:9222": {Error: errors.New("dial fail"), UnixTime: 1},
+	}
+	return stats, nil
+}
+
+func (s *fakeService) StatsSnapshot() (*protocol.StatsSnapshot, error) {
+	stats, err := s.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.StatsSnapshot{Version: 1, Stats: stats}, nil
+}
+
+func (s *fakeService) CreateGroup(name string, rPort, wPort int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, g := range s.groups {
+		if g.Name == name {
+			return errors.New("group-[" + name + "] already exists")
+		}
+	}
+	s.groups = append(s.groups, &protocol.Group{Name: name, ProxyReadPort: rPort, ProxyWritePort: wPort})
+	return nil
+}
+
+func (s *fakeService) AddGroupServer(name, addr string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, g := range s.groups {
+		if g.Name == name {
+			g.Servers = append(g.Servers, &protocol.GroupServer{Addr: addr})
+			return nil
+		}
+	}
+	return errors.New("group-[" + name + "] not found")
+}
+
+func (s *fakeService) ResyncGroupAll() error {
+	return nil
+}
+
+func (s *fakeService) Job(id string) (*dao.Job, error) {
+	return &dao.Job{ID: id, Type: protocol.JobTypeResyncGroupAll, State: dao.JobFinished}, nil
+}
+
+func (s *fakeService) ServerInfo(addr string) ([]byte, error) {
+	return []byte("# Server\r\naddr:" + addr), nil
+}
+
+func (s *fakeService) DelSentinel(addr string, force bool) error {
+	if !force {
+		return errors.New("sentinel-[" + addr + "] can't be reset")
+	}
+	return nil
+}
+
+// newServer serves the handlers as cmd/dashboard does, the paths of the
+// requests are recorded.
+func newServer(t *testing.T, s handler.V2Service, paths chan<- string) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		select {
+		case paths <- ctx.Request.Method + " " + ctx.Request.URL.Path:
+		default:
+		}
+	})
+	apiRouter := r.Group("/api/topom")
+	handler.InitAggHandler(s, r, apiRouter)
+	handler.InitGroupHandler(s, apiRouter)
+	handler.InitSentinelHandler(s, apiRouter)
+	handler.InitGSLBHandler(s, apiRouter)
+	handler.InitTFHandler(s, apiRouter)
+	handler.InitJobHandler(s, apiRouter)
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient(t *testing.T) {
+	paths := make(chan string, 100)
+	ts := newServer(t, &fakeService{}, paths)
+	c := NewClient(ts.URL, "demo", nil)
+	ctx := context.Background()
+
+	if err := c.CreateGroup(ctx, "g1", 16001, 16002); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-paths; p != "PUT /api/topom/group/create/"+XAuth("demo")+"/g1/16001/16002" {
+		t.Fatalf("path = %s", p)
+	}
+	if err := c.AddGroupServer(ctx, "g1", "127.0.0.1:9221"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.CreateGroup(ctx, "g1", 16001, 16002)
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusInternalServerError || e.Message != "group-[g1] already exists" {
+		t.Fatalf("create existing group = %v", err)
+	}
+
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Group.Models) != 1 || len(stats.Group.Models[0].Servers) != 1 ||
+		stats.Group.Models[0].Servers[0].Addr != "127.0.0.1:9221" {
+		t.Fatalf("groups = %+v", stats.Group.Models)
+	}
+	if s := stats.Group.Stats["127.0.0.1:9221"]; s.Error != nil || s.Stats["role"] != "master" {
+		t.Fatalf("stats = %+v", s)
+	}
+	if s := stats.Group.Stats["127.0.0.1:9222"]; s.Error == nil || s.UnixTime != 1 {
+		t.Fatalf("stats of a failed server = %+v", s)
+	}
+
+	if err := c.ResyncGroupAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.WaitJob(ctx, "1", time.Millisecond)
+	if err != nil || job.ID != "1" || job.State != dao.JobFinished {
+		t.Fatalf("wait job = %+v, %v", job, err)
+	}
+
+	info, err := c.ServerInfo(ctx, "127.0.0.1:9221")
+	if err != nil || info != "# Server\r\naddr:127.0.0.1:9221" {
+		t.Fatalf("server info = %q, %v", info, err)
+	}
+
+	if err := c.DelSentinel(ctx, "127.0.0.1:26379", false); err == nil {
+		t.Fatal("del sentinel without force succeeded")
+	}
+	if err := c.DelSentinel(ctx, "127.0.0.1:26379", true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var failures, requests int32
+	ts := newServer(t, &fakeService{}, nil)
+	target, _ := url.Parse(ts.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	c := NewClient(flaky.URL, "demo", &Options{RetryInterval: time.Millisecond})
+	ctx := context.Background()
+
+	// a get is retried.
+	atomic.StoreInt32(&failures, 2)
+	if _, err := c.Stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("requests = %d, want 3", n)
+	}
+
+	// the retries are limited.
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 3)
+	var e *Error
+	if _, err := c.Stats(ctx); !errors.As(err, &e) || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("stats = %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("requests = %d, want 3", n)
+	}
+
+	// a put isn't.
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	if err := c.CreateGroup(ctx, "g1", 16001, 16002); err == nil {
+		t.Fatal("create group succeeded")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("requests = %d, want 1", n)
+	}
+
+	// nor is a canceled request.
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Stats(cctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("stats = %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+// The routes of handler.InitGroupHandler.
+
+func (c *Client) CreateGroup(ctx context.Context, groupName string, rPort, wPort int) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/create", groupName, strconv.Itoa(rPort), strconv.Itoa(wPort)), nil)
+}
+
+func (c *Client) RemoveGroup(ctx context.Context, groupName string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/remove", groupName), nil)
+}
+
+func (c *Client) ResyncGroup(ctx context.Context, groupName string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/resync", groupName), nil)
+}
+
+// ResyncGroupAll resyncs every group, the call lasts until the job is
+// finished.
+func (c *Client) ResyncGroupAll(ctx context.Context) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/resync-all"), nil)
+}
+
+func (c *Client) AddGroupServer(ctx context.Context, groupName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/add", groupName, addr), nil)
+}
+
+func (c *Client) DelGroupServer(ctx context.Context, groupName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/del", groupName, addr), nil)
+}
+
+func (c *Client) GroupPromoteServer(ctx context.Context, groupName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/promote", groupName, addr), nil)
+}
+
+// GroupForceFullSyncServer forces a full sync of the slave, the call lasts
+// until the job is finished.
+func (c *Client) GroupForceFullSyncServer(ctx context.Context, groupName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/group/force-full-sync", groupName, addr), nil)
+}
+
+// FailoverGroup fails the master of the group over through a sentinel, the
+// call lasts until the switch so the context must outlive the failover.
+func (c *Client) FailoverGroup(ctx context.Context, groupName string) (*protocol.Failover, error) {
+	var failover protocol.Failover
+	if err := c.do(ctx, "PUT", c.apiPath("/api/topom/group/failover", groupName), &failover); err != nil {
+		return nil, err
+	}
+	return &failover, nil
+}
+
+// ServerInfo returns the INFO of the server.
+func (c *Client) ServerInfo(ctx context.Context, addr string) (string, error) {
+	data, err := c.doRaw(ctx, "GET", "/api/topom/group/info"+path(addr))
+	if err != nil {
+		return "", err
+	}
+	return text(data), nil
+}
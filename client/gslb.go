@@ -0,0 +1,22 @@
+package client
+
+import "context"
+
+// The routes of handler.InitGSLBHandler.
+
+func (c *Client) AddGSLB(ctx context.Context, gslbName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/gslbs/add", gslbName, addr), nil)
+}
+
+func (c *Client) DelGSLB(ctx context.Context, gslbName, addr string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/gslbs/del", gslbName, addr), nil)
+}
+
+// GSLBMonitorInfo returns the status page of the gslb server.
+func (c *Client) GSLBMonitorInfo(ctx context.Context, addr string) (string, error) {
+	data, err := c.doRaw(ctx, "GET", "/api/topom/gslbs/info"+path(addr, "monitored"))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// The routes of handler.InitJobHandler.
+
+func (c *Client) Jobs(ctx context.Context) ([]*dao.Job, error) {
+	var jobs []*dao.Job
+	if err := c.do(ctx, "GET", c.apiPath("/api/topom/job/list"), &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (c *Client) Job(ctx context.Context, id string) (*dao.Job, error) {
+	var job dao.Job
+	if err := c.do(ctx, "GET", c.apiPath("/api/topom/job/info", id), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/job/cancel", id), nil)
+}
+
+// WaitJob polls the job every interval until it's finished.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*dao.Job, error) {
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Finished() {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
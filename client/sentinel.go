@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// The routes of handler.InitSentinelHandler.
+
+// AddSentinel adds the sentinel, force adds it even if it breaks the quorum
+// of a group.
+func (c *Client) AddSentinel(ctx context.Context, addr string, force bool) error {
+	f := "0"
+	if force {
+		f = "1"
+	}
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/sentinels/add", addr, f), nil)
+}
+
+// DelSentinel removes the sentinel, force removes it even if it can't be
+// reset or it breaks the quorum of a group.
+func (c *Client) DelSentinel(ctx context.Context, addr string, force bool) error {
+	f := "0"
+	if force {
+		f = "1"
+	}
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/sentinels/del", addr, f), nil)
+}
+
+// ResyncSentinels resyncs every sentinel, the call lasts until the job is
+// finished.
+func (c *Client) ResyncSentinels(ctx context.Context) error {
+	return c.do(ctx, "PUT", c.apiPath("/api/topom/sentinels/resync-all"), nil)
+}
+
+// VerifySentinels returns the drifted entries of every sentinel.
+func (c *Client) VerifySentinels(ctx context.Context) ([]*protocol.SentinelVerification, error) {
+	var verifications []*protocol.SentinelVerification
+	if err := c.do(ctx, "GET", "/api/topom/sentinels/verify", &verifications); err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
+
+// SentinelEvents returns the timeline of the sentinel events, the newest
+// first, of the group if it's set, limit caps the events unless it's 0.
+func (c *Client) SentinelEvents(ctx context.Context, groupName string, limit int) ([]*dao.SentinelEvent, error) {
+	query := url.Values{}
+	if groupName != "" {
+		query.Set("group", groupName)
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	p := "/api/topom/sentinels/events"
+	if len(query) != 0 {
+		p += "?" + query.Encode()
+	}
+	var events []*dao.SentinelEvent
+	if err := c.do(ctx, "GET", p, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RepairSentinels submits the job which repairs the drifted entries of every
+// sentinel.
+func (c *Client) RepairSentinels(ctx context.Context) (*dao.Job, error) {
+	var job dao.Job
+	if err := c.do(ctx, "PUT", c.apiPath("/api/topom/sentinels/repair"), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SentinelInfo returns the INFO of the sentinel.
+func (c *Client) SentinelInfo(ctx context.Context, addr string) (string, error) {
+	data, err := c.doRaw(ctx, "GET", "/api/topom/sentinels/info"+path(addr))
+	if err != nil {
+		return "", err
+	}
+	return text(data), nil
+}
+
+// SentinelMonitoredInfo returns the masters monitored by the sentinel and
+// their slaves, by the name of the master.
+func (c *Client) SentinelMonitoredInfo(ctx context.Context, addr string) (map[string]*protocol.SentinelGroup, error) {
+	var m map[string]*protocol.SentinelGroup
+	if err := c.do(ctx, "GET", "/api/topom/sentinels/info"+path(addr, "monitored"), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,14 @@
+package client
+
+import "context"
+
+// The routes of handler.InitTFHandler.
+
+// ViewTemplateFile returns the content of the template file.
+func (c *Client) ViewTemplateFile(ctx context.Context, fileName string) (string, error) {
+	data, err := c.doRaw(ctx, "GET", "/api/topom/tf/info"+path(fileName))
+	if err != nil {
+		return "", err
+	}
+	return text(data), nil
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/archive"
+)
+
+// The routes of handler.InitAggHandler.
+
+func (c *Client) Overview(ctx context.Context) (*protocol.Overview, error) {
+	var o protocol.Overview
+	if err := c.do(ctx, "GET", "/topom", &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Model returns the overview served at /topom/model.
+func (c *Client) Model(ctx context.Context) (*protocol.Overview, error) {
+	var o protocol.Overview
+	if err := c.do(ctx, "GET", "/topom/model", &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (c *Client) Stats(ctx context.Context) (*protocol.Stats, error) {
+	var s protocol.Stats
+	if err := c.do(ctx, "GET", c.apiPath("/api/topom/stats"), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *Client) Export(ctx context.Context) (*archive.Archive, error) {
+	var a archive.Archive
+	if err := c.do(ctx, "GET", c.apiPath("/api/topom/export"), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+// apiClient calls the v2 api of a dashboard.
+type apiClient struct {
+	base   string
+	client *http.Client
+}
+
+func newAPIClient(addr string, timeout time.Duration) *apiClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &apiClient{
+		base:   strings.TrimRight(addr, "/") + "/api/v2",
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func escape(s string) string {
+	return url.PathEscape(s)
+}
+
+// do sends in as the json body and decodes the response into out, both may
+// be nil. A failed request returns the error reported by the dashboard.
+func (c *apiClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode/100 != 2 {
+		var e protocol.Error
+		if json.Unmarshal(data, &e) == nil && e.Error != nil {
+			return fmt.Errorf("%s: %s", e.Error.Code, e.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, rsp.Status)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// get returns the raw json body.
+func (c *apiClient) get(path string) ([]byte, error) {
+	var data json.RawMessage
+	if err := c.do("GET", path, nil, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
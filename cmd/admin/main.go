@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+
+	"github.com/CodisLabs/codis/pkg/utils/timesize"
+)
+
+const usage = `Usage:
+	pika-admin overview [options]
+	pika-admin stats [options]
+	pika-admin group list [options]
+	pika-admin group create [options] <group> <read-port> <write-port>
+	pika-admin group remove [options] <group>
+	pika-admin group add [options] <group> <addr>
+	pika-admin group del [options] <group> <addr>
+	pika-admin group promote [options] <group> <addr>
+	pika-admin group failover [options] <group>
+	pika-admin group resync [options] <group>
+	pika-admin group resync-all [options]
+	pika-admin group sentinel-params [options] [-clear] [-quorum n] [-down-after d] ... <group>
+	pika-admin sentinel list [options]
+	pika-admin sentinel add [options] [-force] <addr>
+	pika-admin sentinel del [options] [-force] <addr>
+	pika-admin sentinel resync [options]
+	pika-admin sentinel verify [options]
+	pika-admin sentinel repair [options]
+	pika-admin sentinel events [options] [-group g] [-limit n]
+	pika-admin auth rotate [options] < new-auth
+	pika-admin config reload [options] [-resync-sentinels]
+	pika-admin gslb list [options]
+	pika-admin gslb add [options] <gslb> <addr>
+	pika-admin gslb del [options] <gslb> <addr>
+	pika-admin template list [options]
+	pika-admin template show [options] <name>
+
+The dashboard is either given by -dashboard or located through the
+coordinator by -product. Run 'pika-admin <command> [<subcommand>] -h' for
+the options.
+`
+
+// options are shared by every command.
+type options struct {
+	dashboard string
+	product   string
+	coord     struct {
+		name, addr, auth string
+	}
+	json    bool
+	timeout time.Duration
+}
+
+func (o *options) register(set *flag.FlagSet) {
+	set.StringVar(&o.dashboard, "dashboard", "", "admin address of the dashboard, host:port")
+	set.StringVar(&o.product, "product", "", "locate the dashboard of the product through the coordinator")
+	set.StringVar(&o.coord.name, "coordinator", "zookeeper", "coordinator name, zookeeper, etcd, etcdv3 or filesystem")
+	set.StringVar(&o.coord.addr, "coordinator-addr", "127.0.0.1:2181", "coordinator address list")
+	set.StringVar(&o.coord.auth, "coordinator-auth", "", "coordinator auth, user:password")
+	set.BoolVar(&o.json, "json", false, "print json instead of tables")
+	set.DurationVar(&o.timeout, "timeout", time.Minute, "timeout of every request")
+}
+
+// locate returns the admin address of the dashboard, it's read from the topom
+// node of the product as cmd/fe does.
+func (o *options) locate() (string, error) {
+	if o.dashboard != "" {
+		return o.dashboard, nil
+	}
+	if o.product == "" {
+		return "", fmt.Errorf("missing -dashboard or -product")
+	}
+
+	client, err := coordinate.NewCoordinator(o.coord.name, o.coord.addr, o.coord.auth, time.Minute)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	b, err := client.Read(coordinate.TopomPath(o.product), false)
+	if err != nil {
+		return "", err
+	}
+	if b == nil {
+		return "", fmt.Errorf("no dashboard of product-[%s] is online", o.product)
+	}
+	t := &dao.Topom{}
+	if err := t.Decode(b); err != nil {
+		return "", err
+	}
+	return t.AdminAddr, nil
+}
+
+// command is a parsed command line, the api is connected once the options are
+// known.
+type command struct {
+	options
+	set  *flag.FlagSet
+	args []string
+	api  *apiClient
+}
+
+func newCommand(name string) *command {
+	c := &command{set: flag.NewFlagSet(name, flag.ExitOnError)}
+	c.options.register(c.set)
+	return c
+}
+
+// parse parses the options and checks the number of the arguments, usage
+// describes them.
+func (c *command) parse(args []string, nargs int, usage string) error {
+	c.set.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pika-admin %s [options] %s\n", c.set.Name(), usage)
+		c.set.PrintDefaults()
+	}
+	c.set.Parse(args)
+	if c.set.NArg() != nargs {
+		c.set.Usage()
+		os.Exit(2)
+	}
+	c.args = c.set.Args()
+
+	addr, err := c.locate()
+	if err != nil {
+		return err
+	}
+	c.api = newAPIClient(addr, c.timeout)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.Lwarn)
+
+	var err error
+	switch os.Args[1] {
+	case "overview":
+		err = runOverview(os.Args[2:])
+	case "stats":
+		err = runStats(os.Args[2:])
+	case "group", "sentinel", "auth", "config", "gslb", "template":
+		if len(os.Args) < 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = runResource(os.Args[1], os.Args[2], os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pika-admin:", err)
+		os.Exit(1)
+	}
+}
+
+func runOverview(args []string) error {
+	c := newCommand("overview")
+	if err := c.parse(args, 0, ""); err != nil {
+		return err
+	}
+	data, err := c.api.get("/overview")
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(data)
+	}
+	var o overview
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	printOverview(&o)
+	return nil
+}
+
+func runStats(args []string) error {
+	c := newCommand("stats")
+	if err := c.parse(args, 0, ""); err != nil {
+		return err
+	}
+	data, err := c.api.get("/stats")
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(data)
+	}
+	var s stats
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	printStats(&s)
+	return nil
+}
+
+func runResource(resource, verb string, args []string) error {
+	switch resource + " " + verb {
+	case "group list":
+		return runList(resource+" "+verb, args, "/groups", func(data []byte) error {
+			var groups []*protocol.Group
+			if err := json.Unmarshal(data, &groups); err != nil {
+				return err
+			}
+			printGroups(groups)
+			return nil
+		})
+	case "group create":
+		c := newCommand("group create")
+		if err := c.parse(args, 3, "<group> <read-port> <write-port>"); err != nil {
+			return err
+		}
+		rport, err := strconv.Atoi(c.args[1])
+		if err != nil {
+			return fmt.Errorf("invalid read port-[%s]", c.args[1])
+		}
+		wport, err := strconv.Atoi(c.args[2])
+		if err != nil {
+			return fmt.Errorf("invalid write port-[%s]", c.args[2])
+		}
+		return c.api.do("POST", "/groups", &protocol.CreateGroupRequest{
+			Name:           c.args[0],
+			ProxyReadPort:  rport,
+			ProxyWritePort: wport,
+		}, nil)
+	case "group remove":
+		c := newCommand("group remove")
+		if err := c.parse(args, 1, "<group>"); err != nil {
+			return err
+		}
+		return c.api.do("DELETE", "/groups/"+escape(c.args[0]), nil, nil)
+	case "group add":
+		c := newCommand("group add")
+		if err := c.parse(args, 2, "<group> <addr>"); err != nil {
+			return err
+		}
+		return c.api.do("POST", "/groups/"+escape(c.args[0])+"/servers", &protocol.AddServerRequest{Addr: c.args[1]}, nil)
+	case "group del":
+		c := newCommand("group del")
+		if err := c.parse(args, 2, "<group> <addr>"); err != nil {
+			return err
+		}
+		return c.api.do("DELETE", "/groups/"+escape(c.args[0])+"/servers/"+escape(c.args[1]), nil, nil)
+	case "group promote":
+		c := newCommand("group promote")
+		if err := c.parse(args, 2, "<group> <addr>"); err != nil {
+			return err
+		}
+		return c.api.do("POST", "/groups/"+escape(c.args[0])+"/servers/"+escape(c.args[1])+"/promote", nil, nil)
+	case "group failover":
+		// the request lasts until the switch, -timeout must cover the
+		// failover timeout of the group.
+		c := newCommand("group failover")
+		if err := c.parse(args, 1, "<group>"); err != nil {
+			return err
+		}
+		var failover protocol.Failover
+		if err := c.api.do("POST", "/groups/"+escape(c.args[0])+"/failover", nil, &failover); err != nil {
+			return err
+		}
+		if c.json {
+			return printValue(&failover)
+		}
+		printFailover(&failover)
+		return nil
+	case "group resync":
+		c := newCommand("group resync")
+		if err := c.parse(args, 1, "<group>"); err != nil {
+			return err
+		}
+		return c.api.do("POST", "/groups/"+escape(c.args[0])+"/resync", nil, nil)
+	case "group resync-all":
+		return runJob("group resync-all", args, &protocol.SubmitJobRequest{Type: protocol.JobTypeResyncGroupAll}, nil)
+	case "group sentinel-params":
+		// the params are replaced as a whole, the ones not given keep the
+		// dashboard config. They take effect at the next 'sentinel resync'.
+		c := newCommand("group sentinel-params")
+		clearParams := c.set.Bool("clear", false, "clear the params of the group")
+		quorum := c.set.Int("quorum", 0, "quorum of the sentinels")
+		parallelSyncs := c.set.Int("parallel-syncs", 0, "slaves resynced at once after a failover")
+		downAfter := c.set.Duration("down-after", 0, "time before the master is down")
+		failoverTimeout := c.set.Duration("failover-timeout", 0, "timeout of a failover")
+		notificationScript := c.set.String("notification-script", "", "script notified of the events")
+		clientReconfigScript := c.set.String("client-reconfig-script", "", "script called once the master changed")
+		if err := c.parse(args, 1, "<group>"); err != nil {
+			return err
+		}
+		path := "/groups/" + escape(c.args[0]) + "/sentinel-params"
+		if *clearParams {
+			return c.api.do("DELETE", path, nil, nil)
+		}
+		return c.api.do("PUT", path, &protocol.SentinelParams{
+			Quorum:               *quorum,
+			ParallelSyncs:        *parallelSyncs,
+			DownAfter:            timesize.Duration(*downAfter),
+			FailoverTimeout:      timesize.Duration(*failoverTimeout),
+			NotificationScript:   *notificationScript,
+			ClientReconfigScript: *clientReconfigScript,
+		}, nil)
+
+	case "sentinel list":
+		return runList(resource+" "+verb, args, "/sentinels", func(data []byte) error {
+			var sentinel protocol.Sentinel
+			if err := json.Unmarshal(data, &sentinel); err != nil {
+				return err
+			}
+			printSentinel(&sentinel)
+			return nil
+		})
+	case "sentinel add":
+		c := newCommand("sentinel add")
+		force := c.set.Bool("force", false, "add the sentinel even if it breaks the quorum of a group")
+		if err := c.parse(args, 1, "<addr>"); err != nil {
+			return err
+		}
+		return c.api.do("POST", "/sentinels?force="+strconv.FormatBool(*force), &protocol.AddServerRequest{Addr: c.args[0]}, nil)
+	case "sentinel del":
+		c := newCommand("sentinel del")
+		force := c.set.Bool("force", false, "remove the sentinel even if it can't be reset or it breaks the quorum of a group")
+		if err := c.parse(args, 1, "<addr>"); err != nil {
+			return err
+		}
+		return c.api.do("DELETE", "/sentinels/"+escape(c.args[0])+"?force="+strconv.FormatBool(*force), nil, nil)
+	case "sentinel resync":
+		return runJob("sentinel resync", args, &protocol.SubmitJobRequest{Type: protocol.JobTypeResyncSentinels}, nil)
+	case "sentinel verify":
+		return runList(resource+" "+verb, args, "/sentinel-drift", func(data []byte) error {
+			var verifications []*protocol.SentinelVerification
+			if err := json.Unmarshal(data, &verifications); err != nil {
+				return err
+			}
+			printSentinelDrift(verifications)
+			return nil
+		})
+	case "sentinel events":
+		c := newCommand("sentinel events")
+		group := c.set.String("group", "", "only the events of the group")
+		limit := c.set.Int("limit", 50, "the number of events, 0 for all of them")
+		if err := c.parse(args, 0, ""); err != nil {
+			return err
+		}
+		query := url.Values{"limit": {strconv.Itoa(*limit)}}
+		if *group != "" {
+			query.Set("group", *group)
+		}
+		data, err := c.api.get("/sentinel-events?" + query.Encode())
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(data)
+		}
+		var events []*dao.SentinelEvent
+		if err := json.Unmarshal(data, &events); err != nil {
+			return err
+		}
+		printSentinelEvents(events)
+		return nil
+	case "sentinel repair":
+		// only the drifted entries shown by 'sentinel verify' are repaired.
+		return runJob("sentinel repair", args, &protocol.SubmitJobRequest{Type: protocol.JobTypeRepairSentinels}, nil)
+
+	case "auth rotate":
+		// the new auth is read from the standard input, it's left neither in
+		// the shell history nor in the process list.
+		req := &protocol.SubmitJobRequest{Type: protocol.JobTypeRotateAuth}
+		return runJob("auth rotate", args, req, func() error {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if req.Auth = strings.TrimRight(line, "\r\n"); req.Auth == "" {
+				return fmt.Errorf("missing the new auth on the standard input")
+			}
+			return nil
+		})
+
+	case "config reload":
+		c := newCommand("config reload")
+		resync := c.set.Bool("resync-sentinels", false, "resync the sentinels once a sentinel key changed")
+		if err := c.parse(args, 0, ""); err != nil {
+			return err
+		}
+		var reload protocol.ConfigReload
+		if err := c.api.do("POST", "/config/reload", &protocol.ReloadConfigRequest{ResyncSentinels: *resync}, &reload); err != nil {
+			return err
+		}
+		if c.json {
+			return printValue(&reload)
+		}
+		printConfigReload(&reload)
+		return nil
+
+	case "gslb list":
+		return runList(resource+" "+verb, args, "/gslbs", func(data []byte) error {
+			var gslbs map[string]*protocol.GSLB
+			if err := json.Unmarshal(data, &gslbs); err != nil {
+				return err
+			}
+			printGSLBs(gslbs)
+			return nil
+		})
+	case "gslb add":
+		c := newCommand("gslb add")
+		if err := c.parse(args, 2, "<gslb> <addr>"); err != nil {
+			return err
+		}
+		return c.api.do("POST", "/gslbs/"+escape(c.args[0])+"/servers", &protocol.AddServerRequest{Addr: c.args[1]}, nil)
+	case "gslb del":
+		c := newCommand("gslb del")
+		if err := c.parse(args, 2, "<gslb> <addr>"); err != nil {
+			return err
+		}
+		return c.api.do("DELETE", "/gslbs/"+escape(c.args[0])+"/servers/"+escape(c.args[1]), nil, nil)
+
+	case "template list":
+		return runList(resource+" "+verb, args, "/template-files", func(data []byte) error {
+			var names []string
+			if err := json.Unmarshal(data, &names); err != nil {
+				return err
+			}
+			printTemplateFiles(names)
+			return nil
+		})
+	case "template show":
+		c := newCommand("template show")
+		if err := c.parse(args, 1, "<name>"); err != nil {
+			return err
+		}
+		var text protocol.Text
+		if err := c.api.do("GET", "/template-files/"+escape(c.args[0]), nil, &text); err != nil {
+			return err
+		}
+		if c.json {
+			return printValue(&text)
+		}
+		fmt.Print(text.Text)
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(2)
+	return nil
+}
+
+// runList gets the path and prints it as json or by the table printer.
+func runList(name string, args []string, path string, table func(data []byte) error) error {
+	c := newCommand(name)
+	if err := c.parse(args, 0, ""); err != nil {
+		return err
+	}
+	data, err := c.api.get(path)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(data)
+	}
+	return table(data)
+}
+
+// runJob submits a job and, unless -detach is given, follows it until it's
+// finished. The exit status is non-zero if the job didn't finish successfully.
+// prepare is optional, it completes the request once the options are parsed.
+func runJob(name string, args []string, req *protocol.SubmitJobRequest, prepare func() error) error {
+	c := newCommand(name)
+	detach := c.set.Bool("detach", false, "don't wait for the job")
+	if err := c.parse(args, 0, ""); err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			return err
+		}
+	}
+
+	job := &dao.Job{}
+	if err := c.api.do("POST", "/jobs", req, job); err != nil {
+		return err
+	}
+	if !*detach {
+		for !job.Finished() {
+			time.Sleep(time.Second)
+			if err := c.api.do("GET", "/jobs/"+escape(job.ID), nil, job); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.json {
+		if err := printValue(job); err != nil {
+			return err
+		}
+	} else {
+		printJob(job)
+	}
+	if job.Finished() && job.State != dao.JobFinished {
+		return fmt.Errorf("job-[%s] %s", job.ID, job.State)
+	}
+	return nil
+}
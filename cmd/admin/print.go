@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// serverStats mirrors protocol.RedisStats, the error is kept raw as an error
+// can't be decoded into the interface.
+type serverStats struct {
+	Error    json.RawMessage                    `json:"error"`
+	Stats    map[string]string                  `json:"stats"`
+	Sentinel map[string]*protocol.SentinelGroup `json:"sentinel"`
+	Timeout  bool                               `json:"timeout"`
+}
+
+func (s *serverStats) status() string {
+	switch {
+	case s == nil:
+		return "-"
+	case s.Timeout:
+		return "timeout"
+	case len(s.Error) != 0 && string(s.Error) != "null":
+		return "error"
+	}
+	return "ok"
+}
+
+// stats mirrors the parts of protocol.Stats which are printed.
+type stats struct {
+	Closed bool `json:"closed"`
+	Group  struct {
+		Models []*protocol.Group       `json:"models"`
+		Stats  map[string]*serverStats `json:"stats"`
+	} `json:"group"`
+	HA struct {
+		Model    *protocol.Sentinel          `json:"model"`
+		Stats    map[string]*serverStats     `json:"stats"`
+		Masters  map[string]string           `json:"masters"`
+		Warnings []*protocol.SentinelWarning `json:"warnings"`
+	} `json:"sentinels"`
+	GSLB struct {
+		Models map[string]*protocol.GSLB `json:"models"`
+		Stats  map[string]*serverStats   `json:"stats"`
+	} `json:"gslbs"`
+}
+
+type overview struct {
+	Version string          `json:"version"`
+	Compile string          `json:"compile"`
+	Model   *protocol.Topom `json:"model"`
+	Stats   *stats          `json:"stats"`
+}
+
+func printJSON(data []byte) error {
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, "", "    "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := b.WriteTo(os.Stdout)
+	return err
+}
+
+func printValue(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return printJSON(data)
+}
+
+// table prints the rows aligned, the first row is the header.
+func table(rows ...[]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func printOverview(o *overview) {
+	rows := [][]string{
+		{"version:", o.Version},
+		{"compile:", o.Compile},
+	}
+	if m := o.Model; m != nil {
+		rows = append(rows,
+			[]string{"product:", m.ProductName},
+			[]string{"admin:", m.AdminAddr},
+			[]string{"start:", m.StartTime},
+			[]string{"pid:", fmt.Sprint(m.Pid)},
+		)
+	}
+	if s := o.Stats; s != nil {
+		var sentinels int
+		if s.HA.Model != nil {
+			sentinels = len(s.HA.Model.Servers)
+		}
+		rows = append(rows,
+			[]string{"closed:", fmt.Sprint(s.Closed)},
+			[]string{"groups:", fmt.Sprint(len(s.Group.Models))},
+			[]string{"sentinels:", fmt.Sprint(sentinels)},
+			[]string{"gslbs:", fmt.Sprint(len(s.GSLB.Models))},
+		)
+	}
+	table(rows...)
+}
+
+func printStats(s *stats) {
+	rows := [][]string{{"GROUP", "SERVER", "ROLE", "LINK", "STATUS"}}
+	for _, g := range s.Group.Models {
+		for _, x := range g.Servers {
+			st := s.Group.Stats[x.Addr]
+			role, link := "-", "-"
+			if st != nil && st.Stats != nil {
+				role = valueOr(st.Stats["role"], "-")
+				link = valueOr(st.Stats["master_link_status"], "-")
+			}
+			rows = append(rows, []string{g.Name, x.Addr, role, link, st.status()})
+		}
+	}
+	table(rows...)
+	fmt.Println()
+
+	rows = [][]string{{"SENTINEL", "MONITORED", "STATUS"}}
+	if s.HA.Model != nil {
+		for _, addr := range s.HA.Model.Servers {
+			st := s.HA.Stats[addr]
+			monitored := "-"
+			if st != nil {
+				monitored = fmt.Sprint(len(st.Sentinel))
+			}
+			rows = append(rows, []string{addr, monitored, st.status()})
+		}
+	}
+	table(rows...)
+	fmt.Println()
+
+	if len(s.HA.Warnings) != 0 {
+		rows = [][]string{{"WARNING", "GROUP", "SENTINEL", "MESSAGE"}}
+		for _, w := range s.HA.Warnings {
+			rows = append(rows, []string{w.Kind, valueOr(w.Group, "-"), valueOr(w.Sentinel, "-"), w.Message})
+		}
+		table(rows...)
+		fmt.Println()
+	}
+
+	rows = [][]string{{"GSLB", "SERVER", "STATUS"}}
+	for _, name := range sortedGSLBs(s.GSLB.Models) {
+		for _, addr := range s.GSLB.Models[name].Servers {
+			rows = append(rows, []string{name, addr, s.GSLB.Stats[addr].status()})
+		}
+	}
+	table(rows...)
+}
+
+func printGroups(groups []*protocol.Group) {
+	rows := [][]string{{"GROUP", "MASTER", "SLAVES", "READ-PORT", "WRITE-PORT", "PROMOTING", "OUT-OF-SYNC"}}
+	for _, g := range groups {
+		master, slaves := "-", []string{}
+		for i, x := range g.Servers {
+			if i == 0 {
+				master = x.Addr
+			} else {
+				slaves = append(slaves, x.Addr)
+			}
+		}
+		rows = append(rows, []string{
+			g.Name, master, valueOr(strings.Join(slaves, ","), "-"),
+			fmt.Sprint(g.ProxyReadPort), fmt.Sprint(g.ProxyWritePort),
+			valueOr(g.Promoting.State, "-"), fmt.Sprint(g.OutOfSync),
+		})
+	}
+	table(rows...)
+}
+
+func printSentinel(s *protocol.Sentinel) {
+	rows := [][]string{{"SENTINEL", "OUT-OF-SYNC"}}
+	for _, addr := range s.Servers {
+		rows = append(rows, []string{addr, fmt.Sprint(s.OutOfSync)})
+	}
+	table(rows...)
+}
+
+func printSentinelDrift(verifications []*protocol.SentinelVerification) {
+	rows := [][]string{{"SENTINEL", "GROUP", "DRIFT", "KEY", "EXPECTED", "ACTUAL"}}
+	for _, v := range verifications {
+		if v.Error != "" {
+			rows = append(rows, []string{v.Sentinel, "-", "error: " + v.Error, "-", "-", "-"})
+			continue
+		}
+		if len(v.Drifts) == 0 {
+			rows = append(rows, []string{v.Sentinel, "-", "in sync", "-", "-", "-"})
+		}
+		for _, d := range v.Drifts {
+			rows = append(rows, []string{v.Sentinel, d.Group, d.Kind,
+				valueOr(d.Key, "-"), valueOr(d.Expected, "-"), valueOr(d.Actual, "-")})
+		}
+	}
+	table(rows...)
+}
+
+func printSentinelEvents(events []*dao.SentinelEvent) {
+	rows := [][]string{{"TIME", "SENTINEL", "GROUP", "EVENT", "INSTANCE", "ADDR", "DETAILS"}}
+	for _, e := range events {
+		rows = append(rows, []string{e.Time, e.Sentinel, e.Group, e.Type,
+			e.Instance, e.Addr, valueOr(e.Details, "-")})
+	}
+	table(rows...)
+}
+
+func printFailover(f *protocol.Failover) {
+	table(
+		[]string{"group:", f.Group},
+		[]string{"sentinel:", f.Sentinel},
+		[]string{"old master:", f.OldMaster},
+		[]string{"new master:", f.NewMaster},
+		[]string{"elapsed:", (time.Duration(f.Elapsed) * time.Millisecond).String()},
+	)
+}
+
+func printGSLBs(gslbs map[string]*protocol.GSLB) {
+	rows := [][]string{{"GSLB", "SERVERS"}}
+	for _, name := range sortedGSLBs(gslbs) {
+		rows = append(rows, []string{name, valueOr(strings.Join(gslbs[name].Servers, ","), "-")})
+	}
+	table(rows...)
+}
+
+func printTemplateFiles(names []string) {
+	rows := [][]string{{"TEMPLATE"}}
+	for _, name := range names {
+		rows = append(rows, []string{name})
+	}
+	table(rows...)
+}
+
+func printConfigReload(r *protocol.ConfigReload) {
+	rows := [][]string{{"KEY", "OLD", "NEW", "APPLIED"}}
+	for _, c := range r.Changes {
+		applied := "yes"
+		if c.Restart {
+			applied = "at restart"
+		}
+		rows = append(rows, []string{c.Key, valueOr(c.Old, "-"), valueOr(c.New, "-"), applied})
+	}
+	table(rows...)
+	if r.ResyncJob != "" {
+		fmt.Println()
+		fmt.Println("sentinels resynced by job", r.ResyncJob)
+	}
+}
+
+func printJob(j *dao.Job) {
+	table(
+		[]string{"job:", j.ID},
+		[]string{"type:", j.Type},
+		[]string{"state:", j.State},
+		[]string{"error:", valueOr(j.Error, "-")},
+	)
+	fmt.Println()
+
+	rows := [][]string{{"TARGET", "STATE", "ERROR"}}
+	for _, t := range j.Targets {
+		rows = append(rows, []string{t.Name, t.State, valueOr(t.Error, "-")})
+	}
+	table(rows...)
+}
+
+func sortedGSLBs(gslbs map[string]*protocol.GSLB) []string {
+	names := make([]string, 0, len(gslbs))
+	for name := range gslbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
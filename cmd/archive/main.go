@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/archive"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+const usage = `Usage:
+	pika-archive export [options] -product <name> [-o file]
+	pika-archive import [options] [-i file]
+
+Run 'pika-archive <command> -h' for the options of each command.
+`
+
+type coordinatorFlags struct {
+	name, addr, auth string
+}
+
+func (c *coordinatorFlags) register(set *flag.FlagSet) {
+	set.StringVar(&c.name, "coordinator", "zookeeper", "coordinator name, zookeeper, etcd, etcdv3 or filesystem")
+	set.StringVar(&c.addr, "coordinator-addr", "127.0.0.1:2181", "coordinator address list")
+	set.StringVar(&c.auth, "coordinator-auth", "", "coordinator auth, user:password")
+}
+
+func (c *coordinatorFlags) connect() (coordinate.Client, error) {
+	return coordinate.NewCoordinator(c.name, c.addr, c.auth, time.Minute)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.Lwarn)
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pika-archive:", err)
+		os.Exit(1)
+	}
+}
+
+func runExport(args []string) error {
+	var (
+		coord     coordinatorFlags
+		product   string
+		output    string
+		templates bool
+	)
+	set := flag.NewFlagSet("export", flag.ExitOnError)
+	coord.register(set)
+	set.StringVar(&product, "product", "", "name of the product to export")
+	set.StringVar(&output, "o", "", "output file, default stdout")
+	set.BoolVar(&templates, "template-files", true, "export the template files too")
+	set.Parse(args)
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+
+	client, err := coord.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	a, err := archive.Export(client, product, templates)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(a.Encode())
+		return err
+	}
+	return ioutil.WriteFile(output, a.Encode(), 0644)
+}
+
+func runImport(args []string) error {
+	var (
+		coord     coordinatorFlags
+		input     string
+		rename    string
+		rewrite   string
+		overwrite bool
+		skipTF    bool
+	)
+	set := flag.NewFlagSet("import", flag.ExitOnError)
+	coord.register(set)
+	set.StringVar(&input, "i", "", "input file, default stdin")
+	set.StringVar(&rename, "product", "", "import as another product name")
+	set.StringVar(&rewrite, "rewrite", "", "address rewrite list, old=new[,old=new...], host or host:port")
+	set.BoolVar(&overwrite, "overwrite", false, "overwrite the groups of an existing product")
+	set.BoolVar(&skipTF, "skip-template-files", false, "don't restore the template files")
+	set.Parse(args)
+
+	rewriter, err := parseRewrite(rewrite)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if input == "" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	a := &archive.Archive{}
+	if err := a.Decode(data); err != nil {
+		return err
+	}
+
+	client, err := coord.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return archive.Import(client, a, &archive.ImportOptions{
+		ProductName:       rename,
+		AddrRewriter:      rewriter,
+		Overwrite:         overwrite,
+		SkipTemplateFiles: skipTF,
+	})
+}
+
+func parseRewrite(s string) (archive.AddrRewriter, error) {
+	r := make(archive.AddrRewriter)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, fmt.Errorf("invalid rewrite rule-[%s]", kv)
+		}
+		r[p[0]] = p[1]
+	}
+	return r, nil
+}
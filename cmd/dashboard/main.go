@@ -17,6 +17,7 @@ import (
 	"github.com/pourer/pikamgr/topom"
 	"github.com/pourer/pikamgr/topom/dao/mapper"
 	"github.com/pourer/pikamgr/utils/log"
+	"github.com/pourer/pikamgr/utils/tlsconfig"
 
 	"github.com/gin-gonic/gin"
 )
@@ -34,6 +35,7 @@ func main() {
 	if err != nil {
 		log.Fatal("main: loadConfig fail. err:", err)
 	}
+	log.AddSecrets(config.Secrets()...)
 	logFile, err := initLog(config)
 	if err != nil {
 		log.Fatal("main: initLog fail. err:", err)
@@ -57,24 +59,40 @@ func main() {
 		log.Errorln("main: NewGroupMapper fail. err:", err)
 		return
 	}
+	defer groupMapper.Close()
 	sentinelMapper, err := mapper.NewSentinelMapper(config.ProductName, coordinator)
 	if err != nil {
 		log.Errorln("main: NewSentinelMapper fail. err:", err)
 		return
 	}
+	defer sentinelMapper.Close()
 	gslbMapper, err := mapper.NewGSLBMapper(config.ProductName, coordinator, groupMapper)
 	if err != nil {
 		log.Errorln("main: NewGSLBMapper fail. err:", err)
 		return
 	}
+	defer gslbMapper.Close()
 	templateFileMapper, err := mapper.NewTemplateFileMapper(coordinator, config.TemplateFileScanDir, config.TemplateFileScanInterval.Duration())
 	if err != nil {
 		log.Errorln("main: NewTemplateFileMapper fail. err:", err)
 		return
 	}
 	defer templateFileMapper.Close()
+	txnMapper := mapper.NewTxnMapper(coordinator, groupMapper, sentinelMapper, gslbMapper)
+	jobMapper, err := mapper.NewJobMapper(config.ProductName, coordinator)
+	if err != nil {
+		log.Errorln("main: NewJobMapper fail. err:", err)
+		return
+	}
+	defer jobMapper.Close()
+	sentinelEventMapper, err := mapper.NewSentinelEventMapper(config.ProductName, coordinator)
+	if err != nil {
+		log.Errorln("main: NewSentinelEventMapper fail. err:", err)
+		return
+	}
+	defer sentinelEventMapper.Close()
 
-	service, err := topom.NewService(config, topomMapper, groupMapper, sentinelMapper, gslbMapper, templateFileMapper)
+	service, err := topom.NewService(config, topomMapper, groupMapper, sentinelMapper, gslbMapper, templateFileMapper, txnMapper, jobMapper, sentinelEventMapper)
 	if err != nil {
 		log.Errorln("main: NewService fail. err:", err)
 		return
@@ -91,16 +109,30 @@ func main() {
 	handler.InitSentinelHandler(service, apiRouter)
 	handler.InitGSLBHandler(service, apiRouter)
 	handler.InitTFHandler(service, apiRouter)
+	handler.InitJobHandler(service, apiRouter)
+	handler.InitEventHandler(service, apiRouter)
+	handler.InitV2Handler(service, r.Group("/api/v2"))
 
 	server := &http.Server{
 		Addr:    config.AdminAddr,
 		Handler: r,
 	}
+	if config.TLSCertFile != "" {
+		server.TLSConfig, err = tlsconfig.ServerConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			log.Errorln("main: load tls config fail. err:", err)
+			return
+		}
+	}
 
 	errChan := make(chan error, 1)
 	go func() {
 		defer service.Close()
-		errChan <- server.ListenAndServe()
+		if server.TLSConfig != nil {
+			errChan <- server.ListenAndServeTLS("", "")
+		} else {
+			errChan <- server.ListenAndServe()
+		}
 	}()
 
 	go func() {
@@ -113,6 +145,19 @@ func main() {
 		errChan <- fmt.Errorf("%s", <-sigChan)
 	}()
 
+	go func() {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		defer signal.Stop(hupChan)
+
+		for range hupChan {
+			log.Infoln("main: reload config on SIGHUP")
+			if _, err := service.ReloadConfig(false); err != nil {
+				log.Errorln("main: reload config fail. err:", err)
+			}
+		}
+	}()
+
 	if err := service.Start(); err != nil {
 		log.Errorln("main: service Start fail. err:", err)
 	} else {
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -27,16 +28,15 @@ import (
 	"github.com/pourer/pikamgr/coordinate"
 	"github.com/pourer/pikamgr/topom/dao"
 	"github.com/pourer/pikamgr/utils/log"
+	"github.com/pourer/pikamgr/utils/tlsconfig"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
 )
 
-var roundTripper http.RoundTripper
-
-func init() {
+func newRoundTripper(tlsConfig *tls.Config) http.RoundTripper {
 	var dials int64
-	tr := &http.Transport{}
+	tr := &http.Transport{TLSClientConfig: tlsConfig}
 	tr.Dial = func(network, addr string) (net.Conn, error) {
 		c, err := net.DialTimeout(network, addr, time.Second*10)
 		if err == nil {
@@ -50,7 +50,7 @@ func init() {
 			tr.CloseIdleConnections()
 		}
 	}()
-	roundTripper = tr
+	return tr
 }
 
 func main() {
@@ -66,6 +66,7 @@ func main() {
 	if err != nil {
 		log.Fatal("main: loadConfig fail. err:", err)
 	}
+	log.AddSecrets(config.Secrets()...)
 	logFile, err := initLog(config)
 	if err != nil {
 		log.Fatal("main: initLog fail. err:", err)
@@ -82,7 +83,7 @@ func main() {
 	case "filesystem":
 		loader = &StaticLoader{config.CoordinatorAddr}
 		log.Infoln("main: set dashboard-list-file:", config.CoordinatorAddr)
-	case "zookeeper", "etcd":
+	case "zookeeper", "etcd", "etcdv3":
 		log.Infof("main: set %s = %s", config.CoordinatorName, config.CoordinatorAddr)
 
 		coordinator, err := coordinate.NewCoordinator(config.CoordinatorName, config.CoordinatorAddr, config.CoordinatorAuth, time.Minute)
@@ -94,10 +95,19 @@ func main() {
 
 		loader = &DynamicLoader{coordinator}
 	default:
-		log.Fatalln("main: unsupported coordinator. Only: filesystem zookeeper etcd")
+		log.Fatalln("main: unsupported coordinator. Only: filesystem zookeeper etcd etcdv3")
 	}
 
-	router := NewReverseProxy(loader)
+	var tlsConfig *tls.Config
+	scheme := "http"
+	if config.DashboardTLS {
+		scheme = "https"
+		tlsConfig, err = tlsconfig.ClientConfig(config.DashboardTLSCAFile, config.DashboardTLSCertFile, config.DashboardTLSKeyFile)
+		if err != nil {
+			log.Fatalln("main: load tls config of dashboards fail. err:", err)
+		}
+	}
+	router := NewReverseProxy(loader, scheme, newRoundTripper(tlsConfig))
 
 	m := martini.New()
 	m.Use(martini.Recovery())
@@ -135,9 +145,19 @@ func main() {
 		Addr:    config.ListenAddr,
 		Handler: h,
 	}
+	if config.TLSCertFile != "" {
+		server.TLSConfig, err = tlsconfig.ServerConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			log.Fatalln("main: load tls config fail. err:", err)
+		}
+	}
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- server.ListenAndServe()
+		if server.TLSConfig != nil {
+			errChan <- server.ListenAndServeTLS("", "")
+		} else {
+			errChan <- server.ListenAndServe()
+		}
 	}()
 
 	go func() {
@@ -240,14 +260,18 @@ func (l *DynamicLoader) Reload() (map[string]string, error) {
 
 type ReverseProxy struct {
 	sync.Mutex
-	loadAt time.Time
-	loader ConfigLoader
-	routes map[string]*httputil.ReverseProxy
+	loadAt    time.Time
+	loader    ConfigLoader
+	scheme    string
+	transport http.RoundTripper
+	routes    map[string]*httputil.ReverseProxy
 }
 
-func NewReverseProxy(loader ConfigLoader) *ReverseProxy {
+func NewReverseProxy(loader ConfigLoader, scheme string, transport http.RoundTripper) *ReverseProxy {
 	r := &ReverseProxy{}
 	r.loader = loader
+	r.scheme = scheme
+	r.transport = transport
 	r.routes = make(map[string]*httputil.ReverseProxy)
 	return r
 }
@@ -264,9 +288,11 @@ func (r *ReverseProxy) reload(d time.Duration) {
 			if name == "" || host == "" {
 				continue
 			}
-			u := &url.URL{Scheme: "http", Host: host}
+			u := &url.URL{Scheme: r.scheme, Host: host}
 			p := httputil.NewSingleHostReverseProxy(u)
-			p.Transport = roundTripper
+			p.Transport = r.transport
+			// the event and job streams are passed through as they're written.
+			p.FlushInterval = -1
 			r.routes[name] = p
 		}
 	}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+type endpoint struct {
+	name, addr, auth string
+}
+
+func (e *endpoint) register(set *flag.FlagSet, prefix string) {
+	set.StringVar(&e.name, prefix+"-coordinator", "", "coordinator name, zookeeper, etcd, etcdv3 or filesystem")
+	set.StringVar(&e.addr, prefix+"-addr", "", "coordinator address list")
+	set.StringVar(&e.auth, prefix+"-auth", "", "coordinator auth, user:password")
+}
+
+func (e *endpoint) String() string {
+	return fmt.Sprintf("%s://%s", e.name, e.addr)
+}
+
+func main() {
+	var (
+		from, to  endpoint
+		root      string
+		lock      bool
+		verify    bool
+		dryRun    bool
+		overwrite bool
+		verbose   bool
+	)
+	set := flag.CommandLine
+	from.register(set, "from")
+	to.register(set, "to")
+	set.StringVar(&root, "root", coordinate.DefaultBaseDir, "root of the tree to copy")
+	set.BoolVar(&lock, "lock", true, "lock every product of the source, fails if a dashboard is online")
+	set.BoolVar(&verify, "verify", true, "compare the target with the source after copy")
+	set.BoolVar(&dryRun, "dry-run", false, "only print the nodes to copy")
+	set.BoolVar(&overwrite, "overwrite", false, "allow copying into a non-empty target")
+	set.BoolVar(&verbose, "v", false, "print coordinator logs")
+	flag.Parse()
+
+	if from.name == "" || from.addr == "" || to.name == "" || to.addr == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	log.SetOutput(os.Stderr)
+	if verbose {
+		log.SetLevel(log.Ldebug)
+	} else {
+		log.SetLevel(log.Lwarn)
+	}
+
+	if err := run(&from, &to, root, lock, verify, dryRun, overwrite); err != nil {
+		fmt.Fprintln(os.Stderr, "pika-migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run(from, to *endpoint, root string, lock, verify, dryRun, overwrite bool) error {
+	src, err := coordinate.NewCoordinator(from.name, from.addr, from.auth, time.Minute)
+	if err != nil {
+		return fmt.Errorf("connect to source %s failed. err:%s", from, err)
+	}
+	defer src.Close()
+
+	dst, err := coordinate.NewCoordinator(to.name, to.addr, to.auth, time.Minute)
+	if err != nil {
+		return fmt.Errorf("connect to target %s failed. err:%s", to, err)
+	}
+	defer dst.Close()
+
+	if lock {
+		unlock, err := lockProducts(src)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	nodes, err := snapshot(src, root)
+	if err != nil {
+		return fmt.Errorf("read source %s failed. err:%s", from, err)
+	}
+	fmt.Printf("source %s has %d nodes under %s\n", from, len(nodes), root)
+
+	if !overwrite {
+		exists, err := snapshot(dst, root)
+		if err != nil {
+			return fmt.Errorf("read target %s failed. err:%s", to, err)
+		}
+		if len(exists) != 0 {
+			return fmt.Errorf("target %s already has %d nodes under %s, use -overwrite", to, len(exists), root)
+		}
+	}
+
+	for _, path := range sortedPaths(nodes) {
+		fmt.Printf("copy %s (%d bytes)\n", path, len(nodes[path]))
+		if dryRun {
+			continue
+		}
+		if err := dst.Update(path, nodes[path]); err != nil {
+			return fmt.Errorf("write %s to target failed. err:%s", path, err)
+		}
+	}
+	if dryRun || !verify {
+		return nil
+	}
+
+	copied, err := snapshot(dst, root)
+	if err != nil {
+		return fmt.Errorf("read target %s failed. err:%s", to, err)
+	}
+	var mismatch int
+	for _, path := range sortedPaths(nodes) {
+		if data, ok := copied[path]; !ok {
+			fmt.Printf("verify: %s missing in target\n", path)
+			mismatch++
+		} else if !bytes.Equal(data, nodes[path]) {
+			fmt.Printf("verify: %s differs\n", path)
+			mismatch++
+		}
+	}
+	for _, path := range sortedPaths(copied) {
+		if _, ok := nodes[path]; !ok {
+			fmt.Printf("verify: %s only exists in target\n", path)
+		}
+	}
+	if mismatch != 0 {
+		return fmt.Errorf("verify failed, %d nodes mismatch", mismatch)
+	}
+	fmt.Printf("verify OK, %d nodes copied\n", len(nodes))
+	return nil
+}
+
+// snapshot walks the tree and returns the data of every leaf node, empty
+// ones included. Topom nodes belong to running dashboards and are never
+// copied.
+func snapshot(client coordinate.Client, root string) (map[string][]byte, error) {
+	nodes := make(map[string][]byte)
+	topoms := make(map[string]bool)
+	products, err := client.List(coordinate.ProductDir(), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range products {
+		topoms[coordinate.TopomPath(filepath.Base(path))] = true
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		if topoms[path] {
+			return nil
+		}
+		// etcd returns an error when listing a file, zookeeper returns no
+		// children, both are treated as leaf nodes.
+		children, err := client.List(path, false)
+		if err == nil && len(children) != 0 {
+			for _, child := range children {
+				if err := walk(filepath.ToSlash(child)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		data, err := client.Read(path, false)
+		switch {
+		case coordinate.IsNotFile(err):
+			// an empty dir of etcd or of the filesystem.
+			return nil
+		case err != nil:
+			return err
+		case data == nil:
+			// a missing node, or an empty dir of etcdv3.
+			return nil
+		case len(data) == 0 && isDir(path):
+			// an empty dir of zookeeper, which can't be told from an
+			// empty node but by the layout.
+			return nil
+		}
+		nodes[path] = data
+		return nil
+	}
+	return nodes, walk(root)
+}
+
+// isDir tells if the path is a dir of the layout of the coordinator.
+func isDir(path string) bool {
+	switch path {
+	case coordinate.DefaultBaseDir, coordinate.ProductDir(), coordinate.GSLBDir(), coordinate.TemplateFileDir():
+		return true
+	}
+	switch parent := filepath.ToSlash(filepath.Dir(path)); parent {
+	case coordinate.ProductDir(), coordinate.GSLBDir():
+		return true
+	default:
+		base := "/" + filepath.Base(path)
+		return filepath.ToSlash(filepath.Dir(parent)) == coordinate.ProductDir() &&
+			(base == coordinate.DefaultGroupDir || base == coordinate.DefaultJobDir)
+	}
+}
+
+func sortedPaths(nodes map[string][]byte) []string {
+	paths := make([]string, 0, len(nodes))
+	for path := range nodes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// lockProducts holds the topom node of every product, the same node a
+// dashboard creates on start, so no dashboard can run during the copy.
+func lockProducts(client coordinate.Client) (func(), error) {
+	products, err := client.List(coordinate.ProductDir(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked []string
+	unlock := func() {
+		for _, path := range locked {
+			if err := client.Delete(path); err != nil {
+				fmt.Fprintf(os.Stderr, "unlock %s failed. err:%s\n", path, err)
+			}
+		}
+	}
+
+	host, _ := os.Hostname()
+	for _, path := range products {
+		product := filepath.Base(path)
+		t := &dao.Topom{
+			StartTime:   time.Now().String(),
+			ProductName: product,
+			Pid:         os.Getpid(),
+			Sys:         fmt.Sprintf("pika-migrate@%s", host),
+		}
+		if _, err := client.CreateEphemeral(coordinate.TopomPath(product), t.Encode()); err != nil {
+			unlock()
+			return nil, fmt.Errorf("lock product-[%s] failed, is the dashboard online? err:%s", product, err)
+		}
+		locked = append(locked, coordinate.TopomPath(product))
+		fmt.Printf("lock product-[%s]\n", product)
+	}
+	return unlock, nil
+}
@@ -18,20 +18,52 @@ const DefaultDashboardConfig = `
 #                                                #
 ##################################################
 
-# Set Coordinator, only accept "zookeeper" & "etcd".
-# for zookeeper/etcd, coorinator_auth accept "user:password" 
+# The credentials, coordinator_auth, product_auth and sentinel_auth, accept "${NAME}" to be read from the
+# environment variable NAME, or are read from the file of their *_file key, e.g. product_auth_file. They're
+# masked in the logs and the api.
+
+# Set Coordinator, only accept "zookeeper" & "etcd" & "etcdv3" & "filesystem".
+# For "filesystem", coordinator_addr is the root dir of the tree, for single-node setups.
+# for zookeeper/etcd/etcdv3, coorinator_auth accept "user:password" 
 # Quick Start
 coordinator_name = "zookeeper"
 coordinator_addr = "127.0.0.1:2181"
 coordinator_auth = ""
+coordinator_auth_file = ""
 
 # Set Codis Product Name/Auth.
+# product_user is the ACL user of product_auth, "AUTH <product_auth>" is sent if empty.
 product_name = "codis-demo"
 product_auth = ""
+product_auth_file = ""
+product_user = ""
+
+# Set the auth of the sentinels, they're not authenticated to if sentinel_auth is empty.
+# sentinel_user is the ACL user of sentinel_auth.
+sentinel_auth = ""
+sentinel_auth_file = ""
+sentinel_user = ""
+
+# Set configs for the tls to the pika servers and the sentinels, they're connected over tls once redis_tls is set.
+# The servers are verified against the CAs of redis_tls_ca_file, the system ones if empty, by the host of their
+# address unless redis_tls_server_name is set. The key pair is presented to the servers which require a client
+# certificate, it's reloaded once its files change.
+redis_tls = false
+redis_tls_ca_file = ""
+redis_tls_cert_file = ""
+redis_tls_key_file = ""
+redis_tls_server_name = ""
 
 # Set bind address for admin(rpc), tcp only.
 admin_addr = "0.0.0.0:18080"
 
+# Set configs for the tls of the admin api, it's served over https once tls_cert_file is set.
+# With tls_client_ca_file the clients, the fe included, must present a certificate signed by one of its CAs.
+# The files are reloaded once they change, a renewed certificate doesn't need a restart.
+tls_cert_file = ""
+tls_key_file = ""
+tls_client_ca_file = ""
+
 # Set configs for redis sentinel.
 sentinel_client_timeout = "10s"
 sentinel_quorum = 2
@@ -41,10 +73,30 @@ sentinel_failover_timeout = "5m"
 sentinel_notification_script = ""
 sentinel_client_reconfig_script = ""
 
+# Set configs for the stats collector.
+# The replication of every server is probed each stats_interval, the full INFO each stats_full_interval.
+# An unreachable server is probed less and less often, up to stats_max_backoff.
+stats_workers = 32
+stats_interval = "1s"
+stats_full_interval = "10s"
+stats_timeout = "1s"
+stats_max_backoff = "30s"
+
+# Set configs for the keyspace scans of the server info, "INFO keyspace 1" makes Pika scan every key.
+# A server is scanned once per keyspace_scan_window at most. A slave whose link is up is scanned in place
+# of its master, a master only while its instantaneous_ops_per_sec is below keyspace_scan_max_ops, 0 never.
+keyspace_scan_window = "10m"
+keyspace_scan_max_ops = 1000
+
 # Set configs for template-file
 template_file_scan_dir = "/tmp/template"
 template_file_scan_interval = "30s"
 
+# Set configs for the reload of this file, on a SIGHUP or a call of the api. The sentinel_*, keyspace_scan_*,
+# template_file_scan_interval and log_level keys take effect at once, the others at a restart.
+# The sentinels are resynced once a sentinel_* key changes if reload_resync_sentinels is set.
+reload_resync_sentinels = false
+
 # Set configs for log
 log_print_screen = false
 log_file_path = ""
@@ -56,28 +108,63 @@ log_level = "info"
 type DashboardConfig struct {
 	CoordinatorName string `toml:"coordinator_name" json:"coordinator_name"`
 	CoordinatorAddr string `toml:"coordinator_addr" json:"coordinator_addr"`
-	CoordinatorAuth string `toml:"coordinator_auth" json:"coordinator_auth"`
+	CoordinatorAuth string `toml:"coordinator_auth" json:"coordinator_auth" secret:"userpass"`
+
+	CoordinatorAuthFile string `toml:"coordinator_auth_file" json:"coordinator_auth_file"`
 
 	AdminAddr   string `toml:"admin_addr" json:"admin_addr"`
 	ProductName string `toml:"product_name" json:"product_name"`
-	ProductAuth string `toml:"product_auth" json:"-"`
+	ProductAuth string `toml:"product_auth" json:"-" secret:"true"`
+	ProductUser string `toml:"product_user" json:"product_user"`
+
+	ProductAuthFile string `toml:"product_auth_file" json:"product_auth_file"`
+
+	SentinelAuth     string `toml:"sentinel_auth" json:"-" secret:"true"`
+	SentinelAuthFile string `toml:"sentinel_auth_file" json:"sentinel_auth_file"`
+	SentinelUser     string `toml:"sentinel_user" json:"sentinel_user"`
+
+	RedisTLS           bool   `toml:"redis_tls" json:"redis_tls"`
+	RedisTLSCAFile     string `toml:"redis_tls_ca_file" json:"redis_tls_ca_file"`
+	RedisTLSCertFile   string `toml:"redis_tls_cert_file" json:"redis_tls_cert_file"`
+	RedisTLSKeyFile    string `toml:"redis_tls_key_file" json:"redis_tls_key_file"`
+	RedisTLSServerName string `toml:"redis_tls_server_name" json:"redis_tls_server_name"`
 
-	SentinelClientTimeout        timesize.Duration `toml:"sentinel_client_timeout" json:"sentinel_client_timeout"`
-	SentinelQuorum               int               `toml:"sentinel_quorum" json:"sentinel_quorum"`
-	SentinelParallelSyncs        int               `toml:"sentinel_parallel_syncs" json:"sentinel_parallel_syncs"`
-	SentinelDownAfter            timesize.Duration `toml:"sentinel_down_after" json:"sentinel_down_after"`
-	SentinelFailoverTimeout      timesize.Duration `toml:"sentinel_failover_timeout" json:"sentinel_failover_timeout"`
-	SentinelNotificationScript   string            `toml:"sentinel_notification_script" json:"sentinel_notification_script"`
-	SentinelClientReconfigScript string            `toml:"sentinel_client_reconfig_script" json:"sentinel_client_reconfig_script"`
+	TLSCertFile     string `toml:"tls_cert_file" json:"tls_cert_file"`
+	TLSKeyFile      string `toml:"tls_key_file" json:"tls_key_file"`
+	TLSClientCAFile string `toml:"tls_client_ca_file" json:"tls_client_ca_file"`
+
+	SentinelClientTimeout        timesize.Duration `toml:"sentinel_client_timeout" json:"sentinel_client_timeout" reload:"live"`
+	SentinelQuorum               int               `toml:"sentinel_quorum" json:"sentinel_quorum" reload:"live"`
+	SentinelParallelSyncs        int               `toml:"sentinel_parallel_syncs" json:"sentinel_parallel_syncs" reload:"live"`
+	SentinelDownAfter            timesize.Duration `toml:"sentinel_down_after" json:"sentinel_down_after" reload:"live"`
+	SentinelFailoverTimeout      timesize.Duration `toml:"sentinel_failover_timeout" json:"sentinel_failover_timeout" reload:"live"`
+	SentinelNotificationScript   string            `toml:"sentinel_notification_script" json:"sentinel_notification_script" reload:"live"`
+	SentinelClientReconfigScript string            `toml:"sentinel_client_reconfig_script" json:"sentinel_client_reconfig_script" reload:"live"`
+
+	StatsWorkers      int               `toml:"stats_workers" json:"stats_workers"`
+	StatsInterval     timesize.Duration `toml:"stats_interval" json:"stats_interval"`
+	StatsFullInterval timesize.Duration `toml:"stats_full_interval" json:"stats_full_interval"`
+	StatsTimeout      timesize.Duration `toml:"stats_timeout" json:"stats_timeout"`
+	StatsMaxBackoff   timesize.Duration `toml:"stats_max_backoff" json:"stats_max_backoff"`
+
+	KeyspaceScanWindow timesize.Duration `toml:"keyspace_scan_window" json:"keyspace_scan_window" reload:"live"`
+	KeyspaceScanMaxOps int               `toml:"keyspace_scan_max_ops" json:"keyspace_scan_max_ops" reload:"live"`
 
 	TemplateFileScanDir      string            `toml:"template_file_scan_dir" json:"template_file_scan_dir"`
-	TemplateFileScanInterval timesize.Duration `toml:"template_file_scan_interval" json:"template_file_scan_interval"`
+	TemplateFileScanInterval timesize.Duration `toml:"template_file_scan_interval" json:"template_file_scan_interval" reload:"live"`
+
+	ReloadResyncSentinels bool `toml:"reload_resync_sentinels" json:"reload_resync_sentinels" reload:"live"`
 
 	LogPrintScreen bool   `toml:"log_print_screen" json:"log_print_screen"`
 	LogFilePath    string `toml:"log_file_path" json:"log_file_path"`
 	LogMaxSize     int    `toml:"log_max_size" json:"log_max_size"`
 	LogReserveDays int    `toml:"log_reserve_days" json:"log_reserve_days"`
-	LogLevel       string `toml:"log_level" json:"log_level"`
+	LogLevel       string `toml:"log_level" json:"log_level" reload:"live"`
+
+	// path is the file the config was loaded from, sources where its
+	// credentials were loaded from.
+	path    string
+	sources map[string]secretSource
 }
 
 func NewDashboardDefaultConfig() *DashboardConfig {
@@ -96,14 +183,55 @@ func (c *DashboardConfig) LoadFromFile(path string) error {
 	if err != nil {
 		return err
 	}
+	if c.sources, err = loadSecrets(c); err != nil {
+		return err
+	}
+	c.path = path
 	return c.Validate()
 }
 
+// RewriteSecret sets the credential of the key where it was loaded from, its
+// file or the config file. It returns false if it can't be set, the config
+// wasn't loaded from a file or the credential is read from the environment.
+func (c *DashboardConfig) RewriteSecret(key, value string) (bool, error) {
+	source := c.sources[key]
+	switch {
+	case source.file != "":
+		return true, writeSecretFile(source.file, value)
+	case source.env != "":
+		return false, nil
+	}
+	return c.RewriteFile(map[string]string{key: value})
+}
+
+// RewriteFile sets the keys to the values in the file the config was loaded
+// from, the other lines are kept. It returns false if the config wasn't
+// loaded from a file.
+func (c *DashboardConfig) RewriteFile(values map[string]string) (bool, error) {
+	if c.path == "" {
+		return false, nil
+	}
+	return true, rewriteFile(c.path, values)
+}
+
+// Redacted returns a copy of the config whose credentials are masked, it's
+// the one to show.
+func (c *DashboardConfig) Redacted() *DashboardConfig {
+	r := *c
+	redact(&r)
+	return &r
+}
+
+// Secrets returns the credentials of the config.
+func (c *DashboardConfig) Secrets() []string {
+	return secrets(c)
+}
+
 func (c *DashboardConfig) String() string {
 	var b bytes.Buffer
 	e := toml.NewEncoder(&b)
 	e.Indent = "    "
-	e.Encode(c)
+	e.Encode(c.Redacted())
 	return b.String()
 }
 
@@ -120,6 +248,24 @@ func (c *DashboardConfig) Validate() error {
 	if c.ProductName == "" || !validateProduct(c.ProductName) {
 		return errors.New("invalid product_name")
 	}
+	if c.ProductUser != "" && c.ProductAuth == "" {
+		return errors.New("invalid product_user")
+	}
+	if c.SentinelUser != "" && c.SentinelAuth == "" {
+		return errors.New("invalid sentinel_user")
+	}
+	if (c.RedisTLSCertFile == "") != (c.RedisTLSKeyFile == "") {
+		return errors.New("invalid redis_tls_cert_file or redis_tls_key_file")
+	}
+	if !c.RedisTLS && (c.RedisTLSCAFile != "" || c.RedisTLSCertFile != "" || c.RedisTLSServerName != "") {
+		return errors.New("invalid redis_tls")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("invalid tls_cert_file or tls_key_file")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		return errors.New("invalid tls_client_ca_file")
+	}
 	if c.SentinelClientTimeout <= 0 {
 		return errors.New("invalid sentinel_client_timeout")
 	}
@@ -135,6 +281,27 @@ func (c *DashboardConfig) Validate() error {
 	if c.SentinelFailoverTimeout <= 0 {
 		return errors.New("invalid sentinel_failover_timeout")
 	}
+	if c.StatsWorkers <= 0 {
+		return errors.New("invalid stats_workers")
+	}
+	if c.StatsInterval <= 0 {
+		return errors.New("invalid stats_interval")
+	}
+	if c.StatsFullInterval < c.StatsInterval {
+		return errors.New("invalid stats_full_interval")
+	}
+	if c.StatsTimeout <= 0 {
+		return errors.New("invalid stats_timeout")
+	}
+	if c.StatsMaxBackoff < c.StatsInterval {
+		return errors.New("invalid stats_max_backoff")
+	}
+	if c.KeyspaceScanWindow <= 0 {
+		return errors.New("invalid keyspace_scan_window")
+	}
+	if c.KeyspaceScanMaxOps < 0 {
+		return errors.New("invalid keyspace_scan_max_ops")
+	}
 	if c.TemplateFileScanDir == "" {
 		return errors.New("invalid template_file_scan_dir")
 	}
@@ -18,18 +18,35 @@ const DefaultFEConfig = `
 #                                                #
 ##################################################
 
-# Set Coordinator, only accept "zookeeper" & "etcd" & "filesystem".
-# for zookeeper/etcd, coorinator_auth accept "user:password" 
+# Set Coordinator, only accept "zookeeper" & "etcd" & "etcdv3" & "filesystem".
+# for zookeeper/etcd/etcdv3, coorinator_auth accept "user:password" 
 # Quick Start
 #coordinator_name = "filesystem"
 #coordinator_addr = "/tmp/dashboard-list.json"
 coordinator_name = "zookeeper"
 coordinator_addr = "127.0.0.1:2181"
 coordinator_auth = ""
+# coordinator_auth accepts "${NAME}" to be read from the environment variable NAME, or is read from coordinator_auth_file.
+coordinator_auth_file = ""
 
 # Set bind address for visitor, tcp only.
 listen_addr = "0.0.0.0:8080"
 
+# Set configs for the tls of the listener, it's served over https once tls_cert_file is set.
+# With tls_client_ca_file the visitors must present a certificate signed by one of its CAs.
+tls_cert_file = ""
+tls_key_file = ""
+tls_client_ca_file = ""
+
+# Set configs for the tls to the dashboards, they're proxied over https once dashboard_tls is set.
+# The dashboards are verified against the CAs of dashboard_tls_ca_file, the system ones if empty.
+# The key pair is presented to the dashboards which require a client certificate.
+# The key pairs are reloaded once their files change, the CAs at a restart.
+dashboard_tls = false
+dashboard_tls_ca_file = ""
+dashboard_tls_cert_file = ""
+dashboard_tls_key_file = ""
+
 # Set configs for assets-files
 assets_dir = ""
 
@@ -44,10 +61,21 @@ log_level = "info"
 type FEConfig struct {
 	CoordinatorName string `toml:"coordinator_name" json:"coordinator_name"`
 	CoordinatorAddr string `toml:"coordinator_addr" json:"coordinator_addr"`
-	CoordinatorAuth string `toml:"coordinator_auth" json:"coordinator_auth"`
+	CoordinatorAuth string `toml:"coordinator_auth" json:"coordinator_auth" secret:"userpass"`
+
+	CoordinatorAuthFile string `toml:"coordinator_auth_file" json:"coordinator_auth_file"`
 
 	ListenAddr string `toml:"listen_addr" json:"listen_addr"`
 
+	TLSCertFile     string `toml:"tls_cert_file" json:"tls_cert_file"`
+	TLSKeyFile      string `toml:"tls_key_file" json:"tls_key_file"`
+	TLSClientCAFile string `toml:"tls_client_ca_file" json:"tls_client_ca_file"`
+
+	DashboardTLS         bool   `toml:"dashboard_tls" json:"dashboard_tls"`
+	DashboardTLSCAFile   string `toml:"dashboard_tls_ca_file" json:"dashboard_tls_ca_file"`
+	DashboardTLSCertFile string `toml:"dashboard_tls_cert_file" json:"dashboard_tls_cert_file"`
+	DashboardTLSKeyFile  string `toml:"dashboard_tls_key_file" json:"dashboard_tls_key_file"`
+
 	AssetsDir string `toml:"assets_dir" json:"assets_dir"`
 
 	LogPrintScreen bool   `toml:"log_print_screen" json:"log_print_screen"`
@@ -82,14 +110,29 @@ func (c *FEConfig) LoadFromFile(path string) error {
 	if err != nil {
 		return err
 	}
+	if _, err := loadSecrets(c); err != nil {
+		return err
+	}
 	return c.Validate()
 }
 
+// Redacted returns a copy of the config whose credentials are masked.
+func (c *FEConfig) Redacted() *FEConfig {
+	r := *c
+	redact(&r)
+	return &r
+}
+
+// Secrets returns the credentials of the config.
+func (c *FEConfig) Secrets() []string {
+	return secrets(c)
+}
+
 func (c *FEConfig) String() string {
 	var b bytes.Buffer
 	e := toml.NewEncoder(&b)
 	e.Indent = "    "
-	e.Encode(c)
+	e.Encode(c.Redacted())
 	return b.String()
 }
 
@@ -103,6 +146,18 @@ func (c *FEConfig) Validate() error {
 	if c.ListenAddr == "" {
 		return errors.New("invalid listen_addr")
 	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("invalid tls_cert_file or tls_key_file")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		return errors.New("invalid tls_client_ca_file")
+	}
+	if (c.DashboardTLSCertFile == "") != (c.DashboardTLSKeyFile == "") {
+		return errors.New("invalid dashboard_tls_cert_file or dashboard_tls_key_file")
+	}
+	if !c.DashboardTLS && (c.DashboardTLSCAFile != "" || c.DashboardTLSCertFile != "") {
+		return errors.New("invalid dashboard_tls")
+	}
 	if c.AssetsDir == "" {
 		return errors.New("invalid assets_dir")
 	}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// The keys which take effect without a restart are the fields tagged
+// `reload:"live"`, the others are kept until the dashboard restarts.
+
+// Change is a key of the config whose value changed, the credentials are
+// redacted.
+type Change struct {
+	Key string
+	Old string
+	New string
+	// Live is set if the new value takes effect without a restart.
+	Live bool
+}
+
+// Reload loads the file the config was loaded from again, on the defaults.
+func (c *DashboardConfig) Reload() (*DashboardConfig, error) {
+	if c.path == "" {
+		return nil, errors.New("the config wasn't loaded from a file")
+	}
+	n := NewDashboardDefaultConfig()
+	if err := n.LoadFromFile(c.path); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Apply returns a copy of the config which takes the live keys of n, and the
+// changes from the config to n.
+func (c *DashboardConfig) Apply(n *DashboardConfig) (*DashboardConfig, []*Change) {
+	applied := *c
+	var changes []*Change
+
+	ov, nv, av := reflect.ValueOf(c).Elem(), reflect.ValueOf(n).Elem(), reflect.ValueOf(&applied).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := tomlKey(f)
+		if key == "" || reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		change := &Change{
+			Key:  key,
+			Old:  formatValue(f, ov.Field(i)),
+			New:  formatValue(f, nv.Field(i)),
+			Live: f.Tag.Get("reload") == "live",
+		}
+		if change.Live {
+			av.Field(i).Set(nv.Field(i))
+		}
+		changes = append(changes, change)
+	}
+	return &applied, changes
+}
+
+func formatValue(f reflect.StructField, v reflect.Value) string {
+	if isSecret(f) {
+		if v.String() == "" {
+			return ""
+		}
+		return log.Redacted
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		if b, err := m.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
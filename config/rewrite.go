@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var keyLine = regexp.MustCompile(`^\s*([\w\-]+)\s*=`)
+
+// rewriteFile sets the keys of the toml file to the string values, the lines
+// of the other keys and the comments are kept. The keys missing from the file
+// are appended. The file is replaced at once.
+func rewriteFile(path string, values map[string]string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	encode := func(key string) (string, error) {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(map[string]string{key: values[key]}); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	done := make(map[string]bool)
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		m := keyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if _, ok := values[m[1]]; !ok {
+			continue
+		}
+		if lines[i], err = encode(m[1]); err != nil {
+			return err
+		}
+		done[m[1]] = true
+	}
+	for key := range values {
+		if done[key] {
+			continue
+		}
+		line, err := encode(key)
+		if err != nil {
+			return err
+		}
+		if n := len(lines); n != 0 && lines[n-1] == "" {
+			lines = append(lines[:n-1], line, "")
+		} else {
+			lines = append(lines, line)
+		}
+	}
+
+	return replaceFile(path, []byte(strings.Join(lines, "\n")), fi.Mode())
+}
+
+// replaceFile replaces the content of the file at once.
+func replaceFile(path string, b []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
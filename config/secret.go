@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// The credentials of the configs are the string fields tagged `secret:"true"`,
+// or `secret:"userpass"` for a "user:password" auth whose password is a secret
+// on its own. A credential is read from the file of its <key>_file field,
+// named <Field>File, when it's set, or from the environment variable of a
+// "${NAME}" value. The credentials are never written out, see redact.
+
+var envRef = regexp.MustCompile(`^\$\{(\w+)\}$`)
+
+func tomlKey(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("toml"), ",")[0]
+}
+
+func isSecret(f reflect.StructField) bool {
+	tag := f.Tag.Get("secret")
+	return tag == "true" || tag == "userpass"
+}
+
+// secretSource is where a credential was loaded from.
+type secretSource struct {
+	file string
+	env  string
+}
+
+// loadSecrets resolves the credentials of the config, c is a pointer to it.
+// It returns the sources of the ones which weren't set in place.
+func loadSecrets(c interface{}) (map[string]secretSource, error) {
+	sources := make(map[string]secretSource)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isSecret(f) {
+			continue
+		}
+		key, value := tomlKey(f), v.Field(i)
+
+		if file := v.FieldByName(f.Name + "File"); file.IsValid() && file.String() != "" {
+			if value.String() != "" {
+				return nil, fmt.Errorf("invalid %s and %s_file, only one is allowed", key, key)
+			}
+			b, err := ioutil.ReadFile(file.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s_file. err-[%s]", key, err.Error())
+			}
+			value.SetString(strings.TrimRight(string(b), "\r\n"))
+			sources[key] = secretSource{file: file.String()}
+			continue
+		}
+		if m := envRef.FindStringSubmatch(value.String()); m != nil {
+			s, ok := os.LookupEnv(m[1])
+			if !ok {
+				return nil, fmt.Errorf("invalid %s, environment variable %s is not set", key, m[1])
+			}
+			value.SetString(s)
+			sources[key] = secretSource{env: m[1]}
+		}
+	}
+	return sources, nil
+}
+
+// redact masks the credentials of the config, c is a pointer to a copy of it.
+func redact(c interface{}) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if isSecret(t.Field(i)) && v.Field(i).String() != "" {
+			v.Field(i).SetString(log.Redacted)
+		}
+	}
+}
+
+// secrets returns the credentials of the config, c is a pointer to it. The
+// password of a "user:password" auth follows the auth, so that it's masked
+// where it's shown alone.
+func secrets(c interface{}) []string {
+	var values []string
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, value := t.Field(i), v.Field(i).String()
+		if !isSecret(f) || value == "" {
+			continue
+		}
+		values = append(values, value)
+		if split := strings.SplitN(value, ":", 2); f.Tag.Get("secret") == "userpass" && len(split) == 2 && split[1] != "" {
+			values = append(values, split[1])
+		}
+	}
+	return values
+}
+
+// writeSecretFile replaces the content of the file of a credential at once,
+// its mode is kept.
+func writeSecretFile(path, value string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, []byte(value+"\n"), fi.Mode())
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// credentialKey matches the keys which hold a credential, a new one must be
+// tagged as a secret.
+var credentialKey = regexp.MustCompile(`(auth|pass|password|passwd|secret|token|credential)$`)
+
+// TestSecretsDontLeak fails once a credential of a config is shown.
+func TestSecretsDontLeak(t *testing.T) {
+	dashboard, fe := NewDashboardDefaultConfig(), NewFEDefaultConfig()
+	for _, c := range []interface {
+		Secrets() []string
+		String() string
+	}{dashboard, fe} {
+		v := reflect.ValueOf(c).Elem()
+		typ := v.Type()
+		var want []string
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			key := tomlKey(f)
+			if credentialKey.MatchString(key) && !isSecret(f) {
+				t.Fatalf("%s.%s holds a credential, it must be tagged `secret:\"true\"`", typ.Name(), f.Name)
+			}
+			if isSecret(f) {
+				if f.Type.Kind() != reflect.String {
+					t.Fatalf("%s.%s is a secret of kind %s", typ.Name(), f.Name, f.Type.Kind())
+				}
+				v.Field(i).SetString("leaked-" + key)
+				want = append(want, "leaked-"+key)
+			}
+		}
+		if len(want) == 0 {
+			t.Fatalf("%s has no secret", typ.Name())
+		}
+		if got := c.Secrets(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("secrets of %s = %v, want %v", typ.Name(), got, want)
+		}
+
+		redacted := reflect.ValueOf(c).MethodByName("Redacted").Call(nil)[0].Interface()
+		b, err := json.Marshal(redacted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, shown := range []string{string(b), c.String(), fmt.Sprintf("%+v", redacted)} {
+			if strings.Contains(shown, "leaked-") {
+				t.Fatalf("a secret of %s is shown: %s", typ.Name(), shown)
+			}
+		}
+		// the config itself is kept.
+		if got := c.Secrets(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("secrets of %s = %v after redaction", typ.Name(), got)
+		}
+	}
+}
+
+func TestUserPassSecrets(t *testing.T) {
+	c := NewDashboardDefaultConfig()
+	c.CoordinatorAuth, c.ProductAuth = "user:leaked-password", "plain:password"
+	want := []string{"user:leaked-password", "leaked-password", "plain:password"}
+	if got := c.Secrets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("secrets = %v, want %v", got, want)
+	}
+	fe := NewFEDefaultConfig()
+	fe.CoordinatorAuth = "user:"
+	if got := fe.Secrets(); !reflect.DeepEqual(got, []string{"user:"}) {
+		t.Fatalf("secrets = %v", got)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pikamgr-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	os.Setenv("PIKAMGR_TEST_AUTH", "from-env")
+	defer os.Unsetenv("PIKAMGR_TEST_AUTH")
+	auth := file("auth", "from-file\n")
+	path := file("dashboard.toml", fmt.Sprintf(`
+coordinator_auth = "plain"
+product_auth = "${PIKAMGR_TEST_AUTH}"
+sentinel_auth_file = %q
+`, auth))
+
+	c := NewDashboardDefaultConfig()
+	if err := c.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.CoordinatorAuth != "plain" || c.ProductAuth != "from-env" || c.SentinelAuth != "from-file" {
+		t.Fatalf("auths %q %q %q", c.CoordinatorAuth, c.ProductAuth, c.SentinelAuth)
+	}
+
+	// a credential is rewritten where it was loaded from.
+	if ok, err := c.RewriteSecret("product_auth", "new"); ok || err != nil {
+		t.Fatalf("rewrite of an environment variable: %v, %v", ok, err)
+	}
+	if ok, err := c.RewriteSecret("sentinel_auth", "new"); !ok || err != nil {
+		t.Fatalf("rewrite of a file: %v, %v", ok, err)
+	}
+	if b, _ := ioutil.ReadFile(auth); string(b) != "new\n" {
+		t.Fatalf("auth file %q", b)
+	}
+	if ok, err := c.RewriteSecret("coordinator_auth", "new"); !ok || err != nil {
+		t.Fatalf("rewrite of the config: %v, %v", ok, err)
+	}
+	if b, _ := ioutil.ReadFile(path); !strings.Contains(string(b), `coordinator_auth = "new"`) || !strings.Contains(string(b), "${PIKAMGR_TEST_AUTH}") {
+		t.Fatalf("config file %s", b)
+	}
+
+	for _, text := range []string{
+		`product_auth = "${PIKAMGR_TEST_UNSET}"`,
+		fmt.Sprintf("product_auth = \"x\"\nproduct_auth_file = %q", auth),
+		`product_auth_file = "/nonexistent/auth"`,
+	} {
+		if err := NewDashboardDefaultConfig().LoadFromFile(file("invalid.toml", text)); err == nil {
+			t.Fatalf("loaded %s", text)
+		}
+	}
+}
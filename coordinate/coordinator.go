@@ -5,9 +5,28 @@ import (
 	"time"
 
 	"github.com/pourer/pikamgr/coordinate/etcd"
+	"github.com/pourer/pikamgr/coordinate/etcdv3"
+	"github.com/pourer/pikamgr/coordinate/filesystem"
+	"github.com/pourer/pikamgr/coordinate/memory"
+	"github.com/pourer/pikamgr/coordinate/types"
 	"github.com/pourer/pikamgr/coordinate/zk"
 )
 
+var ErrVersionConflict = types.ErrVersionConflict
+
+type Op = types.Op
+
+const (
+	OpUpdate   = types.OpUpdate
+	OpDelete   = types.OpDelete
+	AnyVersion = types.AnyVersion
+)
+
+// IsNotFile tells if the error is the one returned by Read on a dir.
+func IsNotFile(err error) bool {
+	return err == etcd.ErrNotFile || err == filesystem.ErrNotFile
+}
+
 type Client interface {
 	Create(path string, data []byte) error
 	Update(path string, data []byte) error
@@ -16,9 +35,22 @@ type Client interface {
 	Read(path string, must bool) ([]byte, error)
 	List(path string, must bool) ([]string, error)
 
+	// ReadVersion returns the data of the node with its version, a missing
+	// node has version 0.
+	ReadVersion(path string, must bool) ([]byte, int64, error)
+	// UpdateVersion writes the node only if it is still at the version, 0
+	// means the node must not exist yet. It returns the new version or
+	// ErrVersionConflict.
+	UpdateVersion(path string, data []byte, version int64) (int64, error)
+	// Txn commits every op or none of them, and returns the new version of
+	// each node, 0 for the deleted ones.
+	Txn(ops []Op) ([]int64, error)
+
 	Close() error
 
 	WatchInOrder(path string) (<-chan struct{}, []string, error)
+	// Watch fires once the node is created, changed or deleted.
+	Watch(path string) (<-chan struct{}, error)
 
 	CreateEphemeral(path string, data []byte) (<-chan struct{}, error)
 	CreateEphemeralInOrder(path string, data []byte) (<-chan struct{}, string, error)
@@ -30,6 +62,12 @@ func NewCoordinator(coordinator string, addrlist string, auth string, timeout ti
 		return zk.New(addrlist, auth, timeout)
 	case "etcd":
 		return etcd.New(addrlist, auth, timeout)
+	case "etcdv3", "etcd3":
+		return etcdv3.New(addrlist, auth, timeout)
+	case "filesystem", "fs":
+		return filesystem.New(addrlist)
+	case "memory":
+		return memory.New(), nil
 	}
 	return nil, fmt.Errorf("invalid coordinator name:%s", coordinator)
 }
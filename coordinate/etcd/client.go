@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pourer/pikamgr/coordinate/types"
 	"github.com/pourer/pikamgr/utils/log"
 
 	"github.com/coreos/etcd/client"
@@ -103,6 +104,15 @@ func isErrNodeExists(err error) bool {
 	return false
 }
 
+func isErrTestFailed(err error) bool {
+	if err != nil {
+		if e, ok := err.(client.Error); ok {
+			return e.Code == client.ErrorCodeTestFailed
+		}
+	}
+	return false
+}
+
 func (c *Client) Mkdir(path string) error {
 	c.Lock()
 	defer c.Unlock()
@@ -200,6 +210,156 @@ func (c *Client) Read(path string, must bool) ([]byte, error) {
 	}
 }
 
+// ReadVersion returns the modified index of the node as its version.
+func (c *Client) ReadVersion(path string, must bool) ([]byte, int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosedClient
+	}
+	cntx, cancel := c.newContext()
+	defer cancel()
+	r, err := c.kapi.Get(cntx, path, &client.GetOptions{Quorum: true})
+	switch {
+	case err != nil:
+		if isErrNoNode(err) && !must {
+			return nil, 0, nil
+		}
+		log.Debugf("etcd read-version node %s failed: %s", path, err)
+		return nil, 0, err
+	case !r.Node.Dir:
+		return []byte(r.Node.Value), int64(r.Node.ModifiedIndex), nil
+	default:
+		log.Debugf("etcd read-version node %s failed: not a file", path)
+		return nil, 0, ErrNotFile
+	}
+}
+
+func (c *Client) UpdateVersion(path string, data []byte, version int64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, ErrClosedClient
+	}
+	cntx, cancel := c.newContext()
+	defer cancel()
+	log.Debugf("etcd update-version node %s version %d", path, version)
+	opts := &client.SetOptions{PrevExist: client.PrevNoExist}
+	if version != 0 {
+		opts = &client.SetOptions{PrevExist: client.PrevExist, PrevIndex: uint64(version)}
+	}
+	r, err := c.kapi.Set(cntx, path, string(data), opts)
+	switch {
+	case isErrTestFailed(err) || isErrNodeExists(err) || isErrNoNode(err):
+		log.Debugf("etcd update-version node %s failed: %s", path, err)
+		return 0, types.ErrVersionConflict
+	case err != nil:
+		log.Debugf("etcd update-version node %s failed: %s", path, err)
+		return 0, err
+	}
+	log.Debugf("etcd update-version OK")
+	return int64(r.Node.ModifiedIndex), nil
+}
+
+// Txn applies the ops one by one, each guarded by its version, etcd v2 has
+// no multi-key transaction. Once an op fails the ops applied before are rolled
+// back, so it's not atomic to readers and a crash may leave a partial apply.
+func (c *Client) Txn(ops []types.Op) ([]int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("etcd txn %d ops", len(ops))
+	type undo struct {
+		path string
+		prev *client.Node
+	}
+	var undos []undo
+	versions := make([]int64, len(ops))
+	for i, op := range ops {
+		r, err := c.applyOp(op)
+		if err != nil {
+			log.Debugf("etcd txn op %s failed: %s", op.String(), err)
+			for j := len(undos) - 1; j >= 0; j-- {
+				if err := c.rollback(undos[j].path, undos[j].prev); err != nil {
+					log.Warnf("etcd txn rollback node %s failed: %s", undos[j].path, err)
+				}
+			}
+			return nil, err
+		}
+		if r == nil {
+			continue
+		}
+		undos = append(undos, undo{op.Path, r.PrevNode})
+		if op.Type == types.OpUpdate {
+			versions[i] = int64(r.Node.ModifiedIndex)
+		}
+	}
+	log.Debugf("etcd txn OK")
+	return versions, nil
+}
+
+func (c *Client) applyOp(op types.Op) (*client.Response, error) {
+	cntx, cancel := c.newContext()
+	defer cancel()
+	var r *client.Response
+	var err error
+	switch op.Type {
+	case types.OpUpdate:
+		opts := &client.SetOptions{PrevExist: client.PrevIgnore}
+		if op.Version == 0 {
+			opts = &client.SetOptions{PrevExist: client.PrevNoExist}
+		} else if op.Version != types.AnyVersion {
+			opts = &client.SetOptions{PrevExist: client.PrevExist, PrevIndex: uint64(op.Version)}
+		}
+		r, err = c.kapi.Set(cntx, op.Path, string(op.Data), opts)
+	case types.OpDelete:
+		switch op.Version {
+		case 0:
+			// the node must not exist, nothing to delete.
+			_, err = c.kapi.Get(cntx, op.Path, &client.GetOptions{Quorum: true})
+			if isErrNoNode(err) {
+				return nil, nil
+			} else if err == nil {
+				return nil, types.ErrVersionConflict
+			}
+			return nil, err
+		case types.AnyVersion:
+			r, err = c.kapi.Delete(cntx, op.Path, nil)
+			if isErrNoNode(err) {
+				return nil, nil
+			}
+		default:
+			r, err = c.kapi.Delete(cntx, op.Path, &client.DeleteOptions{PrevIndex: uint64(op.Version)})
+		}
+	default:
+		return nil, errors.New("etcd: invalid op " + op.String())
+	}
+	switch {
+	case isErrTestFailed(err) || isErrNodeExists(err) || isErrNoNode(err):
+		return nil, types.ErrVersionConflict
+	case err != nil:
+		return nil, err
+	}
+	return r, nil
+}
+
+// rollback restores the node to prev, a nil prev means it didn't exist.
+func (c *Client) rollback(path string, prev *client.Node) error {
+	cntx, cancel := c.newContext()
+	defer cancel()
+	if prev == nil {
+		_, err := c.kapi.Delete(cntx, path, nil)
+		if isErrNoNode(err) {
+			return nil
+		}
+		return err
+	}
+	_, err := c.kapi.Set(cntx, path, prev.Value, &client.SetOptions{PrevExist: client.PrevIgnore})
+	return err
+}
+
 func (c *Client) List(path string, must bool) ([]string, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -343,3 +503,37 @@ func (c *Client) WatchInOrder(path string) (<-chan struct{}, []string, error) {
 	log.Debugf("etcd watch-inorder OK")
 	return signal, paths, nil
 }
+
+func (c *Client) Watch(path string) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("etcd watch node %s", path)
+	cntx, cancel := c.newContext()
+	defer cancel()
+	var index uint64
+	r, err := c.kapi.Get(cntx, path, &client.GetOptions{Quorum: true})
+	switch {
+	case err == nil:
+		index = r.Index
+	case isErrNoNode(err):
+		index = err.(client.Error).Index
+	default:
+		log.Debugf("etcd watch node %s failed: %s", path, err)
+		return nil, err
+	}
+	signal := make(chan struct{})
+	go func() {
+		defer close(signal)
+		watch := c.kapi.Watcher(path, &client.WatcherOptions{AfterIndex: index})
+		if _, err := watch.Next(c.context); err != nil {
+			log.Debugf("etcd watch node %s failed: %s", path, err)
+			return
+		}
+		log.Debugf("etcd watch node %s update", path)
+	}()
+	log.Debugf("etcd watch OK")
+	return signal, nil
+}
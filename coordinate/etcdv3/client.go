@@ -0,0 +1,796 @@
+package etcdv3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// Client talks to etcd v3 through its grpc-gateway, the JSON api served on
+// the client port, so the v3 keyspace can be used without the grpc stack.
+//
+// The v3 keyspace is flat, directories are emulated by key prefixes: a node
+// is a directory if any key lives below "<path>/".
+
+var ErrClosedClient = errors.New("use of closed etcdv3 client")
+
+var (
+	ErrNoNode     = errors.New("etcdv3: node does not exist")
+	ErrNodeExists = errors.New("etcdv3: node already exists")
+	ErrNotDir     = errors.New("etcdv3: not a dir")
+)
+
+// APIPrefix is the url prefix of the grpc-gateway, etcd 3.3 serves the api
+// under "/v3beta", 3.4 under both, and 3.5+ only under "/v3".
+var APIPrefix = "/v3"
+
+type Client struct {
+	sync.Mutex
+
+	endpoints []string
+	username  string
+	password  string
+	token     string
+
+	httpc   *http.Client
+	closed  bool
+	timeout time.Duration
+
+	// leases are the leases of the ephemeral nodes kept alive.
+	leases map[int64]bool
+
+	cancel  context.CancelFunc
+	context context.Context
+}
+
+func New(addrlist string, auth string, timeout time.Duration) (*Client, error) {
+	var endpoints []string
+	for _, s := range strings.Split(addrlist, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			s = "http://" + s
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(s, "/"))
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("invalid addrlist")
+	}
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+
+	c := &Client{
+		endpoints: endpoints, timeout: timeout,
+		httpc:  &http.Client{Transport: http.DefaultTransport},
+		leases: make(map[int64]bool),
+	}
+	if auth != "" {
+		split := strings.SplitN(auth, ":", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, errors.New("invalid auth")
+		}
+		c.username = split[0]
+		c.password = split[1]
+	}
+	c.context, c.cancel = context.WithCancel(context.Background())
+
+	if c.username != "" {
+		if err := c.authenticate(); err != nil {
+			c.cancel()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	// the ephemeral nodes are deleted at once rather than once their leases
+	// expired, a new owner doesn't wait for the ttl.
+	for lease := range c.leases {
+		c.revoke(lease)
+	}
+	c.leases = nil
+	c.cancel()
+	return nil
+}
+
+type int64s int64
+
+func (v *int64s) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(bytes.Trim(b, `"`), &n); err != nil {
+		return err
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return err
+	}
+	*v = int64s(i)
+	return nil
+}
+
+func (v int64s) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%d"`, int64(v))), nil
+}
+
+type keyValue struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64s `json:"create_revision"`
+	ModRevision    int64s `json:"mod_revision"`
+	Version        int64s `json:"version"`
+	Lease          int64s `json:"lease"`
+}
+
+type responseHeader struct {
+	Revision int64s `json:"revision"`
+}
+
+type rangeRequest struct {
+	Key        []byte `json:"key"`
+	RangeEnd   []byte `json:"range_end,omitempty"`
+	KeysOnly   bool   `json:"keys_only,omitempty"`
+	SortOrder  string `json:"sort_order,omitempty"`
+	SortTarget string `json:"sort_target,omitempty"`
+}
+
+type rangeResponse struct {
+	Header responseHeader `json:"header"`
+	Kvs    []*keyValue    `json:"kvs"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64s `json:"lease,omitempty"`
+}
+
+type deleteRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type compare struct {
+	Result         string  `json:"result"`
+	Target         string  `json:"target"`
+	Key            []byte  `json:"key"`
+	CreateRevision *int64s `json:"create_revision,omitempty"`
+	ModRevision    *int64s `json:"mod_revision,omitempty"`
+}
+
+type requestOp struct {
+	RequestPut         *putRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []*compare   `json:"compare,omitempty"`
+	Success []*requestOp `json:"success,omitempty"`
+	Failure []*requestOp `json:"failure,omitempty"`
+}
+
+type txnResponse struct {
+	Header    responseHeader `json:"header"`
+	Succeeded bool           `json:"succeeded"`
+}
+
+type leaseGrantResponse struct {
+	ID  int64s `json:"ID"`
+	TTL int64s `json:"TTL"`
+}
+
+type leaseKeepAliveResponse struct {
+	Result *leaseGrantResponse `json:"result"`
+}
+
+type gatewayError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *gatewayError) String() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Error
+}
+
+// prefixEnd returns the range end which covers every key with the prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func dirPrefix(path string) []byte {
+	return []byte(strings.TrimSuffix(path, "/") + "/")
+}
+
+func (c *Client) newContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.context, c.timeout)
+}
+
+func (c *Client) post(ctx context.Context, api string, req interface{}) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var last error
+	for _, endpoint := range c.endpoints {
+		r, err := http.NewRequest(http.MethodPost, endpoint+APIPrefix+api, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = r.WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		if c.token != "" {
+			r.Header.Set("Authorization", c.token)
+		}
+		resp, err := c.httpc.Do(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			last = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, last
+}
+
+func (c *Client) call(api string, req, resp interface{}) error {
+	cntx, cancel := c.newContext()
+	defer cancel()
+	err := c.doCall(cntx, api, req, resp)
+	if err != nil && c.username != "" && strings.Contains(err.Error(), "token") {
+		if err := c.authenticate(); err != nil {
+			return err
+		}
+		return c.doCall(cntx, api, req, resp)
+	}
+	return err
+}
+
+func (c *Client) doCall(ctx context.Context, api string, req, resp interface{}) error {
+	r, err := c.post(ctx, api, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		var e gatewayError
+		if json.Unmarshal(data, &e) == nil && e.String() != "" {
+			return fmt.Errorf("etcdv3: %s", e.String())
+		}
+		return fmt.Errorf("etcdv3: http status %d", r.StatusCode)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
+
+func (c *Client) authenticate() error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	c.token = ""
+	cntx, cancel := c.newContext()
+	defer cancel()
+	err := c.doCall(cntx, "/auth/authenticate", map[string]string{
+		"name": c.username, "password": c.password,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	c.token = resp.Token
+	return nil
+}
+
+func (c *Client) get(path string) (*keyValue, int64, error) {
+	var resp rangeResponse
+	if err := c.call("/kv/range", &rangeRequest{Key: []byte(path)}, &resp); err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, int64(resp.Header.Revision), nil
+	}
+	return resp.Kvs[0], int64(resp.Header.Revision), nil
+}
+
+func (c *Client) children(path string) ([]string, bool, int64, error) {
+	prefix := dirPrefix(path)
+	var resp rangeResponse
+	err := c.call("/kv/range", &rangeRequest{
+		Key: prefix, RangeEnd: prefixEnd(prefix), KeysOnly: true,
+		SortOrder: "ASCEND", SortTarget: "KEY",
+	}, &resp)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	var names []string
+	var seen = make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		name := strings.SplitN(string(kv.Key[len(prefix):]), "/", 2)[0]
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, string(prefix)+name)
+	}
+	sort.Strings(names)
+	return names, len(resp.Kvs) != 0, int64(resp.Header.Revision), nil
+}
+
+func (c *Client) Mkdir(path string) error {
+	// directories are implicit in the v3 keyspace.
+	return nil
+}
+
+func (c *Client) Create(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	log.Debugf("etcdv3 create node %s", path)
+	if err := c.create(path, data, 0); err != nil {
+		log.Debugf("etcdv3 create node %s failed: %s", path, err)
+		return err
+	}
+	log.Debugf("etcdv3 create OK")
+	return nil
+}
+
+func (c *Client) create(path string, data []byte, lease int64) error {
+	var zero int64s
+	var resp txnResponse
+	err := c.call("/kv/txn", &txnRequest{
+		Compare: []*compare{{Result: "EQUAL", Target: "CREATE", Key: []byte(path), CreateRevision: &zero}},
+		Success: []*requestOp{{RequestPut: &putRequest{Key: []byte(path), Value: data, Lease: int64s(lease)}}},
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrNodeExists
+	}
+	return nil
+}
+
+func (c *Client) Update(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	log.Debugf("etcdv3 update node %s", path)
+	if err := c.call("/kv/put", &putRequest{Key: []byte(path), Value: data}, nil); err != nil {
+		log.Debugf("etcdv3 update node %s failed: %s", path, err)
+		return err
+	}
+	log.Debugf("etcdv3 update OK")
+	return nil
+}
+
+func (c *Client) Delete(path string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	log.Debugf("etcdv3 delete node %s", path)
+	if err := c.call("/kv/deleterange", &deleteRangeRequest{Key: []byte(path)}, nil); err != nil {
+		log.Debugf("etcdv3 delete node %s failed: %s", path, err)
+		return err
+	}
+	log.Debugf("etcdv3 delete OK")
+	return nil
+}
+
+func (c *Client) Read(path string, must bool) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	kv, _, err := c.get(path)
+	switch {
+	case err != nil:
+		log.Debugf("etcdv3 read node %s failed: %s", path, err)
+		return nil, err
+	case kv == nil:
+		if !must {
+			return nil, nil
+		}
+		return nil, ErrNoNode
+	case kv.Value == nil:
+		return []byte{}, nil
+	default:
+		return kv.Value, nil
+	}
+}
+
+// ReadVersion returns the mod revision of the key as the version of the node.
+func (c *Client) ReadVersion(path string, must bool) ([]byte, int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosedClient
+	}
+	kv, _, err := c.get(path)
+	switch {
+	case err != nil:
+		log.Debugf("etcdv3 read-version node %s failed: %s", path, err)
+		return nil, 0, err
+	case kv == nil:
+		if !must {
+			return nil, 0, nil
+		}
+		return nil, 0, ErrNoNode
+	case kv.Value == nil:
+		return []byte{}, int64(kv.ModRevision), nil
+	default:
+		return kv.Value, int64(kv.ModRevision), nil
+	}
+}
+
+func (c *Client) UpdateVersion(path string, data []byte, version int64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, ErrClosedClient
+	}
+	log.Debugf("etcdv3 update-version node %s version %d", path, version)
+	rev := int64s(version)
+	cmp := &compare{Result: "EQUAL", Target: "MOD", Key: []byte(path), ModRevision: &rev}
+	if version == 0 {
+		cmp = &compare{Result: "EQUAL", Target: "CREATE", Key: []byte(path), CreateRevision: &rev}
+	}
+	var resp txnResponse
+	err := c.call("/kv/txn", &txnRequest{
+		Compare: []*compare{cmp},
+		Success: []*requestOp{{RequestPut: &putRequest{Key: []byte(path), Value: data}}},
+	}, &resp)
+	switch {
+	case err != nil:
+		log.Debugf("etcdv3 update-version node %s failed: %s", path, err)
+		return 0, err
+	case !resp.Succeeded:
+		log.Debugf("etcdv3 update-version node %s failed: version conflict", path)
+		return 0, types.ErrVersionConflict
+	}
+	log.Debugf("etcdv3 update-version OK")
+	return int64(resp.Header.Revision), nil
+}
+
+// Txn maps the ops onto one etcd transaction, the version of every node
+// updated is the revision of the transaction.
+func (c *Client) Txn(ops []types.Op) ([]int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("etcdv3 txn %d ops", len(ops))
+	var req txnRequest
+	for _, op := range ops {
+		if op.Version != types.AnyVersion {
+			rev := int64s(op.Version)
+			cmp := &compare{Result: "EQUAL", Target: "MOD", Key: []byte(op.Path), ModRevision: &rev}
+			if op.Version == 0 {
+				cmp = &compare{Result: "EQUAL", Target: "CREATE", Key: []byte(op.Path), CreateRevision: &rev}
+			}
+			req.Compare = append(req.Compare, cmp)
+		}
+		switch op.Type {
+		case types.OpUpdate:
+			req.Success = append(req.Success, &requestOp{RequestPut: &putRequest{Key: []byte(op.Path), Value: op.Data}})
+		case types.OpDelete:
+			req.Success = append(req.Success, &requestOp{RequestDeleteRange: &deleteRangeRequest{Key: []byte(op.Path)}})
+		default:
+			return nil, fmt.Errorf("etcdv3: invalid op %s", op.String())
+		}
+	}
+	var resp txnResponse
+	switch err := c.call("/kv/txn", &req, &resp); {
+	case err != nil:
+		log.Debugf("etcdv3 txn failed: %s", err)
+		return nil, err
+	case !resp.Succeeded:
+		log.Debugf("etcdv3 txn failed: version conflict")
+		return nil, types.ErrVersionConflict
+	}
+	versions := make([]int64, len(ops))
+	for i, op := range ops {
+		if op.Type == types.OpUpdate {
+			versions[i] = int64(resp.Header.Revision)
+		}
+	}
+	log.Debugf("etcdv3 txn OK")
+	return versions, nil
+}
+
+func (c *Client) List(path string, must bool) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	paths, exists, _, err := c.children(path)
+	if err != nil {
+		log.Debugf("etcdv3 list node %s failed: %s", path, err)
+		return nil, err
+	}
+	if !exists {
+		if kv, _, err := c.get(path); err != nil {
+			return nil, err
+		} else if kv != nil {
+			log.Debugf("etcdv3 list node %s failed: not a dir", path)
+			return nil, ErrNotDir
+		}
+		if must {
+			return nil, ErrNoNode
+		}
+	}
+	return paths, nil
+}
+
+func (c *Client) grant() (int64, error) {
+	ttl := int64(c.timeout / time.Second)
+	if ttl <= 0 {
+		ttl = 1
+	}
+	var resp leaseGrantResponse
+	if err := c.call("/lease/grant", map[string]int64s{"TTL": int64s(ttl)}, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+func (c *Client) revoke(lease int64) {
+	if err := c.call("/lease/revoke", map[string]int64s{"ID": int64s(lease)}, nil); err != nil {
+		log.Debugf("etcdv3 revoke lease %x failed: %s", lease, err)
+	}
+}
+
+func (c *Client) CreateEphemeral(path string, data []byte) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("etcdv3 create-ephemeral node %s", path)
+	lease, err := c.grant()
+	if err != nil {
+		log.Debugf("etcdv3 create-ephemeral node %s failed: %s", path, err)
+		return nil, err
+	}
+	if err := c.create(path, data, lease); err != nil {
+		c.revoke(lease)
+		log.Debugf("etcdv3 create-ephemeral node %s failed: %s", path, err)
+		return nil, err
+	}
+	log.Debugf("etcdv3 create-ephemeral OK")
+	return runKeepAlive(c, path, lease), nil
+}
+
+func (c *Client) CreateEphemeralInOrder(path string, data []byte) (<-chan struct{}, string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, "", ErrClosedClient
+	}
+	log.Debugf("etcdv3 create-ephemeral-inorder node %s", path)
+	lease, err := c.grant()
+	if err != nil {
+		log.Debugf("etcdv3 create-ephemeral-inorder node %s failed: %s", path, err)
+		return nil, "", err
+	}
+	for i := 0; i != 16; i++ {
+		_, _, rev, err := c.children(path)
+		if err != nil {
+			c.revoke(lease)
+			return nil, "", err
+		}
+		// the key is named after the next revision, just like the index of
+		// the etcd v2 CreateInOrder, so that the keys are sorted in order.
+		node := fmt.Sprintf("%s%020d", dirPrefix(path), rev+1)
+		switch err := c.create(node, data, lease); err {
+		case nil:
+			log.Debugf("etcdv3 create-ephemeral-inorder OK, node = %s", node)
+			return runKeepAlive(c, node, lease), node, nil
+		case ErrNodeExists:
+			continue
+		default:
+			c.revoke(lease)
+			log.Debugf("etcdv3 create-ephemeral-inorder node %s failed: %s", path, err)
+			return nil, "", err
+		}
+	}
+	c.revoke(lease)
+	return nil, "", fmt.Errorf("etcdv3 create-ephemeral-inorder node %s failed: too many conflicts", path)
+}
+
+// runKeepAlive keeps the lease alive until it expired or the client is
+// closed, it must be called with c.Mutex held.
+func runKeepAlive(c *Client, path string, lease int64) <-chan struct{} {
+	c.leases[lease] = true
+	signal := make(chan struct{})
+	go func() {
+		defer close(signal)
+		defer func() {
+			c.Lock()
+			delete(c.leases, lease)
+			c.Unlock()
+		}()
+		for {
+			select {
+			case <-c.context.Done():
+				return
+			case <-time.After(c.timeout / 3):
+			}
+			if err := c.KeepAlive(path, lease); err != nil {
+				return
+			}
+		}
+	}()
+	return signal
+}
+
+func (c *Client) KeepAlive(path string, lease int64) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	log.Debugf("etcdv3 keepalive node %s lease %x", path, lease)
+	var resp leaseKeepAliveResponse
+	if err := c.call("/lease/keepalive", map[string]int64s{"ID": int64s(lease)}, &resp); err != nil {
+		log.Debugf("etcdv3 keepalive node %s failed: %s", path, err)
+		return err
+	}
+	if resp.Result == nil || resp.Result.TTL <= 0 {
+		log.Debugf("etcdv3 keepalive node %s failed: lease expired", path)
+		return ErrNoNode
+	}
+	return nil
+}
+
+type watchResponse struct {
+	Result *struct {
+		Header  responseHeader `json:"header"`
+		Created bool           `json:"created"`
+		Events  []*struct {
+			Type string    `json:"type"`
+			Kv   *keyValue `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *gatewayError `json:"error"`
+}
+
+// watch blocks until an event in range [key, end) happens after revision rev.
+// The match func filters the events, a nil match accepts every event.
+func (c *Client) watch(key, end []byte, rev int64, match func(typ string, kv *keyValue) bool) error {
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key": key, "range_end": end, "start_revision": int64s(rev),
+		},
+	}
+	c.Lock()
+	resp, err := c.post(c.context, "/watch", req)
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcdv3: http status %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var r watchResponse
+		if err := decoder.Decode(&r); err != nil {
+			return err
+		}
+		if r.Error != nil {
+			return fmt.Errorf("etcdv3: %s", r.Error.String())
+		}
+		if r.Result == nil {
+			continue
+		}
+		for _, e := range r.Result.Events {
+			if match == nil || match(e.Type, e.Kv) {
+				return nil
+			}
+		}
+	}
+}
+
+func (c *Client) WatchInOrder(path string) (<-chan struct{}, []string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosedClient
+	}
+	log.Debugf("etcdv3 watch-inorder node %s", path)
+	paths, _, rev, err := c.children(path)
+	if err != nil {
+		log.Debugf("etcdv3 watch-inorder node %s failed: %s", path, err)
+		return nil, nil, err
+	}
+	prefix := dirPrefix(path)
+	signal := make(chan struct{})
+	go func() {
+		defer close(signal)
+		err := c.watch(prefix, prefixEnd(prefix), rev+1, func(typ string, kv *keyValue) bool {
+			// the children of a dir only change when a key is created or deleted.
+			return typ == "DELETE" || (kv != nil && kv.Version == 1)
+		})
+		if err != nil {
+			log.Debugf("etcdv3 watch-inorder node %s failed: %s", path, err)
+			return
+		}
+		log.Debugf("etcdv3 watch-inorder node %s update", path)
+	}()
+	log.Debugf("etcdv3 watch-inorder OK")
+	return signal, paths, nil
+}
+
+func (c *Client) Watch(path string) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("etcdv3 watch node %s", path)
+	_, rev, err := c.get(path)
+	if err != nil {
+		log.Debugf("etcdv3 watch node %s failed: %s", path, err)
+		return nil, err
+	}
+	signal := make(chan struct{})
+	go func() {
+		defer close(signal)
+		if err := c.watch([]byte(path), nil, rev+1, nil); err != nil {
+			log.Debugf("etcdv3 watch node %s failed: %s", path, err)
+			return
+		}
+		log.Debugf("etcdv3 watch node %s update", path)
+	}()
+	log.Debugf("etcdv3 watch OK")
+	return signal, nil
+}
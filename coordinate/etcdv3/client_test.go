@@ -0,0 +1,697 @@
+package etcdv3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expect := range map[string]string{
+		"/a/":      "/a0",
+		"a\xff":    "b",
+		"\xff\xff": "\x00",
+	} {
+		if got := string(prefixEnd([]byte(prefix))); got != expect {
+			t.Errorf("prefixEnd(%q) = %q, expect %q", prefix, got, expect)
+		}
+	}
+}
+
+func TestInt64s(t *testing.T) {
+	var v struct {
+		A int64s `json:"a"`
+		B int64s `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":"12","b":34}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 12 || v.B != 34 {
+		t.Fatalf("decode = %+v", v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"a":"12","b":"34"}` {
+		t.Fatalf("encode = %s", b)
+	}
+}
+
+// fakeGateway serves the part of the grpc-gateway api used by the client on
+// an in-memory keyspace, every write bumps the revision once.
+type fakeGateway struct {
+	mutex sync.Mutex
+
+	revision int64
+	kvs      map[string]*keyValue
+	events   []*fakeEvent
+	changed  chan struct{}
+
+	leases     map[int64]bool
+	nextLease  int64
+	keepalives int
+}
+
+type fakeEvent struct {
+	Type string    `json:"type"`
+	Kv   *keyValue `json:"kv"`
+}
+
+func newFakeGateway(t *testing.T) (*fakeGateway, *Client, func()) {
+	g := &fakeGateway{
+		kvs: make(map[string]*keyValue), changed: make(chan struct{}),
+		leases: make(map[int64]bool),
+	}
+	s := httptest.NewServer(g)
+	c, err := New(s.Listener.Addr().String(), "", 300*time.Millisecond)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return g, c, func() {
+		c.Close()
+		s.Close()
+	}
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var handle func(body []byte) (interface{}, error)
+	switch strings.TrimPrefix(r.URL.Path, APIPrefix) {
+	case "/kv/range":
+		handle = g.handleRange
+	case "/kv/put":
+		handle = g.handlePut
+	case "/kv/deleterange":
+		handle = g.handleDeleteRange
+	case "/kv/txn":
+		handle = g.handleTxn
+	case "/lease/grant":
+		handle = g.handleGrant
+	case "/lease/revoke":
+		handle = g.handleRevoke
+	case "/lease/keepalive":
+		handle = g.handleKeepAlive
+	case "/watch":
+		g.serveWatch(w, r)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.mutex.Lock()
+	resp, err := handle(body)
+	g.mutex.Unlock()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&gatewayError{Error: err.Error(), Code: 3})
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (g *fakeGateway) header() responseHeader {
+	return responseHeader{Revision: int64s(g.revision)}
+}
+
+func (g *fakeGateway) keys(key, end []byte) []string {
+	if len(end) == 0 {
+		if g.kvs[string(key)] != nil {
+			return []string{string(key)}
+		}
+		return nil
+	}
+	var keys []string
+	for k := range g.kvs {
+		if k >= string(key) && (string(end) == "\x00" || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (g *fakeGateway) handleRange(body []byte) (interface{}, error) {
+	var req rangeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	resp := &rangeResponse{Header: g.header()}
+	for _, k := range g.keys(req.Key, req.RangeEnd) {
+		kv := *g.kvs[k]
+		if req.KeysOnly {
+			kv.Value = nil
+		}
+		resp.Kvs = append(resp.Kvs, &kv)
+	}
+	return resp, nil
+}
+
+func (g *fakeGateway) handlePut(body []byte) (interface{}, error) {
+	var req putRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	g.revision++
+	g.put(&req)
+	g.notify()
+	return map[string]interface{}{"header": g.header()}, nil
+}
+
+func (g *fakeGateway) handleDeleteRange(body []byte) (interface{}, error) {
+	var req deleteRangeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	g.revision++
+	g.deleteRange(&req)
+	g.notify()
+	return map[string]interface{}{"header": g.header()}, nil
+}
+
+func (g *fakeGateway) handleTxn(body []byte) (interface{}, error) {
+	var req txnRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	succeeded := true
+	for _, cmp := range req.Compare {
+		var create, mod int64s
+		if kv := g.kvs[string(cmp.Key)]; kv != nil {
+			create, mod = kv.CreateRevision, kv.ModRevision
+		}
+		if cmp.Result != "EQUAL" {
+			return nil, fmt.Errorf("unsupported compare result %s", cmp.Result)
+		}
+		switch {
+		case cmp.Target == "CREATE" && cmp.CreateRevision != nil:
+			succeeded = succeeded && create == *cmp.CreateRevision
+		case cmp.Target == "MOD" && cmp.ModRevision != nil:
+			succeeded = succeeded && mod == *cmp.ModRevision
+		default:
+			return nil, fmt.Errorf("unsupported compare target %s", cmp.Target)
+		}
+	}
+	ops := req.Success
+	if !succeeded {
+		ops = req.Failure
+	}
+	if len(ops) != 0 {
+		g.revision++
+		for _, op := range ops {
+			switch {
+			case op.RequestPut != nil:
+				if op.RequestPut.Lease != 0 && !g.leases[int64(op.RequestPut.Lease)] {
+					return nil, errors.New("etcdserver: requested lease not found")
+				}
+				g.put(op.RequestPut)
+			case op.RequestDeleteRange != nil:
+				g.deleteRange(op.RequestDeleteRange)
+			}
+		}
+		g.notify()
+	}
+	return &txnResponse{Header: g.header(), Succeeded: succeeded}, nil
+}
+
+func (g *fakeGateway) put(req *putRequest) {
+	kv := &keyValue{
+		Key: req.Key, Value: req.Value, Lease: req.Lease,
+		CreateRevision: int64s(g.revision), ModRevision: int64s(g.revision), Version: 1,
+	}
+	if old := g.kvs[string(req.Key)]; old != nil {
+		kv.CreateRevision, kv.Version = old.CreateRevision, old.Version+1
+	}
+	g.kvs[string(req.Key)] = kv
+	g.events = append(g.events, &fakeEvent{Type: "PUT", Kv: kv})
+}
+
+func (g *fakeGateway) deleteRange(req *deleteRangeRequest) {
+	for _, k := range g.keys(req.Key, req.RangeEnd) {
+		g.remove(k)
+	}
+}
+
+func (g *fakeGateway) remove(k string) {
+	delete(g.kvs, k)
+	g.events = append(g.events, &fakeEvent{
+		Type: "DELETE", Kv: &keyValue{Key: []byte(k), ModRevision: int64s(g.revision)},
+	})
+}
+
+func (g *fakeGateway) notify() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *fakeGateway) handleGrant(body []byte) (interface{}, error) {
+	var req map[string]int64s
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	g.nextLease++
+	g.leases[g.nextLease] = true
+	return &leaseGrantResponse{ID: int64s(g.nextLease), TTL: req["TTL"]}, nil
+}
+
+func (g *fakeGateway) handleRevoke(body []byte) (interface{}, error) {
+	var req map[string]int64s
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	g.expire(int64(req["ID"]))
+	return map[string]interface{}{"header": g.header()}, nil
+}
+
+func (g *fakeGateway) handleKeepAlive(body []byte) (interface{}, error) {
+	var req map[string]int64s
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	g.keepalives++
+	resp := &leaseGrantResponse{ID: req["ID"]}
+	if g.leases[int64(req["ID"])] {
+		resp.TTL = 1
+	}
+	return &leaseKeepAliveResponse{Result: resp}, nil
+}
+
+// expire drops the lease and the keys attached to it, as etcd does once the
+// ttl of the lease elapsed without a keepalive.
+func (g *fakeGateway) expire(lease int64) {
+	if !g.leases[lease] {
+		return
+	}
+	delete(g.leases, lease)
+	g.revision++
+	for _, k := range g.keys([]byte{0}, []byte{0}) {
+		if int64(g.kvs[k].Lease) == lease {
+			g.remove(k)
+		}
+	}
+	g.notify()
+}
+
+func (g *fakeGateway) Expire(lease int64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.expire(lease)
+}
+
+func (g *fakeGateway) Lease(path string) int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if kv := g.kvs[path]; kv != nil {
+		return int64(kv.Lease)
+	}
+	return 0
+}
+
+func (g *fakeGateway) KeepAlives() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.keepalives
+}
+
+// serveWatch streams the events in the range of the watch from its start
+// revision on, until the client goes away.
+func (g *fakeGateway) serveWatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CreateRequest struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			StartRevision int64s `json:"start_revision"`
+		} `json:"create_request"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, end := string(req.CreateRequest.Key), string(req.CreateRequest.RangeEnd)
+	match := func(k string) bool {
+		if end == "" {
+			return k == key
+		}
+		return k >= key && (end == "\x00" || k < end)
+	}
+
+	flusher := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	g.mutex.Lock()
+	encoder.Encode(map[string]interface{}{
+		"result": map[string]interface{}{"header": g.header(), "created": true},
+	})
+	g.mutex.Unlock()
+	flusher.Flush()
+
+	var sent int
+	for {
+		g.mutex.Lock()
+		var events []*fakeEvent
+		for _, e := range g.events[sent:] {
+			if e.Kv.ModRevision >= req.CreateRequest.StartRevision && match(string(e.Kv.Key)) {
+				events = append(events, e)
+			}
+		}
+		sent = len(g.events)
+		header, changed := g.header(), g.changed
+		g.mutex.Unlock()
+
+		if len(events) != 0 {
+			encoder.Encode(map[string]interface{}{
+				"result": map[string]interface{}{"header": header, "events": events},
+			})
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func fired(w <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-w:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestCreateReadList(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	if err := c.Create("/a/b/c", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/a/b/c", []byte("2")); err != ErrNodeExists {
+		t.Fatalf("create twice, err = %v", err)
+	}
+	if err := c.Update("/a/b/d", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/a/b/e", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := c.Read("/a/b/c", true); err != nil || string(data) != "1" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+	if data, err := c.Read("/a/b/e", true); err != nil || data == nil || len(data) != 0 {
+		t.Fatalf("read empty node = %q, %v", data, err)
+	}
+	if _, err := c.Read("/a/b/x", true); err != ErrNoNode {
+		t.Fatalf("read missing node, err = %v", err)
+	}
+	if data, err := c.Read("/a/b/x", false); err != nil || data != nil {
+		t.Fatalf("read missing node = %q, %v", data, err)
+	}
+	if _, err := c.List("/a/b/c", true); err != ErrNotDir {
+		t.Fatalf("list file, err = %v", err)
+	}
+
+	paths, err := c.List("/a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/a/b"}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("list = %v, expect %v", paths, expect)
+	}
+	if paths, err = c.List("/a/b", true); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/a/b/c", "/a/b/d", "/a/b/e"}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("list = %v, expect %v", paths, expect)
+	}
+
+	if err := c.Update("/a/b/c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := c.Read("/a/b/c", true); string(data) != "3" {
+		t.Fatalf("read after update = %q", data)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := c.Read("/a/b/c", false); data != nil {
+		t.Fatalf("read deleted node = %q", data)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatalf("delete missing node, err = %v", err)
+	}
+	if _, err := c.List("/x", true); err != ErrNoNode {
+		t.Fatalf("list missing node, err = %v", err)
+	}
+}
+
+func TestUpdateVersion(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	if _, v, err := c.ReadVersion("/a", false); err != nil || v != 0 {
+		t.Fatalf("read missing node version = %d, %v", v, err)
+	}
+	if _, _, err := c.ReadVersion("/a", true); err != ErrNoNode {
+		t.Fatalf("read missing node version, err = %v", err)
+	}
+	v1, err := c.UpdateVersion("/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("x"), 0); err != types.ErrVersionConflict {
+		t.Fatalf("create existing node, err = %v", err)
+	}
+	v2, err := c.UpdateVersion("/a", []byte("2"), v1)
+	if err != nil || v2 == v1 {
+		t.Fatalf("update = %d, %v", v2, err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("x"), v1); err != types.ErrVersionConflict {
+		t.Fatalf("update with stale version, err = %v", err)
+	}
+	if data, v, err := c.ReadVersion("/a", true); err != nil || v != v2 || string(data) != "2" {
+		t.Fatalf("read = %q, %d, %v", data, v, err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	v1, err := c.UpdateVersion("/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/b", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale op fails the transaction, no op is applied.
+	_, err = c.Txn([]types.Op{
+		{Type: types.OpUpdate, Path: "/a", Data: []byte("2"), Version: v1},
+		{Type: types.OpDelete, Path: "/b", Version: types.AnyVersion},
+		{Type: types.OpUpdate, Path: "/c", Data: []byte("2"), Version: v1},
+	})
+	if err != types.ErrVersionConflict {
+		t.Fatalf("txn with stale version, err = %v", err)
+	}
+	if data, v, _ := c.ReadVersion("/a", true); v != v1 || string(data) != "1" {
+		t.Fatalf("read after failed txn = %q, %d", data, v)
+	}
+	if data, _ := c.Read("/b", false); data == nil {
+		t.Fatal("node deleted by failed txn")
+	}
+
+	versions, err := c.Txn([]types.Op{
+		{Type: types.OpUpdate, Path: "/a", Data: []byte("2"), Version: v1},
+		{Type: types.OpDelete, Path: "/b", Version: types.AnyVersion},
+		{Type: types.OpUpdate, Path: "/c", Data: []byte("2"), Version: 0},
+		{Type: types.OpUpdate, Path: "/d", Data: []byte("2"), Version: types.AnyVersion},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[1] != 0 || versions[0] != versions[2] || versions[0] != versions[3] {
+		t.Fatalf("txn versions = %v", versions)
+	}
+	for _, path := range []string{"/a", "/c", "/d"} {
+		if data, v, _ := c.ReadVersion(path, true); v != versions[0] || string(data) != "2" {
+			t.Fatalf("read %s after txn = %q, %d, expect version %d", path, data, v, versions[0])
+		}
+	}
+	if data, _ := c.Read("/b", false); data != nil {
+		t.Fatal("node not deleted by txn")
+	}
+
+	if _, err := c.Txn([]types.Op{{Type: types.OpType(-1), Path: "/a"}}); err == nil {
+		t.Fatal("txn with an invalid op")
+	}
+}
+
+func TestEphemeral(t *testing.T) {
+	g, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	w, err := c.CreateEphemeral("/topom", []byte("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateEphemeral("/topom", nil); err != ErrNodeExists {
+		t.Fatalf("create ephemeral twice, err = %v", err)
+	}
+	lease := g.Lease("/topom")
+	if lease == 0 {
+		t.Fatal("ephemeral node without a lease")
+	}
+
+	// the lease is kept alive as long as the node lives.
+	deadline := time.Now().Add(2 * time.Second)
+	for g.KeepAlives() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("lease of the ephemeral node not kept alive")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if fired(w, 0) {
+		t.Fatal("watch fired while the lease is alive")
+	}
+
+	g.Expire(lease)
+	if !fired(w, time.Second) {
+		t.Fatal("watch not fired after lease expired")
+	}
+	if data, _ := c.Read("/topom", false); data != nil {
+		t.Fatalf("ephemeral node still exists: %q", data)
+	}
+
+	if w, err = c.CreateEphemeral("/topom", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if !fired(w, time.Second) {
+		t.Fatal("watch not fired after client closed")
+	}
+}
+
+func TestCloseRevokesLeases(t *testing.T) {
+	g, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	if _, err := c.CreateEphemeral("/topom", []byte("t")); err != nil {
+		t.Fatal(err)
+	}
+	_, node, err := c.CreateEphemeralInOrder("/gslb", []byte("g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes are gone right after the close, not at the end of the ttl.
+	c.Close()
+	for _, path := range []string{"/topom", node} {
+		if g.Lease(path) != 0 {
+			t.Fatalf("ephemeral node %s survives the close", path)
+		}
+	}
+}
+
+func TestWatchInOrder(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	w, paths, err := c.WatchInOrder("/events")
+	if err != nil || len(paths) != 0 {
+		t.Fatalf("watch = %v, %v", paths, err)
+	}
+	_, p0, err := c.CreateEphemeralInOrder("/events", []byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fired(w, time.Second) {
+		t.Fatal("watch not fired after create")
+	}
+
+	if w, _, err = c.WatchInOrder("/events"); err != nil {
+		t.Fatal(err)
+	}
+	// an update of a child doesn't change the children of the dir.
+	if err := c.Update(p0, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if fired(w, 200*time.Millisecond) {
+		t.Fatal("watch fired after update")
+	}
+	_, p1, err := c.CreateEphemeralInOrder("/events", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fired(w, time.Second) {
+		t.Fatal("watch not fired after create")
+	}
+
+	if _, paths, err = c.WatchInOrder("/events"); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{p0, p1}; !reflect.DeepEqual(paths, expect) || p0 >= p1 {
+		t.Fatalf("watch = %v, expect %v", paths, expect)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	for _, op := range []func() error{
+		func() error { return c.Create("/a/b", []byte("1")) },
+		func() error { return c.Update("/a/b", []byte("2")) },
+		func() error { return c.Delete("/a/b") },
+	} {
+		w, err := c.Watch("/a/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a change of another key doesn't fire the watch.
+		if err := c.Update("/a/bc", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if fired(w, 100*time.Millisecond) {
+			t.Fatal("watch fired by another key")
+		}
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+		if !fired(w, time.Second) {
+			t.Fatal("watch not fired")
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	_, c, cleanup := newFakeGateway(t)
+	defer cleanup()
+
+	c.Close()
+	if err := c.Create("/a", nil); err != ErrClosedClient {
+		t.Fatalf("create on closed client, err = %v", err)
+	}
+	if _, err := c.Watch("/a"); err != ErrClosedClient {
+		t.Fatalf("watch on closed client, err = %v", err)
+	}
+}
@@ -0,0 +1,686 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// Client stores the coordinator tree in a local directory, which is enough
+// for a single-node setup. Like etcd v2, a node is either a file holding the
+// data or a directory holding the children, so the tree can be inspected and
+// edited by hand. Watches poll the directory, edits made by other processes
+// are noticed within PollInterval.
+//
+// The version of a node is a hash of its data, it needs no extra file and
+// survives hand edits. Versioned updates are only atomic within the process.
+//
+// An ephemeral node has a hidden owner file next to it, holding the pid and
+// the session of the client which created it. A client removes its ephemeral
+// nodes on Close, the ones left by a crashed client are expired once the
+// store is opened again.
+
+var ErrClosedClient = errors.New("use of closed filesystem client")
+
+var (
+	ErrNoNode     = errors.New("filesystem: node does not exist")
+	ErrNodeExists = errors.New("filesystem: node already exists")
+	ErrNotDir     = errors.New("filesystem: not a dir")
+	ErrNotFile    = errors.New("filesystem: not a file")
+	ErrNotEmpty   = errors.New("filesystem: dir not empty")
+)
+
+var PollInterval = time.Second
+
+type Client struct {
+	sync.Mutex
+	root    string
+	session string
+
+	closed     bool
+	ephemerals map[string]bool
+	done       chan struct{}
+}
+
+// sessions holds the sessions of the clients of the process not closed yet.
+var sessions struct {
+	sync.Mutex
+	next int64
+	live map[string]bool
+}
+
+const ownerPrefix = ".ephemeral-"
+
+func New(root string) (*Client, error) {
+	if root == "" {
+		return nil, errors.New("invalid root dir")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	c := &Client{
+		root:       root,
+		ephemerals: make(map[string]bool),
+		done:       make(chan struct{}),
+	}
+
+	sessions.Lock()
+	if sessions.live == nil {
+		sessions.live = make(map[string]bool)
+	}
+	sessions.next++
+	c.session = fmt.Sprintf("%d-%d", time.Now().UnixNano(), sessions.next)
+	sessions.live[c.session] = true
+	sessions.Unlock()
+
+	if err := c.expireEphemerals(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// alive tells if the owner of an ephemeral node, the pid and the session of
+// its client, is still running.
+func alive(pid int, session string) bool {
+	if pid == os.Getpid() {
+		sessions.Lock()
+		defer sessions.Unlock()
+		return sessions.live[session]
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// expireEphemerals removes the ephemeral nodes whose owner is gone.
+func (c *Client) expireEphemerals() error {
+	return filepath.Walk(c.root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasPrefix(info.Name(), ownerPrefix) {
+			return nil
+		}
+		owner, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(owner))
+		if len(fields) == 2 {
+			if pid, err := strconv.Atoi(fields[0]); err == nil && alive(pid, fields[1]) {
+				return nil
+			}
+		}
+		node := filepath.Join(filepath.Dir(file), strings.TrimPrefix(info.Name(), ownerPrefix))
+		log.Warnf("filesystem expire ephemeral node %s, owner [%s] is gone",
+			filepath.ToSlash(strings.TrimPrefix(node, c.root)), strings.TrimSpace(string(owner)))
+		if info, err := os.Stat(node); err == nil && !info.IsDir() {
+			if err := os.Remove(node); err != nil {
+				return err
+			}
+		}
+		return os.Remove(file)
+	})
+}
+
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	for p := range c.ephemerals {
+		if err := c.delete(p); err != nil {
+			log.Warnf("filesystem remove ephemeral node %s failed: %s", p, err)
+		}
+	}
+	c.closed = true
+	close(c.done)
+
+	sessions.Lock()
+	delete(sessions.live, c.session)
+	sessions.Unlock()
+	return nil
+}
+
+// cleanPath normalizes the path at the api boundary, every path used below,
+// the keys of the ephemerals included, is a clean one.
+func cleanPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("filesystem: invalid path %s", p)
+	}
+	return path.Clean(p), nil
+}
+
+func (c *Client) realPath(p string) string {
+	return filepath.Join(c.root, filepath.FromSlash(p))
+}
+
+func (c *Client) ownerPath(p string) string {
+	file := c.realPath(p)
+	return filepath.Join(filepath.Dir(file), ownerPrefix+filepath.Base(file))
+}
+
+// writeEphemeral writes the node along with its owner file.
+func (c *Client) writeEphemeral(p string, data []byte) error {
+	if err := c.write(p, data, true); err != nil {
+		return err
+	}
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), c.session)
+	if err := ioutil.WriteFile(c.ownerPath(p), []byte(owner), 0644); err != nil {
+		os.Remove(c.realPath(p))
+		return err
+	}
+	c.ephemerals[p] = true
+	return nil
+}
+
+func (c *Client) write(p string, data []byte, exclusive bool) error {
+	file := c.realPath(p)
+	if info, err := os.Stat(file); err == nil {
+		if info.IsDir() {
+			return ErrNotFile
+		}
+		if exclusive {
+			return ErrNodeExists
+		}
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// write to a hidden temporary file and rename it, so readers never see
+	// a partially written node.
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (c *Client) delete(p string) error {
+	file := c.realPath(p)
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() {
+		names, err := c.names(file)
+		if err != nil {
+			return err
+		}
+		if len(names) != 0 {
+			return ErrNotEmpty
+		}
+	}
+	if c.ephemerals[p] {
+		delete(c.ephemerals, p)
+		os.Remove(c.ownerPath(p))
+	}
+	return os.RemoveAll(file)
+}
+
+// names returns the visible entries of the dir, temporary files are hidden.
+func (c *Client) names(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *Client) read(p string, must bool) ([]byte, error) {
+	file := c.realPath(p)
+	info, err := os.Stat(file)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		if must {
+			return nil, ErrNoNode
+		}
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case info.IsDir():
+		return nil, ErrNotFile
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+func version(data []byte) int64 {
+	if data == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	if v := int64(h.Sum64() >> 1); v != 0 {
+		return v
+	}
+	return 1
+}
+
+func (c *Client) list(p string, must bool) ([]string, error) {
+	file := c.realPath(p)
+	info, err := os.Stat(file)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		if must {
+			return nil, ErrNoNode
+		}
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return nil, ErrNotDir
+	}
+	names, err := c.names(file)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, name := range names {
+		paths = append(paths, path.Join(p, name))
+	}
+	return paths, nil
+}
+
+func (c *Client) Mkdir(p string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	p, err := cleanPath(p)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(c.realPath(p), 0755)
+}
+
+func (c *Client) Create(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return err
+	}
+	log.Debugf("filesystem create node %s", path)
+	if err := c.write(path, data, true); err != nil {
+		log.Debugf("filesystem create node %s failed: %s", path, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) Update(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return err
+	}
+	log.Debugf("filesystem update node %s", path)
+	if err := c.write(path, data, false); err != nil {
+		log.Debugf("filesystem update node %s failed: %s", path, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) Delete(path string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return err
+	}
+	log.Debugf("filesystem delete node %s", path)
+	if err := c.delete(path); err != nil {
+		log.Debugf("filesystem delete node %s failed: %s", path, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) Read(path string, must bool) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.read(path, must)
+}
+
+func (c *Client) ReadVersion(path string, must bool) ([]byte, int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := c.read(path, must)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, version(data), nil
+}
+
+func (c *Client) UpdateVersion(path string, data []byte, v int64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return 0, err
+	}
+	log.Debugf("filesystem update-version node %s version %d", path, v)
+	old, err := c.read(path, false)
+	if err != nil {
+		log.Debugf("filesystem update-version node %s failed: %s", path, err)
+		return 0, err
+	}
+	if version(old) != v {
+		log.Debugf("filesystem update-version node %s failed: version conflict", path)
+		return 0, types.ErrVersionConflict
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	if err := c.write(path, data, v == 0); err != nil {
+		log.Debugf("filesystem update-version node %s failed: %s", path, err)
+		return 0, err
+	}
+	return version(data), nil
+}
+
+// Txn checks every op before applying any, the ops are atomic within the
+// process but a crash may leave a part of them applied.
+func (c *Client) Txn(ops []types.Op) ([]int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	ops = append([]types.Op(nil), ops...)
+	for i := range ops {
+		p, err := cleanPath(ops[i].Path)
+		if err != nil {
+			return nil, err
+		}
+		ops[i].Path = p
+	}
+	for _, op := range ops {
+		var data []byte
+		var err error
+		switch op.Type {
+		case types.OpUpdate:
+			data, err = c.read(op.Path, false)
+		case types.OpDelete:
+			if data, err = c.read(op.Path, false); err == ErrNotFile {
+				var paths []string
+				if paths, err = c.list(op.Path, false); err == nil && len(paths) != 0 {
+					err = ErrNotEmpty
+				}
+			}
+		default:
+			err = fmt.Errorf("filesystem: invalid op %s", op.String())
+		}
+		if err != nil {
+			log.Debugf("filesystem txn %s failed: %s", op.String(), err)
+			return nil, err
+		}
+		if op.Version != types.AnyVersion && op.Version != version(data) {
+			log.Debugf("filesystem txn %s failed: version conflict", op.String())
+			return nil, types.ErrVersionConflict
+		}
+	}
+	versions := make([]int64, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case types.OpUpdate:
+			data := op.Data
+			if data == nil {
+				data = []byte{}
+			}
+			if err := c.write(op.Path, data, false); err != nil {
+				log.Warnf("filesystem txn %s failed, partially applied: %s", op.String(), err)
+				return nil, err
+			}
+			versions[i] = version(data)
+		case types.OpDelete:
+			if err := c.delete(op.Path); err != nil {
+				log.Warnf("filesystem txn %s failed, partially applied: %s", op.String(), err)
+				return nil, err
+			}
+		}
+	}
+	return versions, nil
+}
+
+func (c *Client) List(path string, must bool) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.list(path, must)
+}
+
+// poll closes the signal once the probe returns something different from
+// origin, or the client is closed.
+func (c *Client) poll(origin interface{}, probe func() (interface{}, bool)) <-chan struct{} {
+	signal := make(chan struct{})
+	interval := PollInterval
+	go func() {
+		defer close(signal)
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(interval):
+			}
+			if v, ok := probe(); !ok || !equal(origin, v) {
+				return
+			}
+		}
+	}()
+	return signal
+}
+
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case int64:
+		b, ok := b.(int64)
+		return ok && a == b
+	}
+	return false
+}
+
+func (c *Client) WatchInOrder(path string) (<-chan struct{}, []string, error) {
+	if err := c.Mkdir(path); err != nil {
+		return nil, nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, err := c.list(path, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	signal := c.poll(strings.Join(paths, "\n"), func() (interface{}, bool) {
+		c.Lock()
+		defer c.Unlock()
+		paths, err := c.list(path, true)
+		return strings.Join(paths, "\n"), err == nil
+	})
+	return signal, paths, nil
+}
+
+func (c *Client) watchFile(path string, data []byte) <-chan struct{} {
+	return c.poll(data, func() (interface{}, bool) {
+		c.Lock()
+		defer c.Unlock()
+		data, err := c.read(path, true)
+		return data, err == nil
+	})
+}
+
+func (c *Client) Watch(path string) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.read(path, false)
+	if err != nil {
+		return nil, err
+	}
+	signal := c.poll(version(data), func() (interface{}, bool) {
+		c.Lock()
+		defer c.Unlock()
+		data, err := c.read(path, false)
+		return version(data), err == nil
+	})
+	return signal, nil
+}
+
+func (c *Client) CreateEphemeral(path string, data []byte) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("filesystem create-ephemeral node %s", path)
+	if err := c.writeEphemeral(path, data); err != nil {
+		log.Debugf("filesystem create-ephemeral node %s failed: %s", path, err)
+		return nil, err
+	}
+	return c.watchFile(path, data), nil
+}
+
+func (c *Client) CreateEphemeralInOrder(p string, data []byte) (<-chan struct{}, string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, "", ErrClosedClient
+	}
+	p, err := cleanPath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Debugf("filesystem create-ephemeral-inorder node %s", p)
+	paths, err := c.list(p, false)
+	if err != nil {
+		return nil, "", err
+	}
+	var seq int64
+	for _, v := range paths {
+		var n int64
+		if _, err := fmt.Sscanf(path.Base(v), "%d", &n); err == nil && n >= seq {
+			seq = n + 1
+		}
+	}
+	node := path.Join(p, fmt.Sprintf("%020d", seq))
+	if err := c.writeEphemeral(node, data); err != nil {
+		log.Debugf("filesystem create-ephemeral-inorder node %s failed: %s", p, err)
+		return nil, "", err
+	}
+	return c.watchFile(node, data), node, nil
+}
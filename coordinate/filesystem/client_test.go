@@ -0,0 +1,278 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+)
+
+func newTestClient(t *testing.T) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "pikamgr-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCreateReadList(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	if err := c.Create("/a/b/c", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/a/b/c", []byte("2")); err != ErrNodeExists {
+		t.Fatalf("create twice, err = %v", err)
+	}
+	if err := c.Update("/a/b/d", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Read("/a/b/c", true)
+	if err != nil || string(data) != "1" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+	if _, err := c.Read("/a/b", true); err != ErrNotFile {
+		t.Fatalf("read dir, err = %v", err)
+	}
+	if _, err := c.List("/a/b/c", true); err != ErrNotDir {
+		t.Fatalf("list file, err = %v", err)
+	}
+	if _, err := c.Read("/a/b/x", true); err != ErrNoNode {
+		t.Fatalf("read missing node, err = %v", err)
+	}
+
+	paths, err := c.List("/a/b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/a/b/c", "/a/b/d"}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("list = %v, expect %v", paths, expect)
+	}
+
+	if err := c.Delete("/a/b"); err != ErrNotEmpty {
+		t.Fatalf("delete non-empty dir, err = %v", err)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatalf("delete missing node, err = %v", err)
+	}
+}
+
+func TestEphemeral(t *testing.T) {
+	defer func(d time.Duration) { PollInterval = d }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	w, err := c.CreateEphemeral("/topom", []byte("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update("/topom", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Fatal("watch not fired after update")
+	}
+
+	other, err := New(c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.CreateEphemeral("/lock", nil); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if data, _ := c.Read("/lock", false); data != nil {
+		t.Fatalf("ephemeral node still exists after close")
+	}
+}
+
+func TestWatchInOrder(t *testing.T) {
+	defer func(d time.Duration) { PollInterval = d }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	w, paths, err := c.WatchInOrder("/events")
+	if err != nil || len(paths) != 0 {
+		t.Fatalf("watch = %v, %v", paths, err)
+	}
+	_, p0, err := c.CreateEphemeralInOrder("/events", []byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Fatal("watch not fired after create")
+	}
+	_, p1, err := c.CreateEphemeralInOrder("/events", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, paths, err = c.WatchInOrder("/events"); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{p0, p1}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("watch = %v, expect %v", paths, expect)
+	}
+}
+
+func TestUpdateVersion(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	v1, err := c.UpdateVersion("/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("x"), 0); err != types.ErrVersionConflict {
+		t.Fatalf("create existing node, err = %v", err)
+	}
+	// an edit made by hand changes the version too.
+	if err := ioutil.WriteFile(c.root+"/a", []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("2"), v1); err != types.ErrVersionConflict {
+		t.Fatalf("update with stale version, err = %v", err)
+	}
+	data, v, err := c.ReadVersion("/a", true)
+	if err != nil || string(data) != "edited" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("2"), v); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(d time.Duration) { PollInterval = d }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+	w, err := c.Watch("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Fatal("watch not fired after create")
+	}
+}
+
+func TestExpireEphemerals(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	if _, err := c.CreateEphemeral("/live", []byte("l")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client of the process gone without Close.
+	crashed, err := New(c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crashed.CreateEphemeral("/topom", []byte("t")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := crashed.CreateEphemeralInOrder("/events", []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	sessions.Lock()
+	delete(sessions.live, crashed.session)
+	sessions.Unlock()
+
+	// a client of another process, which exited.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/lock", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	owner := fmt.Sprintf("%d %s\n", cmd.Process.Pid, "0-1")
+	if err := ioutil.WriteFile(c.ownerPath("/lock"), []byte(owner), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopen, err := New(c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopen.Close()
+	for _, p := range []string{"/topom", "/lock"} {
+		if data, _ := reopen.Read(p, false); data != nil {
+			t.Fatalf("ephemeral node %s not expired", p)
+		}
+	}
+	if paths, _ := reopen.List("/events", false); len(paths) != 0 {
+		t.Fatalf("ephemeral nodes %v not expired", paths)
+	}
+	if data, _ := reopen.Read("/live", false); string(data) != "l" {
+		t.Fatal("ephemeral node of a live client expired")
+	}
+	if names, _ := ioutil.ReadDir(c.root); len(names) != 3 {
+		t.Fatalf("owner files left %d entries", len(names))
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	if err := c.Create("/a//b/../c/", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read("/a/c", true); err != nil || string(data) != "1" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+	if paths, err := c.List("/a/./", true); err != nil || !reflect.DeepEqual(paths, []string{"/a/c"}) {
+		t.Fatalf("list = %v, %v", paths, err)
+	}
+	if _, err := c.Read("a/c", true); err == nil {
+		t.Fatal("read a relative path")
+	}
+
+	other, err := New(c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.CreateEphemeral("//lock/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Txn([]types.Op{{Type: types.OpDelete, Path: "/./lock", Version: types.AnyVersion}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.CreateEphemeral("/lock", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if data, _ := c.Read("/lock", false); data != nil {
+		t.Fatalf("ephemeral node still exists after close")
+	}
+	if _, err := os.Stat(other.ownerPath("/lock")); !os.IsNotExist(err) {
+		t.Fatalf("owner file still exists after close, err = %v", err)
+	}
+}
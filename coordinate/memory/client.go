@@ -0,0 +1,478 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+)
+
+// Client is an in-memory coordinator with the semantics of zookeeper: nodes
+// may have both data and children, ephemeral nodes belong to the session of
+// the client which created them, and watches fire once.
+//
+// Clients created by NewSession share the tree of their parent, so several
+// dashboards or external writers can be simulated in one process.
+
+var ErrClosedClient = errors.New("use of closed memory client")
+
+var (
+	ErrNoNode     = errors.New("memory: node does not exist")
+	ErrNodeExists = errors.New("memory: node already exists")
+	ErrNotEmpty   = errors.New("memory: node has children")
+	ErrBadPath    = errors.New("memory: invalid path")
+)
+
+type node struct {
+	data     []byte
+	version  int64
+	children map[string]*node
+	owner    *Client
+	sequence int
+
+	dataWatches  []chan struct{}
+	childWatches []chan struct{}
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+func fire(watches []chan struct{}) {
+	for _, w := range watches {
+		close(w)
+	}
+}
+
+type store struct {
+	sync.Mutex
+	root *node
+
+	// watches of missing nodes, fired when the node is created.
+	existWatches map[string][]chan struct{}
+}
+
+type Client struct {
+	store *store
+
+	closed     bool
+	ephemerals map[string]bool
+}
+
+func New() *Client {
+	return &Client{
+		store:      &store{root: newNode(), existWatches: make(map[string][]chan struct{})},
+		ephemerals: make(map[string]bool),
+	}
+}
+
+// NewSession returns a new client sharing the same tree.
+func (c *Client) NewSession() *Client {
+	return &Client{
+		store:      c.store,
+		ephemerals: make(map[string]bool),
+	}
+}
+
+func split(p string) ([]string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, ErrBadPath
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return nil, nil
+	}
+	return strings.Split(p[1:], "/"), nil
+}
+
+func (c *Client) lookup(p string) (*node, *node, string, error) {
+	names, err := split(p)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(names) == 0 {
+		return c.store.root, nil, "", nil
+	}
+	parent := c.store.root
+	for _, name := range names[:len(names)-1] {
+		if parent = parent.children[name]; parent == nil {
+			return nil, nil, "", nil
+		}
+	}
+	name := names[len(names)-1]
+	return parent.children[name], parent, name, nil
+}
+
+func (c *Client) mkdir(p string) (*node, error) {
+	names, err := split(p)
+	if err != nil {
+		return nil, err
+	}
+	n, p := c.store.root, ""
+	for _, name := range names {
+		p += "/" + name
+		child := n.children[name]
+		if child == nil {
+			child = newNode()
+			child.version = 1
+			n.children[name] = child
+			fire(n.childWatches)
+			n.childWatches = nil
+			fire(c.store.existWatches[p])
+			delete(c.store.existWatches, p)
+		}
+		n = child
+	}
+	return n, nil
+}
+
+func (c *Client) create(p string, data []byte, owner *Client) error {
+	names, err := split(p)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return ErrNodeExists
+	}
+	parent, err := c.mkdir(path.Dir(path.Clean(p)))
+	if err != nil {
+		return err
+	}
+	name := names[len(names)-1]
+	if parent.children[name] != nil {
+		return ErrNodeExists
+	}
+	n := newNode()
+	n.data = append([]byte{}, data...)
+	n.version = 1
+	n.owner = owner
+	parent.children[name] = n
+	fire(parent.childWatches)
+	parent.childWatches = nil
+	fire(c.store.existWatches[path.Clean(p)])
+	delete(c.store.existWatches, path.Clean(p))
+	if owner != nil {
+		owner.ephemerals[path.Clean(p)] = true
+	}
+	return nil
+}
+
+func (c *Client) delete(p string) error {
+	n, parent, name, err := c.lookup(p)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return nil
+	}
+	if parent == nil {
+		return ErrBadPath
+	}
+	if len(n.children) != 0 {
+		return ErrNotEmpty
+	}
+	delete(parent.children, name)
+	fire(n.dataWatches)
+	n.dataWatches = nil
+	fire(n.childWatches)
+	n.childWatches = nil
+	fire(parent.childWatches)
+	parent.childWatches = nil
+	if n.owner != nil {
+		delete(n.owner.ephemerals, path.Clean(p))
+	}
+	return nil
+}
+
+func (c *Client) Close() error {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.expire()
+	return nil
+}
+
+// Expire drops every ephemeral node of the session, like an expired
+// zookeeper session, the client itself is still usable.
+func (c *Client) Expire() {
+	c.store.Lock()
+	defer c.store.Unlock()
+	c.expire()
+}
+
+func (c *Client) expire() {
+	paths := make([]string, 0, len(c.ephemerals))
+	for p := range c.ephemerals {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		c.delete(p)
+	}
+}
+
+func (c *Client) Mkdir(path string) error {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	_, err := c.mkdir(path)
+	return err
+}
+
+func (c *Client) Create(path string, data []byte) error {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	return c.create(path, data, nil)
+}
+
+func (c *Client) Update(path string, data []byte) error {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	n, _, _, err := c.lookup(path)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return c.create(path, data, nil)
+	}
+	n.data = append([]byte{}, data...)
+	n.version++
+	fire(n.dataWatches)
+	n.dataWatches = nil
+	return nil
+}
+
+func (c *Client) Delete(path string) error {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return ErrClosedClient
+	}
+	return c.delete(path)
+}
+
+func (c *Client) Read(path string, must bool) ([]byte, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	n, _, _, err := c.lookup(path)
+	switch {
+	case err != nil:
+		return nil, err
+	case n != nil:
+		return append([]byte{}, n.data...), nil
+	case must:
+		return nil, ErrNoNode
+	default:
+		return nil, nil
+	}
+}
+
+func (c *Client) ReadVersion(path string, must bool) ([]byte, int64, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosedClient
+	}
+	n, _, _, err := c.lookup(path)
+	switch {
+	case err != nil:
+		return nil, 0, err
+	case n != nil:
+		return append([]byte{}, n.data...), n.version, nil
+	case must:
+		return nil, 0, ErrNoNode
+	default:
+		return nil, 0, nil
+	}
+}
+
+func (c *Client) UpdateVersion(path string, data []byte, version int64) (int64, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return 0, ErrClosedClient
+	}
+	n, _, _, err := c.lookup(path)
+	switch {
+	case err != nil:
+		return 0, err
+	case n == nil && version == 0:
+		return 1, c.create(path, data, nil)
+	case n == nil || n.version != version:
+		return 0, types.ErrVersionConflict
+	}
+	n.data = append([]byte{}, data...)
+	n.version++
+	fire(n.dataWatches)
+	n.dataWatches = nil
+	return n.version, nil
+}
+
+// Txn checks every op before applying any, so it's atomic.
+func (c *Client) Txn(ops []types.Op) ([]int64, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	for _, op := range ops {
+		if op.Type != types.OpUpdate && op.Type != types.OpDelete {
+			return nil, fmt.Errorf("memory: invalid op %s", op.String())
+		}
+		n, parent, _, err := c.lookup(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		var version int64
+		if n != nil {
+			version = n.version
+		}
+		if op.Version != types.AnyVersion && op.Version != version {
+			return nil, types.ErrVersionConflict
+		}
+		if op.Type == types.OpDelete && n != nil {
+			if parent == nil {
+				return nil, ErrBadPath
+			}
+			if len(n.children) != 0 {
+				return nil, ErrNotEmpty
+			}
+		}
+	}
+	versions := make([]int64, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case types.OpUpdate:
+			n, _, _, _ := c.lookup(op.Path)
+			if n == nil {
+				c.create(op.Path, op.Data, nil)
+				versions[i] = 1
+				continue
+			}
+			n.data = append([]byte{}, op.Data...)
+			n.version++
+			fire(n.dataWatches)
+			n.dataWatches = nil
+			versions[i] = n.version
+		case types.OpDelete:
+			c.delete(op.Path)
+		}
+	}
+	return versions, nil
+}
+
+func (n *node) list(p string) []string {
+	paths := make([]string, 0, len(n.children))
+	for name := range n.children {
+		paths = append(paths, path.Join(p, name))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (c *Client) List(path string, must bool) ([]string, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	n, _, _, err := c.lookup(path)
+	switch {
+	case err != nil:
+		return nil, err
+	case n != nil:
+		return n.list(path), nil
+	case must:
+		return nil, ErrNoNode
+	default:
+		return nil, nil
+	}
+}
+
+func (c *Client) WatchInOrder(path string) (<-chan struct{}, []string, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosedClient
+	}
+	n, err := c.mkdir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	signal := make(chan struct{})
+	n.childWatches = append(n.childWatches, signal)
+	return signal, n.list(path), nil
+}
+
+func (c *Client) Watch(p string) (<-chan struct{}, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	n, _, _, err := c.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	signal := make(chan struct{})
+	if n == nil {
+		p = path.Clean(p)
+		c.store.existWatches[p] = append(c.store.existWatches[p], signal)
+	} else {
+		n.dataWatches = append(n.dataWatches, signal)
+	}
+	return signal, nil
+}
+
+func (c *Client) CreateEphemeral(path string, data []byte) (<-chan struct{}, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	if err := c.create(path, data, c); err != nil {
+		return nil, err
+	}
+	n, _, _, _ := c.lookup(path)
+	signal := make(chan struct{})
+	n.dataWatches = append(n.dataWatches, signal)
+	return signal, nil
+}
+
+func (c *Client) CreateEphemeralInOrder(path string, data []byte) (<-chan struct{}, string, error) {
+	c.store.Lock()
+	defer c.store.Unlock()
+	if c.closed {
+		return nil, "", ErrClosedClient
+	}
+	parent, err := c.mkdir(path)
+	if err != nil {
+		return nil, "", err
+	}
+	node := fmt.Sprintf("%s/%010d", strings.TrimSuffix(path, "/"), parent.sequence)
+	parent.sequence++
+	if err := c.create(node, data, c); err != nil {
+		return nil, "", err
+	}
+	n, _, _, _ := c.lookup(node)
+	signal := make(chan struct{})
+	n.dataWatches = append(n.dataWatches, signal)
+	return signal, node, nil
+}
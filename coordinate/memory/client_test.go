@@ -0,0 +1,223 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+)
+
+func TestCreateReadList(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	if err := c.Create("/a/b/c", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/a/b/c", []byte("2")); err != ErrNodeExists {
+		t.Fatalf("create twice, err = %v", err)
+	}
+	if err := c.Update("/a/b/d", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Read("/a/b/c", true)
+	if err != nil || string(data) != "1" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+	if _, err := c.Read("/a/b/x", true); err != ErrNoNode {
+		t.Fatalf("read missing node, err = %v", err)
+	}
+	if data, err := c.Read("/a/b/x", false); err != nil || data != nil {
+		t.Fatalf("read missing node = %q, %v", data, err)
+	}
+
+	paths, err := c.List("/a/b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/a/b/c", "/a/b/d"}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("list = %v, expect %v", paths, expect)
+	}
+
+	if err := c.Delete("/a/b"); err != ErrNotEmpty {
+		t.Fatalf("delete non-empty node, err = %v", err)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("/a/b/c"); err != nil {
+		t.Fatalf("delete missing node, err = %v", err)
+	}
+}
+
+func TestEphemeral(t *testing.T) {
+	c := New()
+	defer c.Close()
+	s := c.NewSession()
+
+	w, err := s.CreateEphemeral("/topom", []byte("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateEphemeral("/topom", nil); err != ErrNodeExists {
+		t.Fatalf("create ephemeral twice, err = %v", err)
+	}
+
+	s.Expire()
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Fatal("watch not fired after session expired")
+	}
+	if data, _ := c.Read("/topom", false); data != nil {
+		t.Fatalf("ephemeral node still exists: %q", data)
+	}
+	if _, err := c.CreateEphemeral("/topom", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchInOrder(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	w, paths, err := c.WatchInOrder("/events")
+	if err != nil || len(paths) != 0 {
+		t.Fatalf("watch = %v, %v", paths, err)
+	}
+
+	_, p0, err := c.CreateEphemeralInOrder("/events", []byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w:
+	case <-time.After(time.Second):
+		t.Fatal("watch not fired after create")
+	}
+
+	_, p1, err := c.CreateEphemeralInOrder("/events", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, paths, err = c.WatchInOrder("/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{p0, p1}; !reflect.DeepEqual(paths, expect) {
+		t.Fatalf("watch = %v, expect %v", paths, expect)
+	}
+}
+
+func TestUpdateVersion(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	if _, v, err := c.ReadVersion("/a", false); err != nil || v != 0 {
+		t.Fatalf("read missing node version = %d, %v", v, err)
+	}
+	v1, err := c.UpdateVersion("/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("x"), 0); err != types.ErrVersionConflict {
+		t.Fatalf("create existing node, err = %v", err)
+	}
+	v2, err := c.UpdateVersion("/a", []byte("2"), v1)
+	if err != nil || v2 == v1 {
+		t.Fatalf("update = %d, %v", v2, err)
+	}
+	if _, err := c.UpdateVersion("/a", []byte("x"), v1); err != types.ErrVersionConflict {
+		t.Fatalf("update with stale version, err = %v", err)
+	}
+	if data, v, err := c.ReadVersion("/a", true); err != nil || v != v2 || string(data) != "2" {
+		t.Fatalf("read = %q, %d, %v", data, v, err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	v1, err := c.UpdateVersion("/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create("/b", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale op fails the transaction, no op is applied.
+	_, err = c.Txn([]types.Op{
+		{Type: types.OpUpdate, Path: "/a", Data: []byte("2"), Version: v1},
+		{Type: types.OpDelete, Path: "/b", Version: types.AnyVersion},
+		{Type: types.OpUpdate, Path: "/c", Data: []byte("2"), Version: v1},
+	})
+	if err != types.ErrVersionConflict {
+		t.Fatalf("txn with stale version, err = %v", err)
+	}
+	if data, v, _ := c.ReadVersion("/a", true); v != v1 || string(data) != "1" {
+		t.Fatalf("read after failed txn = %q, %d", data, v)
+	}
+	if data, _ := c.Read("/b", false); data == nil {
+		t.Fatal("node deleted by failed txn")
+	}
+
+	versions, err := c.Txn([]types.Op{
+		{Type: types.OpUpdate, Path: "/a", Data: []byte("2"), Version: v1},
+		{Type: types.OpDelete, Path: "/b", Version: types.AnyVersion},
+		{Type: types.OpUpdate, Path: "/c", Data: []byte("2"), Version: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, v, _ := c.ReadVersion("/a", true); v != versions[0] || string(data) != "2" {
+		t.Fatalf("read after txn = %q, %d, expect version %d", data, v, versions[0])
+	}
+	if data, _ := c.Read("/b", false); data != nil {
+		t.Fatal("node not deleted by txn")
+	}
+	if data, v, _ := c.ReadVersion("/c", true); v != versions[2] || string(data) != "2" {
+		t.Fatalf("read after txn = %q, %d, expect version %d", data, v, versions[2])
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	fired := func(w <-chan struct{}) bool {
+		select {
+		case <-w:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	for _, op := range []func() error{
+		func() error { return c.Create("/a/b", []byte("1")) },
+		func() error { return c.Update("/a/b", []byte("2")) },
+		func() error { return c.Delete("/a/b") },
+	} {
+		w, err := c.Watch("/a/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+		if !fired(w) {
+			t.Fatal("watch not fired")
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	c := New()
+	c.Close()
+	if err := c.Create("/a", nil); err != ErrClosedClient {
+		t.Fatalf("create on closed client, err = %v", err)
+	}
+}
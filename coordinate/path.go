@@ -6,13 +6,15 @@ import (
 )
 
 const (
-	DefaultBaseDir             = "/cache-manager"
-	DefaultProductDir          = "/products"
-	DefaultTopomDir            = "/topom"
-	DefaultGroupDir            = "/groups"
-	DefaultSentinelDir         = "/sentinel"
-	DefaultGSLBDir             = "/gslb"
-	DefaultTemplateFileDir     = "/template-files"
+	DefaultBaseDir           = "/cache-manager"
+	DefaultProductDir        = "/products"
+	DefaultTopomDir          = "/topom"
+	DefaultGroupDir          = "/groups"
+	DefaultSentinelDir       = "/sentinel"
+	DefaultGSLBDir           = "/gslb"
+	DefaultTemplateFileDir   = "/template-files"
+	DefaultJobDir            = "/jobs"
+	DefaultSentinelEventsDir = "/sentinel-events"
 )
 
 func ProductDir() string {
@@ -54,3 +56,15 @@ func TemplateFileDir() string {
 func TemplateFilePath(fileName string) string {
 	return filepath.ToSlash(filepath.Join(DefaultBaseDir, DefaultTemplateFileDir, fileName))
 }
+
+func JobDir(productName string) string {
+	return filepath.ToSlash(filepath.Join(DefaultBaseDir, DefaultProductDir, productName, DefaultJobDir))
+}
+
+func JobPath(productName, id string) string {
+	return filepath.ToSlash(filepath.Join(DefaultBaseDir, DefaultProductDir, productName, DefaultJobDir, fmt.Sprintf("job-%s", id)))
+}
+
+func SentinelEventsPath(productName string) string {
+	return filepath.ToSlash(filepath.Join(DefaultBaseDir, DefaultProductDir, productName, DefaultSentinelEventsDir))
+}
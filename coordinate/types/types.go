@@ -0,0 +1,42 @@
+// Package types holds what is shared by the coordinate package and its
+// backends, it can't live in coordinate which imports every backend.
+package types
+
+import "errors"
+
+// ErrVersionConflict is returned by UpdateVersion and Txn when a node has been
+// changed, created or deleted since the version was read.
+var ErrVersionConflict = errors.New("coordinate: version conflict")
+
+type OpType int
+
+const (
+	// OpUpdate creates or updates the node.
+	OpUpdate OpType = iota
+	// OpDelete deletes the node, it must have no children.
+	OpDelete
+)
+
+// AnyVersion skips the version check of an op.
+const AnyVersion int64 = -1
+
+// Op is one operation of a transaction. Version is checked like the version
+// of UpdateVersion, 0 means the node must not exist, unless it's AnyVersion.
+// The ops of a transaction must have distinct paths.
+type Op struct {
+	Type    OpType
+	Path    string
+	Data    []byte
+	Version int64
+}
+
+func (op *Op) String() string {
+	switch op.Type {
+	case OpUpdate:
+		return "update " + op.Path
+	case OpDelete:
+		return "delete " + op.Path
+	default:
+		return "unknown " + op.Path
+	}
+}
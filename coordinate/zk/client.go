@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pourer/pikamgr/coordinate/types"
 	"github.com/pourer/pikamgr/utils/log"
 
 	"github.com/eahydra/go-zookeeper/zk"
@@ -127,7 +128,7 @@ func (c *Client) Do(fn func(conn *zk.Conn) error) error {
 
 func (c *Client) shell(fn func(conn *zk.Conn) error) error {
 	if err := fn(c.conn); err != nil {
-		for _, e := range []error{zk.ErrNoNode, zk.ErrNodeExists, zk.ErrNotEmpty} {
+		for _, e := range []error{zk.ErrNoNode, zk.ErrNodeExists, zk.ErrNotEmpty, types.ErrVersionConflict} {
 			if errEqual(e, err) {
 				return err
 			}
@@ -160,7 +161,14 @@ func (c *Client) Mkdir(path string) error {
 	return nil
 }
 
-func (c *Client) mkdir(conn *zk.Conn, path string) error {
+// txnConn is the part of the connection used by mkdir and by the requests of
+// a txn, so that they can be built without a server.
+type txnConn interface {
+	Exists(path string) (bool, *zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+}
+
+func (c *Client) mkdir(conn txnConn, path string) error {
 	if path == "" || path == "/" {
 		return nil
 	}
@@ -228,7 +236,7 @@ func (c *Client) CreateEphemeral(path string, data []byte) (<-chan struct{}, err
 		log.Debugf("zkclient create-ephemeral node %s failed: %s", path, err)
 		return nil, err
 	}
-	log.Debugf("zkclient create-ephemeral OK", path)
+	log.Debugf("zkclient create-ephemeral OK")
 	return signal, nil
 }
 
@@ -344,6 +352,185 @@ func (c *Client) Read(path string, must bool) ([]byte, error) {
 	return data, nil
 }
 
+// ReadVersion returns the zookeeper version of the node plus one, so that a
+// missing node has version 0.
+func (c *Client) ReadVersion(path string, must bool) ([]byte, int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosedClient
+	}
+	var data []byte
+	var version int64
+	err := c.shell(func(conn *zk.Conn) error {
+		b, stat, err := conn.Get(path)
+		if err != nil {
+			if errEqual(err, zk.ErrNoNode) && !must {
+				return nil
+			}
+			return err
+		}
+		data, version = b, int64(stat.Version)+1
+		return nil
+	})
+	if err != nil {
+		log.Debugf("zkclient read-version node %s failed: %s", path, err)
+		return nil, 0, err
+	}
+	return data, version, nil
+}
+
+func (c *Client) UpdateVersion(path string, data []byte, version int64) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, ErrClosedClient
+	}
+	log.Debugf("zkclient update-version node %s version %d", path, version)
+	var newVersion int64
+	err := c.shell(func(conn *zk.Conn) error {
+		if version == 0 {
+			_, err := c.create(conn, path, data, 0)
+			if errEqual(err, zk.ErrNodeExists) {
+				return types.ErrVersionConflict
+			}
+			newVersion = 1
+			return err
+		}
+		stat, err := conn.Set(path, data, int32(version-1))
+		if errEqual(err, zk.ErrBadVersion) || errEqual(err, zk.ErrNoNode) {
+			return types.ErrVersionConflict
+		} else if err != nil {
+			return err
+		}
+		newVersion = int64(stat.Version) + 1
+		return nil
+	})
+	if err != nil {
+		log.Debugf("zkclient update-version node %s failed: %s", path, err)
+		return 0, err
+	}
+	log.Debugf("zkclient update-version OK")
+	return newVersion, nil
+}
+
+// Txn runs the ops as one zookeeper multi.
+func (c *Client) Txn(ops []types.Op) ([]int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	log.Debugf("zkclient txn %d ops", len(ops))
+	var versions []int64
+	err := c.shell(func(conn *zk.Conn) error {
+		var requests []interface{}
+		var index = make([]int, len(ops))
+		for i, op := range ops {
+			r, err := c.txnRequest(conn, op)
+			if err != nil {
+				return err
+			}
+			index[i] = -1
+			if r != nil {
+				index[i] = len(requests)
+				requests = append(requests, r)
+			}
+		}
+		responses, err := conn.Multi(requests...)
+		if err != nil {
+			return err
+		}
+		if err := multiError(responses); err != nil {
+			return err
+		}
+		versions = make([]int64, len(ops))
+		for i, op := range ops {
+			if op.Type != types.OpUpdate {
+				continue
+			}
+			if r := responses[index[i]]; r.Stat != nil {
+				versions[i] = int64(r.Stat.Version) + 1
+			} else {
+				versions[i] = 1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Debugf("zkclient txn failed: %s", err)
+		return nil, err
+	}
+	log.Debugf("zkclient txn OK")
+	return versions, nil
+}
+
+func (c *Client) txnRequest(conn txnConn, op types.Op) (interface{}, error) {
+	switch op.Type {
+	case types.OpUpdate:
+		switch op.Version {
+		case types.AnyVersion:
+			exists, _, err := conn.Exists(op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				// -1 is the any version of zookeeper.
+				return &zk.SetDataRequest{Path: op.Path, Data: op.Data, Version: -1}, nil
+			}
+		case 0:
+			// the node must not exist, it's created below.
+		default:
+			return &zk.SetDataRequest{Path: op.Path, Data: op.Data, Version: int32(op.Version - 1)}, nil
+		}
+		// the parents can't be created within the multi.
+		if err := c.mkdir(conn, filepath.Dir(op.Path)); err != nil {
+			return nil, err
+		}
+		const perm = zk.PermAdmin | zk.PermRead | zk.PermWrite
+		acl := zk.WorldACL(perm)
+		if c.username != "" {
+			acl = zk.DigestACL(perm, c.username, c.password)
+		}
+		return &zk.CreateRequest{Path: op.Path, Data: op.Data, Acl: acl}, nil
+	case types.OpDelete:
+		if op.Version == types.AnyVersion || op.Version == 0 {
+			// deleting a missing node is a no-op, like Delete.
+			exists, _, err := conn.Exists(op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, nil
+			} else if op.Version == 0 {
+				return nil, types.ErrVersionConflict
+			}
+			return &zk.DeleteRequest{Path: op.Path, Version: -1}, nil
+		}
+		return &zk.DeleteRequest{Path: op.Path, Version: int32(op.Version - 1)}, nil
+	default:
+		return nil, fmt.Errorf("zkclient invalid op %s", op.String())
+	}
+}
+
+// multiError returns the error of the op which failed the multi, the other
+// ops fail with a runtime inconsistency.
+func multiError(responses []zk.MultiResponse) error {
+	var unknown error
+	for _, r := range responses {
+		switch {
+		case r.Error == nil:
+		case errEqual(r.Error, zk.ErrUnknown):
+			unknown = r.Error
+		case errEqual(r.Error, zk.ErrBadVersion), errEqual(r.Error, zk.ErrNodeExists), errEqual(r.Error, zk.ErrNoNode):
+			return types.ErrVersionConflict
+		default:
+			return r.Error
+		}
+	}
+	return unknown
+}
+
 func (c *Client) List(path string, must bool) ([]string, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -440,6 +627,34 @@ func (c *Client) WatchInOrder(path string) (<-chan struct{}, []string, error) {
 	return signal, paths, nil
 }
 
+func (c *Client) Watch(path string) (<-chan struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedClient
+	}
+	var signal chan struct{}
+	log.Debugf("zkclient watch node %s", path)
+	err := c.shell(func(conn *zk.Conn) error {
+		_, _, w, err := conn.ExistsW(path)
+		if err != nil {
+			return err
+		}
+		signal = make(chan struct{})
+		go func() {
+			defer close(signal)
+			<-w
+			log.Debugf("zkclient watch node %s update", path)
+		}()
+		return nil
+	})
+	if err != nil {
+		log.Debugf("zkclient watch node %s failed: %s", path, err)
+		return nil, err
+	}
+	return signal, nil
+}
+
 func errEqual(e1, e2 error) bool {
 	if e1 == e2 {
 		return true
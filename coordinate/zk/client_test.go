@@ -0,0 +1,78 @@
+package zk
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pourer/pikamgr/coordinate/types"
+
+	"github.com/eahydra/go-zookeeper/zk"
+)
+
+// fakeConn holds the paths of the existing nodes.
+type fakeConn map[string]bool
+
+func (c fakeConn) Exists(path string) (bool, *zk.Stat, error) {
+	return c[path], nil, nil
+}
+
+func (c fakeConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if c[path] {
+		return "", zk.ErrNodeExists
+	}
+	if !c[filepath.Dir(path)] && filepath.Dir(path) != "/" {
+		return "", zk.ErrNoNode
+	}
+	c[path] = true
+	return path, nil
+}
+
+func TestTxnRequest(t *testing.T) {
+	c := &Client{}
+	conn := fakeConn{"/a": true, "/a/b": true}
+	data := []byte("x")
+
+	for _, tc := range []struct {
+		op     types.Op
+		expect interface{}
+	}{
+		// an unconditional update of an existing node sets any version.
+		{types.Op{Type: types.OpUpdate, Path: "/a/b", Data: data, Version: types.AnyVersion},
+			&zk.SetDataRequest{Path: "/a/b", Data: data, Version: -1}},
+		{types.Op{Type: types.OpUpdate, Path: "/a/b", Data: data, Version: 3},
+			&zk.SetDataRequest{Path: "/a/b", Data: data, Version: 2}},
+		{types.Op{Type: types.OpDelete, Path: "/a/b", Version: types.AnyVersion},
+			&zk.DeleteRequest{Path: "/a/b", Version: -1}},
+		{types.Op{Type: types.OpDelete, Path: "/a/b", Version: 3},
+			&zk.DeleteRequest{Path: "/a/b", Version: 2}},
+		{types.Op{Type: types.OpDelete, Path: "/a/x", Version: types.AnyVersion}, nil},
+	} {
+		r, err := c.txnRequest(conn, tc.op)
+		if err != nil {
+			t.Fatalf("%s, err = %v", tc.op.String(), err)
+		}
+		if tc.expect == nil && r != nil || tc.expect != nil && !reflect.DeepEqual(r, tc.expect) {
+			t.Fatalf("%s = %#v, expect %#v", tc.op.String(), r, tc.expect)
+		}
+	}
+
+	// an unconditional update of a missing node creates it, the parents are
+	// created before the multi.
+	for _, version := range []int64{types.AnyVersion, 0} {
+		r, err := c.txnRequest(conn, types.Op{Type: types.OpUpdate, Path: "/c/d", Data: data, Version: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, ok := r.(*zk.CreateRequest); !ok || r.Path != "/c/d" || string(r.Data) != "x" {
+			t.Fatalf("update missing node with version %d = %#v", version, r)
+		}
+		if !conn["/c"] {
+			t.Fatal("parent not created")
+		}
+	}
+
+	if _, err := c.txnRequest(conn, types.Op{Type: types.OpDelete, Path: "/a/b", Version: 0}); err != types.ErrVersionConflict {
+		t.Fatalf("delete existing node with version 0, err = %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/archive"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,6 +15,8 @@ type AggService interface {
 	Overview() (*protocol.Overview, error)
 	Topom() (*protocol.Topom, error)
 	Stats() (*protocol.Stats, error)
+	StatsSnapshot() (*protocol.StatsSnapshot, error)
+	Export() (*archive.Archive, error)
 }
 
 type aggHandler struct {
@@ -27,6 +32,7 @@ func InitAggHandler(s AggService, router, apiRouter gin.IRouter) {
 	r.GET("/stats", h.Stats)
 
 	apiRouter.GET("/stats/:xauth", h.Stats)
+	apiRouter.GET("/export/:xauth", h.Export)
 }
 
 func (h *aggHandler) Overview(ctx *gin.Context) {
@@ -45,10 +51,126 @@ func (h *aggHandler) Topom(ctx *gin.Context) {
 	}
 }
 
+// Stats serves the last snapshot of the stats, see statsFilter for the query.
+// The version of the snapshot is the ETag.
 func (h *aggHandler) Stats(ctx *gin.Context) {
-	if data, err := h.s.Stats(); err != nil {
+	filter, err := parseStatsFilter(ctx)
+	if err != nil {
+		ctx.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	snap, err := h.s.StatsSnapshot()
+	if err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if notModified(ctx, snap.Version) {
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, filter.apply(snap.Stats))
+}
+
+// statsFilter selects the parts of the stats, group=g1,g2 keeps the models,
+// the server stats and the masters of the groups only, section=group,gslbs
+// keeps the sections only. Both may be repeated.
+type statsFilter struct {
+	groups   map[string]bool
+	sections map[string]bool
+}
+
+var statsSections = map[string]bool{
+	"group":     true,
+	"sentinels": true,
+	"gslbs":     true,
+	"template":  true,
+}
+
+func parseStatsFilter(ctx *gin.Context) (*statsFilter, error) {
+	values := func(key string) map[string]bool {
+		var m map[string]bool
+		for _, v := range ctx.QueryArray(key) {
+			for _, x := range strings.Split(v, ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					if m == nil {
+						m = make(map[string]bool)
+					}
+					m[x] = true
+				}
+			}
+		}
+		return m
+	}
+	f := &statsFilter{groups: values("group"), sections: values("section")}
+	for section := range f.sections {
+		if !statsSections[section] {
+			return nil, fmt.Errorf("unknown stats section-[%s]", section)
+		}
+	}
+	return f, nil
+}
+
+// apply returns the filtered stats, the stats are shared so they're copied
+// as far as they're filtered.
+func (f *statsFilter) apply(stats *protocol.Stats) *protocol.Stats {
+	if f.groups == nil && f.sections == nil {
+		return stats
+	}
+	filtered := *stats
+	if f.groups != nil {
+		filtered.Group.Models = nil
+		filtered.Group.Stats = make(map[string]*protocol.RedisStats)
+		filtered.HA.Masters = make(map[string]string)
+		for _, g := range stats.Group.Models {
+			if !f.groups[g.Name] {
+				continue
+			}
+			filtered.Group.Models = append(filtered.Group.Models, g)
+			for _, x := range g.Servers {
+				if st, ok := stats.Group.Stats[x.Addr]; ok {
+					filtered.Group.Stats[x.Addr] = st
+				}
+			}
+			if addr, ok := stats.HA.Masters[g.Name]; ok {
+				filtered.HA.Masters[g.Name] = addr
+			}
+		}
+	}
+	if f.sections != nil {
+		if !f.sections["group"] {
+			filtered.Group.Models, filtered.Group.Stats = nil, nil
+		}
+		if !f.sections["sentinels"] {
+			filtered.HA.Model, filtered.HA.Stats, filtered.HA.Masters = nil, nil, nil
+		}
+		if !f.sections["gslbs"] {
+			filtered.GSLB.Models, filtered.GSLB.Stats = nil, nil
+		}
+		if !f.sections["template"] {
+			filtered.Template.FileNames = nil
+		}
+	}
+	return &filtered
+}
+
+// notModified sets the ETag of the version and answers 304 if the client has
+// it already.
+func notModified(ctx *gin.Context, version int64) bool {
+	etag := fmt.Sprintf(`W/"%d"`, version)
+	ctx.Header("ETag", etag)
+	for _, v := range strings.Split(ctx.GetHeader("If-None-Match"), ",") {
+		if v = strings.TrimSpace(v); v == etag || v == "*" {
+			ctx.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func (h *aggHandler) Export(ctx *gin.Context) {
+	if data, err := h.s.Export(); err != nil {
 		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
 	} else {
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", data.ProductName))
 		ctx.IndentedJSON(http.StatusOK, data)
 	}
 }
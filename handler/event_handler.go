@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EventService interface {
+	SubscribeEvents(lastID int64) ([]*protocol.Event, <-chan *protocol.Event, func(), error)
+}
+
+// EventKeepAliveInterval is how often an idle event stream sends a comment,
+// so that proxies don't close it.
+var EventKeepAliveInterval = time.Second * 15
+
+type eventHandler struct {
+	s EventService
+}
+
+func InitEventHandler(s EventService, router gin.IRouter) {
+	h := &eventHandler{s: s}
+
+	r := router.Group("/events")
+	r.GET("/stream/:xauth", h.Stream)
+}
+
+// Stream pushes the changes of the product as server-sent events.
+func (h *eventHandler) Stream(ctx *gin.Context) {
+	lastID, err := lastEventID(ctx)
+	if err != nil {
+		ctx.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	events, ch, cancel, err := h.s.SubscribeEvents(lastID)
+	if err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer cancel()
+	streamEvents(ctx, events, ch)
+}
+
+// lastEventID is the id a stream resumes after, EventSource sends it in the
+// header on reconnect. The query parameter is for the first connection.
+func lastEventID(ctx *gin.Context) (int64, error) {
+	v := ctx.GetHeader("Last-Event-ID")
+	if v == "" {
+		v = ctx.Query("lastEventId")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last event id-[%s]", v)
+	}
+	return id, nil
+}
+
+func streamEvents(ctx *gin.Context, events []*protocol.Event, ch <-chan *protocol.Event) {
+	w := ctx.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e *protocol.Event) bool {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err == nil
+	}
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, e := range events {
+		if !write(e) {
+			return
+		}
+	}
+	w.Flush()
+
+	keepAlive := time.NewTicker(EventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case e, ok := <-ch:
+			// a closed channel ends the stream, the client resumes from the
+			// last id it got.
+			if !ok || !write(e) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/pourer/pikamgr/protocol"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,6 +19,7 @@ type GroupService interface {
 	DelGroupServer(groupName, addr string) error
 	GroupPromoteServer(groupName, addr string) error
 	GroupForceFullSyncServer(groupName, addr string) error
+	FailoverGroup(groupName string) (*protocol.Failover, error)
 	ServerInfo(addr string) ([]byte, error)
 }
 
@@ -36,6 +39,7 @@ func InitGroupHandler(s GroupService, router gin.IRouter) {
 	r.PUT("/del/:xauth/:gname/:addr", h.DelServer)
 	r.PUT("/promote/:xauth/:gname/:addr", h.PromoteServer)
 	r.PUT("/force-full-sync/:xauth/:gname/:addr", h.ForceFullSyncServer)
+	r.PUT("/failover/:xauth/:gname", h.Failover)
 	r.GET("/info/:addr", h.ServerInfo)
 }
 
@@ -95,6 +99,8 @@ func (h *groupHandler) Resync(ctx *gin.Context) {
 	ctx.IndentedJSON(http.StatusOK, nil)
 }
 
+// ResyncAll runs in a job and waits for it, the job is returned by the v2
+// api only.
 func (h *groupHandler) ResyncAll(ctx *gin.Context) {
 	if err := h.s.ResyncGroupAll(); err != nil {
 		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
@@ -167,6 +173,22 @@ func (h *groupHandler) PromoteServer(ctx *gin.Context) {
 	ctx.IndentedJSON(http.StatusOK, nil)
 }
 
+func (h *groupHandler) Failover(ctx *gin.Context) {
+	groupName := ctx.Param("gname")
+	if groupName == "" {
+		ctx.IndentedJSON(http.StatusBadRequest, "group name invalid")
+		return
+	}
+
+	failover, err := h.s.FailoverGroup(groupName)
+	if err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, failover)
+}
+
 func (h *groupHandler) ForceFullSyncServer(ctx *gin.Context) {
 	groupName := ctx.Param("gname")
 	if groupName == "" {
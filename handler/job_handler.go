@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/dao"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobService interface {
+	Jobs() ([]*dao.Job, error)
+	Job(id string) (*dao.Job, error)
+	CancelJob(id string) error
+}
+
+// JobStreamInterval is how often a streamed job is checked for changes.
+var JobStreamInterval = time.Millisecond * 500
+
+type jobHandler struct {
+	s JobService
+}
+
+func InitJobHandler(s JobService, router gin.IRouter) {
+	h := &jobHandler{s: s}
+
+	r := router.Group("/job")
+	r.GET("/list/:xauth", h.List)
+	r.GET("/info/:xauth/:id", h.Info)
+	r.GET("/stream/:xauth/:id", h.Stream)
+	r.PUT("/cancel/:xauth/:id", h.Cancel)
+}
+
+func (h *jobHandler) List(ctx *gin.Context) {
+	if data, err := h.s.Jobs(); err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+	} else {
+		ctx.IndentedJSON(http.StatusOK, data)
+	}
+}
+
+func (h *jobHandler) Info(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.IndentedJSON(http.StatusBadRequest, "missing job id")
+		return
+	}
+
+	if data, err := h.s.Job(id); err != nil {
+		ctx.IndentedJSON(http.StatusNotFound, err.Error())
+	} else {
+		ctx.IndentedJSON(http.StatusOK, data)
+	}
+}
+
+// Stream writes the job as a line of json every time it changes, until it's
+// finished.
+func (h *jobHandler) Stream(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.IndentedJSON(http.StatusBadRequest, "missing job id")
+		return
+	}
+	if _, err := h.s.Job(id); err != nil {
+		ctx.IndentedJSON(http.StatusNotFound, err.Error())
+		return
+	}
+	streamJob(ctx, h.s, id)
+}
+
+func streamJob(ctx *gin.Context, s JobService, id string) {
+	ctx.Header("Content-Type", "application/x-ndjson")
+	var last []byte
+	ctx.Stream(func(w io.Writer) bool {
+		job, err := s.Job(id)
+		if err != nil {
+			return false
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return false
+		}
+		if !bytes.Equal(data, last) {
+			last = data
+			w.Write(append(data, '\n'))
+		}
+		if job.Finished() {
+			return false
+		}
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-time.After(JobStreamInterval):
+			return true
+		}
+	})
+}
+
+func (h *jobHandler) Cancel(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.IndentedJSON(http.StatusBadRequest, "missing job id")
+		return
+	}
+
+	if err := h.s.CancelJob(id); err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, nil)
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"strings"
+
 	"github.com/pourer/pikamgr/utils/log"
 
 	"github.com/gin-contrib/gzip"
@@ -28,7 +30,17 @@ func RecordSourceHandler(ctx *gin.Context) {
 	ctx.Next()
 }
 
-var GzipHandler = gzip.Gzip(gzip.DefaultCompression)
+var gzipHandler = gzip.Gzip(gzip.DefaultCompression)
+
+// GzipHandler compresses every response but the streams, the gzip writer
+// would hold back what they flush.
+func GzipHandler(ctx *gin.Context) {
+	if path := ctx.Request.URL.Path; strings.Contains(path, "/stream/") || strings.HasSuffix(path, "/stream") {
+		ctx.Next()
+		return
+	}
+	gzipHandler(ctx)
+}
 
 func validPort(port int) bool {
 	if port < 10000 || port > 59999 {
@@ -5,13 +5,19 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+
 	"github.com/gin-gonic/gin"
 )
 
 type SentinelService interface {
-	AddSentinel(addr string) error
+	AddSentinel(addr string, force bool) error
 	DelSentinel(addr string, force bool) error
 	ResyncSentinels() error
+	VerifySentinels() ([]*protocol.SentinelVerification, error)
+	SubmitRepairSentinels() (*dao.Job, error)
+	SentinelEvents(groupName string, limit int) ([]*dao.SentinelEvent, error)
 	SentinelInfo(addr string) ([]byte, error)
 	SentinelMonitoredInfo(addr string) (interface{}, error)
 }
@@ -25,8 +31,12 @@ func InitSentinelHandler(s SentinelService, router gin.IRouter) {
 
 	r := router.Group("/sentinels")
 	r.PUT("/add/:xauth/:addr", h.Add)
+	r.PUT("/add/:xauth/:addr/:force", h.Add)
 	r.PUT("/del/:xauth/:addr/:force", h.Del)
 	r.PUT("/resync-all/:xauth", h.ResyncAll)
+	r.PUT("/repair/:xauth", h.Repair)
+	r.GET("/verify", h.Verify)
+	r.GET("/events", h.Events)
 	r.GET("/info/:addr", h.SentinelInfo)
 	r.GET("/info/:addr/monitored", h.SentinelMonitoredInfo)
 }
@@ -38,7 +48,17 @@ func (h *sentinelHandler) Add(ctx *gin.Context) {
 		return
 	}
 
-	if err := h.s.AddSentinel(addr); err != nil {
+	// the route without force is kept for the older clients.
+	var force int
+	if v := ctx.Param("force"); v != "" {
+		var err error
+		if force, err = strconv.Atoi(v); err != nil {
+			ctx.IndentedJSON(http.StatusBadRequest, "invalid force")
+			return
+		}
+	}
+
+	if err := h.s.AddSentinel(addr, force != 0); err != nil {
 		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -67,6 +87,8 @@ func (h *sentinelHandler) Del(ctx *gin.Context) {
 	ctx.IndentedJSON(http.StatusOK, nil)
 }
 
+// ResyncAll runs in a job and waits for it, the job is returned by the v2
+// api only.
 func (h *sentinelHandler) ResyncAll(ctx *gin.Context) {
 	if err := h.s.ResyncSentinels(); err != nil {
 		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
@@ -76,6 +98,43 @@ func (h *sentinelHandler) ResyncAll(ctx *gin.Context) {
 	ctx.IndentedJSON(http.StatusOK, nil)
 }
 
+// Verify shows the drifted entries of every sentinel.
+func (h *sentinelHandler) Verify(ctx *gin.Context) {
+	if data, err := h.s.VerifySentinels(); err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+	} else {
+		ctx.IndentedJSON(http.StatusOK, data)
+	}
+}
+
+// Events shows the timeline of the sentinel events, the newest first, the
+// group and limit are optional.
+func (h *sentinelHandler) Events(ctx *gin.Context) {
+	var limit int
+	if v := ctx.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			ctx.IndentedJSON(http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	if data, err := h.s.SentinelEvents(ctx.Query("group"), limit); err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+	} else {
+		ctx.IndentedJSON(http.StatusOK, data)
+	}
+}
+
+// Repair runs in a job, the response is the job just submitted.
+func (h *sentinelHandler) Repair(ctx *gin.Context) {
+	if job, err := h.s.SubmitRepairSentinels(); err != nil {
+		ctx.IndentedJSON(http.StatusInternalServerError, err.Error())
+	} else {
+		ctx.IndentedJSON(http.StatusOK, job)
+	}
+}
+
 func (h *sentinelHandler) SentinelInfo(ctx *gin.Context) {
 	addr := ctx.Param("addr")
 	if len(addr) == 0 {
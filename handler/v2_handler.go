@@ -0,0 +1,641 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom"
+	"github.com/pourer/pikamgr/topom/archive"
+	"github.com/pourer/pikamgr/topom/dao"
+
+	"github.com/gin-gonic/gin"
+)
+
+type V2Service interface {
+	AggService
+	GroupService
+	SentinelService
+	GSLBService
+	TemplateFileService
+	JobService
+	EventService
+	AuthService
+	ConfigService
+	SentinelParamsService
+	JobSubmitService
+}
+
+// JobSubmitService submits the jobs the v1 routes wait for.
+type JobSubmitService interface {
+	SubmitResyncGroupAll() (*dao.Job, error)
+	SubmitGroupForceFullSyncServer(groupName, addr string) (*dao.Job, error)
+	SubmitResyncSentinels() (*dao.Job, error)
+}
+
+type SentinelParamsService interface {
+	SetGroupSentinelParams(groupName string, params *dao.SentinelParams) error
+}
+
+type AuthService interface {
+	SubmitRotateAuth(auth string) (*dao.Job, error)
+}
+
+type ConfigService interface {
+	ReloadConfig(resyncSentinels bool) (*protocol.ConfigReload, error)
+}
+
+// v2Route is a route of the v2 api, the openapi document is generated from
+// the routes so every route must describe its bodies.
+type v2Route struct {
+	method  string
+	path    string
+	summary string
+	// query lists the query parameters.
+	query []string
+	// request is a value of the body type, nil if there is no body.
+	request interface{}
+	// optionalBody tells the request body may be left out.
+	optionalBody bool
+	// response is a value of the body type, nil if there is no body.
+	response interface{}
+	status   int
+	handle   gin.HandlerFunc
+}
+
+type v2Handler struct {
+	s      V2Service
+	routes []*v2Route
+}
+
+// InitV2Handler registers the v2 api, resources are addressed by path and
+// every body, the errors included, is json. The openapi document of the api
+// is served at /openapi.json.
+func InitV2Handler(s V2Service, router gin.IRouter) {
+	h := &v2Handler{s: s}
+
+	h.routes = []*v2Route{
+		{method: "GET", path: "/overview", summary: "Show the dashboard, its config and stats",
+			response: &protocol.Overview{}, status: http.StatusOK, handle: h.Overview},
+		{method: "GET", path: "/stats", summary: "Show the stats of every group, sentinel and gslb, filtered by group and section, the ETag is the version",
+			query: []string{"group", "section"}, response: &protocol.Stats{}, status: http.StatusOK, handle: h.Stats},
+		{method: "GET", path: "/export", summary: "Export the product as an archive",
+			response: &archive.Archive{}, status: http.StatusOK, handle: h.Export},
+		{method: "POST", path: "/config/reload", summary: "Reload the config file, the keys which need a restart are reported",
+			request: &protocol.ReloadConfigRequest{}, optionalBody: true, response: &protocol.ConfigReload{}, status: http.StatusOK, handle: h.ReloadConfig},
+
+		{method: "GET", path: "/groups", summary: "List the groups",
+			response: []*protocol.Group{}, status: http.StatusOK, handle: h.ListGroups},
+		{method: "POST", path: "/groups", summary: "Create a group",
+			request: &protocol.CreateGroupRequest{}, response: &protocol.Group{}, status: http.StatusCreated, handle: h.CreateGroup},
+		{method: "GET", path: "/groups/:name", summary: "Show a group",
+			response: &protocol.Group{}, status: http.StatusOK, handle: h.GetGroup},
+		{method: "DELETE", path: "/groups/:name", summary: "Remove an empty group",
+			status: http.StatusNoContent, handle: h.RemoveGroup},
+		{method: "POST", path: "/groups/:name/resync", summary: "Point every server of the group to its master",
+			status: http.StatusNoContent, handle: h.ResyncGroup},
+		{method: "POST", path: "/groups/:name/servers", summary: "Add a server to the group",
+			request: &protocol.AddServerRequest{}, status: http.StatusNoContent, handle: h.AddGroupServer},
+		{method: "DELETE", path: "/groups/:name/servers/:addr", summary: "Remove a server from the group",
+			status: http.StatusNoContent, handle: h.DelGroupServer},
+		{method: "POST", path: "/groups/:name/servers/:addr/promote", summary: "Promote a server to the master of the group",
+			status: http.StatusNoContent, handle: h.PromoteGroupServer},
+		{method: "POST", path: "/groups/:name/failover", summary: "Fail the master of the group over through a sentinel and wait for the switch",
+			response: &protocol.Failover{}, status: http.StatusOK, handle: h.FailoverGroup},
+		{method: "PUT", path: "/groups/:name/sentinel-params", summary: "Set the sentinel params of the group, they take effect once the sentinels are resynced",
+			request: &protocol.SentinelParams{}, status: http.StatusNoContent, handle: h.SetGroupSentinelParams},
+		{method: "DELETE", path: "/groups/:name/sentinel-params", summary: "Clear the sentinel params of the group, the dashboard ones apply once the sentinels are resynced",
+			status: http.StatusNoContent, handle: h.ClearGroupSentinelParams},
+		{method: "GET", path: "/servers/:addr/info", summary: "Show the INFO of a server",
+			response: &protocol.Text{}, status: http.StatusOK, handle: h.ServerInfo},
+
+		{method: "GET", path: "/sentinels", summary: "Show the sentinels",
+			response: &protocol.Sentinel{}, status: http.StatusOK, handle: h.ListSentinels},
+		{method: "POST", path: "/sentinels", summary: "Add a sentinel, force adds it even if it breaks the quorum of a group",
+			query: []string{"force"}, request: &protocol.AddServerRequest{}, status: http.StatusNoContent, handle: h.AddSentinel},
+		{method: "DELETE", path: "/sentinels/:addr", summary: "Remove a sentinel, force removes it even if it can't be reset or it breaks the quorum of a group",
+			query: []string{"force"}, status: http.StatusNoContent, handle: h.DelSentinel},
+		{method: "GET", path: "/sentinels/:addr/info", summary: "Show the INFO of a sentinel",
+			response: &protocol.Text{}, status: http.StatusOK, handle: h.SentinelInfo},
+		{method: "GET", path: "/sentinels/:addr/monitored", summary: "Show the groups monitored by a sentinel",
+			response: map[string]interface{}{}, status: http.StatusOK, handle: h.SentinelMonitored},
+		{method: "GET", path: "/sentinel-events", summary: "Show the timeline of the sentinel events, the newest first, filtered by group",
+			query: []string{"group", "limit"}, response: []*dao.SentinelEvent{}, status: http.StatusOK, handle: h.SentinelEvents},
+		{method: "GET", path: "/sentinel-drift", summary: "Verify every sentinel against the groups and show the drifted entries, the repair-sentinels job repairs them",
+			response: []*protocol.SentinelVerification{}, status: http.StatusOK, handle: h.SentinelDrift},
+
+		{method: "GET", path: "/gslbs", summary: "List the gslbs",
+			response: map[string]*protocol.GSLB{}, status: http.StatusOK, handle: h.ListGSLBs},
+		{method: "POST", path: "/gslbs/:name/servers", summary: "Add a server to the gslb, the gslb is created if missing",
+			request: &protocol.AddServerRequest{}, status: http.StatusNoContent, handle: h.AddGSLBServer},
+		{method: "DELETE", path: "/gslbs/:name/servers/:addr", summary: "Remove a server from the gslb",
+			status: http.StatusNoContent, handle: h.DelGSLBServer},
+		{method: "GET", path: "/gslbs/:name/servers/:addr/monitor", summary: "Show the monitor of a gslb server",
+			response: &protocol.Text{}, status: http.StatusOK, handle: h.GSLBServerMonitor},
+
+		{method: "GET", path: "/template-files", summary: "List the template files",
+			response: []string{}, status: http.StatusOK, handle: h.ListTemplateFiles},
+		{method: "GET", path: "/template-files/:name", summary: "Show a template file",
+			response: &protocol.Text{}, status: http.StatusOK, handle: h.GetTemplateFile},
+
+		{method: "GET", path: "/jobs", summary: "List the jobs, the newest first",
+			response: []*dao.Job{}, status: http.StatusOK, handle: h.ListJobs},
+		{method: "POST", path: "/jobs", summary: "Submit a job",
+			request: &protocol.SubmitJobRequest{}, response: &dao.Job{}, status: http.StatusAccepted, handle: h.SubmitJob},
+		{method: "GET", path: "/jobs/:id", summary: "Show a job",
+			response: &dao.Job{}, status: http.StatusOK, handle: h.GetJob},
+		{method: "POST", path: "/jobs/:id/cancel", summary: "Cancel a running job",
+			status: http.StatusNoContent, handle: h.CancelJob},
+		{method: "GET", path: "/jobs/:id/stream", summary: "Stream the job as a line of json on every change until it's finished",
+			response: &dao.Job{}, status: http.StatusOK, handle: h.StreamJob},
+
+		{method: "GET", path: "/events/stream", summary: "Stream the changes of the product as server-sent events, a snapshot first unless it resumes",
+			query: []string{"lastEventId"}, response: &protocol.Event{}, status: http.StatusOK, handle: h.StreamEvents},
+	}
+
+	for _, r := range h.routes {
+		router.Handle(r.method, r.path, r.handle)
+	}
+	router.GET("/openapi.json", h.OpenAPI)
+}
+
+// v2Error writes the error with the status and code of the sentinel error it
+// wraps, an internal error if it wraps none.
+func v2Error(ctx *gin.Context, err error) {
+	status, code := http.StatusInternalServerError, protocol.ErrorCodeInternal
+	switch {
+	case errors.Is(err, coordinate.ErrVersionConflict):
+		status, code = http.StatusConflict, protocol.ErrorCodeConflict
+	case errors.Is(err, topom.ErrNotFound):
+		status, code = http.StatusNotFound, protocol.ErrorCodeNotFound
+	case errors.Is(err, topom.ErrExists):
+		status, code = http.StatusConflict, protocol.ErrorCodeAlreadyExists
+	}
+	v2Abort(ctx, status, code, err.Error())
+}
+
+func v2Abort(ctx *gin.Context, status int, code, message string) {
+	ctx.AbortWithStatusJSON(status, &protocol.Error{
+		Error: &protocol.ErrorDetail{Code: code, Message: message},
+	})
+}
+
+func v2InvalidArgument(ctx *gin.Context, format string, args ...interface{}) {
+	v2Abort(ctx, http.StatusBadRequest, protocol.ErrorCodeInvalidArgument, fmt.Sprintf(format, args...))
+}
+
+// v2Bind decodes the json body, a bad body is answered and false returned.
+func v2Bind(ctx *gin.Context, v interface{}) bool {
+	if err := ctx.ShouldBindJSON(v); err != nil {
+		v2InvalidArgument(ctx, "invalid body: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// v2BindOptional is v2Bind for an optional body, v is left zero by an empty
+// body.
+func v2BindOptional(ctx *gin.Context, v interface{}) bool {
+	if ctx.Request.ContentLength == 0 {
+		return true
+	}
+	if err := ctx.ShouldBindJSON(v); err != nil && err != io.EOF {
+		v2InvalidArgument(ctx, "invalid body: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *v2Handler) Overview(ctx *gin.Context) {
+	if data, err := h.s.Overview(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) Stats(ctx *gin.Context) {
+	filter, err := parseStatsFilter(ctx)
+	if err != nil {
+		v2InvalidArgument(ctx, "%s", err.Error())
+		return
+	}
+	snap, err := h.s.StatsSnapshot()
+	if err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	if notModified(ctx, snap.Version) {
+		return
+	}
+	ctx.JSON(http.StatusOK, filter.apply(snap.Stats))
+}
+
+func (h *v2Handler) Export(ctx *gin.Context) {
+	if data, err := h.s.Export(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) ReloadConfig(ctx *gin.Context) {
+	var req protocol.ReloadConfigRequest
+	if !v2BindOptional(ctx, &req) {
+		return
+	}
+	if data, err := h.s.ReloadConfig(req.ResyncSentinels); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) group(name string) (*protocol.Group, error) {
+	stats, err := h.s.Stats()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range stats.Group.Models {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("group-[%s] %w", name, topom.ErrNotFound)
+}
+
+func (h *v2Handler) ListGroups(ctx *gin.Context) {
+	if stats, err := h.s.Stats(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, stats.Group.Models)
+	}
+}
+
+func (h *v2Handler) CreateGroup(ctx *gin.Context) {
+	var req protocol.CreateGroupRequest
+	if !v2Bind(ctx, &req) {
+		return
+	}
+	if req.Name == "" {
+		v2InvalidArgument(ctx, "group name invalid")
+		return
+	}
+	if !validPort(req.ProxyReadPort) {
+		v2InvalidArgument(ctx, "proxy read port invalid")
+		return
+	}
+	if !validPort(req.ProxyWritePort) {
+		v2InvalidArgument(ctx, "proxy write port invalid")
+		return
+	}
+
+	if err := h.s.CreateGroup(req.Name, req.ProxyReadPort, req.ProxyWritePort); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	if g, err := h.group(req.Name); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusCreated, g)
+	}
+}
+
+func (h *v2Handler) GetGroup(ctx *gin.Context) {
+	if g, err := h.group(ctx.Param("name")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, g)
+	}
+}
+
+func (h *v2Handler) RemoveGroup(ctx *gin.Context) {
+	if err := h.s.RemoveGroup(ctx.Param("name")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) ResyncGroup(ctx *gin.Context) {
+	if err := h.s.ResyncGroup(ctx.Param("name")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) AddGroupServer(ctx *gin.Context) {
+	var req protocol.AddServerRequest
+	if !v2Bind(ctx, &req) {
+		return
+	}
+	if req.Addr == "" {
+		v2InvalidArgument(ctx, "missing addr")
+		return
+	}
+
+	if err := h.s.AddGroupServer(ctx.Param("name"), req.Addr); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) DelGroupServer(ctx *gin.Context) {
+	if err := h.s.DelGroupServer(ctx.Param("name"), ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) PromoteGroupServer(ctx *gin.Context) {
+	if err := h.s.GroupPromoteServer(ctx.Param("name"), ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) FailoverGroup(ctx *gin.Context) {
+	if failover, err := h.s.FailoverGroup(ctx.Param("name")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, failover)
+	}
+}
+
+func (h *v2Handler) SetGroupSentinelParams(ctx *gin.Context) {
+	var req protocol.SentinelParams
+	if !v2Bind(ctx, &req) {
+		return
+	}
+	params := dao.SentinelParams(req)
+	if err := params.Validate(); err != nil {
+		v2InvalidArgument(ctx, "%s", err.Error())
+		return
+	}
+	if err := h.s.SetGroupSentinelParams(ctx.Param("name"), &params); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) ClearGroupSentinelParams(ctx *gin.Context) {
+	if err := h.s.SetGroupSentinelParams(ctx.Param("name"), nil); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) ServerInfo(ctx *gin.Context) {
+	if data, err := h.s.ServerInfo(ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, &protocol.Text{Text: string(data)})
+	}
+}
+
+func (h *v2Handler) ListSentinels(ctx *gin.Context) {
+	if stats, err := h.s.Stats(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, stats.HA.Model)
+	}
+}
+
+func (h *v2Handler) AddSentinel(ctx *gin.Context) {
+	var req protocol.AddServerRequest
+	if !v2Bind(ctx, &req) {
+		return
+	}
+	if req.Addr == "" {
+		v2InvalidArgument(ctx, "missing addr")
+		return
+	}
+	var force bool
+	if v := ctx.Query("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			v2InvalidArgument(ctx, "invalid force")
+			return
+		}
+	}
+
+	if err := h.s.AddSentinel(req.Addr, force); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) DelSentinel(ctx *gin.Context) {
+	var force bool
+	if v := ctx.Query("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			v2InvalidArgument(ctx, "invalid force")
+			return
+		}
+	}
+
+	if err := h.s.DelSentinel(ctx.Param("addr"), force); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) SentinelInfo(ctx *gin.Context) {
+	if data, err := h.s.SentinelInfo(ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, &protocol.Text{Text: string(data)})
+	}
+}
+
+func (h *v2Handler) SentinelMonitored(ctx *gin.Context) {
+	if data, err := h.s.SentinelMonitoredInfo(ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) SentinelEvents(ctx *gin.Context) {
+	var limit int
+	if v := ctx.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			v2InvalidArgument(ctx, "invalid limit")
+			return
+		}
+	}
+
+	if data, err := h.s.SentinelEvents(ctx.Query("group"), limit); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) SentinelDrift(ctx *gin.Context) {
+	if data, err := h.s.VerifySentinels(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) ListGSLBs(ctx *gin.Context) {
+	if stats, err := h.s.Stats(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, stats.GSLB.Models)
+	}
+}
+
+func (h *v2Handler) AddGSLBServer(ctx *gin.Context) {
+	var req protocol.AddServerRequest
+	if !v2Bind(ctx, &req) {
+		return
+	}
+	if req.Addr == "" {
+		v2InvalidArgument(ctx, "missing addr")
+		return
+	}
+
+	if err := h.s.AddGSLB(ctx.Param("name"), req.Addr); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) DelGSLBServer(ctx *gin.Context) {
+	if err := h.s.DelGSLB(ctx.Param("name"), ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) GSLBServerMonitor(ctx *gin.Context) {
+	if data, err := h.s.GSLBMonitorInfo(ctx.Param("addr")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, &protocol.Text{Text: string(data)})
+	}
+}
+
+func (h *v2Handler) ListTemplateFiles(ctx *gin.Context) {
+	if stats, err := h.s.Stats(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, stats.Template.FileNames)
+	}
+}
+
+func (h *v2Handler) GetTemplateFile(ctx *gin.Context) {
+	if data, err := h.s.ViewTemplateFile(ctx.Param("name")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, &protocol.Text{Text: string(data)})
+	}
+}
+
+func (h *v2Handler) ListJobs(ctx *gin.Context) {
+	if data, err := h.s.Jobs(); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) SubmitJob(ctx *gin.Context) {
+	var req protocol.SubmitJobRequest
+	if !v2Bind(ctx, &req) {
+		return
+	}
+
+	var job *dao.Job
+	var err error
+	switch req.Type {
+	case protocol.JobTypeResyncGroupAll:
+		job, err = h.s.SubmitResyncGroupAll()
+	case protocol.JobTypeResyncSentinels:
+		job, err = h.s.SubmitResyncSentinels()
+	case protocol.JobTypeRepairSentinels:
+		job, err = h.s.SubmitRepairSentinels()
+	case protocol.JobTypeForceFullSync:
+		if req.Group == "" || req.Server == "" {
+			v2InvalidArgument(ctx, "job %s needs group and server", req.Type)
+			return
+		}
+		job, err = h.s.SubmitGroupForceFullSyncServer(req.Group, req.Server)
+	case protocol.JobTypeRotateAuth:
+		if req.Auth == "" {
+			v2InvalidArgument(ctx, "job %s needs auth", req.Type)
+			return
+		}
+		job, err = h.s.SubmitRotateAuth(req.Auth)
+	default:
+		v2InvalidArgument(ctx, "unknown job type %q", req.Type)
+		return
+	}
+	if err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+func (h *v2Handler) GetJob(ctx *gin.Context) {
+	if data, err := h.s.Job(ctx.Param("id")); err != nil {
+		v2Error(ctx, err)
+	} else {
+		ctx.JSON(http.StatusOK, data)
+	}
+}
+
+func (h *v2Handler) CancelJob(ctx *gin.Context) {
+	if err := h.s.CancelJob(ctx.Param("id")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *v2Handler) StreamJob(ctx *gin.Context) {
+	if _, err := h.s.Job(ctx.Param("id")); err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	streamJob(ctx, h.s, ctx.Param("id"))
+}
+
+func (h *v2Handler) StreamEvents(ctx *gin.Context) {
+	lastID, err := lastEventID(ctx)
+	if err != nil {
+		v2InvalidArgument(ctx, "%s", err.Error())
+		return
+	}
+	events, ch, cancel, err := h.s.SubscribeEvents(lastID)
+	if err != nil {
+		v2Error(ctx, err)
+		return
+	}
+	defer cancel()
+	streamEvents(ctx, events, ch)
+}
+
+func (h *v2Handler) OpenAPI(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.openAPI())
+}
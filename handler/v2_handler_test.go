@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeService implements the methods the tests call, the others panic.
+type fakeService struct {
+	V2Service
+	groups []*protocol.Group
+	// reloadErr is returned by ReloadConfig.
+	reloadErr error
+}
+
+func (s *fakeService) Stats() (*protocol.Stats, error) {
+	stats := &protocol.Stats{}
+	stats.Group.Models = s.groups
+	return stats, nil
+}
+
+func (s *fakeService) StatsSnapshot() (*protocol.StatsSnapshot, error) {
+	stats, err := s.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.StatsSnapshot{Version: 1, Stats: stats}, nil
+}
+
+func (s *fakeService) CreateGroup(name string, rPort, wPort int) error {
+	for _, g := range s.groups {
+		if g.Name == name {
+			return fmt.Errorf("group-[%s] %w", name, topom.ErrExists)
+		}
+	}
+	s.groups = append(s.groups, &protocol.Group{Name: name, ProxyReadPort: rPort, ProxyWritePort: wPort})
+	return nil
+}
+
+func (s *fakeService) ReloadConfig(resyncSentinels bool) (*protocol.ConfigReload, error) {
+	if s.reloadErr != nil {
+		return nil, s.reloadErr
+	}
+	return &protocol.ConfigReload{}, nil
+}
+
+func newV2Server(s V2Service) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	InitV2Handler(s, r.Group("/api/v2"))
+	return r
+}
+
+func do(r http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestV2Groups(t *testing.T) {
+	r := newV2Server(&fakeService{})
+
+	w := do(r, "POST", "/api/v2/groups", `{"name":"g1","proxyReadPort":16001,"proxyWritePort":16002}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create group = %d %s", w.Code, w.Body)
+	}
+	var g protocol.Group
+	if err := json.Unmarshal(w.Body.Bytes(), &g); err != nil || g.Name != "g1" || g.ProxyReadPort != 16001 {
+		t.Fatalf("created group = %+v, %v", g, err)
+	}
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+		code              string
+	}{
+		{"POST", "/api/v2/groups", `{"name":`, http.StatusBadRequest, protocol.ErrorCodeInvalidArgument},
+		{"POST", "/api/v2/groups", `{"name":"g2","proxyReadPort":1,"proxyWritePort":16002}`, http.StatusBadRequest, protocol.ErrorCodeInvalidArgument},
+		{"POST", "/api/v2/groups", `{"name":"g1","proxyReadPort":16003,"proxyWritePort":16004}`, http.StatusConflict, protocol.ErrorCodeAlreadyExists},
+		{"GET", "/api/v2/groups/g2", "", http.StatusNotFound, protocol.ErrorCodeNotFound},
+		{"POST", "/api/v2/jobs", `{"type":"unknown"}`, http.StatusBadRequest, protocol.ErrorCodeInvalidArgument},
+	} {
+		w := do(r, c.method, c.url, c.body)
+		var e protocol.Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Error == nil {
+			t.Fatalf("%s %s: unstructured error %s", c.method, c.url, w.Body)
+		}
+		if w.Code != c.status || e.Error.Code != c.code {
+			t.Fatalf("%s %s = %d %s, expect %d %s", c.method, c.url, w.Code, e.Error.Code, c.status, c.code)
+		}
+	}
+
+	w = do(r, "GET", "/api/v2/groups/g1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get group = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestV2ReloadConfig(t *testing.T) {
+	r := newV2Server(&fakeService{})
+	for _, body := range []string{"", `{}`, `{"resyncSentinels":true}`} {
+		if w := do(r, "POST", "/api/v2/config/reload", body); w.Code != http.StatusOK {
+			t.Fatalf("reload with body %q = %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	// a chunked body has no length.
+	req := httptest.NewRequest("POST", "/api/v2/config/reload", strings.NewReader(""))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload with an empty chunked body = %d %s", w.Code, w.Body)
+	}
+
+	if w := do(r, "POST", "/api/v2/config/reload", `{"resyncSentinels":`); w.Code != http.StatusBadRequest {
+		t.Fatalf("reload with a bad body = %d", w.Code)
+	}
+}
+
+func TestV2Error(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("group-[g1] %w", topom.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("sentinel-[s1] %w", topom.ErrExists), http.StatusConflict},
+		{fmt.Errorf("update group: %w", coordinate.ErrVersionConflict), http.StatusConflict},
+		// the status isn't guessed from the message.
+		{errors.New("no sentinel reaches the quorum, master not found"), http.StatusInternalServerError},
+	} {
+		r := newV2Server(&fakeService{reloadErr: c.err})
+		if w := do(r, "POST", "/api/v2/config/reload", `{}`); w.Code != c.status {
+			t.Fatalf("error %q = %d, expect %d", c.err, w.Code, c.status)
+		}
+	}
+}
+
+func TestV2OpenAPI(t *testing.T) {
+	r := newV2Server(&fakeService{})
+	w := do(r, "GET", "/api/v2/openapi.json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("openapi = %d", w.Code)
+	}
+
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []struct{ method, path string }{
+		{"post", "/groups"},
+		{"delete", "/groups/{name}/servers/{addr}"},
+		{"post", "/jobs"},
+		{"get", "/jobs/{id}/stream"},
+	} {
+		if _, ok := doc.Paths[p.path][p.method]; !ok {
+			t.Fatalf("openapi misses %s %s", p.method, p.path)
+		}
+	}
+
+	// every reference resolves.
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Fatalf("openapi misses schema %s", name)
+		}
+	}
+	if _, ok := doc.Components.Schemas["protocol.CreateGroupRequest"]; !ok {
+		t.Fatal("openapi misses the request schemas")
+	}
+}
+
+func (s *fakeService) SubscribeEvents(lastID int64) ([]*protocol.Event, <-chan *protocol.Event, func(), error) {
+	ch := make(chan *protocol.Event, 1)
+	ch <- &protocol.Event{ID: lastID + 2, Type: protocol.EventGroup, Name: "g1"}
+	close(ch)
+	return []*protocol.Event{{ID: lastID + 1, Type: protocol.EventSwitchMaster, Name: "g1"}}, ch, func() {}, nil
+}
+
+func TestV2EventStream(t *testing.T) {
+	r := newV2Server(&fakeService{})
+	req := httptest.NewRequest("GET", "/api/v2/events/stream", nil)
+	req.Header.Set("Last-Event-ID", "10")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %s", ct)
+	}
+	body := w.Body.String()
+	for _, s := range []string{
+		"id: 11\nevent: switch-master\ndata: {",
+		"id: 12\nevent: group\ndata: {",
+	} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("stream %q misses %q", body, s)
+		}
+	}
+}
+
+func TestV2StatsFilter(t *testing.T) {
+	s := &fakeService{}
+	s.CreateGroup("g1", 16001, 16002)
+	s.CreateGroup("g2", 16003, 16004)
+	r := newV2Server(s)
+
+	w := do(r, "GET", "/api/v2/stats?group=g2&section=group", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `W/"1"` {
+		t.Fatalf("stats = %d %s", w.Code, w.Header().Get("ETag"))
+	}
+	var stats protocol.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Group.Models) != 1 || stats.Group.Models[0].Name != "g2" || stats.HA.Model != nil {
+		t.Fatalf("filtered stats = %+v", stats)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v2/stats", nil)
+	req.Header.Set("If-None-Match", `W/"1"`)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("stats with the etag = %d %s", w.Code, w.Body)
+	}
+
+	if w := do(r, "GET", "/api/v2/stats?section=unknown", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("stats of an unknown section = %d", w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+// openAPI generates the openapi 3 document of the v2 routes, the schemas are
+// derived from the body types by reflection.
+func (h *v2Handler) openAPI() map[string]interface{} {
+	b := &openAPIBuilder{schemas: make(map[string]interface{})}
+	errorSchema := b.schema(reflect.TypeOf(protocol.Error{}))
+
+	paths := make(map[string]interface{})
+	for _, r := range h.routes {
+		var params []interface{}
+		var segments []string
+		for _, seg := range strings.Split(r.path, "/") {
+			if strings.HasPrefix(seg, ":") {
+				params = append(params, map[string]interface{}{
+					"name":     seg[1:],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+				seg = "{" + seg[1:] + "}"
+			}
+			segments = append(segments, seg)
+		}
+		for _, q := range r.query {
+			params = append(params, map[string]interface{}{
+				"name":   q,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		response := map[string]interface{}{"description": http.StatusText(r.status)}
+		if r.response != nil {
+			contentType := "application/json"
+			switch {
+			case strings.HasPrefix(r.path, "/events/"):
+				contentType = "text/event-stream"
+			case path.Base(r.path) == "stream":
+				contentType = "application/x-ndjson"
+			}
+			response["content"] = map[string]interface{}{
+				contentType: map[string]interface{}{"schema": b.schema(reflect.TypeOf(r.response))},
+			}
+		}
+		op := map[string]interface{}{
+			"summary": r.summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(r.status): response,
+				"default": map[string]interface{}{
+					"description": "error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": errorSchema},
+					},
+				},
+			},
+		}
+		if len(params) != 0 {
+			op["parameters"] = params
+		}
+		if r.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": !r.optionalBody,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(r.request))},
+				},
+			}
+		}
+
+		p := strings.Join(segments, "/")
+		item, ok := paths[p].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[p] = item
+		}
+		item[strings.ToLower(r.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "pikamgr dashboard",
+			"version": "v2",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/api/v2"}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.schemas},
+	}
+}
+
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// a value marshaled as text, a duration like "30s", is a string.
+	if t.Implements(textMarshaler) || reflect.PtrTo(t).Implements(textMarshaler) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := b.schemas[name]; !ok {
+			// registered first, a type may refer to itself.
+			b.schemas[name] = nil
+			b.schemas[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		// interfaces, e.g. an error, may hold anything.
+		return map[string]interface{}{}
+	}
+}
+
+func (b *openAPIBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	b.fields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *openAPIBuilder) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.fields(ft, properties)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+	}
+}
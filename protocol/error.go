@@ -0,0 +1,51 @@
+package protocol
+
+import "encoding/json"
+
+// RemoteError is an error decoded from a response of the dashboard, the
+// errors of the stats are encoded as whatever json their type marshals to.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+func decodeError(data json.RawMessage) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return RemoteError(s)
+	}
+	return RemoteError(data)
+}
+
+// UnmarshalJSON decodes the error as a RemoteError, an interface can't be
+// decoded otherwise.
+func (s *RedisStats) UnmarshalJSON(data []byte) error {
+	type redisStats RedisStats
+	v := struct {
+		*redisStats
+		Error json.RawMessage `json:"error"`
+	}{redisStats: (*redisStats)(s)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	s.Error = decodeError(v.Error)
+	return nil
+}
+
+// UnmarshalJSON decodes the error as a RemoteError.
+func (s *GSLBStats) UnmarshalJSON(data []byte) error {
+	type gslbStats GSLBStats
+	v := struct {
+		*gslbStats
+		Error json.RawMessage `json:"error"`
+	}{gslbStats: (*gslbStats)(s)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	s.Error = decodeError(v.Error)
+	return nil
+}
@@ -0,0 +1,34 @@
+package protocol
+
+// The types of the events pushed by the dashboard, a stream starts with a
+// snapshot unless it resumes, every other event replaces the state of one
+// entity.
+const (
+	EventSnapshot      = "snapshot"
+	EventGroup         = "group"
+	EventSentinel      = "sentinel"
+	EventGSLB          = "gslb"
+	EventServerStats   = "server-stats"
+	EventSentinelStats = "sentinel-stats"
+	EventGSLBStats     = "gslb-stats"
+	EventSwitchMaster  = "switch-master"
+)
+
+// Event is a change of the product, only the fields of its type are set.
+// Name is the group or gslb name, or the server address of the stats. A
+// removed entity comes with a nil model or stats.
+type Event struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	UnixTime int64  `json:"unixtime"`
+	Name     string `json:"name,omitempty"`
+
+	Snapshot   *Stats      `json:"snapshot,omitempty"`
+	Group      *Group      `json:"group,omitempty"`
+	Sentinel   *Sentinel   `json:"sentinel,omitempty"`
+	GSLB       *GSLB       `json:"gslb,omitempty"`
+	Server     *RedisStats `json:"server,omitempty"`
+	GSLBServer *GSLBStats  `json:"gslbServer,omitempty"`
+	// Master is the new master of the group of a switch-master.
+	Master string `json:"master,omitempty"`
+}
@@ -1,5 +1,7 @@
 package protocol
 
+import "github.com/CodisLabs/codis/pkg/utils/timesize"
+
 type SentinelGroup struct {
 	Master map[string]string   `json:"master"`
 	Slaves []map[string]string `json:"slaves,omitempty"`
@@ -11,6 +13,21 @@ type RedisStats struct {
 	Sentinel map[string]*SentinelGroup `json:"sentinel,omitempty"`
 	UnixTime int64                     `json:"unixtime"`
 	Timeout  bool                      `json:"timeout,omitempty"`
+	KeySpace *KeySpaceScan             `json:"keyspace,omitempty"`
+	// Quorum is the reply of SENTINEL CKQUORUM of a sentinel, by group.
+	Quorum map[string]string `json:"quorum,omitempty"`
+}
+
+// KeySpaceScan is the state of the keyspace scans of a server, the times are
+// unix times. Result is the keyspace section of the last info view, it comes
+// from ScannedOn in place of a master.
+type KeySpaceScan struct {
+	LastScan   int64    `json:"lastScan,omitempty"`
+	NextScan   int64    `json:"nextScan,omitempty"`
+	Skipped    string   `json:"skipped,omitempty"`
+	ScannedOn  string   `json:"scannedOn,omitempty"`
+	ResultTime int64    `json:"resultTime,omitempty"`
+	Result     []string `json:"result,omitempty"`
 }
 
 type GroupServer struct {
@@ -28,6 +45,21 @@ type Group struct {
 	OutOfSync      bool `json:"outOfSync"`
 	ProxyReadPort  int  `json:"proxyReadPort"`
 	ProxyWritePort int  `json:"proxyWritePort"`
+
+	SentinelParams *SentinelParams `json:"sentinelParams,omitempty"`
+}
+
+// SentinelParams override the sentinel config of the dashboard for the master
+// of a group, the zero fields keep the dashboard ones. The durations are
+// given like "30s".
+type SentinelParams struct {
+	Quorum          int               `json:"quorum,omitempty"`
+	ParallelSyncs   int               `json:"parallelSyncs,omitempty"`
+	DownAfter       timesize.Duration `json:"downAfter,omitempty"`
+	FailoverTimeout timesize.Duration `json:"failoverTimeout,omitempty"`
+
+	NotificationScript   string `json:"notificationScript,omitempty"`
+	ClientReconfigScript string `json:"clientReconfigScript,omitempty"`
 }
 
 type Sentinel struct {
@@ -35,6 +67,31 @@ type Sentinel struct {
 	OutOfSync bool     `json:"outOfSync"`
 }
 
+// The kinds of the sentinel warnings.
+const (
+	// SentinelWarningMajority is a set of less than 3 sentinels, which can't
+	// authorize a failover once one of them is down.
+	SentinelWarningMajority = "majority"
+	// SentinelWarningQuorum is a group whose master can't be failed over by
+	// the sentinels up and not on its host, see Message.
+	SentinelWarningQuorum = "quorum"
+	// SentinelWarningCheckQuorum is a sentinel whose SENTINEL CKQUORUM for the
+	// group failed, Message is the reply.
+	SentinelWarningCheckQuorum = "ckquorum"
+	// SentinelWarningColocated is a sentinel on the host of the master of the
+	// group, or on the host of another sentinel if Group isn't set.
+	SentinelWarningColocated = "colocated"
+)
+
+// SentinelWarning is a problem of the health or the placement of the
+// sentinels, they're checked by every round of the stats.
+type SentinelWarning struct {
+	Kind     string `json:"kind"`
+	Group    string `json:"group,omitempty"`
+	Sentinel string `json:"sentinel,omitempty"`
+	Message  string `json:"message"`
+}
+
 type GSLBStats struct {
 	Error    error `json:"error"`
 	UnixTime int64 `json:"unixtime"`
@@ -55,6 +112,8 @@ type Stats struct {
 		Model   *Sentinel              `json:"model"`
 		Stats   map[string]*RedisStats `json:"stats"`
 		Masters map[string]string      `json:"masters"`
+		// Warnings are sorted by kind, group then sentinel.
+		Warnings []*SentinelWarning `json:"warnings,omitempty"`
 	} `json:"sentinels"`
 	GSLB struct {
 		Models map[string]*GSLB      `json:"models"`
@@ -75,9 +134,36 @@ type Topom struct {
 }
 
 type Overview struct {
-	Version string      `json:"version"`
-	Compile string      `json:"compile"`
-	Config  interface{} `json:"config"`
-	Model   *Topom      `json:"model,omitempty"`
-	Stats   *Stats      `json:"stats,omitempty"`
+	Version   string          `json:"version"`
+	Compile   string          `json:"compile"`
+	Config    interface{}     `json:"config"`
+	Model     *Topom          `json:"model,omitempty"`
+	Stats     *Stats          `json:"stats,omitempty"`
+	Collector *CollectorStats `json:"collector,omitempty"`
+}
+
+// CollectorStats are the timings of the stats collector, the durations are
+// in milliseconds.
+type CollectorStats struct {
+	Workers   int   `json:"workers"`
+	Targets   int   `json:"targets"`
+	Rounds    int64 `json:"rounds"`
+	LastRound struct {
+		UnixTime   int64 `json:"unixtime"`
+		Duration   int64 `json:"duration"`
+		Probes     int   `json:"probes"`
+		FullProbes int   `json:"fullProbes"`
+		Failures   int   `json:"failures"`
+		Timeouts   int   `json:"timeouts"`
+		BackedOff  int   `json:"backedOff"`
+		MaxProbe   int64 `json:"maxProbe"`
+		AvgProbe   int64 `json:"avgProbe"`
+	} `json:"lastRound"`
+}
+
+// StatsSnapshot is an immutable version of the stats, the version is bumped
+// every time they change.
+type StatsSnapshot struct {
+	Version int64
+	Stats   *Stats
 }
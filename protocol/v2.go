@@ -0,0 +1,121 @@
+package protocol
+
+// The bodies of the v2 api, see handler.InitV2Handler.
+
+type CreateGroupRequest struct {
+	Name           string `json:"name"`
+	ProxyReadPort  int    `json:"proxyReadPort"`
+	ProxyWritePort int    `json:"proxyWritePort"`
+}
+
+type AddServerRequest struct {
+	Addr string `json:"addr"`
+}
+
+const (
+	JobTypeResyncGroupAll  = "resync-group-all"
+	JobTypeResyncSentinels = "resync-sentinels"
+	JobTypeForceFullSync   = "force-full-sync"
+	JobTypeRotateAuth      = "rotate-auth"
+	JobTypeRepairSentinels = "repair-sentinels"
+)
+
+// SubmitJobRequest submits a job, Group and Server are only used by the
+// force-full-sync job, Auth, the new product auth, by the rotate-auth job.
+type SubmitJobRequest struct {
+	Type   string `json:"type"`
+	Group  string `json:"group,omitempty"`
+	Server string `json:"server,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+}
+
+// ReloadConfigRequest reloads the config file of the dashboard, the sentinels
+// are resynced once a sentinel key changed if ResyncSentinels is set.
+type ReloadConfigRequest struct {
+	ResyncSentinels bool `json:"resyncSentinels"`
+}
+
+// ConfigChange is a key of the config whose value changed, the credentials
+// are redacted. Restart is set for the keys which take effect at a restart.
+type ConfigChange struct {
+	Key     string `json:"key"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Restart bool   `json:"restart"`
+}
+
+// ConfigReload is the result of a reload, ResyncJob is the id of the job
+// which resyncs the sentinels, if any.
+type ConfigReload struct {
+	Changes   []*ConfigChange `json:"changes"`
+	ResyncJob string          `json:"resyncJob,omitempty"`
+}
+
+// The kinds of drift of a sentinel from the groups of the product.
+const (
+	// SentinelDriftMissing is a group whose master isn't monitored.
+	SentinelDriftMissing = "missing"
+	// SentinelDriftUnexpected is a master monitored for no group, or for a
+	// group without master.
+	SentinelDriftUnexpected = "unexpected"
+	// SentinelDriftMaster is a master monitored at another address.
+	SentinelDriftMaster = "master"
+	// SentinelDriftConfig is a param of the master which differs, see Key.
+	SentinelDriftConfig = "config"
+	// SentinelDriftMissingPeer is a sentinel of the product which isn't a
+	// peer of the master, the sentinels discover their peers on their own.
+	SentinelDriftMissingPeer = "missing-peer"
+	// SentinelDriftUnknownPeer is a peer of the master which isn't a
+	// sentinel of the product.
+	SentinelDriftUnknownPeer = "unknown-peer"
+)
+
+// SentinelDrift is an entry of a sentinel which differs from the expected
+// one, the addresses are the ones of the masters or of the peers.
+type SentinelDrift struct {
+	Group    string `json:"group"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// SentinelVerification is the drift of a sentinel, Error is set if it
+// couldn't be verified.
+type SentinelVerification struct {
+	Sentinel string           `json:"sentinel"`
+	Error    string           `json:"error,omitempty"`
+	Drifts   []*SentinelDrift `json:"drifts"`
+}
+
+// Failover is a failover of a group triggered through a sentinel, Elapsed is
+// the time until +switch-master in milliseconds.
+type Failover struct {
+	Group     string `json:"group"`
+	Sentinel  string `json:"sentinel"`
+	OldMaster string `json:"oldMaster"`
+	NewMaster string `json:"newMaster"`
+	Elapsed   int64  `json:"elapsed"`
+}
+
+type Text struct {
+	Text string `json:"text"`
+}
+
+const (
+	ErrorCodeInvalidArgument = "invalid_argument"
+	ErrorCodeNotFound        = "not_found"
+	ErrorCodeAlreadyExists   = "already_exists"
+	ErrorCodeConflict        = "conflict"
+	ErrorCodeInternal        = "internal"
+)
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is the body of every failed v2 request.
+type Error struct {
+	Error *ErrorDetail `json:"error"`
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// Version is the current archive format version. Archives written by a newer
+// version can't be imported by an older one.
+const Version = 1
+
+type Archive struct {
+	Version       int               `json:"version"`
+	ProductName   string            `json:"productName"`
+	ExportTime    string            `json:"exportTime"`
+	Groups        []*dao.Group      `json:"groups"`
+	Sentinel      *dao.Sentinel     `json:"sentinel,omitempty"`
+	GSLBs         []*dao.GSLB       `json:"gslbs,omitempty"`
+	TemplateFiles map[string]string `json:"templateFiles,omitempty"`
+}
+
+func New(product string, groups dao.Groups, sentinel *dao.Sentinel, gslbs dao.GSLBs, tfs dao.TemplateFiles) *Archive {
+	a := &Archive{
+		Version:     Version,
+		ProductName: product,
+		ExportTime:  time.Now().Format("2006-01-02 15:04:05"),
+		Groups:      make([]*dao.Group, 0, len(groups)),
+		Sentinel:    sentinel,
+	}
+
+	for _, g := range groups {
+		a.Groups = append(a.Groups, g)
+	}
+	sort.Slice(a.Groups, func(i, j int) bool {
+		return a.Groups[i].Name < a.Groups[j].Name
+	})
+
+	for _, g := range gslbs {
+		a.GSLBs = append(a.GSLBs, g)
+	}
+	sort.Slice(a.GSLBs, func(i, j int) bool {
+		return a.GSLBs[i].Name < a.GSLBs[j].Name
+	})
+
+	if len(tfs) != 0 {
+		a.TemplateFiles = make(map[string]string, len(tfs))
+		for name, tf := range tfs {
+			a.TemplateFiles[name] = string(tf.Data)
+		}
+	}
+	return a
+}
+
+func (a *Archive) Encode() []byte {
+	data, err := json.MarshalIndent(a, "", "    ")
+	if err != nil {
+		panic(fmt.Sprintf("encode archive to json failed. err:%s", err))
+	}
+	return data
+}
+
+func (a *Archive) Decode(data []byte) error {
+	if err := json.Unmarshal(data, a); err != nil {
+		return err
+	}
+	return a.Validate()
+}
+
+func (a *Archive) Validate() error {
+	if a.Version <= 0 || a.Version > Version {
+		return fmt.Errorf("unsupported archive version-[%d], expect <= %d", a.Version, Version)
+	}
+	if a.ProductName == "" {
+		return fmt.Errorf("archive product name is empty")
+	}
+	names := make(map[string]bool, len(a.Groups))
+	for _, g := range a.Groups {
+		if g == nil || g.Name == "" {
+			return fmt.Errorf("archive has group without name")
+		}
+		if names[g.Name] {
+			return fmt.Errorf("archive has duplicated group-[%s]", g.Name)
+		}
+		names[g.Name] = true
+	}
+	for _, g := range a.GSLBs {
+		if g == nil || g.Name == "" {
+			return fmt.Errorf("archive has gslb without name")
+		}
+	}
+	return nil
+}
+
+// AddrRewriter maps addresses of the source deployment to the target one.
+// A key is either a full "host:port" address, which is replaced as a whole,
+// or a bare host, in which case only the host part is replaced and the port
+// is kept.
+type AddrRewriter map[string]string
+
+func (r AddrRewriter) Rewrite(addr string) string {
+	if len(r) == 0 || addr == "" {
+		return addr
+	}
+	if v, ok := r[addr]; ok {
+		return v
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if v, ok := r[host]; ok {
+		return net.JoinHostPort(v, port)
+	}
+	return addr
+}
+
+func (r AddrRewriter) rewriteSlice(addrs []string) []string {
+	if addrs == nil {
+		return nil
+	}
+	results := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		results = append(results, r.Rewrite(addr))
+	}
+	return results
+}
+
+// Rewrite renames the product and rewrites every server address found in
+// the archive. An empty product keeps the original name.
+func (a *Archive) Rewrite(product string, r AddrRewriter) {
+	if product != "" {
+		a.ProductName = product
+	}
+
+	for _, g := range a.Groups {
+		for _, v := range g.Servers {
+			v.Addr = r.Rewrite(v.Addr)
+		}
+	}
+
+	if a.Sentinel != nil {
+		a.Sentinel.Servers = r.rewriteSlice(a.Sentinel.Servers)
+	}
+
+	for _, g := range a.GSLBs {
+		g.Servers = r.rewriteSlice(g.Servers)
+		g.Monitors = r.rewriteSlice(g.Monitors)
+		for _, bg := range g.Backends {
+			bg.Servers = r.rewriteSlice(bg.Servers)
+			for _, b := range bg.ServerGroup {
+				b.Servers = r.rewriteSlice(b.Servers)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func TestAddrRewriter(t *testing.T) {
+	r := AddrRewriter{
+		"10.0.0.1":      "10.1.0.1",
+		"10.0.0.2:9221": "10.1.0.2:9331",
+	}
+	for addr, expect := range map[string]string{
+		"10.0.0.1:9221": "10.1.0.1:9221",
+		"10.0.0.1:9222": "10.1.0.1:9222",
+		"10.0.0.2:9221": "10.1.0.2:9331",
+		"10.0.0.2:9222": "10.0.0.2:9222",
+		"10.0.0.3:9221": "10.0.0.3:9221",
+		"":              "",
+	} {
+		if got := r.Rewrite(addr); got != expect {
+			t.Errorf("rewrite %q = %q, expect %q", addr, got, expect)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	groups := dao.Groups{
+		"g1": {Name: "g1", Servers: []*dao.GroupServer{{Addr: "10.0.0.1:9221"}, {Addr: "10.0.0.2:9221"}}},
+	}
+	sentinel := &dao.Sentinel{Servers: []string{"10.0.0.1:26379"}}
+	gslbs := dao.GSLBs{
+		"haproxy": {Name: "haproxy", Servers: []string{"10.0.0.9:8000"}},
+	}
+	tfs := dao.TemplateFiles{"haproxy.tmpl": {Data: []byte("global")}}
+
+	a := New("demo", groups, sentinel, gslbs, tfs)
+	b := &Archive{}
+	if err := b.Decode(a.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	b.Rewrite("staging", AddrRewriter{"10.0.0.1": "192.168.0.1"})
+
+	if b.ProductName != "staging" {
+		t.Fatalf("product = %s", b.ProductName)
+	}
+	if addr := b.Groups[0].Servers[0].Addr; addr != "192.168.0.1:9221" {
+		t.Fatalf("group server = %s", addr)
+	}
+	if addr := b.Groups[0].Servers[1].Addr; addr != "10.0.0.2:9221" {
+		t.Fatalf("group server = %s", addr)
+	}
+	if addr := b.Sentinel.Servers[0]; addr != "192.168.0.1:26379" {
+		t.Fatalf("sentinel server = %s", addr)
+	}
+	if b.TemplateFiles["haproxy.tmpl"] != "global" {
+		t.Fatalf("template file = %q", b.TemplateFiles["haproxy.tmpl"])
+	}
+}
+
+func TestArchiveVersion(t *testing.T) {
+	a := &Archive{Version: Version + 1, ProductName: "demo"}
+	if err := a.Decode(a.Encode()); err == nil {
+		t.Fatal("expect error for newer archive version")
+	}
+}
@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// Export reads the product state directly from the coordinator.
+func Export(client coordinate.Client, product string, withTemplateFiles bool) (*Archive, error) {
+	groups := make(dao.Groups)
+	paths, err := client.List(coordinate.GroupDir(product), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := client.Read(path, true)
+		if err != nil {
+			return nil, err
+		}
+		g := &dao.Group{}
+		if err := g.Decode(data); err != nil {
+			return nil, fmt.Errorf("decode group node-[%s] failed. err-[%s]", path, err.Error())
+		}
+		groups[g.Name] = g
+	}
+
+	var sentinel *dao.Sentinel
+	data, err := client.Read(coordinate.SentinelPath(product), false)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		sentinel = &dao.Sentinel{}
+		if err := sentinel.Decode(data); err != nil {
+			return nil, fmt.Errorf("decode sentinel node failed. err-[%s]", err.Error())
+		}
+	}
+
+	gslbs := make(dao.GSLBs)
+	paths, err = client.List(coordinate.GSLBDir(), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := client.Read(coordinate.GSLBPath(filepath.Base(path), product), false)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		g := &dao.GSLB{}
+		if err := g.Decode(data); err != nil {
+			return nil, fmt.Errorf("decode gslb node-[%s] failed. err-[%s]", path, err.Error())
+		}
+		gslbs[g.Name] = g
+	}
+
+	var tfs dao.TemplateFiles
+	if withTemplateFiles {
+		tfs = make(dao.TemplateFiles)
+		paths, err = client.List(coordinate.TemplateFileDir(), false)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := client.Read(path, true)
+			if err != nil {
+				return nil, err
+			}
+			tfs[filepath.Base(path)] = &dao.TemplateFile{Data: data}
+		}
+	}
+
+	return New(product, groups, sentinel, gslbs, tfs), nil
+}
+
+type ImportOptions struct {
+	// ProductName renames the product on import, empty keeps the archived name.
+	ProductName string
+	// AddrRewriter rewrites server addresses on import.
+	AddrRewriter AddrRewriter
+	// Overwrite allows importing into a product which already has groups,
+	// groups missing from the archive will be removed.
+	Overwrite bool
+	// SkipTemplateFiles doesn't restore the template files, which are shared
+	// by all products of the coordinator.
+	SkipTemplateFiles bool
+}
+
+// Import restores the archive into the coordinator. The target product must
+// not be online, since a running dashboard caches the product state.
+func Import(client coordinate.Client, a *Archive, opts *ImportOptions) error {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	a.Rewrite(opts.ProductName, opts.AddrRewriter)
+	product := a.ProductName
+
+	if data, err := client.Read(coordinate.TopomPath(product), false); err != nil {
+		return err
+	} else if data != nil {
+		return fmt.Errorf("product-[%s] is online, stop the dashboard first", product)
+	}
+
+	paths, err := client.List(coordinate.GroupDir(product), false)
+	if err != nil {
+		return err
+	}
+	if len(paths) != 0 && !opts.Overwrite {
+		return fmt.Errorf("product-[%s] already has %d groups", product, len(paths))
+	}
+
+	expect := make(map[string]bool, len(a.Groups))
+	for _, g := range a.Groups {
+		expect[coordinate.GroupPath(product, g.Name)] = true
+	}
+	for _, path := range paths {
+		if expect[filepath.ToSlash(path)] {
+			continue
+		}
+		log.Infof("archive::Import remove group node-[%s]", path)
+		if err := client.Delete(path); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range a.Groups {
+		log.Infof("archive::Import restore group-[%s]", g.Name)
+		if err := client.Update(coordinate.GroupPath(product, g.Name), g.Encode()); err != nil {
+			return fmt.Errorf("restore group-[%s] failed. err-[%s]", g.Name, err.Error())
+		}
+	}
+
+	if a.Sentinel != nil {
+		// sentinels of the target deployment have to be resynced by the dashboard.
+		sentinel := &dao.Sentinel{Servers: a.Sentinel.Servers, OutOfSync: len(a.Sentinel.Servers) != 0}
+		log.Infof("archive::Import restore sentinel %v", sentinel.Servers)
+		if err := client.Update(coordinate.SentinelPath(product), sentinel.Encode()); err != nil {
+			return fmt.Errorf("restore sentinel failed. err-[%s]", err.Error())
+		}
+	}
+
+	for _, g := range a.GSLBs {
+		log.Infof("archive::Import restore gslb-[%s]", g.Name)
+		if err := client.Update(coordinate.GSLBPath(g.Name, product), g.Encode()); err != nil {
+			return fmt.Errorf("restore gslb-[%s] failed. err-[%s]", g.Name, err.Error())
+		}
+	}
+
+	if !opts.SkipTemplateFiles {
+		for name, data := range a.TemplateFiles {
+			log.Infof("archive::Import restore template file-[%s]", name)
+			if err := client.Update(coordinate.TemplateFilePath(name), []byte(data)); err != nil {
+				return fmt.Errorf("restore template file-[%s] failed. err-[%s]", name, err.Error())
+			}
+		}
+	}
+	return nil
+}
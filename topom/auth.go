@@ -0,0 +1,314 @@
+package topom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pourer/pikamgr/config"
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// The steps of a rotation of the product auth, they're the targets of its job
+// and run in this order.
+const (
+	rotateMasterAuth      = "masterauth"
+	rotateRequirePass     = "requirepass"
+	rotateSentinels       = "sentinels"
+	rotateConfigRewrite   = "config-rewrite"
+	rotateDashboardConfig = "dashboard-config"
+)
+
+func (s *service) redisOptions() *redis.Options {
+	return s.auth.options.Load().(*redis.Options)
+}
+
+// setRedisOptions sets the options of the connections to the servers, the
+// idle connections of the pools are kept.
+func (s *service) setRedisOptions(options *redis.Options) {
+	s.auth.options.Store(options)
+	s.stats.redisp.SetOptions(options)
+	s.ha.redisp.SetOptions(options.Sentinel())
+}
+
+// authRotation is the state of a rotation, it's only used by the goroutine of
+// its job. The servers and sentinels which were switched are recorded for the
+// rollback.
+type authRotation struct {
+	s *service
+
+	// old are the options before the rotation. window authenticates with the
+	// new auth, then with the old one, while the servers are switched.
+	old, window *redis.Options
+
+	masterAuth  []string
+	requirePass []string
+	sentinels   []string
+}
+
+// RotateAuth rotates the product auth and waits, see SubmitRotateAuth.
+func (s *service) RotateAuth(auth string) error {
+	r, err := s.submitRotateAuth(auth)
+	if err != nil {
+		return err
+	}
+	return r.wait()
+}
+
+// SubmitRotateAuth submits a job which switches every server and sentinel of
+// the product to the new auth. The masterauth of the servers is set first,
+// then their requirepass, slaves before masters, then the auth-pass of the
+// sentinels. The configs of the servers are rewritten and the product_auth of
+// the dashboard config last. The dashboard authenticates with either auth
+// meanwhile, and everything is switched back to the old auth if a step fails
+// or the job is cancelled.
+func (s *service) SubmitRotateAuth(auth string) (*dao.Job, error) {
+	r, err := s.submitRotateAuth(auth)
+	if err != nil {
+		return nil, err
+	}
+	return s.Job(r.job.ID)
+}
+
+func (s *service) submitRotateAuth(auth string) (*jobRunner, error) {
+	old := s.redisOptions()
+	switch {
+	case auth == "":
+		return nil, errors.New("invalid auth")
+	case old.Password == "":
+		return nil, errors.New("the product has no auth to rotate")
+	case old.Username != "":
+		return nil, errors.New("the auth of an acl user can't be rotated")
+	case auth == old.Password:
+		return nil, errors.New("auth unchanged")
+	}
+	if !atomic.CompareAndSwapInt32(&s.auth.rotating, 0, 1) {
+		return nil, errors.New("a rotation of the auth is running")
+	}
+	log.AddSecrets(auth)
+
+	window := *old
+	window.Password, window.FallbackPassword = auth, old.Password
+	a := &authRotation{s: s, old: old, window: &window}
+
+	r, err := s.submitJob(&jobTask{
+		typ: JobRotateAuth,
+		targets: []string{
+			rotateMasterAuth, rotateRequirePass, rotateSentinels, rotateConfigRewrite, rotateDashboardConfig,
+		},
+		stopOnError: true,
+		run: func(ctx context.Context, r *jobRunner, step string) error {
+			return a.run(ctx, r, step)
+		},
+		finish: func(ctx context.Context, r *jobRunner, err error) error {
+			atomic.StoreInt32(&s.auth.rotating, 0)
+			return err
+		},
+		rollback: func(r *jobRunner, err error) {
+			a.rollback(r)
+			atomic.StoreInt32(&s.auth.rotating, 0)
+		},
+	})
+	if err != nil {
+		atomic.StoreInt32(&s.auth.rotating, 0)
+		return nil, err
+	}
+	return r, nil
+}
+
+func (a *authRotation) run(ctx context.Context, r *jobRunner, step string) error {
+	s := a.s
+	switch step {
+	case rotateMasterAuth:
+		s.setRedisOptions(a.window)
+		return a.eachServer(ctx, false, func(addr string, c *redis.Client) error {
+			if err := c.ConfigSet("masterauth", a.window.Password); err != nil {
+				return err
+			}
+			a.masterAuth = append(a.masterAuth, addr)
+			return nil
+		})
+	case rotateRequirePass:
+		return a.eachServer(ctx, true, func(addr string, c *redis.Client) error {
+			if err := c.ConfigSet("requirepass", a.window.Password); err != nil {
+				return err
+			}
+			a.requirePass = append(a.requirePass, addr)
+			r.logf("%s switched", addr)
+			return nil
+		})
+	case rotateSentinels:
+		servers, groupNames, err := a.sentinelsAndGroups()
+		if err != nil {
+			return err
+		}
+		sentinel := redis.NewSentinel(s.config().ProductName, a.window)
+		for _, addr := range servers {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := sentinel.SetAuthPass(addr, s.config().SentinelClientTimeout.Duration(), groupNames); err != nil {
+				return fmt.Errorf("sentinel-[%s] set auth-pass fail. err-[%s]", addr, err.Error())
+			}
+			a.sentinels = append(a.sentinels, addr)
+		}
+		return nil
+	case rotateConfigRewrite:
+		return a.eachServer(ctx, false, func(addr string, c *redis.Client) error {
+			return c.ConfigRewrite()
+		})
+	case rotateDashboardConfig:
+		if ok, err := s.config().RewriteSecret("product_auth", a.window.Password); err != nil {
+			return fmt.Errorf("rewrite dashboard config fail. err-[%s]", err.Error())
+		} else if !ok {
+			r.logf("the product_auth of the dashboard config can't be rewritten, it must be updated by hand")
+		}
+		s.updateConfig(func(c *config.DashboardConfig) {
+			c.ProductAuth = a.window.Password
+		})
+		final := *a.window
+		final.FallbackPassword = ""
+		s.setRedisOptions(&final)
+		log.Infof("service::RotateAuth the auth of product-[%s] is rotated", s.config().ProductName)
+		return nil
+	}
+	return fmt.Errorf("unknown step %s", step)
+}
+
+// eachServer calls fn on every server of every group, the group is locked
+// meanwhile. The slaves come before their master if slavesFirst.
+func (a *authRotation) eachServer(ctx context.Context, slavesFirst bool, fn func(addr string, c *redis.Client) error) error {
+	s := a.s
+	s.mutex.Lock()
+	groups, err := s.groupMapper.Info()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range sortGroups(groups) {
+		if err := a.eachServerOfGroup(ctx, g.Name, slavesFirst, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *authRotation) eachServerOfGroup(ctx context.Context, groupName string, slavesFirst bool, fn func(addr string, c *redis.Client) error) error {
+	s := a.s
+	defer s.lockGroup(groupName)()
+
+	s.mutex.Lock()
+	groups, err := s.groupMapper.Info()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	g, ok := groups[groupName]
+	if !ok {
+		return nil
+	}
+
+	var addrs []string
+	for _, server := range g.Servers {
+		addrs = append(addrs, server.Addr)
+	}
+	if slavesFirst && len(addrs) > 1 {
+		addrs = append(addrs[1:], addrs[0])
+	}
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.callServer(addr, fn); err != nil {
+			return fmt.Errorf("group-[%s] server-[%s] %s", groupName, addr, err.Error())
+		}
+	}
+	return nil
+}
+
+func (a *authRotation) callServer(addr string, fn func(addr string, c *redis.Client) error) error {
+	c, err := redis.NewClientOptions(addr, a.window, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return fn(addr, c)
+}
+
+func (a *authRotation) sentinelsAndGroups() ([]string, []string, error) {
+	s := a.s
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return nil, nil, err
+	}
+	var groupNames []string
+	for _, g := range sortGroups(groups) {
+		groupNames = append(groupNames, g.Name)
+	}
+	return sentinel.Servers, groupNames, nil
+}
+
+// rollback switches the sentinels and the servers which were switched back to
+// the old auth in the reverse order, the failures are logged and the others
+// switched anyway.
+func (a *authRotation) rollback(r *jobRunner) {
+	s := a.s
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Errorln("service::RotateAuth rollback", msg)
+		r.logf("rollback %s", msg)
+	}
+
+	if len(a.sentinels) != 0 {
+		_, groupNames, err := a.sentinelsAndGroups()
+		if err != nil {
+			fail("list groups fail. err-[%s]", err.Error())
+		}
+		sentinel := redis.NewSentinel(s.config().ProductName, a.old)
+		for _, addr := range a.sentinels {
+			if err := sentinel.SetAuthPass(addr, s.config().SentinelClientTimeout.Duration(), groupNames); err != nil {
+				fail("sentinel-[%s] set auth-pass fail. err-[%s]", addr, err.Error())
+			}
+		}
+	}
+
+	rewrite := make(map[string]bool)
+	for i := len(a.requirePass) - 1; i >= 0; i-- {
+		addr := a.requirePass[i]
+		rewrite[addr] = true
+		if err := a.callServer(addr, func(addr string, c *redis.Client) error {
+			return c.ConfigSet("requirepass", a.old.Password)
+		}); err != nil {
+			fail("server-[%s] set requirepass fail. err-[%s]", addr, err.Error())
+		}
+	}
+	for _, addr := range a.masterAuth {
+		rewrite[addr] = true
+		if err := a.callServer(addr, func(addr string, c *redis.Client) error {
+			return c.ConfigSet("masterauth", a.old.Password)
+		}); err != nil {
+			fail("server-[%s] set masterauth fail. err-[%s]", addr, err.Error())
+		}
+	}
+	for addr := range rewrite {
+		if err := a.callServer(addr, func(addr string, c *redis.Client) error {
+			return c.ConfigRewrite()
+		}); err != nil {
+			fail("server-[%s] config rewrite fail. err-[%s]", addr, err.Error())
+		}
+	}
+
+	s.setRedisOptions(a.old)
+	log.Warnf("service::RotateAuth the auth of product-[%s] is rolled back", s.config().ProductName)
+}
@@ -0,0 +1,171 @@
+package topom
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/client/redis/redistest"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// setupAuth makes the servers of the cluster require the auth, the config of
+// the dashboard is loaded from a file which holds it.
+func (e *testEnv) setupAuth(t *testing.T, c *testCluster, auth string) string {
+	for _, s := range c.servers {
+		s.SetPassword(auth)
+	}
+	e.setRedisOptions(&redis.Options{Password: auth})
+
+	dir, err := ioutil.TempDir("", "pikamgr-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "dashboard.toml")
+	text := "# the product\nproduct_name = \"" + testProduct + "\"\nproduct_auth = \"" + auth + "\"\n"
+	if err := ioutil.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.config().LoadFromFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// recordRequirePass records the servers in the order their requirepass is set.
+func recordRequirePass(servers []*redistest.Server) func() []string {
+	var mutex sync.Mutex
+	var order []string
+	for _, s := range servers {
+		addr := s.Addr()
+		s.SetHook(func(args []string) error {
+			if len(args) >= 3 && args[0] == "CONFIG" && strings.ToLower(args[2]) == "requirepass" {
+				mutex.Lock()
+				order = append(order, addr)
+				mutex.Unlock()
+			}
+			return nil
+		})
+	}
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), order...)
+	}
+}
+
+func TestRotateAuth(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 1)
+	defer c.close()
+	file := e.setupAuth(t, c, "0ld-s3cret")
+	defer os.RemoveAll(filepath.Dir(file))
+	order := recordRequirePass(c.servers)
+
+	if err := e.RotateAuth("n3w-s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.servers {
+		if s.ConfigValue("requirepass") != "n3w-s3cret" || s.ConfigValue("masterauth") != "n3w-s3cret" || s.Rewrites() == 0 {
+			t.Fatalf("server %s requirepass %q masterauth %q", s.Addr(), s.ConfigValue("requirepass"), s.ConfigValue("masterauth"))
+		}
+	}
+	// the slave is switched before its master.
+	if got := order(); len(got) != 2 || got[0] != c.servers[1].Addr() || got[1] != c.servers[0].Addr() {
+		t.Fatalf("requirepass set on %v", got)
+	}
+	if opts := c.sentinels[0].MasterOptions(testProduct + "-g1"); opts["auth-pass"] != "n3w-s3cret" {
+		t.Fatalf("unexpected options %v", opts)
+	}
+	if o := e.redisOptions(); o.Password != "n3w-s3cret" || o.FallbackPassword != "" {
+		t.Fatalf("options %+v", o)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# the product\nproduct_name = \"" + testProduct + "\"\nproduct_auth = \"n3w-s3cret\"\n"; string(b) != want {
+		t.Fatalf("config file:\n%s", b)
+	}
+
+	// the stats are collected with the new auth.
+	if _, _, err := e.stats.redisp.Info(c.servers[0].Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, auth := range []string{"", "n3w-s3cret"} {
+		if err := e.RotateAuth(auth); err == nil {
+			t.Fatalf("rotate to %q", auth)
+		}
+	}
+}
+
+func TestRotateAuthRollback(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 1)
+	defer c.close()
+	file := e.setupAuth(t, c, "0ld-s3cret")
+	defer os.RemoveAll(filepath.Dir(file))
+	order := recordRequirePass(c.servers)
+
+	c.sentinels[0].Fail("SENTINEL SET", "ERR injected")
+	r, err := e.submitRotateAuth("n3w-s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.wait(); err == nil {
+		t.Fatal("rotation with a failing sentinel")
+	}
+
+	for _, s := range c.servers {
+		if s.ConfigValue("requirepass") != "0ld-s3cret" || s.ConfigValue("masterauth") != "0ld-s3cret" {
+			t.Fatalf("server %s requirepass %q masterauth %q", s.Addr(), s.ConfigValue("requirepass"), s.ConfigValue("masterauth"))
+		}
+	}
+	// the master is switched back before its slave.
+	if got := order(); len(got) != 4 || got[2] != c.servers[0].Addr() || got[3] != c.servers[1].Addr() {
+		t.Fatalf("requirepass set on %v", got)
+	}
+	if o := e.redisOptions(); o.Password != "0ld-s3cret" || o.FallbackPassword != "" {
+		t.Fatalf("options %+v", o)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || !strings.Contains(string(b), `product_auth = "0ld-s3cret"`) {
+		t.Fatalf("config file:\n%s, err = %v", b, err)
+	}
+
+	j := e.storedJob(t, r.job.ID)
+	states := map[string]string{
+		rotateMasterAuth: dao.TargetDone, rotateRequirePass: dao.TargetDone, rotateSentinels: dao.TargetFailed,
+		rotateConfigRewrite: dao.TargetCancelled, rotateDashboardConfig: dao.TargetCancelled,
+	}
+	for _, target := range j.Targets {
+		if target.State != states[target.Name] {
+			t.Fatalf("target %s %s", target.Name, target.State)
+		}
+	}
+	if j.State != dao.JobFailed {
+		t.Fatalf("job %s", j.State)
+	}
+	for _, line := range j.Logs {
+		if strings.Contains(line, "n3w-s3cret") || strings.Contains(line, "0ld-s3cret") {
+			t.Fatalf("auth in the logs: %s", line)
+		}
+	}
+
+	// another rotation may run once it's rolled back.
+	c.sentinels[0].Fail("SENTINEL SET", "")
+	if err := e.RotateAuth("n3w-s3cret"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,8 @@ package redis
 
 import (
 	"container/list"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strconv"
@@ -12,15 +14,84 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pourer/pikamgr/utils/log"
+
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/math2"
 	redigo "github.com/garyburd/redigo/redis"
 )
 
+// Options are the options of the connections to the Pika servers and the
+// sentinels.
+type Options struct {
+	// TLS is the config of the tls connections, plain tcp if nil. The server
+	// name defaults to the host of the address.
+	TLS *tls.Config
+
+	// Username and Password authenticate to the Pika servers, as an ACL user
+	// unless Username is empty.
+	Username string
+	Password string
+
+	// FallbackPassword is tried once Password is refused, while the servers
+	// are switched from one password to the other.
+	FallbackPassword string
+
+	// SentinelUsername and SentinelPassword authenticate to the sentinels,
+	// which aren't authenticated to if SentinelPassword is empty.
+	SentinelUsername string
+	SentinelPassword string
+}
+
+// Sentinel returns the options of the connections to the sentinels.
+func (o *Options) Sentinel() *Options {
+	if o == nil {
+		return nil
+	}
+	return &Options{TLS: o.TLS, Username: o.SentinelUsername, Password: o.SentinelPassword}
+}
+
+func (o *Options) tlsConfig() *tls.Config {
+	if o == nil {
+		return nil
+	}
+	return o.TLS
+}
+
+func (o *Options) auth() (username, password string) {
+	if o == nil {
+		return "", ""
+	}
+	return o.Username, o.Password
+}
+
+func (o *Options) fallback() string {
+	if o == nil {
+		return ""
+	}
+	return o.FallbackPassword
+}
+
+// authenticate sends AUTH with the password, then with the fallback if the
+// password is refused.
+func authenticate(c redigo.Conn, username, password, fallback string) error {
+	args := []interface{}{password}
+	if username != "" {
+		args = []interface{}{username, password}
+	}
+	_, err := c.Do("AUTH", args...)
+	if _, refused := err.(redigo.Error); refused && fallback != "" {
+		args[len(args)-1] = fallback
+		_, err = c.Do("AUTH", args...)
+	}
+	return err
+}
+
 type Client struct {
-	conn redigo.Conn
-	Addr string
-	Auth string
+	conn     redigo.Conn
+	Addr     string
+	Auth     string
+	Username string
 
 	Database int
 
@@ -37,20 +108,79 @@ func NewClientNoAuth(addr string, timeout time.Duration) (*Client, error) {
 }
 
 func NewClient(addr string, auth string, timeout time.Duration) (*Client, error) {
+	return NewClientOptions(addr, &Options{Password: auth}, timeout)
+}
+
+// NewClientOptions returns a client of the server connected with the options,
+// nil for a plain connection without AUTH.
+func NewClientOptions(addr string, options *Options, timeout time.Duration) (*Client, error) {
+	return NewClientContext(context.Background(), addr, options, timeout)
+}
+
+// NewClientContext is NewClientOptions whose dial, the tls handshake and AUTH
+// included, is interrupted once ctx is done. The client doesn't watch ctx
+// afterwards.
+func NewClientContext(ctx context.Context, addr string, options *Options, timeout time.Duration) (*Client, error) {
+	var stop func() bool
+	dial := func(network, address string) (net.Conn, error) {
+		d := &net.Dialer{Timeout: math2.MinDuration(time.Second, timeout)}
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		stop = context.AfterFunc(ctx, func() { conn.Close() })
+		if config := options.tlsConfig(); config != nil {
+			if conn, err = handshake(conn, address, config, timeout); err != nil {
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
 	c, err := redigo.Dial("tcp", addr, []redigo.DialOption{
-		redigo.DialConnectTimeout(math2.MinDuration(time.Second, timeout)),
-		redigo.DialPassword(auth),
+		redigo.DialNetDial(dial),
 		redigo.DialReadTimeout(timeout), redigo.DialWriteTimeout(timeout),
 	}...)
+	username, password := options.auth()
+	if err == nil && password != "" {
+		if err = authenticate(c, username, password, options.fallback()); err != nil {
+			c.Close()
+		}
+	}
+	if stop != nil && !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, errors.Trace(ctx.Err())
+	}
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	return &Client{
-		conn: c, Addr: addr, Auth: auth,
+		conn: c, Addr: addr, Auth: password, Username: username,
 		LastUse: time.Now(), Timeout: timeout,
 	}, nil
 }
 
+func handshake(conn net.Conn, address string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tc := tls.Client(conn, config)
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
@@ -149,6 +279,10 @@ func (c *Client) Info() (string, map[string]string, error) {
 	if err != nil {
 		return "", nil, errors.Trace(err)
 	}
+	return text, parseInfo(text), nil
+}
+
+func parseInfo(text string) map[string]string {
 	info := make(map[string]string)
 	for _, line := range strings.Split(text, "\n") {
 		kv := strings.SplitN(line, ":", 2)
@@ -159,7 +293,96 @@ func (c *Client) Info() (string, map[string]string, error) {
 			info[key] = strings.TrimSpace(kv[1])
 		}
 	}
-	return text, info, nil
+	return info
+}
+
+// InfoReplication returns the replication section only, with master_addr
+// set as InfoFull does.
+func (c *Client) InfoReplication() (map[string]string, error) {
+	text, err := redigo.String(c.Do("INFO", "replication"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info := parseInfo(text)
+	host := info["master_host"]
+	port := info["master_port"]
+	if host != "" || port != "" {
+		info["master_addr"] = net.JoinHostPort(host, port)
+	}
+	return info, nil
+}
+
+// InfoSectionKeys returns the keys of the sections of the INFO text whose
+// header starts with prefix, like "Replication" for the "# Replication(MASTER)"
+// of Pika.
+func InfoSectionKeys(text, prefix string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, line := range InfoSection(text, prefix) {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			if key := strings.TrimSpace(kv[0]); key != "" {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// InfoSection returns the lines of the sections of the INFO text whose header
+// starts with prefix, the headers included.
+func InfoSection(text, prefix string) []string {
+	var lines []string
+	var in bool
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if isInfoHeader(line) {
+			in = strings.HasPrefix(strings.TrimSpace(line[1:]), prefix)
+		}
+		if in && line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// ReplaceInfoSection replaces the sections of the INFO text whose header
+// starts with prefix with the lines, which are appended if there is none.
+func ReplaceInfoSection(text, prefix string, lines []string) string {
+	var out []string
+	var in bool
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if t := strings.TrimSpace(line); isInfoHeader(t) {
+			in = strings.HasPrefix(strings.TrimSpace(t[1:]), prefix)
+		}
+		if !in {
+			out = append(out, line)
+		}
+	}
+	for len(out) != 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+		out = out[:len(out)-1]
+	}
+	if len(out) != 0 {
+		out = append(out, "")
+	}
+	return strings.Join(append(out, lines...), "\r\n") + "\r\n"
+}
+
+// isInfoHeader tells a section header from a comment like the "# Time:" in
+// the keyspace of Pika.
+func isInfoHeader(line string) bool {
+	return strings.HasPrefix(line, "#") && !strings.Contains(line, ":")
+}
+
+// InfoText returns the INFO text of the section.
+func (c *Client) InfoText(section string) (string, error) {
+	text, err := redigo.String(c.Do("INFO", section))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return text, nil
 }
 
 func (c *Client) InfoKeySpace() (string, map[int]string, error) {
@@ -213,24 +436,73 @@ func (c *Client) InfoFull() (string, map[string]string, error) {
 	return text, info, nil
 }
 
+type command struct {
+	Cmd  string
+	Args []interface{}
+}
+
+// maskSecrets masks the secrets a command carries in its error, a server may
+// quote the argument it rejects, like the value of a CONFIG SET.
+func maskSecrets(err error, secrets ...string) error {
+	msg := err.Error()
+	for _, s := range secrets {
+		if s != "" {
+			msg = strings.Replace(msg, s, log.Redacted, -1)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	if _, ok := errors.Cause(err).(redigo.Error); ok {
+		return errors.Trace(redigo.Error(msg))
+	}
+	return errors.New(msg)
+}
+
+// ConfigSet sets the config of the server, it isn't rewritten.
+func (c *Client) ConfigSet(key, value string) error {
+	if _, err := c.Do("CONFIG", "SET", key, value); err != nil {
+		if key == "requirepass" || key == "masterauth" {
+			err = maskSecrets(err, value)
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (c *Client) ConfigRewrite() error {
+	if _, err := c.Do("CONFIG", "REWRITE"); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// masterAuthCommands make a slave authenticate to its master as the client
+// does, the ACL user is set only if there is one.
+func (c *Client) masterAuthCommands() []command {
+	cmds := []command{
+		{Cmd: "CONFIG", Args: []interface{}{"SET", "masterauth", c.Auth}},
+	}
+	if c.Username != "" {
+		cmds = append(cmds, command{Cmd: "CONFIG", Args: []interface{}{"SET", "masteruser", c.Username}})
+	}
+	return cmds
+}
+
 func (c *Client) SetMaster(master string) error {
 	host, port, err := net.SplitHostPort(master)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	opts := []struct {
-		Cmd  string
-		Args []interface{}
-	}{
-		{Cmd: "CONFIG", Args: []interface{}{"SET", "masterauth", c.Auth}},
+	opts := append(c.masterAuthCommands(), []command{
 		{Cmd: "SLAVEOF", Args: []interface{}{host, port}},
 		{Cmd: "CONFIG", Args: []interface{}{"REWRITE"}},
-	}
+	}...)
 
 	for _, opt := range opts {
 		if _, err := c.conn.Do(opt.Cmd, opt.Args...); err != nil {
-			return errors.Trace(fmt.Errorf("cmd:%s err:%s", opt.Cmd, err.Error()))
+			return errors.Trace(fmt.Errorf("cmd:%s err:%s", opt.Cmd, maskSecrets(err, c.Auth).Error()))
 		}
 	}
 
@@ -259,19 +531,15 @@ func (c *Client) ForceFullSyncFromMaster(master string) error {
 		return errors.Trace(err)
 	}
 
-	opts := []struct {
-		Cmd  string
-		Args []interface{}
-	}{
-		{Cmd: "CONFIG", Args: []interface{}{"SET", "masterauth", c.Auth}},
+	opts := append(c.masterAuthCommands(), []command{
 		{Cmd: "SLAVEOF", Args: []interface{}{"NO", "ONE"}},
 		{Cmd: "SLAVEOF", Args: []interface{}{host, port, "force"}},
 		{Cmd: "CONFIG", Args: []interface{}{"REWRITE"}},
-	}
+	}...)
 
 	for _, opt := range opts {
 		if _, err := c.conn.Do(opt.Cmd, opt.Args...); err != nil {
-			return errors.Trace(fmt.Errorf("cmd:%s err:%s", opt.Cmd, err.Error()))
+			return errors.Trace(fmt.Errorf("cmd:%s err:%s", opt.Cmd, maskSecrets(err, c.Auth).Error()))
 		}
 	}
 	return nil
@@ -362,8 +630,8 @@ var ErrClosedPool = errors.New("use of closed redis pool")
 type Pool struct {
 	mu sync.Mutex
 
-	auth string
-	pool map[string]*list.List
+	options *Options
+	pool    map[string]*list.List
 
 	timeout time.Duration
 
@@ -374,9 +642,10 @@ type Pool struct {
 	closed bool
 }
 
-func NewPool(auth string, timeout time.Duration) *Pool {
+// NewPool returns a pool of the clients connected with the options.
+func NewPool(options *Options, timeout time.Duration) *Pool {
 	p := &Pool{
-		auth: auth, timeout: timeout,
+		options: options, timeout: timeout,
 		pool: make(map[string]*list.List),
 	}
 	p.exit.C = make(chan struct{})
@@ -399,6 +668,14 @@ func NewPool(auth string, timeout time.Duration) *Pool {
 	return p
 }
 
+// SetOptions sets the options of the clients connected from now on, the
+// idle clients are kept.
+func (p *Pool) SetOptions(options *Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.options = options
+}
+
 func (p *Pool) Close() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -442,18 +719,27 @@ func (p *Pool) Cleanup() error {
 }
 
 func (p *Pool) GetClient(addr string) (*Client, error) {
-	c, err := p.getClientFromCache(addr)
+	c, options, err := p.getClientFromCache(addr)
+	if err != nil || c != nil {
+		return c, err
+	}
+	return NewClientOptions(addr, options, p.timeout)
+}
+
+// GetClientContext is GetClient whose dial is interrupted once ctx is done.
+func (p *Pool) GetClientContext(ctx context.Context, addr string) (*Client, error) {
+	c, options, err := p.getClientFromCache(addr)
 	if err != nil || c != nil {
 		return c, err
 	}
-	return NewClient(addr, p.auth, p.timeout)
+	return NewClientContext(ctx, addr, options, p.timeout)
 }
 
-func (p *Pool) getClientFromCache(addr string) (*Client, error) {
+func (p *Pool) getClientFromCache(addr string) (*Client, *Options, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.closed {
-		return nil, ErrClosedPool
+		return nil, nil, ErrClosedPool
 	}
 	if list := p.pool[addr]; list != nil {
 		for i := list.Len(); i != 0; i-- {
@@ -461,11 +747,11 @@ func (p *Pool) getClientFromCache(addr string) (*Client, error) {
 			if !c.isRecyclable() {
 				c.Close()
 			} else {
-				return c, nil
+				return c, p.options, nil
 			}
 		}
 	}
-	return nil, nil
+	return nil, p.options, nil
 }
 
 func (p *Pool) PutClient(c *Client) {
@@ -513,8 +799,8 @@ func (p *Pool) InfoFull(addr string) (string, map[string]string, error) {
 type InfoCache struct {
 	mu sync.Mutex
 
-	Auth string
-	data map[string]map[string]string
+	Options *Options
+	data    map[string]map[string]string
 
 	Timeout time.Duration
 }
@@ -556,7 +842,7 @@ func (s *InfoCache) GetRunId(addr string) string {
 }
 
 func (s *InfoCache) getSlow(addr string) (string, map[string]string, error) {
-	c, err := NewClient(addr, s.Auth, s.Timeout)
+	c, err := NewClientOptions(addr, s.Options, s.Timeout)
 	if err != nil {
 		return "", nil, err
 	}
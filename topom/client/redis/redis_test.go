@@ -0,0 +1,521 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/client/redis/redistest"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+func newTestServer(t *testing.T) *redistest.Server {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestSentinel(t *testing.T) *redistest.Server {
+	s, err := redistest.NewSentinel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestClientSetMaster(t *testing.T) {
+	master, slave := newTestServer(t), newTestServer(t)
+	defer master.Close()
+	defer slave.Close()
+
+	c, err := NewClient(slave.Addr(), "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SetMaster(master.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if slave.Master() != master.Addr() || slave.Rewrites() != 1 {
+		t.Fatalf("master = %s, rewrites = %d", slave.Master(), slave.Rewrites())
+	}
+
+	_, info, err := c.InfoFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["master_addr"] != master.Addr() || info["master_link_status"] != "up" {
+		t.Fatalf("unexpected info %v", info)
+	}
+	if role, err := c.Role(); err != nil || role != "SLAVE" {
+		t.Fatalf("role = %s, %v", role, err)
+	}
+
+	if err := c.ForceFullSyncFromMaster(master.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if slave.FullSyncs() != 1 || slave.Master() != master.Addr() {
+		t.Fatalf("full syncs = %d, master = %s", slave.FullSyncs(), slave.Master())
+	}
+
+	if err := c.SetMaster("NO:ONE"); err != nil {
+		t.Fatal(err)
+	}
+	if slave.Master() != "" {
+		t.Fatalf("master = %s, expect none", slave.Master())
+	}
+
+	slave.Fail("SLAVEOF", "ERR injected")
+	if err := c.SetMaster(master.Addr()); err == nil {
+		t.Fatal("set master with injected failure")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.SetPassword("secret")
+
+	if c, err := NewClient(s.Addr(), "wrong", time.Second); err == nil {
+		c.Close()
+		t.Fatal("connect with wrong password")
+	}
+	c, err := NewClient(s.Addr(), "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, _, err := c.Info(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fallback password is tried once the password is refused.
+	fallback := &Options{Password: "next", FallbackPassword: "secret"}
+	c2, err := NewClientOptions(s.Addr(), fallback, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	s.SetPassword("next")
+	c2, err = NewClientOptions(s.Addr(), fallback, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	if c2, err := NewClientOptions(s.Addr(), &Options{Password: "secret", FallbackPassword: "other"}, time.Second); err == nil {
+		c2.Close()
+		t.Fatal("connect with wrong passwords")
+	}
+}
+
+func TestClientMaskSecrets(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	// the server quotes the value it rejects.
+	s.Fail("CONFIG SET", "ERR Invalid argument 'leaked' for CONFIG SET")
+
+	// a client is closed by an error.
+	for _, call := range []struct {
+		name   string
+		masked bool
+		call   func(c *Client) error
+	}{
+		{"requirepass", true, func(c *Client) error { return c.ConfigSet("requirepass", "leaked") }},
+		{"maxclients", false, func(c *Client) error { return c.ConfigSet("maxclients", "leaked") }},
+		{"set master", true, func(c *Client) error { return c.SetMaster("127.0.0.1:6379") }},
+	} {
+		c, err := NewClient(s.Addr(), "", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Auth = "leaked"
+		err = call.call(c)
+		c.Close()
+		if err == nil || strings.Contains(err.Error(), "leaked") == call.masked {
+			t.Fatalf("%s = %v", call.name, err)
+		}
+		if call.name == "requirepass" {
+			if _, ok := errors.Cause(err).(redigo.Error); !ok {
+				t.Fatalf("%s = %T, the reply error is lost", call.name, errors.Cause(err))
+			}
+		}
+	}
+}
+
+// newTestTLS returns the configs of a server with a self-signed certificate of
+// 127.0.0.1 and of a client which trusts it.
+func newTestTLS(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func TestClientOptions(t *testing.T) {
+	master, slave := newTestServer(t), newTestServer(t)
+	defer master.Close()
+	defer slave.Close()
+	serverTLS, clientTLS := newTestTLS(t)
+	for _, s := range []*redistest.Server{master, slave} {
+		s.SetTLS(serverTLS)
+		s.SetUser("pikamgr", "secret")
+	}
+
+	options := &Options{TLS: clientTLS, Username: "pikamgr", Password: "secret"}
+	for _, o := range []*Options{
+		{Username: "pikamgr", Password: "secret"},
+		{TLS: clientTLS, Password: "secret"},
+		{TLS: clientTLS, Username: "other", Password: "secret"},
+		{TLS: &tls.Config{}, Username: "pikamgr", Password: "secret"},
+	} {
+		if c, err := NewClientOptions(slave.Addr(), o, time.Second); err == nil {
+			c.Close()
+			t.Fatalf("connected with %+v", o)
+		}
+	}
+
+	p := NewPool(options, time.Second)
+	defer p.Close()
+	if _, _, err := p.Info(slave.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the slave authenticates to its master as the client does.
+	c, err := NewClientOptions(slave.Addr(), options, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SetMaster(master.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if slave.ConfigValue("masterauth") != "secret" || slave.ConfigValue("masteruser") != "pikamgr" {
+		t.Fatalf("masterauth = %q, masteruser = %q", slave.ConfigValue("masterauth"), slave.ConfigValue("masteruser"))
+	}
+
+	// the sentinels are connected with their own auth.
+	s := newTestSentinel(t)
+	defer s.Close()
+	s.SetTLS(serverTLS)
+	s.SetUser("sentinel", "hidden")
+	s.Monitor("demo-g1", master.Addr(), 1)
+	options.SentinelUsername, options.SentinelPassword = "sentinel", "hidden"
+	if groups, err := NewSentinel("demo", options).MastersAndSlaves(s.Addr(), time.Second); err != nil || groups["demo-g1"] == nil {
+		t.Fatalf("groups = %v, err = %v", groups, err)
+	}
+	options.SentinelPassword = "secret"
+	if _, err := NewSentinel("demo", options).MastersAndSlaves(s.Addr(), time.Second); err == nil {
+		t.Fatal("sentinel connected with the auth of the servers")
+	}
+}
+
+func TestSentinelMonitorGroups(t *testing.T) {
+	m1, m2 := newTestServer(t), newTestServer(t)
+	defer m1.Close()
+	defer m2.Close()
+	s1, s2, s3 := newTestSentinel(t), newTestSentinel(t), newTestSentinel(t)
+	defer s1.Close()
+	defer s2.Close()
+	defer s3.Close()
+	sentinels := []string{s1.Addr(), s2.Addr(), s3.Addr()}
+
+	// a master of another product must be kept.
+	s1.Monitor("other-g1", m1.Addr(), 2)
+
+	p := NewSentinel("demo", &Options{Username: "pikamgr", Password: "auth"})
+	configs := map[string]*MonitorConfig{
+		"g1": {Quorum: 2, ParallelSyncs: 1, DownAfter: 5 * time.Second, FailoverTimeout: time.Minute},
+		"g2": {Quorum: 3, ParallelSyncs: 4, DownAfter: time.Second, FailoverTimeout: time.Minute},
+	}
+	groups := map[string]string{"g1": m1.Addr(), "g2": m2.Addr()}
+	if err := p.MonitorGroups(sentinels, time.Second, map[string]*MonitorConfig{"g1": configs["g1"]}, groups); err == nil {
+		t.Fatal("monitor a group without its config")
+	}
+	if err := p.MonitorGroups(sentinels, time.Second, configs, groups); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*redistest.Server{s1, s2, s3} {
+		if addr := s.Monitored()["demo-g1"]; addr != m1.Addr() {
+			t.Fatalf("sentinel %s monitors demo-g1 at %s", s.Addr(), addr)
+		}
+		opts := s.MasterOptions("demo-g1")
+		if opts["down-after-milliseconds"] != "5000" || opts["parallel-syncs"] != "1" || opts["auth-pass"] != "auth" || opts["auth-user"] != "pikamgr" {
+			t.Fatalf("unexpected options %v", opts)
+		}
+		// every master is monitored with the config of its group.
+		opts = s.MasterOptions("demo-g2")
+		if opts["down-after-milliseconds"] != "1000" || opts["parallel-syncs"] != "4" || s.Quorum("demo-g2") != 3 {
+			t.Fatalf("unexpected options %v, quorum %d", opts, s.Quorum("demo-g2"))
+		}
+	}
+
+	masters, err := p.Masters(sentinels, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masters) != 2 || masters["g1"] != m1.Addr() || masters["g2"] != m2.Addr() {
+		t.Fatalf("unexpected masters %v", masters)
+	}
+
+	if err := p.RemoveGroups(sentinels, time.Second, map[string]bool{"g1": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s2.Monitored()["demo-g1"]; ok {
+		t.Fatal("demo-g1 not removed")
+	}
+	if err := p.RemoveGroupsAll(sentinels, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m := s1.Monitored(); len(m) != 1 || m["other-g1"] == "" {
+		t.Fatalf("unexpected masters after remove all %v", m)
+	}
+}
+
+func TestSentinelMonitoredMasters(t *testing.T) {
+	m1 := newTestServer(t)
+	defer m1.Close()
+	s1, s2 := newTestSentinel(t), newTestSentinel(t)
+	defer s1.Close()
+	defer s2.Close()
+	s1.Monitor("other-g1", m1.Addr(), 1)
+
+	p := NewSentinel("demo", &Options{Password: "auth"})
+	config := &MonitorConfig{Quorum: 2, ParallelSyncs: 1, DownAfter: 5 * time.Second, FailoverTimeout: time.Minute}
+	sentinels := []string{s1.Addr(), s2.Addr()}
+	if err := p.MonitorGroups(sentinels, time.Second, map[string]*MonitorConfig{"g1": config}, map[string]string{"g1": m1.Addr()}); err != nil {
+		t.Fatal(err)
+	}
+	masters, err := p.MonitoredMasters(s1.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := masters["g1"]
+	if len(masters) != 1 || m == nil || m.Addr != m1.Addr() || *m.Config != *config {
+		t.Fatalf("unexpected masters %v", masters)
+	}
+	if len(m.Peers) != 1 || m.Peers[0] != s2.Addr() {
+		t.Fatalf("unexpected peers %v", m.Peers)
+	}
+
+	changed := &MonitorConfig{Quorum: 1, ParallelSyncs: 2, DownAfter: time.Second, FailoverTimeout: time.Minute, NotificationScript: "/bin/notify"}
+	if err := p.ConfigureGroups(s1.Addr(), time.Second, map[string]*MonitorConfig{"g1": changed}); err != nil {
+		t.Fatal(err)
+	}
+	if s1.Quorum("demo-g1") != 1 || s1.MasterOptions("demo-g1")["notification-script"] != "/bin/notify" {
+		t.Fatalf("quorum %d, options %v", s1.Quorum("demo-g1"), s1.MasterOptions("demo-g1"))
+	}
+	if masters, err = p.MonitoredMasters(s1.Addr(), time.Second); err != nil || *masters["g1"].Config != *changed {
+		t.Fatalf("unexpected masters %v, %v", masters, err)
+	}
+
+	// a peer which went away is remembered until the master is reset.
+	s2.Close()
+	if masters, err = p.MonitoredMasters(s1.Addr(), time.Second); err != nil || len(masters["g1"].Peers) != 1 {
+		t.Fatalf("unexpected masters %v, %v", masters, err)
+	}
+	if err := p.ResetGroups(s1.Addr(), time.Second, []string{"g1"}); err != nil {
+		t.Fatal(err)
+	}
+	if masters, err = p.MonitoredMasters(s1.Addr(), time.Second); err != nil || len(masters["g1"].Peers) != 0 {
+		t.Fatalf("unexpected masters %v, %v", masters, err)
+	}
+}
+
+func TestSentinelMastersAndSlaves(t *testing.T) {
+	master, slave := newTestServer(t), newTestServer(t)
+	defer master.Close()
+	defer slave.Close()
+	slave.SetMaster(master.Addr())
+
+	s := newTestSentinel(t)
+	defer s.Close()
+	s.Monitor("demo-g1", master.Addr(), 1)
+
+	groups, err := NewSentinel("demo", nil).MastersAndSlaves(s.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := groups["demo-g1"]
+	if g == nil || g.Master["ip"]+":"+g.Master["port"] != master.Addr() {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+	if len(g.Slaves) != 1 || g.Slaves[0]["name"] != slave.Addr() || g.Slaves[0]["master-link-status"] != "ok" {
+		t.Fatalf("unexpected slaves %v", g.Slaves)
+	}
+
+	if err := NewSentinel("demo", nil).FlushConfig(s.Addr(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if s.Flushes() != 1 {
+		t.Fatalf("flushes = %d", s.Flushes())
+	}
+}
+
+func TestSentinelSubscribe(t *testing.T) {
+	master, slave := newTestServer(t), newTestServer(t)
+	defer master.Close()
+	defer slave.Close()
+	slave.SetMaster(master.Addr())
+
+	s := newTestSentinel(t)
+	defer s.Close()
+	s.Monitor("demo-g1", master.Addr(), 1)
+
+	p := NewSentinel("demo", nil)
+	defer p.Cancel()
+	events := make(chan *SentinelEvent, 16)
+	p.EventFunc = func(e *SentinelEvent) {
+		events <- e
+	}
+
+	subscribed := make(chan struct{})
+	notified := make(chan bool, 1)
+	go func() {
+		notified <- p.Subscribe([]string{s.Addr()}, 5*time.Second, func() {
+			close(subscribed)
+		})
+	}()
+
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe timeout")
+	}
+	// the events of another product are skipped.
+	s.Publish("+sdown", "master other-g1 127.0.0.1 7000")
+	s.Publish("+odown", "master demo-g1 "+strings.Replace(master.Addr(), ":", " ", 1)+" #quorum 1/1")
+	if err := s.Failover("demo-g1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ok := <-notified:
+		if !ok {
+			t.Fatal("subscribe not notified")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("+switch-master not received")
+	}
+
+	var types []string
+	for len(events) != 0 {
+		e := <-events
+		types = append(types, e.Type)
+		switch e.Type {
+		case "+odown":
+			if e.Group != "g1" || e.Instance != "master" || e.Addr != master.Addr() || e.Details != "#quorum 1/1" {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		case "+promoted-slave":
+			if e.Instance != "slave" || e.Addr != slave.Addr() || e.Master != master.Addr() {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		case "+switch-master":
+			if e.Sentinel != s.Addr() || e.Addr != master.Addr() || e.Master != slave.Addr() {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		}
+	}
+	if strings.Join(types, " ") != "+odown +try-failover +elected-leader +selected-slave +promoted-slave +failover-end +switch-master" {
+		t.Fatalf("unexpected events %v", types)
+	}
+
+	if slave.Master() != "" || master.Master() != slave.Addr() {
+		t.Fatalf("failover not applied, slave of %q, master of %q", slave.Master(), master.Master())
+	}
+	masters, err := p.Masters([]string{s.Addr()}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masters["g1"] != slave.Addr() {
+		t.Fatalf("unexpected masters after failover %v", masters)
+	}
+}
+
+func TestClientInfoReplication(t *testing.T) {
+	master, slave := newTestServer(t), newTestServer(t)
+	defer master.Close()
+	defer slave.Close()
+	slave.SetMaster(master.Addr())
+
+	c, err := NewClientContext(context.Background(), slave.Addr(), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	info, err := c.InfoReplication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["role"] != "slave" || info["master_addr"] != master.Addr() || info["run_id"] != "" {
+		t.Fatalf("unexpected info %v", info)
+	}
+
+	text, _, err := c.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := InfoSectionKeys(text, "Replication")
+	if !keys["master_link_status"] || keys["run_id"] {
+		t.Fatalf("replication keys = %v", keys)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewClientContext(ctx, slave.Addr(), nil, time.Second); err == nil {
+		t.Fatal("dial with a cancelled context")
+	}
+}
+
+func TestInfoSection(t *testing.T) {
+	text := "# Server\r\nrun_id:1\r\n\r\n# Keyspace\r\n# Time:2026-01-01 00:00:00\r\ndb0_Strings: keys=1\r\n\r\n# Replication(MASTER)\r\nrole:master\r\n"
+	if lines := InfoSection(text, "Keyspace"); len(lines) != 3 || lines[2] != "db0_Strings: keys=1" {
+		t.Fatalf("keyspace = %q", lines)
+	}
+	if keys := InfoSectionKeys(text, "Replication"); len(keys) != 1 || !keys["role"] {
+		t.Fatalf("replication keys = %v", keys)
+	}
+
+	replaced := ReplaceInfoSection(text, "Keyspace", []string{"# Keyspace", "db0_Strings: keys=2"})
+	if want := "# Server\r\nrun_id:1\r\n\r\n# Replication(MASTER)\r\nrole:master\r\n\r\n# Keyspace\r\ndb0_Strings: keys=2\r\n"; replaced != want {
+		t.Fatalf("replaced = %q", replaced)
+	}
+}
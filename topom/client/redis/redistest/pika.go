@@ -0,0 +1,311 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type pikaState struct {
+	runID  string
+	master string
+
+	config    map[string]string
+	extra     map[string]string
+	rewrites  int
+	fullSyncs int
+}
+
+func (p *pikaState) init(addr string) {
+	sum := sha1.Sum([]byte(addr))
+	p.runID = hex.EncodeToString(sum[:])
+	p.config = map[string]string{
+		"maxmemory":      "0",
+		"masterauth":     "",
+		"requirepass":    "",
+		"slave-priority": "100",
+	}
+	p.extra = make(map[string]string)
+}
+
+// Master returns the master of the server, empty if it's a master itself.
+func (s *Server) Master() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pika.master
+}
+
+// SetMaster changes the master like SLAVEOF without being recorded as a
+// call, empty makes the server a master.
+func (s *Server) SetMaster(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pika.master = addr
+}
+
+func (s *Server) RunID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pika.runID
+}
+
+func (s *Server) SetRunID(runID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pika.runID = runID
+}
+
+// SetInfo overrides or adds a field of the INFO reply, empty removes the
+// override.
+func (s *Server) SetInfo(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == "" {
+		delete(s.pika.extra, key)
+	} else {
+		s.pika.extra[key] = value
+	}
+}
+
+func (s *Server) ConfigValue(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pika.config[key]
+}
+
+// Rewrites returns the number of CONFIG REWRITE received.
+func (s *Server) Rewrites() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pika.rewrites
+}
+
+// FullSyncs returns the number of SLAVEOF ... force received.
+func (s *Server) FullSyncs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pika.fullSyncs
+}
+
+// slavesOf returns the live fake Pika servers replicating from addr.
+func slavesOf(addr string) []*Server {
+	var slaves []*Server
+	for _, s := range servers() {
+		if s.mode == ModePika && s.Master() == addr {
+			slaves = append(slaves, s)
+		}
+	}
+	return slaves
+}
+
+// linkUp reports whether the server can replicate from its master, the
+// master must be alive and accept the masterauth of the slave.
+func (s *Server) linkUp() bool {
+	s.mu.Lock()
+	master, auth := s.pika.master, s.pika.config["masterauth"]
+	s.mu.Unlock()
+
+	m := lookup(master)
+	if m == nil || m.mode != ModePika {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.closed && (m.password == "" || m.password == auth)
+}
+
+func splitAddr(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+type infoField struct {
+	key, value string
+}
+
+func (p *pikaState) info(s *Server, args []string) interface{} {
+	if len(args) != 0 && strings.ToLower(args[0]) == "keyspace" {
+		return "# Keyspace\r\n" +
+			"# Time:1970-01-01 08:00:00\r\n" +
+			"db0 Strings_keys=0, expires=0, invaild_keys=0\r\n" +
+			"db0 Hashes_keys=0, expires=0, invaild_keys=0\r\n"
+	}
+
+	linkUp := s.linkUp()
+	var slaves []*Server
+	s.mu.Lock()
+	master := p.master
+	s.mu.Unlock()
+	if master == "" {
+		slaves = slavesOf(s.addr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, port := splitAddr(s.addr)
+	sections := []struct {
+		name   string
+		fields []infoField
+	}{
+		{name: "Server", fields: []infoField{
+			{"pika_version", "3.0.16"},
+			{"os", "Linux"},
+			{"run_id", p.runID},
+			{"tcp_port", strconv.Itoa(port)},
+		}},
+		{name: "Replication"},
+	}
+	repl := &sections[1].fields
+	if p.master == "" {
+		*repl = append(*repl, infoField{"role", "master"},
+			infoField{"connected_slaves", strconv.Itoa(len(slaves))})
+		for i, slave := range slaves {
+			host, port := splitAddr(slave.addr)
+			*repl = append(*repl, infoField{fmt.Sprintf("slave%d", i),
+				fmt.Sprintf("ip=%s,port=%d,state=online,lag=0", host, port)})
+		}
+	} else {
+		status := "down"
+		if linkUp {
+			status = "up"
+		}
+		host, port := splitAddr(p.master)
+		*repl = append(*repl, infoField{"role", "slave"},
+			infoField{"master_host", host},
+			infoField{"master_port", strconv.Itoa(port)},
+			infoField{"master_link_status", status},
+			infoField{"slave_priority", p.config["slave-priority"]})
+	}
+
+	overrides := make(map[string]bool)
+	for i := range sections {
+		for j, f := range sections[i].fields {
+			if v, ok := p.extra[f.key]; ok {
+				sections[i].fields[j].value = v
+				overrides[f.key] = true
+			}
+		}
+	}
+	for k, v := range p.extra {
+		if !overrides[k] {
+			*repl = append(*repl, infoField{k, v})
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		if len(args) != 0 && !strings.EqualFold(args[0], section.name) {
+			continue
+		}
+		fmt.Fprintf(&b, "# %s\r\n", section.name)
+		for _, f := range section.fields {
+			fmt.Fprintf(&b, "%s:%s\r\n", f.key, f.value)
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+func (p *pikaState) role(s *Server) interface{} {
+	s.mu.Lock()
+	master := p.master
+	s.mu.Unlock()
+
+	if master == "" {
+		var slaves []interface{}
+		for _, slave := range slavesOf(s.addr) {
+			host, port := splitAddr(slave.addr)
+			slaves = append(slaves, []interface{}{host, strconv.Itoa(port), "0"})
+		}
+		return []interface{}{"master", int64(0), append([]interface{}{}, slaves...)}
+	}
+	state := "connect"
+	if s.linkUp() {
+		state = "connected"
+	}
+	host, port := splitAddr(master)
+	return []interface{}{"slave", host, port, state, int64(0)}
+}
+
+func (s *Server) slaveof(args []string) interface{} {
+	if len(args) != 2 && len(args) != 3 {
+		return errArgs("slaveof")
+	}
+	if strings.ToLower(args[0]) == "no" && strings.ToLower(args[1]) == "one" && len(args) == 2 {
+		s.SetMaster("")
+		return status("OK")
+	}
+	if len(args) == 3 && strings.ToLower(args[2]) != "force" {
+		return errors.New("ERR syntax error")
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.New("ERR Invalid master port")
+	}
+	addr := net.JoinHostPort(args[0], args[1])
+	if addr == s.addr {
+		return errors.New("ERR The master is self")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pika.master = addr
+	if len(args) == 3 {
+		s.pika.fullSyncs++
+	}
+	return status("OK")
+}
+
+func (s *Server) config(args []string) interface{} {
+	if len(args) == 0 {
+		return errArgs("config")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) != 2 {
+			return errArgs("config get")
+		}
+		var keys []string
+		for k := range s.pika.config {
+			if ok, _ := path.Match(strings.ToLower(args[1]), k); ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		reply := []string{}
+		for _, k := range keys {
+			reply = append(reply, k, s.pika.config[k])
+		}
+		return reply
+	case "SET":
+		if len(args) != 3 {
+			return errArgs("config set")
+		}
+		key := strings.ToLower(args[1])
+		if key == "maxmemory" {
+			if _, err := strconv.ParseInt(args[2], 10, 64); err != nil {
+				return errors.New("ERR Invalid argument '" + args[2] + "' for CONFIG SET 'maxmemory'")
+			}
+		}
+		s.pika.config[key] = args[2]
+		if key == "requirepass" {
+			s.password = args[2]
+		}
+		return status("OK")
+	case "REWRITE":
+		s.pika.rewrites++
+		return status("OK")
+	}
+	return fmt.Errorf("ERR CONFIG subcommand must be one of GET, SET, REWRITE")
+}
@@ -0,0 +1,610 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type sentinelMaster struct {
+	name    string
+	addr    string
+	quorum  int
+	epoch   int64
+	options map[string]string
+	known   map[string]bool
+}
+
+func (m *sentinelMaster) clone() *sentinelMaster {
+	c := *m
+	c.options = make(map[string]string, len(m.options))
+	for k, v := range m.options {
+		c.options[k] = v
+	}
+	c.known = make(map[string]bool, len(m.known))
+	for k, v := range m.known {
+		c.known[k] = v
+	}
+	return &c
+}
+
+type sentinelState struct {
+	masters map[string]*sentinelMaster
+	flushes int
+	subs    map[*conn][]string
+}
+
+func (t *sentinelState) init() {
+	t.masters = make(map[string]*sentinelMaster)
+	t.subs = make(map[*conn][]string)
+}
+
+func (s *Server) unsubscribe(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sentinel.subs, c)
+}
+
+var errNoSuchMaster = errors.New("ERR No such master with that name")
+
+// Monitor adds a master like SENTINEL MONITOR without being recorded as a
+// call.
+func (s *Server) Monitor(name, addr string, quorum int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sentinel.masters[name] = newSentinelMaster(name, addr, quorum)
+}
+
+func newSentinelMaster(name, addr string, quorum int) *sentinelMaster {
+	return &sentinelMaster{
+		name: name, addr: addr, quorum: quorum,
+		options: map[string]string{
+			"down-after-milliseconds": "30000",
+			"failover-timeout":        "180000",
+			"parallel-syncs":          "1",
+		},
+		known: make(map[string]bool),
+	}
+}
+
+// Monitored returns the monitored masters, name to address.
+func (s *Server) Monitored() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	masters := make(map[string]string, len(s.sentinel.masters))
+	for name, m := range s.sentinel.masters {
+		masters[name] = m.addr
+	}
+	return masters
+}
+
+// MasterOptions returns the options of the master set by SENTINEL SET,
+// including auth-pass, nil if not monitored.
+func (s *Server) MasterOptions(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sentinel.masters[name]
+	if m == nil {
+		return nil
+	}
+	return m.clone().options
+}
+
+func (s *Server) Quorum(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.sentinel.masters[name]; m != nil {
+		return m.quorum
+	}
+	return 0
+}
+
+func (s *Server) Epoch(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.sentinel.masters[name]; m != nil {
+		return m.epoch
+	}
+	return 0
+}
+
+// Flushes returns the number of SENTINEL FLUSHCONFIG received.
+func (s *Server) Flushes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sentinel.flushes
+}
+
+func (s *Server) master(name string) *sentinelMaster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.sentinel.masters[name]; m != nil {
+		return m.clone()
+	}
+	return nil
+}
+
+func (s *Server) masterList() []*sentinelMaster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*sentinelMaster
+	for _, m := range s.sentinel.masters {
+		list = append(list, m.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// peers returns the other live fake sentinels monitoring the same master,
+// and remembers them, a known peer that goes down is still counted by
+// ckquorum.
+func (s *Server) peers(name string) (alive []*Server, known int) {
+	for _, p := range servers() {
+		if p == s || p.mode != ModeSentinel || p.master(name) == nil {
+			continue
+		}
+		alive = append(alive, p)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sentinel.masters[name]
+	if m == nil {
+		return nil, 0
+	}
+	for _, p := range alive {
+		m.known[p.addr] = true
+	}
+	return alive, len(m.known)
+}
+
+// knownPeers returns the addresses of the peers remembered for the master,
+// the ones which went away included, as SENTINEL SENTINELS does.
+func (s *Server) knownPeers(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sentinel.masters[name]
+	if m == nil {
+		return nil
+	}
+	var addrs []string
+	for addr := range m.known {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func isDown(addr string) bool {
+	p := lookup(addr)
+	return p == nil || p.mode != ModePika || p.isClosed()
+}
+
+func (s *Server) masterReply(m *sentinelMaster) []string {
+	flags := "master"
+	if isDown(m.addr) {
+		flags = "s_down,master"
+	}
+	var runID string
+	if p := lookup(m.addr); p != nil {
+		runID = p.RunID()
+	}
+	peers, _ := s.peers(m.name)
+	host, port := splitAddr(m.addr)
+	reply := []string{
+		"name", m.name,
+		"ip", host,
+		"port", strconv.Itoa(port),
+		"runid", runID,
+		"flags", flags,
+		"num-slaves", strconv.Itoa(len(slavesOf(m.addr))),
+		"num-other-sentinels", strconv.Itoa(len(peers)),
+		"quorum", strconv.Itoa(m.quorum),
+		"config-epoch", strconv.FormatInt(m.epoch, 10),
+	}
+	var keys []string
+	for k := range m.options {
+		if k != "auth-pass" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		reply = append(reply, k, m.options[k])
+	}
+	return reply
+}
+
+func slaveReply(slave *Server, master string) []string {
+	host, port := splitAddr(slave.addr)
+	mhost, mport := splitAddr(master)
+	flags, link := "slave", "ok"
+	if slave.isClosed() {
+		flags = "s_down,slave"
+	}
+	if !slave.linkUp() {
+		link = "err"
+	}
+	return []string{
+		"name", slave.addr,
+		"ip", host,
+		"port", strconv.Itoa(port),
+		"runid", slave.RunID(),
+		"flags", flags,
+		"master-link-status", link,
+		"master-host", mhost,
+		"master-port", strconv.Itoa(mport),
+		"slave-priority", slave.ConfigValue("slave-priority"),
+	}
+}
+
+func (t *sentinelState) info(s *Server) interface{} {
+	masters := s.masterList()
+	var b strings.Builder
+	b.WriteString("# Sentinel\r\n")
+	fmt.Fprintf(&b, "sentinel_masters:%d\r\n", len(masters))
+	b.WriteString("sentinel_tilt:0\r\n")
+	b.WriteString("sentinel_running_scripts:0\r\n")
+	b.WriteString("sentinel_scripts_queue_length:0\r\n")
+	for i, m := range masters {
+		status := "ok"
+		if isDown(m.addr) {
+			status = "sdown"
+		}
+		peers, _ := s.peers(m.name)
+		fmt.Fprintf(&b, "master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, m.name, status, m.addr, len(slavesOf(m.addr)), len(peers)+1)
+	}
+	return b.String()
+}
+
+func (s *Server) sentinelRole() interface{} {
+	var names []interface{}
+	for _, m := range s.masterList() {
+		names = append(names, m.name)
+	}
+	return []interface{}{"sentinel", append([]interface{}{}, names...)}
+}
+
+func (s *Server) sentinelCommand(args []string) interface{} {
+	if len(args) == 0 {
+		return errArgs("sentinel")
+	}
+	sub, args := strings.ToLower(args[0]), args[1:]
+
+	switch sub {
+	case "masters":
+		var reply []interface{}
+		for _, m := range s.masterList() {
+			reply = append(reply, s.masterReply(m))
+		}
+		return append([]interface{}{}, reply...)
+	case "master":
+		if len(args) != 1 {
+			return errArgs("sentinel master")
+		}
+		m := s.master(args[0])
+		if m == nil {
+			return errNoSuchMaster
+		}
+		return s.masterReply(m)
+	case "slaves", "replicas":
+		if len(args) != 1 {
+			return errArgs("sentinel " + sub)
+		}
+		m := s.master(args[0])
+		if m == nil {
+			return errNoSuchMaster
+		}
+		reply := []interface{}{}
+		for _, slave := range slavesOf(m.addr) {
+			reply = append(reply, slaveReply(slave, m.addr))
+		}
+		return reply
+	case "sentinels":
+		if len(args) != 1 {
+			return errArgs("sentinel sentinels")
+		}
+		if s.master(args[0]) == nil {
+			return errNoSuchMaster
+		}
+		alive, _ := s.peers(args[0])
+		reply := []interface{}{}
+		for _, addr := range s.knownPeers(args[0]) {
+			flags, runID := "s_down,sentinel", ""
+			for _, p := range alive {
+				if p.addr == addr {
+					flags, runID = "sentinel", p.RunID()
+				}
+			}
+			host, port := splitAddr(addr)
+			reply = append(reply, []string{
+				"name", addr, "ip", host, "port", strconv.Itoa(port),
+				"runid", runID, "flags", flags,
+			})
+		}
+		return reply
+	case "get-master-addr-by-name":
+		if len(args) != 1 {
+			return errArgs("sentinel get-master-addr-by-name")
+		}
+		m := s.master(args[0])
+		if m == nil {
+			return nil
+		}
+		host, port := splitAddr(m.addr)
+		return []string{host, strconv.Itoa(port)}
+	case "monitor":
+		return s.monitor(args)
+	case "set":
+		return s.set(args)
+	case "remove":
+		if len(args) != 1 {
+			return errArgs("sentinel remove")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.sentinel.masters[args[0]] == nil {
+			return errNoSuchMaster
+		}
+		delete(s.sentinel.masters, args[0])
+		return status("OK")
+	case "reset":
+		if len(args) != 1 {
+			return errArgs("sentinel reset")
+		}
+		return s.reset(args[0])
+	case "flushconfig":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.sentinel.flushes++
+		return status("OK")
+	case "failover":
+		if len(args) != 1 {
+			return errArgs("sentinel failover")
+		}
+		if err := s.Failover(args[0]); err != nil {
+			return err
+		}
+		return status("OK")
+	case "ckquorum":
+		if len(args) != 1 {
+			return errArgs("sentinel ckquorum")
+		}
+		return s.ckquorum(args[0])
+	}
+	return fmt.Errorf("ERR Unknown sentinel subcommand '%s'", sub)
+}
+
+func (s *Server) monitor(args []string) interface{} {
+	if len(args) != 4 {
+		return errArgs("sentinel monitor")
+	}
+	name, host := args[0], args[1]
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.New("ERR Invalid port")
+	}
+	quorum, err := strconv.Atoi(args[3])
+	if err != nil || quorum <= 0 {
+		return errors.New("ERR Quorum must be 1 or greater.")
+	}
+	if net.ParseIP(host) == nil {
+		return errors.New("ERR Invalid IP address specified")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sentinel.masters[name] != nil {
+		return errors.New("ERR Duplicated master name.")
+	}
+	s.sentinel.masters[name] = newSentinelMaster(name, net.JoinHostPort(host, args[2]), quorum)
+	return status("OK")
+}
+
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errArgs("sentinel set")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sentinel.masters[args[0]]
+	if m == nil {
+		return errNoSuchMaster
+	}
+	for i := 1; i < len(args); i += 2 {
+		key, value := strings.ToLower(args[i]), args[i+1]
+		switch key {
+		case "quorum":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("ERR Invalid argument '%s' for SENTINEL SET '%s'", value, key)
+			}
+			m.quorum = n
+		case "down-after-milliseconds", "failover-timeout", "parallel-syncs":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("ERR Invalid argument '%s' for SENTINEL SET '%s'", value, key)
+			}
+			m.options[key] = value
+		case "auth-pass", "auth-user", "notification-script", "client-reconfig-script":
+			m.options[key] = value
+		default:
+			return fmt.Errorf("ERR Invalid argument '%s' for SENTINEL SET '%s'", value, key)
+		}
+	}
+	return status("OK")
+}
+
+// reset forgets the peers of the masters matching the pattern, the live ones
+// are discovered again by the next command.
+func (s *Server) reset(pattern string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for name, m := range s.sentinel.masters {
+		if ok, _ := path.Match(pattern, name); ok {
+			m.known = make(map[string]bool)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) ckquorum(name string) interface{} {
+	m := s.master(name)
+	if m == nil {
+		return errNoSuchMaster
+	}
+	alive, known := s.peers(name)
+	usable := len(alive) + 1
+	voters := known + 1
+	switch {
+	case usable < m.quorum:
+		return fmt.Errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)
+	case usable < voters/2+1:
+		return fmt.Errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)
+	}
+	return status(fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable))
+}
+
+// Failover promotes the first live slave of the master, reconfigures the
+// other fake Pika servers, bumps the config epoch on every fake sentinel
+// monitoring the master and publishes +switch-master. The sentinel publishes
+// the events of the failover before, from +try-failover to +failover-end.
+func (s *Server) Failover(name string) error {
+	m := s.master(name)
+	if m == nil {
+		return errNoSuchMaster
+	}
+	var promoted *Server
+	for _, slave := range slavesOf(m.addr) {
+		if !slave.isClosed() {
+			promoted = slave
+			break
+		}
+	}
+	if promoted == nil {
+		return errors.New("NOGOODSLAVE No suitable replica to promote")
+	}
+	host, port := splitAddr(m.addr)
+	phost, pport := splitAddr(promoted.addr)
+	master := fmt.Sprintf("master %s %s %d", name, host, port)
+	slave := fmt.Sprintf("slave %s %s %d @ %s %s %d", promoted.addr, phost, pport, name, host, port)
+	s.Publish("+try-failover", master)
+	s.Publish("+elected-leader", master)
+	s.Publish("+selected-slave", slave)
+	s.Publish("+promoted-slave", slave)
+	s.Publish("+failover-end", master)
+	SwitchMaster(name, promoted.addr)
+	return nil
+}
+
+// SwitchMaster moves the master to addr as a failover would: the fake Pika
+// server at addr becomes master, the old master and its slaves replicate
+// from it, and every fake sentinel monitoring the name publishes
+// +switch-master with a new config epoch.
+func SwitchMaster(name, addr string) {
+	var sentinels []*Server
+	var old string
+	var epoch int64
+	for _, p := range servers() {
+		if p.mode != ModeSentinel {
+			continue
+		}
+		if m := p.master(name); m != nil {
+			sentinels = append(sentinels, p)
+			old = m.addr
+			if m.epoch > epoch {
+				epoch = m.epoch
+			}
+		}
+	}
+	epoch++
+
+	if old != "" && old != addr {
+		for _, slave := range slavesOf(old) {
+			if slave.addr != addr {
+				slave.SetMaster(addr)
+			}
+		}
+		if p := lookup(old); p != nil && p.mode == ModePika {
+			p.SetMaster(addr)
+		}
+	}
+	if p := lookup(addr); p != nil && p.mode == ModePika {
+		p.SetMaster("")
+	}
+
+	ohost, oport := splitAddr(old)
+	nhost, nport := splitAddr(addr)
+	message := fmt.Sprintf("%s %s %d %s %d", name, ohost, oport, nhost, nport)
+	for _, p := range sentinels {
+		p.mu.Lock()
+		if m := p.sentinel.masters[name]; m != nil {
+			m.addr, m.epoch = addr, epoch
+		}
+		p.mu.Unlock()
+		p.Publish("+switch-master", message)
+	}
+}
+
+func (s *Server) subscribe(c *conn, channels []string) interface{} {
+	if len(channels) == 0 {
+		return errArgs("subscribe")
+	}
+	// hold the writer until the confirmations are written, so no message
+	// is published before them.
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	s.mu.Lock()
+	s.sentinel.subs[c] = append(s.sentinel.subs[c], channels...)
+	total := len(s.sentinel.subs[c])
+	s.mu.Unlock()
+
+	for i, ch := range channels {
+		writeValue(c.w, []interface{}{"subscribe", ch, total - len(channels) + i + 1})
+	}
+	return noReply
+}
+
+// Publish sends the message to every connection subscribed to the channel
+// and returns the number of receivers.
+func (s *Server) Publish(channel, message string) int {
+	var receivers []*conn
+	s.mu.Lock()
+	for c, channels := range s.sentinel.subs {
+		for _, ch := range channels {
+			if ch == channel {
+				receivers = append(receivers, c)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range receivers {
+		c.writeReply([]interface{}{"message", channel, message})
+	}
+	return len(receivers)
+}
+
+// Subscribers returns the number of connections subscribed to the channel.
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, channels := range s.sentinel.subs {
+		for _, ch := range channels {
+			if ch == channel {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
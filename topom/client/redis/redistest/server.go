@@ -0,0 +1,482 @@
+// Package redistest provides in-process fake Pika and sentinel servers that
+// speak RESP, so the group and sentinel operations of the dashboard can be
+// tested without a live deployment.
+//
+// Every server registers itself by address, a fake sentinel resolves the
+// masters it monitors and their slaves through the registry, and a failover
+// reconfigures the fake Pika servers like a real sentinel would.
+package redistest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Mode int
+
+const (
+	ModePika Mode = iota
+	ModeSentinel
+)
+
+// Hook is called before every command with the upper-cased command name
+// followed by the arguments, a non-nil error is replied instead of running
+// the command.
+type Hook func(args []string) error
+
+type Server struct {
+	mu sync.Mutex
+
+	mode Mode
+	addr string
+	ln   net.Listener
+
+	conns  map[*conn]bool
+	closed bool
+
+	username string
+	password string
+	tls      *tls.Config
+	failures map[string]string
+	hook     Hook
+	calls    [][]string
+
+	pika     pikaState
+	sentinel sentinelState
+}
+
+var registry = struct {
+	sync.Mutex
+	servers map[string]*Server
+}{servers: make(map[string]*Server)}
+
+func lookup(addr string) *Server {
+	registry.Lock()
+	defer registry.Unlock()
+	return registry.servers[addr]
+}
+
+func servers() []*Server {
+	registry.Lock()
+	defer registry.Unlock()
+	list := make([]*Server, 0, len(registry.servers))
+	for _, s := range registry.servers {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].addr < list[j].addr })
+	return list
+}
+
+func newServer(mode Mode) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		mode:     mode,
+		addr:     ln.Addr().String(),
+		ln:       ln,
+		conns:    make(map[*conn]bool),
+		failures: make(map[string]string),
+	}
+	s.pika.init(s.addr)
+	s.sentinel.init()
+
+	registry.Lock()
+	registry.servers[s.addr] = s
+	registry.Unlock()
+
+	go s.serve()
+	return s, nil
+}
+
+// NewServer starts a fake Pika server, a master without slaves.
+func NewServer() (*Server, error) {
+	return newServer(ModePika)
+}
+
+// NewSentinel starts a fake sentinel without any master.
+func NewSentinel() (*Server, error) {
+	return newServer(ModeSentinel)
+}
+
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+func (s *Server) Mode() Mode {
+	return s.mode
+}
+
+// Close stops the server and drops every connection, the server is seen as
+// down by the others.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+
+	registry.Lock()
+	delete(registry.servers, s.addr)
+	registry.Unlock()
+
+	for c := range conns {
+		c.Close()
+	}
+	return s.ln.Close()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// SetPassword makes the server require AUTH, empty disables it.
+func (s *Server) SetPassword(password string) {
+	s.SetUser("", password)
+}
+
+// SetUser makes the server require "AUTH <username> <password>", like an
+// ACL user, an empty password disables it.
+func (s *Server) SetUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetTLS makes the server accept tls connections only, nil for plain tcp.
+func (s *Server) SetTLS(config *tls.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tls = config
+}
+
+// Fail makes every call of the command fail with the message, the command
+// is either a name like "SLAVEOF" or a name with a subcommand like
+// "SENTINEL MONITOR". An empty message clears the failure.
+func (s *Server) Fail(command, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command = strings.ToUpper(command)
+	if message == "" {
+		delete(s.failures, command)
+	} else {
+		s.failures[command] = message
+	}
+}
+
+func (s *Server) SetHook(hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+// Calls returns the commands received by the server, each one upper-cased
+// name first.
+func (s *Server) Calls() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([][]string, len(s.calls))
+	copy(calls, s.calls)
+	return calls
+}
+
+// CountCalls returns how many times the command, with an optional
+// subcommand like "SENTINEL MONITOR", was received.
+func (s *Server) CountCalls(command string) int {
+	fields := strings.Fields(strings.ToUpper(command))
+	var n int
+	for _, call := range s.Calls() {
+		if len(call) < len(fields) {
+			continue
+		}
+		match := true
+		for i, f := range fields {
+			if strings.ToUpper(call[i]) != f {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) ResetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		if s.tls != nil {
+			nc = tls.Server(nc, s.tls)
+		}
+		c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+		s.conns[c] = true
+		c.authed = s.password == ""
+		s.mu.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *conn) {
+	defer func() {
+		c.Close()
+		s.unsubscribe(c)
+		s.mu.Lock()
+		if s.conns != nil {
+			delete(s.conns, c)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		args[0] = strings.ToUpper(args[0])
+
+		reply := s.dispatch(c, args)
+		if err := c.writeReply(reply); err != nil {
+			return
+		}
+	}
+}
+
+// check runs the auth check, the scripted failures and the hook.
+func (s *Server) check(c *conn, args []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, append([]string{}, args...))
+
+	if !c.authed && args[0] != "AUTH" {
+		return errors.New("NOAUTH Authentication required.")
+	}
+	if len(args) > 1 {
+		if msg, ok := s.failures[args[0]+" "+strings.ToUpper(args[1])]; ok {
+			return errors.New(msg)
+		}
+	}
+	if msg, ok := s.failures[args[0]]; ok {
+		return errors.New(msg)
+	}
+	if s.hook != nil {
+		return s.hook(args)
+	}
+	return nil
+}
+
+func (s *Server) dispatch(c *conn, args []string) interface{} {
+	if err := s.check(c, args); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "PING":
+		return status("PONG")
+	case "AUTH":
+		return s.auth(c, args[1:])
+	case "SELECT":
+		if len(args) != 2 {
+			return errArgs(args[0])
+		}
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return errors.New("ERR invalid DB index")
+		}
+		return status("OK")
+	case "INFO":
+		return s.infoCommand(args[1:])
+	case "ROLE":
+		return s.roleCommand()
+	}
+
+	switch s.mode {
+	case ModePika:
+		switch args[0] {
+		case "SLAVEOF":
+			return s.slaveof(args[1:])
+		case "CONFIG":
+			return s.config(args[1:])
+		}
+	case ModeSentinel:
+		switch args[0] {
+		case "SENTINEL":
+			return s.sentinelCommand(args[1:])
+		case "SUBSCRIBE":
+			return s.subscribe(c, args[1:])
+		}
+	}
+	return fmt.Errorf("ERR unknown command '%s'", strings.ToLower(args[0]))
+}
+
+func (s *Server) auth(c *conn, args []string) interface{} {
+	if len(args) != 1 && len(args) != 2 {
+		return errArgs("auth")
+	}
+	username := ""
+	if len(args) == 2 {
+		username, args = args[0], args[1:]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.password == "":
+		return errors.New("ERR Client sent AUTH, but no password is set")
+	case s.username != username || s.password != args[0]:
+		c.authed = false
+		return errors.New("ERR invalid password")
+	}
+	c.authed = true
+	return status("OK")
+}
+
+func (s *Server) infoCommand(args []string) interface{} {
+	if s.mode == ModeSentinel {
+		return s.sentinel.info(s)
+	}
+	return s.pika.info(s, args)
+}
+
+func (s *Server) roleCommand() interface{} {
+	if s.mode == ModeSentinel {
+		return s.sentinelRole()
+	}
+	return s.pika.role(s)
+}
+
+func errArgs(cmd string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+// status is replied as a RESP simple string, plain strings are replied as
+// bulk strings.
+type status string
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	authed bool
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *conn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		// inline command
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func (c *conn) writeReply(reply interface{}) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if reply == noReply {
+		return c.w.Flush()
+	}
+	writeValue(c.w, reply)
+	return c.w.Flush()
+}
+
+// noReply is returned by commands which already wrote their reply.
+var noReply = &struct{}{}
+
+func writeValue(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v.Error())
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeValue(w, s)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, x := range v {
+			writeValue(w, x)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply type %T", v))
+	}
+}
@@ -20,14 +20,20 @@ type Sentinel struct {
 	context.Context
 	Cancel context.CancelFunc
 
-	Product, Auth string
+	Product string
+	// Options are the options of the Pika servers, the sentinels are
+	// connected with their Sentinel options.
+	Options *Options
 
 	LogFunc func(format string, args ...interface{})
 	ErrFunc func(format string, args ...interface{})
+	// EventFunc is optional, it's called with every event of the product
+	// received by Subscribe.
+	EventFunc func(e *SentinelEvent)
 }
 
-func NewSentinel(product, auth string) *Sentinel {
-	s := &Sentinel{Product: product, Auth: auth}
+func NewSentinel(product string, options *Options) *Sentinel {
+	s := &Sentinel{Product: product, Options: options}
 	s.Context, s.Cancel = context.WithCancel(context.Background())
 	return s
 }
@@ -70,7 +76,7 @@ func (s *Sentinel) errorf(format string, args ...interface{}) {
 
 func (s *Sentinel) do(sentinel string, timeout time.Duration,
 	fn func(client *Client) error) error {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientOptions(sentinel, s.Options.Sentinel(), timeout)
 	if err != nil {
 		return err
 	}
@@ -80,7 +86,7 @@ func (s *Sentinel) do(sentinel string, timeout time.Duration,
 
 func (s *Sentinel) dispatch(ctx context.Context, sentinel string, timeout time.Duration,
 	fn func(client *Client) error) error {
-	c, err := NewClientNoAuth(sentinel, timeout)
+	c, err := NewClientOptions(sentinel, s.Options.Sentinel(), timeout)
 	if err != nil {
 		return err
 	}
@@ -100,12 +106,91 @@ func (s *Sentinel) dispatch(ctx context.Context, sentinel string, timeout time.D
 	}
 }
 
+// sentinelEventChannels are the events subscribed to, the ones about an
+// instance, a master or one of its slaves or sentinels, and +switch-master.
+var sentinelEventChannels = []string{
+	"+switch-master",
+	"+sdown", "-sdown", "+odown", "-odown",
+	"+try-failover", "+elected-leader", "+failover-detected",
+	"+failover-state-select-slave", "+selected-slave",
+	"+failover-state-send-slaveof-noone", "+failover-state-wait-promotion",
+	"+promoted-slave", "+failover-state-reconf-slaves",
+	"+slave-reconf-sent", "+slave-reconf-inprog", "+slave-reconf-done",
+	"+failover-end", "+failover-end-for-timeout",
+	"-failover-abort-not-elected", "-failover-abort-no-good-slave", "-failover-abort-slave-timeout",
+	"+slave", "+sentinel", "-dup-sentinel", "+reset-master", "+reboot",
+	"+convert-to-slave", "+fix-slave-config", "+config-update-from",
+	"+role-change", "-role-change",
+}
+
+// SentinelEvent is an event published by a sentinel about an instance of a
+// group of the product, Instance is its type, "master", "slave" or
+// "sentinel". Master is the address of the master of the group, the new one
+// of a +switch-master whose Addr is the old one. Details are the words which
+// follow the instance, like "#quorum 2/2" of +odown.
+type SentinelEvent struct {
+	Sentinel string
+	Type     string
+	Group    string
+	Instance string
+	Addr     string
+	Master   string
+	Details  string
+}
+
+// parseEvent parses the message of an event, nil if it isn't about the
+// product. The instance of the message is
+//
+//	<instance-type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>
+//
+// the master part is omitted for a master.
+func (s *Sentinel) parseEvent(sentinel, channel, message string) *SentinelEvent {
+	var fields = strings.Fields(message)
+	var e = &SentinelEvent{Sentinel: sentinel, Type: channel}
+	if channel == "+switch-master" {
+		if len(fields) != 5 {
+			return nil
+		}
+		groupName, yes := s.isSameProduct(fields[0])
+		if !yes {
+			return nil
+		}
+		e.Group, e.Instance = groupName, "master"
+		e.Addr = net.JoinHostPort(fields[1], fields[2])
+		e.Master = net.JoinHostPort(fields[3], fields[4])
+		return e
+	}
+	if len(fields) < 4 {
+		return nil
+	}
+	var name, rest = fields[1], fields[4:]
+	e.Instance = fields[0]
+	e.Addr = net.JoinHostPort(fields[2], fields[3])
+	if e.Instance == "master" {
+		e.Master = e.Addr
+	} else {
+		if len(rest) < 4 || rest[0] != "@" {
+			return nil
+		}
+		name, e.Master, rest = rest[1], net.JoinHostPort(rest[2], rest[3]), rest[4:]
+	}
+	groupName, yes := s.isSameProduct(name)
+	if !yes {
+		return nil
+	}
+	e.Group, e.Details = groupName, strings.Join(rest, " ")
+	return e
+}
+
 func (s *Sentinel) subscribeCommand(client *Client, sentinel string,
 	onSubscribed func()) error {
 	defer func() {
 		client.Close()
 	}()
-	var channels = []interface{}{"+switch-master"}
+	var channels []interface{}
+	for _, channel := range sentinelEventChannels {
+		channels = append(channels, channel)
+	}
 	go func() {
 		client.Send("SUBSCRIBE", channels...)
 		client.Flush()
@@ -135,20 +220,19 @@ func (s *Sentinel) subscribeCommand(client *Client, sentinel string,
 			return errors.Errorf("invalid response = %v", values)
 		}
 		s.printf("sentinel-[%s] subscribe event %v", sentinel, message)
+		if len(message) != 3 {
+			return errors.Errorf("invalid response = %v", values)
+		}
 
-		switch message[1] {
-		case "+switch-master":
-			if len(message) != 3 {
-				return errors.Errorf("invalid response = %v", values)
-			}
-			var params = strings.SplitN(message[2], " ", 2)
-			if len(params) != 2 {
-				return errors.Errorf("invalid response = %v", values)
-			}
-			_, yes := s.isSameProduct(params[0])
-			if yes {
-				return nil
-			}
+		e := s.parseEvent(sentinel, message[1], message[2])
+		if e == nil {
+			continue
+		}
+		if s.EventFunc != nil {
+			s.EventFunc(e)
+		}
+		if e.Type == "+switch-master" {
+			return nil
 		}
 	}
 }
@@ -400,6 +484,7 @@ func (s *Sentinel) Masters(sentinels []string, timeout time.Duration) (map[strin
 	}
 }
 
+// MonitorConfig is the config of a master monitored by the sentinels.
 type MonitorConfig struct {
 	Quorum          int
 	ParallelSyncs   int
@@ -410,7 +495,7 @@ type MonitorConfig struct {
 	ClientReconfigScript string
 }
 
-func (s *Sentinel) monitorGroupsCommand(client *Client, sentniel string, config *MonitorConfig, groups map[string]*net.TCPAddr) error {
+func (s *Sentinel) monitorGroupsCommand(client *Client, sentniel string, configs map[string]*MonitorConfig, groups map[string]*net.TCPAddr) error {
 	defer func() {
 		if !client.isRecyclable() {
 			client.Close()
@@ -423,10 +508,12 @@ func (s *Sentinel) monitorGroupsCommand(client *Client, sentniel string, config
 	if err := s.removeCommand(client, groupNames); err != nil {
 		return err
 	}
+	var sent = make(chan struct{})
 	go func() {
+		defer close(sent)
 		for groupName, tcpAddr := range groups {
 			var ip, port = tcpAddr.IP.String(), tcpAddr.Port
-			client.Send("SENTINEL", "monitor", s.NodeName(groupName), ip, port, config.Quorum)
+			client.Send("SENTINEL", "monitor", s.NodeName(groupName), ip, port, configs[groupName].Quorum)
 		}
 		if len(groups) != 0 {
 			client.Flush()
@@ -438,28 +525,12 @@ func (s *Sentinel) monitorGroupsCommand(client *Client, sentniel string, config
 			return errors.Trace(err)
 		}
 	}
+	<-sent
+	sent = make(chan struct{})
 	go func() {
+		defer close(sent)
 		for groupName := range groups {
-			var args = []interface{}{"set", s.NodeName(groupName)}
-			if config.ParallelSyncs != 0 {
-				args = append(args, "parallel-syncs", config.ParallelSyncs)
-			}
-			if config.DownAfter != 0 {
-				args = append(args, "down-after-milliseconds", int(config.DownAfter/time.Millisecond))
-			}
-			if config.FailoverTimeout != 0 {
-				args = append(args, "failover-timeout", int(config.FailoverTimeout/time.Millisecond))
-			}
-			if s.Auth != "" {
-				args = append(args, "auth-pass", s.Auth)
-			}
-			if config.NotificationScript != "" {
-				args = append(args, "notification-script", config.NotificationScript)
-			}
-			if config.ClientReconfigScript != "" {
-				args = append(args, "client-reconfig-script", config.ClientReconfigScript)
-			}
-			client.Send("SENTINEL", args...)
+			client.Send("SENTINEL", s.setArgs(groupName, configs[groupName])...)
 		}
 		if len(groups) != 0 {
 			client.Flush()
@@ -468,15 +539,45 @@ func (s *Sentinel) monitorGroupsCommand(client *Client, sentniel string, config
 	for range groups {
 		_, err := client.Receive()
 		if err != nil {
-			return errors.Trace(err)
+			_, password := s.Options.auth()
+			return errors.Trace(maskSecrets(err, password))
 		}
 	}
+	<-sent
 	return nil
 }
 
-func (s *Sentinel) monitorGroupsDispatch(ctx context.Context, sentinel string, timeout time.Duration, config *MonitorConfig, groups map[string]*net.TCPAddr) error {
+// setArgs returns the arguments of the SENTINEL SET of the master of the
+// group, all but the quorum which is set by SENTINEL MONITOR.
+func (s *Sentinel) setArgs(groupName string, config *MonitorConfig) []interface{} {
+	var args = []interface{}{"set", s.NodeName(groupName)}
+	if config.ParallelSyncs != 0 {
+		args = append(args, "parallel-syncs", config.ParallelSyncs)
+	}
+	if config.DownAfter != 0 {
+		args = append(args, "down-after-milliseconds", int(config.DownAfter/time.Millisecond))
+	}
+	if config.FailoverTimeout != 0 {
+		args = append(args, "failover-timeout", int(config.FailoverTimeout/time.Millisecond))
+	}
+	if username, password := s.Options.auth(); password != "" {
+		args = append(args, "auth-pass", password)
+		if username != "" {
+			args = append(args, "auth-user", username)
+		}
+	}
+	if config.NotificationScript != "" {
+		args = append(args, "notification-script", config.NotificationScript)
+	}
+	if config.ClientReconfigScript != "" {
+		args = append(args, "client-reconfig-script", config.ClientReconfigScript)
+	}
+	return args
+}
+
+func (s *Sentinel) monitorGroupsDispatch(ctx context.Context, sentinel string, timeout time.Duration, configs map[string]*MonitorConfig, groups map[string]*net.TCPAddr) error {
 	var err = s.dispatch(ctx, sentinel, timeout, func(c *Client) error {
-		return s.monitorGroupsCommand(c, sentinel, config, groups)
+		return s.monitorGroupsCommand(c, sentinel, configs, groups)
 	})
 	if err != nil {
 		switch errors.Cause(err) {
@@ -489,7 +590,15 @@ func (s *Sentinel) monitorGroupsDispatch(ctx context.Context, sentinel string, t
 	return nil
 }
 
-func (s *Sentinel) MonitorGroups(sentinels []string, timeout time.Duration, config *MonitorConfig, groups map[string]string) error {
+// MonitorGroups makes the sentinels monitor the masters of the groups, each
+// one with the config of its group in configs.
+func (s *Sentinel) MonitorGroups(sentinels []string, timeout time.Duration, configs map[string]*MonitorConfig, groups map[string]string) error {
+	for groupName := range groups {
+		if configs[groupName] == nil {
+			return errors.Errorf("missing monitor config of group %s", groupName)
+		}
+	}
+
 	cntx, cancel := context.WithTimeout(s.Context, timeout)
 	defer cancel()
 
@@ -534,7 +643,7 @@ func (s *Sentinel) MonitorGroups(sentinels []string, timeout time.Duration, conf
 
 	for i := range sentinels {
 		go func(sentinel string) {
-			err := s.monitorGroupsDispatch(cntx, sentinel, timeout, config, resolve)
+			err := s.monitorGroupsDispatch(cntx, sentinel, timeout, configs, resolve)
 			if err != nil {
 				s.errorf("sentinel-[%s] monitor failed. err:%s", sentinel, err.Error())
 			}
@@ -569,7 +678,9 @@ func (s *Sentinel) removeCommand(client *Client, names []string) error {
 	if err != nil {
 		return err
 	}
+	var sent = make(chan struct{})
 	go func() {
+		defer close(sent)
 		var pending int
 		for _, name := range names {
 			if !exists[name] {
@@ -591,6 +702,7 @@ func (s *Sentinel) removeCommand(client *Client, names []string) error {
 			return errors.Trace(err)
 		}
 	}
+	<-sent
 	return nil
 }
 
@@ -762,3 +874,210 @@ func (s *Sentinel) FlushConfig(sentinel string, timeout time.Duration) error {
 		return nil
 	})
 }
+
+// CheckQuorum runs SENTINEL CKQUORUM for the master of the group, the error
+// is the reply of a sentinel which can't reach the quorum or the majority.
+func (s *Sentinel) CheckQuorum(sentinel string, timeout time.Duration, groupName string) error {
+	return s.do(sentinel, timeout, func(c *Client) error {
+		if _, err := c.Do("SENTINEL", "ckquorum", s.NodeName(groupName)); err != nil {
+			return errors.Trace(err)
+		}
+		return nil
+	})
+}
+
+// CheckQuorumClient runs SENTINEL CKQUORUM for the masters of the groups over
+// the client, the replies are by group name. A reply which isn't OK is the
+// error of a sentinel which can't reach the quorum or the majority, only the
+// errors of the client itself are returned.
+func (s *Sentinel) CheckQuorumClient(client *Client, groups []string) (map[string]string, error) {
+	defer func() {
+		if !client.isRecyclable() {
+			client.Close()
+		}
+	}()
+	results := make(map[string]string, len(groups))
+	for _, groupName := range groups {
+		r, err := redigo.String(client.Do("SENTINEL", "ckquorum", s.NodeName(groupName)))
+		if err != nil {
+			if _, ok := errors.Cause(err).(redigo.Error); !ok {
+				return nil, err
+			}
+			r = err.Error()
+		}
+		results[groupName] = r
+	}
+	return results, nil
+}
+
+// Failover starts a failover of the master of the group, as if it wasn't
+// reachable, without asking the other sentinels. It returns once the
+// failover started, its end is published as +switch-master.
+func (s *Sentinel) Failover(sentinel string, timeout time.Duration, groupName string) error {
+	return s.do(sentinel, timeout, func(c *Client) error {
+		if _, err := c.Do("SENTINEL", "failover", s.NodeName(groupName)); err != nil {
+			return errors.Trace(err)
+		}
+		return nil
+	})
+}
+
+// SetAuthPass sets the auth-pass, and auth-user, of the groups monitored by
+// the sentinel to the password of the options, the groups it doesn't monitor
+// are skipped.
+func (s *Sentinel) SetAuthPass(sentinel string, timeout time.Duration, groups []string) error {
+	username, password := s.Options.auth()
+	return s.do(sentinel, timeout, func(c *Client) error {
+		var names []string
+		for _, groupName := range groups {
+			names = append(names, s.NodeName(groupName))
+		}
+		exists, err := s.existsCommand(c, names)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !exists[name] {
+				continue
+			}
+			args := []interface{}{"set", name, "auth-pass", password}
+			if username != "" {
+				args = append(args, "auth-user", username)
+			}
+			if _, err := c.Do("SENTINEL", args...); err != nil {
+				return maskSecrets(err, password)
+			}
+		}
+		return nil
+	})
+}
+
+// MonitoredMaster is a master of the product monitored by a sentinel. Config
+// is the one reported by the sentinel, the scripts are only set if the
+// sentinel reports them, see Info. Peers are the addresses of the other
+// sentinels monitoring the master.
+type MonitoredMaster struct {
+	Addr   string
+	Config *MonitorConfig
+	Peers  []string
+	Info   map[string]string
+}
+
+func parseMonitorConfig(master map[string]string) (*MonitorConfig, error) {
+	var config = &MonitorConfig{
+		NotificationScript:   master["notification-script"],
+		ClientReconfigScript: master["client-reconfig-script"],
+	}
+	for _, v := range []struct {
+		key   string
+		value *int
+	}{
+		{"quorum", &config.Quorum},
+		{"parallel-syncs", &config.ParallelSyncs},
+	} {
+		n, err := strconv.Atoi(master[v.key])
+		if err != nil {
+			return nil, errors.Errorf("invalid %s = '%s'", v.key, master[v.key])
+		}
+		*v.value = n
+	}
+	for _, v := range []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"down-after-milliseconds", &config.DownAfter},
+		{"failover-timeout", &config.FailoverTimeout},
+	} {
+		n, err := strconv.ParseInt(master[v.key], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s = '%s'", v.key, master[v.key])
+		}
+		*v.value = time.Duration(n) * time.Millisecond
+	}
+	return config, nil
+}
+
+func (s *Sentinel) monitoredCommand(client *Client) (map[string]*MonitoredMaster, error) {
+	defer func() {
+		if !client.isRecyclable() {
+			client.Close()
+		}
+	}()
+	masters, err := s.mastersCommand(client)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*MonitoredMaster, len(masters))
+	for groupName, master := range masters {
+		config, err := parseMonitorConfig(master)
+		if err != nil {
+			return nil, errors.Errorf("master %s: %s", master["name"], err)
+		}
+		values, err := redigo.Values(client.Do("SENTINEL", "sentinels", master["name"]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var peers []string
+		for i := range values {
+			p, err := redigo.StringMap(values[i], nil)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			peers = append(peers, net.JoinHostPort(p["ip"], p["port"]))
+		}
+		results[groupName] = &MonitoredMaster{
+			Addr:   net.JoinHostPort(master["ip"], master["port"]),
+			Config: config, Peers: peers, Info: master,
+		}
+	}
+	return results, nil
+}
+
+// MonitoredMasters returns the masters of the product monitored by the
+// sentinel, by group.
+func (s *Sentinel) MonitoredMasters(sentinel string, timeout time.Duration) (map[string]*MonitoredMaster, error) {
+	var results map[string]*MonitoredMaster
+	var err = s.do(sentinel, timeout, func(c *Client) error {
+		m, err := s.monitoredCommand(c)
+		if err != nil {
+			return err
+		}
+		results = m
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ConfigureGroups sets the config of the masters of the groups monitored by
+// the sentinel, the quorum included, without monitoring them again.
+func (s *Sentinel) ConfigureGroups(sentinel string, timeout time.Duration, configs map[string]*MonitorConfig) error {
+	return s.do(sentinel, timeout, func(c *Client) error {
+		for groupName, config := range configs {
+			args := append(s.setArgs(groupName, config), "quorum", config.Quorum)
+			if _, err := c.Do("SENTINEL", args...); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+// ResetGroups resets the state of the masters of the groups monitored by the
+// sentinel, it forgets the sentinels and replicas which went away and
+// discovers the live ones again.
+func (s *Sentinel) ResetGroups(sentinel string, timeout time.Duration, groups []string) error {
+	return s.do(sentinel, timeout, func(c *Client) error {
+		for _, groupName := range groups {
+			// SENTINEL RESET takes a glob-style pattern.
+			if _, err := c.Do("SENTINEL", "reset", globEscaper.Replace(s.NodeName(groupName))); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
@@ -0,0 +1,216 @@
+package topom
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+type collectKind int
+
+const (
+	collectPika collectKind = iota
+	collectSentinel
+)
+
+// collectTarget is a server known to the collector, it's probed by one worker
+// at a time.
+type collectTarget struct {
+	addr string
+	kind collectKind
+
+	stats    *RedisStats
+	failures int
+	retryAt  time.Time
+	fullAt   time.Time
+
+	// base is the last full INFO of a Pika server without its replication,
+	// which the probes in between refresh.
+	base map[string]string
+}
+
+// probeFunc probes the target for its stats, full tells a full probe from a
+// probe of the replication only. It must return once ctx is done.
+type probeFunc func(ctx context.Context, t *collectTarget, full bool) (*RedisStats, error)
+
+// collector collects the stats of the servers with a bounded pool of workers.
+// A probe has its own deadline, a server which doesn't answer costs a worker
+// the timeout at most. An unreachable server is probed with an exponential
+// back-off up to maxBackoff, its last stats are kept meanwhile. Sentinels are
+// always fully probed.
+type collector struct {
+	workers      int
+	interval     time.Duration
+	fullInterval time.Duration
+	timeout      time.Duration
+	maxBackoff   time.Duration
+	probe        probeFunc
+
+	mutex   sync.Mutex
+	targets map[string]*collectTarget
+	metrics protocol.CollectorStats
+}
+
+func newCollector(workers int, interval, fullInterval, timeout, maxBackoff time.Duration, probe probeFunc) *collector {
+	c := &collector{
+		workers:      workers,
+		interval:     interval,
+		fullInterval: fullInterval,
+		timeout:      timeout,
+		maxBackoff:   maxBackoff,
+		probe:        probe,
+		targets:      make(map[string]*collectTarget),
+	}
+	c.metrics.Workers = workers
+	return c
+}
+
+type probeResult struct {
+	full     bool
+	failed   bool
+	timeout  bool
+	duration time.Duration
+}
+
+// collect runs a round over the servers, the targets no longer among them
+// are forgotten. It returns the last stats of every server probed so far,
+// and must not be called concurrently.
+func (c *collector) collect(ctx context.Context, servers map[string]collectKind) map[string]*RedisStats {
+	start := time.Now()
+
+	c.mutex.Lock()
+	for addr, t := range c.targets {
+		if kind, ok := servers[addr]; !ok || kind != t.kind {
+			delete(c.targets, addr)
+		}
+	}
+	var due []*collectTarget
+	var backedOff int
+	for addr, kind := range servers {
+		t, ok := c.targets[addr]
+		if !ok {
+			t = &collectTarget{addr: addr, kind: kind}
+			c.targets[addr] = t
+		}
+		if start.Before(t.retryAt) {
+			backedOff++
+			continue
+		}
+		due = append(due, t)
+	}
+	c.mutex.Unlock()
+
+	results := make([]probeResult, len(due))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers && i < len(due); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.probeTarget(ctx, due[i])
+			}
+		}()
+	}
+	probed := len(due)
+LOOP:
+	for i := range due {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			probed = i
+			break LOOP
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := make(map[string]*RedisStats, len(c.targets))
+	for addr, t := range c.targets {
+		if t.stats != nil {
+			stats[addr] = t.stats
+		}
+	}
+
+	m := &c.metrics
+	m.Targets = len(servers)
+	m.Rounds++
+	m.LastRound.UnixTime = start.Unix()
+	m.LastRound.Duration = time.Since(start).Milliseconds()
+	m.LastRound.Probes = probed
+	m.LastRound.BackedOff = backedOff
+	m.LastRound.FullProbes, m.LastRound.Failures, m.LastRound.Timeouts = 0, 0, 0
+	m.LastRound.MaxProbe, m.LastRound.AvgProbe = 0, 0
+	var total time.Duration
+	for _, r := range results[:probed] {
+		if r.full {
+			m.LastRound.FullProbes++
+		}
+		if r.failed {
+			m.LastRound.Failures++
+		}
+		if r.timeout {
+			m.LastRound.Timeouts++
+		}
+		if d := r.duration.Milliseconds(); d > m.LastRound.MaxProbe {
+			m.LastRound.MaxProbe = d
+		}
+		total += r.duration
+	}
+	if probed != 0 {
+		m.LastRound.AvgProbe = (total / time.Duration(probed)).Milliseconds()
+	}
+	return stats
+}
+
+func (c *collector) probeTarget(ctx context.Context, t *collectTarget) probeResult {
+	now := time.Now()
+	full := t.kind == collectSentinel || t.base == nil || !now.Before(t.fullAt)
+
+	pctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	stats, err := c.probe(pctx, t, full)
+	r := probeResult{full: full, duration: time.Since(now)}
+
+	switch {
+	case err == nil:
+		stats.UnixTime = now.Unix()
+		t.stats = stats
+		t.failures, t.retryAt = 0, time.Time{}
+		if full {
+			t.fullAt = now.Add(c.fullInterval)
+		}
+		return r
+	case pctx.Err() == context.DeadlineExceeded:
+		r.timeout = true
+		t.stats = &RedisStats{Timeout: true, UnixTime: now.Unix()}
+	default:
+		t.stats = &RedisStats{Error: err, UnixTime: now.Unix()}
+	}
+
+	// the next success is a full probe, the server may have restarted.
+	r.failed = true
+	t.base, t.fullAt = nil, time.Time{}
+	t.failures++
+	backoff := c.maxBackoff
+	if t.failures <= 16 {
+		if d := c.interval << uint(t.failures-1); d < backoff {
+			backoff = d
+		}
+	}
+	t.retryAt = now.Add(backoff)
+	return r
+}
+
+// stats returns the timings of the last round.
+func (c *collector) stats() *protocol.CollectorStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	m := c.metrics
+	return &m
+}
@@ -0,0 +1,233 @@
+package topom
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeProbe struct {
+	mutex   sync.Mutex
+	calls   map[string][]bool
+	fail    map[string]bool
+	running int
+	max     int
+	delay   time.Duration
+}
+
+func (p *fakeProbe) probe(ctx context.Context, t *collectTarget, full bool) (*RedisStats, error) {
+	p.mutex.Lock()
+	if p.calls == nil {
+		p.calls = make(map[string][]bool)
+	}
+	p.calls[t.addr] = append(p.calls[t.addr], full)
+	if p.running++; p.running > p.max {
+		p.max = p.running
+	}
+	fail := p.fail[t.addr]
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		p.running--
+		p.mutex.Unlock()
+	}()
+	if p.delay != 0 {
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if fail {
+		return nil, errors.New("connection refused")
+	}
+	if full {
+		t.base = map[string]string{"role": "master"}
+	}
+	return &RedisStats{Stats: map[string]string{"full": map[bool]string{true: "1", false: "0"}[full]}}, nil
+}
+
+func (p *fakeProbe) fulls(addr string) []bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]bool(nil), p.calls[addr]...)
+}
+
+func TestCollectorTiers(t *testing.T) {
+	p := &fakeProbe{}
+	c := newCollector(4, time.Second, time.Hour, time.Second, time.Minute, p.probe)
+	servers := map[string]collectKind{"pika": collectPika, "sentinel": collectSentinel}
+
+	for i := 0; i < 3; i++ {
+		c.collect(context.Background(), servers)
+	}
+	c.targets["pika"].fullAt = time.Time{}
+	stats := c.collect(context.Background(), servers)
+
+	if got := p.fulls("pika"); len(got) != 4 || !got[0] || got[1] || got[2] || !got[3] {
+		t.Fatalf("pika probes = %v", got)
+	}
+	if got := p.fulls("sentinel"); len(got) != 4 || !got[0] || !got[1] || !got[2] || !got[3] {
+		t.Fatalf("sentinel probes = %v", got)
+	}
+	if st := stats["pika"]; st == nil || st.Stats["full"] != "1" || st.UnixTime == 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	m := c.stats()
+	if m.Rounds != 4 || m.Targets != 2 || m.LastRound.Probes != 2 || m.LastRound.FullProbes != 2 {
+		t.Fatalf("metrics = %+v", m)
+	}
+
+	// a server no longer collected is forgotten.
+	if stats := c.collect(context.Background(), map[string]collectKind{"pika": collectPika}); len(stats) != 1 {
+		t.Fatalf("stats = %v", stats)
+	}
+	if _, ok := c.targets["sentinel"]; ok {
+		t.Fatal("sentinel still collected")
+	}
+}
+
+func TestCollectorBackoff(t *testing.T) {
+	p := &fakeProbe{fail: map[string]bool{"bad": true}}
+	interval, maxBackoff := time.Minute, 3*time.Minute
+	c := newCollector(4, interval, time.Hour, time.Second, maxBackoff, p.probe)
+	servers := map[string]collectKind{"bad": collectPika, "good": collectPika}
+
+	for _, want := range []time.Duration{interval, 2 * interval, maxBackoff, maxBackoff} {
+		start := time.Now()
+		stats := c.collect(context.Background(), servers)
+		if st := stats["bad"]; st == nil || st.Error == nil {
+			t.Fatalf("stats = %+v", st)
+		}
+		tg := c.targets["bad"]
+		if d := tg.retryAt.Sub(start); d < want || d > want+time.Second {
+			t.Fatalf("failures %d back off %s, want %s", tg.failures, d, want)
+		}
+
+		// backed off, the last stats are kept.
+		stats = c.collect(context.Background(), servers)
+		if stats["bad"] == nil || c.stats().LastRound.BackedOff != 1 {
+			t.Fatalf("stats = %+v, metrics = %+v", stats["bad"], c.stats())
+		}
+		tg.retryAt = time.Time{}
+	}
+	if n := len(p.fulls("bad")); n != 4 {
+		t.Fatalf("bad probed %d times", n)
+	}
+	if n := len(p.fulls("good")); n != 8 {
+		t.Fatalf("good probed %d times", n)
+	}
+
+	// a success resets the back-off.
+	p.fail["bad"] = false
+	c.collect(context.Background(), servers)
+	if tg := c.targets["bad"]; tg.failures != 0 || !tg.retryAt.IsZero() {
+		t.Fatalf("target = %+v", tg)
+	}
+}
+
+func TestCollectorWorkers(t *testing.T) {
+	p := &fakeProbe{delay: 20 * time.Millisecond}
+	c := newCollector(2, time.Second, time.Hour, time.Second, time.Minute, p.probe)
+	servers := make(map[string]collectKind)
+	for _, addr := range []string{"a", "b", "c", "d", "e", "f"} {
+		servers[addr] = collectPika
+	}
+	if stats := c.collect(context.Background(), servers); len(stats) != 6 {
+		t.Fatalf("stats = %v", stats)
+	}
+	if p.max != 2 {
+		t.Fatalf("%d probes ran at once, want 2", p.max)
+	}
+
+	// a probe beyond the timeout is interrupted.
+	p.delay = time.Hour
+	c.timeout = 50 * time.Millisecond
+	start := time.Now()
+	stats := c.collect(context.Background(), servers)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("round took %s", d)
+	}
+	if st := stats["a"]; !st.Timeout || c.stats().LastRound.Timeouts != 6 {
+		t.Fatalf("stats = %+v, metrics = %+v", st, c.stats())
+	}
+}
+
+func TestCollectRedisStats(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 0)
+	defer c.close()
+
+	// a server which accepts and never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{c.servers[0].Addr(), c.servers[1].Addr(), ln.Addr().String()} {
+		if err := e.AddGroupServer("g1", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.stats.collector.timeout = 100 * time.Millisecond
+
+	ctx := context.Background()
+	if err := e.refreshRedisStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats := e.stats.servers
+	if st := stats[c.servers[0].Addr()]; st == nil || st.Error != nil || st.Stats["maxmemory"] == "" || st.Stats["role"] != "master" {
+		t.Fatalf("stats = %+v", st)
+	}
+	if st := stats[ln.Addr().String()]; st == nil || !st.Timeout {
+		t.Fatalf("stats of a hung server = %+v", st)
+	}
+
+	// the replication is refreshed in between the full probes.
+	c.servers[1].SetMaster(c.servers[0].Addr())
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		e.stats.collector.targets[ln.Addr().String()].retryAt = time.Time{}
+		if err := e.refreshRedisStats(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := e.stats.servers[c.servers[1].Addr()]
+	if st.Stats["master_addr"] != c.servers[0].Addr() || st.Stats["role"] != "slave" || st.Stats["maxmemory"] == "" {
+		t.Fatalf("stats = %+v", st)
+	}
+	if m := e.CollectorStats(); m.Rounds != 6 || m.LastRound.FullProbes != 1 || m.LastRound.Timeouts != 1 {
+		t.Fatalf("metrics = %+v", m)
+	}
+
+	// the timed out probes don't leave goroutines behind.
+	waitFor(t, time.Second, "goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= goroutines+2
+	})
+}
@@ -1,5 +1,11 @@
 package dao
 
+import (
+	"errors"
+
+	"github.com/CodisLabs/codis/pkg/utils/timesize"
+)
+
 const MAXGroupNameBytesLength = 32
 
 type GroupServer struct {
@@ -14,6 +20,37 @@ func (g *GroupServer) Decode(data []byte) error {
 	return jsonDecode("group-server", g, data)
 }
 
+// SentinelParams override the sentinel config of the dashboard for the master
+// of a group, the zero fields keep the dashboard ones.
+type SentinelParams struct {
+	Quorum          int               `json:"quorum,omitempty"`
+	ParallelSyncs   int               `json:"parallelSyncs,omitempty"`
+	DownAfter       timesize.Duration `json:"downAfter,omitempty"`
+	FailoverTimeout timesize.Duration `json:"failoverTimeout,omitempty"`
+
+	NotificationScript   string `json:"notificationScript,omitempty"`
+	ClientReconfigScript string `json:"clientReconfigScript,omitempty"`
+}
+
+func (p *SentinelParams) Validate() error {
+	switch {
+	case p.Quorum < 0:
+		return errors.New("invalid quorum")
+	case p.ParallelSyncs < 0:
+		return errors.New("invalid parallel syncs")
+	case p.DownAfter < 0:
+		return errors.New("invalid down after")
+	case p.FailoverTimeout < 0:
+		return errors.New("invalid failover timeout")
+	}
+	return nil
+}
+
+// Empty reports whether the params override nothing.
+func (p *SentinelParams) Empty() bool {
+	return p == nil || *p == SentinelParams{}
+}
+
 const (
 	ActionNothing   = ""
 	ActionPreparing = "preparing"
@@ -32,6 +69,8 @@ type Group struct {
 	ProxyReadPort  int    `json:"proxyReadPort"`
 	ProxyWritePort int    `json:"proxyWritePort"`
 	CreateTime     string `json:"createTime"`
+
+	SentinelParams *SentinelParams `json:"sentinelParams,omitempty"`
 }
 
 func (g Group) GetMaster() string {
@@ -50,6 +89,22 @@ func (g Group) GetServerIndex(addr string) int {
 	return -1
 }
 
+// Clone returns a deep copy, callers may modify it without touching the
+// cached group.
+func (g *Group) Clone() *Group {
+	c := *g
+	c.Servers = make([]*GroupServer, len(g.Servers))
+	for i, v := range g.Servers {
+		x := *v
+		c.Servers[i] = &x
+	}
+	if g.SentinelParams != nil {
+		p := *g.SentinelParams
+		c.SentinelParams = &p
+	}
+	return &c
+}
+
 func (g *Group) Encode() []byte {
 	return jsonEncode("group", g)
 }
@@ -70,6 +125,14 @@ func (g Groups) GetMasters() map[string]string {
 	return masters
 }
 
+func (g Groups) Clone() Groups {
+	c := make(Groups, len(g))
+	for k, v := range g {
+		c[k] = v.Clone()
+	}
+	return c
+}
+
 func (g *Groups) Encode() []byte {
 	return jsonEncode("groups", g)
 }
@@ -45,6 +45,32 @@ type GSLB struct {
 	Backends GSLBBackendGroups `json:"backends,omitempty"`
 }
 
+// Clone returns a deep copy, callers may modify it without touching the
+// cached gslb.
+func (g *GSLB) Clone() *GSLB {
+	c := *g
+	c.Servers = append([]string(nil), g.Servers...)
+	c.Monitors = append(GSLBMonitors(nil), g.Monitors...)
+	c.Backends = nil
+	for _, b := range g.Backends {
+		x := &GSLBBackendGroup{
+			Name:    b.Name,
+			Servers: append([]string(nil), b.Servers...),
+		}
+		if b.ServerGroup != nil {
+			x.ServerGroup = make(GSLBBackends, len(b.ServerGroup))
+			for k, v := range b.ServerGroup {
+				x.ServerGroup[k] = &GSLBBackend{
+					Servers: append([]string(nil), v.Servers...),
+					Port:    v.Port,
+				}
+			}
+		}
+		c.Backends = append(c.Backends, x)
+	}
+	return &c
+}
+
 func (g *GSLB) Encode() []byte {
 	return jsonEncode("gslb", g)
 }
@@ -55,6 +81,14 @@ func (g *GSLB) Decode(data []byte) error {
 
 type GSLBs map[string]*GSLB
 
+func (g GSLBs) Clone() GSLBs {
+	c := make(GSLBs, len(g))
+	for k, v := range g {
+		c[k] = v.Clone()
+	}
+	return c
+}
+
 func (g *GSLBs) Encode() []byte {
 	return jsonEncode("gslbs", g)
 }
@@ -0,0 +1,106 @@
+package dao
+
+import "sort"
+
+const (
+	JobRunning   = "running"
+	JobFinished  = "finished"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	TargetPending   = "pending"
+	TargetRunning   = "running"
+	TargetDone      = "done"
+	TargetFailed    = "failed"
+	TargetCancelled = "cancelled"
+)
+
+// MaxJobLogs caps the log lines kept by a job, the oldest are dropped.
+const MaxJobLogs = 200
+
+type JobTarget struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// Job is a long-running operation, e.g. a resync of every group, the dashboard
+// which runs it is the owner and persists every change of its progress.
+type Job struct {
+	ID         string       `json:"id"`
+	Type       string       `json:"type"`
+	Owner      string       `json:"owner"`
+	State      string       `json:"state"`
+	Targets    []*JobTarget `json:"targets"`
+	Logs       []string     `json:"logs,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	CreateTime string       `json:"createTime"`
+	FinishTime string       `json:"finishTime,omitempty"`
+}
+
+func (j *Job) Finished() bool {
+	return j.State != JobRunning
+}
+
+// Progress returns the number of targets done, whether they succeeded or not.
+func (j *Job) Progress() (done, total int) {
+	for _, t := range j.Targets {
+		switch t.State {
+		case TargetDone, TargetFailed, TargetCancelled:
+			done++
+		}
+	}
+	return done, len(j.Targets)
+}
+
+func (j *Job) Target(name string) *JobTarget {
+	for _, t := range j.Targets {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func (j *Job) Clone() *Job {
+	c := *j
+	c.Targets = make([]*JobTarget, len(j.Targets))
+	for i, t := range j.Targets {
+		x := *t
+		c.Targets[i] = &x
+	}
+	c.Logs = append([]string(nil), j.Logs...)
+	return &c
+}
+
+func (j *Job) Encode() []byte {
+	return jsonEncode("job", j)
+}
+
+func (j *Job) Decode(data []byte) error {
+	return jsonDecode("job", j, data)
+}
+
+type Jobs map[string]*Job
+
+func (j Jobs) Clone() Jobs {
+	c := make(Jobs, len(j))
+	for k, v := range j {
+		c[k] = v.Clone()
+	}
+	return c
+}
+
+// Sorted returns the jobs, the newest first.
+func (j Jobs) Sorted() []*Job {
+	jobs := make([]*Job, 0, len(j))
+	for _, v := range j {
+		jobs = append(jobs, v)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].ID > jobs[b].ID
+	})
+	return jobs
+}
@@ -10,38 +10,55 @@ import (
 )
 
 type groupMapper struct {
-	product string
-	client  Client
-	mutex   *sync.Mutex
-	groups  dao.Groups
+	product  string
+	client   Client
+	mutex    *sync.Mutex
+	groups   dao.Groups
+	versions map[string]int64
+	// gen is bumped on every local write, a reload started before the write
+	// may have read stale nodes and is dropped, see errStaleReload.
+	gen     int64
+	watcher *watcher
 }
 
 func NewGroupMapper(product string, client Client) (*groupMapper, error) {
 	g := &groupMapper{
-		product: product,
-		client:  client,
-		mutex:   new(sync.Mutex),
-		groups:  make(dao.Groups),
+		product:  product,
+		client:   client,
+		mutex:    new(sync.Mutex),
+		groups:   make(dao.Groups),
+		versions: make(map[string]int64),
 	}
 	if err := g.init(); err != nil {
 		return nil, err
 	}
 
+	g.watcher = newWatcher("groupMapper", client, func() (<-chan struct{}, []string, error) {
+		return client.WatchInOrder(coordinate.GroupDir(product))
+	}, g.init)
 	return g, nil
 }
 
 func (m *groupMapper) init() error {
+	m.mutex.Lock()
+	gen := m.gen
+	m.mutex.Unlock()
+
 	paths, err := m.client.List(coordinate.GroupDir(m.product), false)
 	if err != nil {
 		return err
 	}
 
 	groups := make(dao.Groups)
+	versions := make(map[string]int64)
 	for _, path := range paths {
-		data, err := m.client.Read(path, true)
+		data, version, err := m.client.ReadVersion(path, false)
 		if err != nil {
 			return err
 		}
+		if data == nil {
+			continue
+		}
 
 		g := &dao.Group{}
 		if err := g.Decode(data); err != nil {
@@ -49,26 +66,65 @@ func (m *groupMapper) init() error {
 		}
 
 		groups[g.Name] = g
+		versions[g.Name] = version
 	}
 
 	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gen != gen {
+		return errStaleReload
+	}
 	m.groups = groups
-	m.mutex.Unlock()
-
+	m.versions = versions
 	return nil
 }
 
+// refresh reloads one group after a version conflict.
+func (m *groupMapper) refresh(name string) {
+	data, version, err := m.client.ReadVersion(coordinate.GroupPath(m.product, name), false)
+	if err != nil {
+		log.Warnf("groupMapper::refresh read fail. group-[%s] err-[%s]", name, err.Error())
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.gen++
+	if data == nil {
+		delete(m.groups, name)
+		delete(m.versions, name)
+		return
+	}
+	g := &dao.Group{}
+	if err := g.Decode(data); err != nil {
+		log.Warnf("groupMapper::refresh decode fail. group-[%s] err-[%s]", name, err.Error())
+		return
+	}
+	m.groups[name] = g
+	m.versions[name] = version
+}
+
+func (m *groupMapper) Close() error {
+	return m.watcher.Close()
+}
+
 func (m *groupMapper) Create(g *dao.Group) error {
 	data := g.Encode()
 	log.Infof("groupMapper::CreateGroup group-[%s]:\n%s\n", g.Name, string(data))
 
-	if err := m.client.Update(coordinate.GroupPath(m.product, g.Name), data); err != nil {
+	version, err := m.client.UpdateVersion(coordinate.GroupPath(m.product, g.Name), data, 0)
+	if err != nil {
 		log.Errorln("groupMapper::CreateGroup update fail. err:", err)
-		return fmt.Errorf("groupMapper::CreateGroup update fail. group-[%s] err-[%s]", g.Name, err.Error())
+		if err == coordinate.ErrVersionConflict {
+			m.refresh(g.Name)
+		}
+		return fmt.Errorf("groupMapper::CreateGroup update fail. group-[%s] err-[%w]", g.Name, err)
 	}
 
 	m.mutex.Lock()
+	m.gen++
 	m.groups[g.Name] = g
+	m.versions[g.Name] = version
 	m.mutex.Unlock()
 
 	return nil
@@ -78,13 +134,23 @@ func (m *groupMapper) Update(g *dao.Group) error {
 	data := g.Encode()
 	log.Infof("groupMapper::UpdateGroup group-[%s]:\n%s\n", g.Name, string(data))
 
-	if err := m.client.Update(coordinate.GroupPath(m.product, g.Name), data); err != nil {
+	m.mutex.Lock()
+	version := m.versions[g.Name]
+	m.mutex.Unlock()
+
+	version, err := m.client.UpdateVersion(coordinate.GroupPath(m.product, g.Name), data, version)
+	if err != nil {
 		log.Errorln("groupMapper::UpdateGroup update fail. err:", err)
-		return fmt.Errorf("groupMapper::UpdateGroup update fail. group-[%s] err-[%s]", g.Name, err.Error())
+		if err == coordinate.ErrVersionConflict {
+			m.refresh(g.Name)
+		}
+		return fmt.Errorf("groupMapper::UpdateGroup update fail. group-[%s] err-[%w]", g.Name, err)
 	}
 
 	m.mutex.Lock()
+	m.gen++
 	m.groups[g.Name] = g
+	m.versions[g.Name] = version
 	m.mutex.Unlock()
 
 	return nil
@@ -96,11 +162,13 @@ func (m *groupMapper) Remove(g *dao.Group) error {
 
 	if err := m.client.Delete(coordinate.GroupPath(m.product, g.Name)); err != nil {
 		log.Errorln("groupMapper::RemoveGroup delete fail. err:", err)
-		return fmt.Errorf("groupMapper::RemoveGroup delete fail. group-[%s] err-[%s]", g.Name, err.Error())
+		return fmt.Errorf("groupMapper::RemoveGroup delete fail. group-[%s] err-[%w]", g.Name, err)
 	}
 
 	m.mutex.Lock()
+	m.gen++
 	delete(m.groups, g.Name)
+	delete(m.versions, g.Name)
 	m.mutex.Unlock()
 
 	return nil
@@ -108,7 +176,7 @@ func (m *groupMapper) Remove(g *dao.Group) error {
 
 func (m *groupMapper) Info() (dao.Groups, error) {
 	m.mutex.Lock()
-	groups := m.groups
+	groups := m.groups.Clone()
 	m.mutex.Unlock()
 	return groups, nil
 }
@@ -2,6 +2,7 @@ package mapper
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"reflect"
 	"sync"
@@ -12,35 +13,56 @@ import (
 )
 
 type gslbMapper struct {
-	product string
-	client  Client
-	gm      *groupMapper
-	mutex   *sync.Mutex
-	gslbs   dao.GSLBs
+	product  string
+	client   Client
+	gm       *groupMapper
+	mutex    *sync.Mutex
+	gslbs    dao.GSLBs
+	versions map[string]int64
+	gen      int64
+	watcher  *watcher
 }
 
 func NewGSLBMapper(product string, client Client, gm *groupMapper) (*gslbMapper, error) {
 	g := &gslbMapper{
-		product: product,
-		client:  client,
-		gm:      gm,
-		mutex:   new(sync.Mutex),
-		gslbs:   make(dao.GSLBs),
+		product:  product,
+		client:   client,
+		gm:       gm,
+		mutex:    new(sync.Mutex),
+		gslbs:    make(dao.GSLBs),
+		versions: make(map[string]int64),
 	}
 	if err := g.init(); err != nil {
 		return nil, err
 	}
 
+	g.watcher = newWatcher("gslbMapper", client, func() (<-chan struct{}, []string, error) {
+		signal, dirs, err := client.WatchInOrder(coordinate.GSLBDir())
+		if err != nil {
+			return nil, nil, err
+		}
+		// the node of the product may be created later in any gslb dir.
+		var paths []string
+		for _, dir := range dirs {
+			paths = append(paths, path.Join(dir, product))
+		}
+		return signal, paths, nil
+	}, g.init)
 	return g, nil
 }
 
 func (m *gslbMapper) init() error {
+	m.mutex.Lock()
+	gen := m.gen
+	m.mutex.Unlock()
+
 	paths, err := m.client.List(coordinate.GSLBDir(), false)
 	if err != nil {
 		return err
 	}
 
 	gslbs := make(dao.GSLBs)
+	versions := make(map[string]int64)
 	for _, path := range paths {
 		productPaths, err := m.client.List(path, false)
 		if err != nil {
@@ -52,15 +74,19 @@ func (m *gslbMapper) init() error {
 				continue
 			}
 
-			data, err := m.client.Read(pPath, true)
+			data, version, err := m.client.ReadVersion(pPath, false)
 			if err != nil {
 				return err
 			}
+			if data == nil {
+				continue
+			}
 
 			g := &dao.GSLB{}
 			if err := g.Decode(data); err != nil {
 				return err
 			}
+			versions[g.Name] = version
 			if len(g.Servers) == 0 {
 				continue
 			}
@@ -70,29 +96,41 @@ func (m *gslbMapper) init() error {
 	}
 
 	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gen != gen {
+		return errStaleReload
+	}
 	m.gslbs = gslbs
-	m.mutex.Unlock()
-
+	m.versions = versions
 	return nil
 }
 
+func (m *gslbMapper) Close() error {
+	return m.watcher.Close()
+}
+
 func (m *gslbMapper) Update(g *dao.GSLB) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	if old, ok := m.gslbs[g.Name]; ok && reflect.DeepEqual(old, g) {
-		log.Tracef("gslbMapper::Update gslbName-[%s]. old and new is equal.")
+		log.Tracef("gslbMapper::Update gslbName-[%s]. old and new is equal.", g.Name)
 		return nil
 	}
 
 	data := g.Encode()
 	log.Infof("gslbMapper::Update gslbName-[%s]\n%s\n", g.Name, string(data))
-	if err := m.client.Update(coordinate.GSLBPath(g.Name, m.product), data); err != nil {
+	version, err := m.client.UpdateVersion(coordinate.GSLBPath(g.Name, m.product), data, m.versions[g.Name])
+	if err != nil {
+		// on a version conflict the gslb has been changed by someone else,
+		// the watcher reloads it.
 		log.Errorln("gslbMapper::Update update fail. err:", err)
-		return fmt.Errorf("gslbMapper::Update update fail. gslbName-[%s] err-[%s]", g.Name, err.Error())
+		return fmt.Errorf("gslbMapper::Update update fail. gslbName-[%s] err-[%w]", g.Name, err)
 	}
 
+	m.gen++
 	m.gslbs[g.Name] = g
+	m.versions[g.Name] = version
 	return nil
 }
 
@@ -102,11 +140,13 @@ func (m *gslbMapper) Delete(g *dao.GSLB) error {
 
 	if err := m.client.Delete(coordinate.GSLBPath(g.Name, m.product)); err != nil {
 		log.Errorln("gslbMapper::Delete delete fail. err:", err)
-		return fmt.Errorf("gslbMapper::Delete delete fail. err-[%s]", err.Error())
+		return fmt.Errorf("gslbMapper::Delete delete fail. err-[%w]", err)
 	}
 
 	m.mutex.Lock()
+	m.gen++
 	delete(m.gslbs, g.Name)
+	delete(m.versions, g.Name)
 	m.mutex.Unlock()
 
 	return nil
@@ -114,7 +154,7 @@ func (m *gslbMapper) Delete(g *dao.GSLB) error {
 
 func (m *gslbMapper) Info() (dao.GSLBs, error) {
 	m.mutex.Lock()
-	gslbs := m.gslbs
+	gslbs := m.gslbs.Clone()
 	m.mutex.Unlock()
 	return gslbs, nil
 }
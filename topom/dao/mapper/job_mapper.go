@@ -0,0 +1,118 @@
+package mapper
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// jobMapper caches the jobs of every dashboard of the product, a job is only
+// written by the dashboard which owns it.
+type jobMapper struct {
+	product string
+	client  Client
+	mutex   *sync.Mutex
+	jobs    dao.Jobs
+	gen     int64
+	watcher *watcher
+}
+
+func NewJobMapper(product string, client Client) (*jobMapper, error) {
+	j := &jobMapper{
+		product: product,
+		client:  client,
+		mutex:   new(sync.Mutex),
+		jobs:    make(dao.Jobs),
+	}
+	if err := j.init(); err != nil {
+		return nil, err
+	}
+
+	j.watcher = newWatcher("jobMapper", client, func() (<-chan struct{}, []string, error) {
+		return client.WatchInOrder(coordinate.JobDir(product))
+	}, j.init)
+	return j, nil
+}
+
+func (m *jobMapper) init() error {
+	m.mutex.Lock()
+	gen := m.gen
+	m.mutex.Unlock()
+
+	paths, err := m.client.List(coordinate.JobDir(m.product), false)
+	if err != nil {
+		return err
+	}
+
+	jobs := make(dao.Jobs)
+	for _, path := range paths {
+		data, err := m.client.Read(path, false)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+
+		j := &dao.Job{}
+		if err := j.Decode(data); err != nil {
+			return err
+		}
+		jobs[j.ID] = j
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gen != gen {
+		return errStaleReload
+	}
+	m.jobs = jobs
+	return nil
+}
+
+func (m *jobMapper) Close() error {
+	return m.watcher.Close()
+}
+
+func (m *jobMapper) Update(j *dao.Job) error {
+	data := j.Encode()
+	log.Debugf("jobMapper::Update job-[%s]:\n%s\n", j.ID, string(data))
+
+	if err := m.client.Update(coordinate.JobPath(m.product, j.ID), data); err != nil {
+		log.Errorln("jobMapper::Update update fail. err:", err)
+		return fmt.Errorf("jobMapper::Update update fail. job-[%s] err-[%w]", j.ID, err)
+	}
+
+	m.mutex.Lock()
+	m.gen++
+	m.jobs[j.ID] = j
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *jobMapper) Remove(id string) error {
+	log.Infof("jobMapper::Remove job-[%s]", id)
+
+	if err := m.client.Delete(coordinate.JobPath(m.product, id)); err != nil {
+		log.Errorln("jobMapper::Remove delete fail. err:", err)
+		return fmt.Errorf("jobMapper::Remove delete fail. job-[%s] err-[%w]", id, err)
+	}
+
+	m.mutex.Lock()
+	m.gen++
+	delete(m.jobs, id)
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *jobMapper) Info() (dao.Jobs, error) {
+	m.mutex.Lock()
+	jobs := m.jobs.Clone()
+	m.mutex.Unlock()
+	return jobs, nil
+}
@@ -0,0 +1,294 @@
+package mapper
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/coordinate/memory"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+const testProduct = "demo"
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGroupMapperWatch(t *testing.T) {
+	client := memory.New()
+	defer client.Close()
+	m, err := NewGroupMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// another writer shares the coordinator.
+	other := client.NewSession()
+	g := &dao.Group{Name: "g1", Servers: []*dao.GroupServer{{Addr: "127.0.0.1:6379"}}}
+	if err := other.Update(coordinate.GroupPath(testProduct, "g1"), g.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "group created", func() bool {
+		groups, _ := m.Info()
+		return groups["g1"] != nil
+	})
+
+	g.OutOfSync = true
+	if err := other.Update(coordinate.GroupPath(testProduct, "g1"), g.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "group updated", func() bool {
+		groups, _ := m.Info()
+		return groups["g1"] != nil && groups["g1"].OutOfSync
+	})
+
+	if err := other.Delete(coordinate.GroupPath(testProduct, "g1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "group removed", func() bool {
+		groups, _ := m.Info()
+		return len(groups) == 0
+	})
+}
+
+func TestGroupMapperConflict(t *testing.T) {
+	client := memory.New()
+	defer client.Close()
+	m, err := NewGroupMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Create(&dao.Group{Name: "g1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Create(&dao.Group{Name: "g1"}); err == nil {
+		t.Fatal("create existing group")
+	}
+
+	// stop the watcher so that the cache goes stale.
+	m.watcher.Close()
+	path := coordinate.GroupPath(testProduct, "g1")
+	_, version, err := client.ReadVersion(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := &dao.Group{Name: "g1", OutOfSync: true}
+	if _, err := client.UpdateVersion(path, external.Encode(), version); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Update(&dao.Group{Name: "g1", ProxyReadPort: 6001}); err == nil {
+		t.Fatal("update with stale version")
+	}
+	groups, _ := m.Info()
+	if !groups["g1"].OutOfSync {
+		t.Fatal("group not refreshed after conflict")
+	}
+	if err := m.Update(&dao.Group{Name: "g1", ProxyReadPort: 6001}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// conflictClient fails every Delete with a version conflict.
+type conflictClient struct {
+	*memory.Client
+}
+
+func (c *conflictClient) Delete(path string) error {
+	return coordinate.ErrVersionConflict
+}
+
+func TestGroupMapperRemoveConflict(t *testing.T) {
+	client := memory.New()
+	defer client.Close()
+	m, err := NewGroupMapper(testProduct, &conflictClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	g := &dao.Group{Name: "g1"}
+	if err := m.Create(g); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove(g); !errors.Is(err, coordinate.ErrVersionConflict) {
+		t.Fatalf("remove with a conflict, err = %v", err)
+	}
+}
+
+// raceClient blocks the next List once armed, until it's resumed. The watch
+// of the muted path never fires.
+type raceClient struct {
+	*memory.Client
+	armed  int32
+	listed chan struct{}
+	resume chan struct{}
+	muted  string
+}
+
+func (c *raceClient) Watch(path string) (<-chan struct{}, error) {
+	if path == c.muted {
+		return make(chan struct{}), nil
+	}
+	return c.Client.Watch(path)
+}
+
+func (c *raceClient) List(path string, must bool) ([]string, error) {
+	if atomic.CompareAndSwapInt32(&c.armed, 1, 0) {
+		c.listed <- struct{}{}
+		<-c.resume
+	}
+	return c.Client.List(path, must)
+}
+
+func TestGroupMapperReloadRace(t *testing.T) {
+	// the local write to g1 fires no watch, only the rerun of the dropped
+	// reload can pick the external change to g2 up.
+	client := &raceClient{
+		Client: memory.New(), listed: make(chan struct{}), resume: make(chan struct{}),
+		muted: coordinate.GroupPath(testProduct, "g1"),
+	}
+	defer client.Close()
+	m, err := NewGroupMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for _, name := range []string{"g1", "g2"} {
+		if err := m.Create(&dao.Group{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "groups loaded", func() bool {
+		groups, _ := m.Info()
+		return len(groups) == 2
+	})
+
+	// the reload fired by the external change is held until a local write
+	// went through, its result is dropped and the reload must run again.
+	atomic.StoreInt32(&client.armed, 1)
+	other := client.NewSession()
+	if err := other.Update(coordinate.GroupPath(testProduct, "g2"), (&dao.Group{Name: "g2", OutOfSync: true}).Encode()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.listed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("reload not fired after external change")
+	}
+	if err := m.Update(&dao.Group{Name: "g1", ProxyReadPort: 6001}); err != nil {
+		t.Fatal(err)
+	}
+	close(client.resume)
+
+	waitFor(t, "external change reloaded", func() bool {
+		groups, _ := m.Info()
+		return groups["g2"] != nil && groups["g2"].OutOfSync
+	})
+	if groups, _ := m.Info(); groups["g1"].ProxyReadPort != 6001 {
+		t.Fatal("local write lost after reload")
+	}
+}
+
+func TestSentinelMapperWatch(t *testing.T) {
+	client := memory.New()
+	defer client.Close()
+	m, err := NewSentinelMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	s := &dao.Sentinel{Servers: []string{"127.0.0.1:26379"}}
+	if err := client.Update(coordinate.SentinelPath(testProduct), s.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "sentinel updated", func() bool {
+		s, _ := m.Info()
+		return len(s.Servers) == 1
+	})
+	if err := m.Update(&dao.Sentinel{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnMapperCommit(t *testing.T) {
+	client := memory.New()
+	defer client.Close()
+	gm, err := NewGroupMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gm.Close()
+	sm, err := NewSentinelMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+	lm, err := NewGSLBMapper(testProduct, client, gm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	m := NewTxnMapper(client, gm, sm, lm)
+
+	if err := gm.Create(&dao.Group{Name: "g1"}); err != nil {
+		t.Fatal(err)
+	}
+	txn := dao.NewTxn()
+	txn.UpdateGroup(&dao.Group{Name: "g1", OutOfSync: true})
+	txn.UpdateSentinel(&dao.Sentinel{Servers: []string{"127.0.0.1:26379"}, OutOfSync: true})
+	txn.UpdateGSLB(&dao.GSLB{Name: "haproxy", Servers: []string{"127.0.0.1:9001"}})
+	if err := m.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ := gm.Info()
+	sentinel, _ := sm.Info()
+	gslbs, _ := lm.Info()
+	if !groups["g1"].OutOfSync || !sentinel.OutOfSync || gslbs["haproxy"] == nil {
+		t.Fatalf("unexpected cache after commit %+v %+v %+v", groups["g1"], sentinel, gslbs)
+	}
+
+	// another writer changes the sentinel, the whole transaction fails.
+	sm.watcher.Close()
+	path := coordinate.SentinelPath(testProduct)
+	_, version, _ := client.ReadVersion(path, true)
+	if _, err := client.UpdateVersion(path, (&dao.Sentinel{}).Encode(), version); err != nil {
+		t.Fatal(err)
+	}
+	txn = dao.NewTxn()
+	txn.RemoveGroup("g1")
+	txn.UpdateSentinel(&dao.Sentinel{Servers: []string{"127.0.0.1:26380"}})
+	txn.DeleteGSLB("haproxy")
+	if err := m.Commit(txn); err == nil {
+		t.Fatal("commit with stale sentinel version")
+	}
+	if data, _ := client.Read(coordinate.GroupPath(testProduct, "g1"), false); data == nil {
+		t.Fatal("group removed by failed commit")
+	}
+	if data, _ := client.Read(coordinate.GSLBPath("haproxy", testProduct), false); data == nil {
+		t.Fatal("gslb deleted by failed commit")
+	}
+	if sentinel, _ := sm.Info(); len(sentinel.Servers) != 0 {
+		t.Fatal("sentinel not refreshed after conflict")
+	}
+	if err := m.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := gm.Info(); len(groups) != 0 {
+		t.Fatalf("group not removed %+v", groups)
+	}
+}
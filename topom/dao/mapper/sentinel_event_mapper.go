@@ -0,0 +1,91 @@
+package mapper
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// sentinelEventMapper caches the sentinel event timeline of the product, it's
+// only written by the dashboard which owns the product.
+type sentinelEventMapper struct {
+	product string
+	client  Client
+	mutex   *sync.Mutex
+	events  dao.SentinelEvents
+	gen     int64
+	watcher *watcher
+}
+
+func NewSentinelEventMapper(product string, client Client) (*sentinelEventMapper, error) {
+	m := &sentinelEventMapper{
+		product: product,
+		client:  client,
+		mutex:   new(sync.Mutex),
+	}
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	m.watcher = newWatcher("sentinelEventMapper", client, func() (<-chan struct{}, []string, error) {
+		return nil, []string{coordinate.SentinelEventsPath(product)}, nil
+	}, m.init)
+	return m, nil
+}
+
+func (m *sentinelEventMapper) init() error {
+	m.mutex.Lock()
+	gen := m.gen
+	m.mutex.Unlock()
+
+	data, err := m.client.Read(coordinate.SentinelEventsPath(m.product), false)
+	if err != nil {
+		return err
+	}
+
+	var events dao.SentinelEvents
+	if data != nil {
+		if err := events.Decode(data); err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gen != gen {
+		return errStaleReload
+	}
+	m.events = events
+	return nil
+}
+
+func (m *sentinelEventMapper) Close() error {
+	return m.watcher.Close()
+}
+
+func (m *sentinelEventMapper) Update(events dao.SentinelEvents) error {
+	data := events.Encode()
+	log.Debugf("sentinelEventMapper::Update %d events", len(events))
+
+	if err := m.client.Update(coordinate.SentinelEventsPath(m.product), data); err != nil {
+		log.Errorln("sentinelEventMapper::Update update fail. err:", err)
+		return fmt.Errorf("sentinelEventMapper::Update update fail. err-[%w]", err)
+	}
+
+	m.mutex.Lock()
+	m.gen++
+	m.events = events
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *sentinelEventMapper) Info() (dao.SentinelEvents, error) {
+	m.mutex.Lock()
+	events := m.events.Clone()
+	m.mutex.Unlock()
+	return events, nil
+}
@@ -14,6 +14,9 @@ type sentinelMapper struct {
 	client   Client
 	mutex    *sync.Mutex
 	sentinel *dao.Sentinel
+	version  int64
+	gen      int64
+	watcher  *watcher
 }
 
 func NewSentinelMapper(product string, client Client) (*sentinelMapper, error) {
@@ -27,40 +30,66 @@ func NewSentinelMapper(product string, client Client) (*sentinelMapper, error) {
 		return nil, err
 	}
 
+	s.watcher = newWatcher("sentinelMapper", client, func() (<-chan struct{}, []string, error) {
+		return nil, []string{coordinate.SentinelPath(product)}, nil
+	}, s.init)
 	return s, nil
 }
 
 func (m *sentinelMapper) init() error {
-	data, err := m.client.Read(coordinate.SentinelPath(m.product), false)
+	m.mutex.Lock()
+	gen := m.gen
+	m.mutex.Unlock()
+
+	data, version, err := m.client.ReadVersion(coordinate.SentinelPath(m.product), false)
 	if err != nil {
 		return err
 	}
 
+	sentinel := &dao.Sentinel{}
 	if data != nil {
-		sentinel := &dao.Sentinel{}
 		if err := sentinel.Decode(data); err != nil {
 			return err
 		}
-
-		m.mutex.Lock()
-		m.sentinel = sentinel
-		m.mutex.Unlock()
 	}
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gen != gen {
+		return errStaleReload
+	}
+	m.sentinel = sentinel
+	m.version = version
 	return nil
 }
 
+func (m *sentinelMapper) Close() error {
+	return m.watcher.Close()
+}
+
 func (m *sentinelMapper) Update(sentinel *dao.Sentinel) error {
 	data := sentinel.Encode()
 	log.Infof("sentinelMapper::UpdateSentinel \n%s\n", string(data))
 
-	if err := m.client.Update(coordinate.SentinelPath(m.product), data); err != nil {
+	m.mutex.Lock()
+	version := m.version
+	m.mutex.Unlock()
+
+	version, err := m.client.UpdateVersion(coordinate.SentinelPath(m.product), data, version)
+	if err != nil {
 		log.Errorln("sentinelMapper::UpdateSentinel update fail. err:", err)
-		return fmt.Errorf("sentinelMapper::UpdateSentinel update fail. err-[%s]", err.Error())
+		if err == coordinate.ErrVersionConflict {
+			if err := m.init(); err != nil && err != errStaleReload {
+				log.Warnf("sentinelMapper::UpdateSentinel reload fail. err-[%s]", err.Error())
+			}
+		}
+		return fmt.Errorf("sentinelMapper::UpdateSentinel update fail. err-[%w]", err)
 	}
 
 	m.mutex.Lock()
+	m.gen++
 	m.sentinel = sentinel
+	m.version = version
 	m.mutex.Unlock()
 
 	return nil
@@ -68,7 +97,7 @@ func (m *sentinelMapper) Update(sentinel *dao.Sentinel) error {
 
 func (m *sentinelMapper) Info() (*dao.Sentinel, error) {
 	m.mutex.Lock()
-	sentinel := m.sentinel
+	sentinel := m.sentinel.Clone()
 	m.mutex.Unlock()
 	return sentinel, nil
 }
@@ -1,5 +1,7 @@
 package mapper
 
+import "github.com/pourer/pikamgr/coordinate/types"
+
 type Client interface {
 	Create(path string, data []byte) error
 	Update(path string, data []byte) error
@@ -8,9 +10,14 @@ type Client interface {
 	Read(path string, must bool) ([]byte, error)
 	List(path string, must bool) ([]string, error)
 
+	ReadVersion(path string, must bool) ([]byte, int64, error)
+	UpdateVersion(path string, data []byte, version int64) (int64, error)
+	Txn(ops []types.Op) ([]int64, error)
+
 	Close() error
 
 	WatchInOrder(path string) (<-chan struct{}, []string, error)
+	Watch(path string) (<-chan struct{}, error)
 
 	CreateEphemeral(path string, data []byte) (<-chan struct{}, error)
 	CreateEphemeralInOrder(path string, data []byte) (<-chan struct{}, string, error)
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pourer/pikamgr/coordinate"
@@ -26,21 +27,25 @@ type templateFileMapper struct {
 	mutex   *sync.Mutex
 	tfs     dao.TemplateFiles
 	done    chan struct{}
+
+	// interval is the time.Duration between two scans.
+	interval int64
 }
 
 func NewTemplateFileMapper(client Client, scanDir string, interval time.Duration) (*templateFileMapper, error) {
 	t := &templateFileMapper{
-		client:  client,
-		scanDir: scanDir,
-		mutex:   new(sync.Mutex),
-		tfs:     make(dao.TemplateFiles),
-		done:    make(chan struct{}),
+		client:   client,
+		scanDir:  scanDir,
+		mutex:    new(sync.Mutex),
+		tfs:      make(dao.TemplateFiles),
+		done:     make(chan struct{}),
+		interval: int64(interval),
 	}
 	if err := t.init(); err != nil {
 		return nil, err
 	}
 
-	go t.monitor(interval)
+	go t.monitor()
 	return t, nil
 }
 
@@ -86,12 +91,12 @@ func (m *templateFileMapper) init() error {
 		if err := func(file string) error {
 			f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 			if err != nil {
-				return fmt.Errorf("open file-[%s] err-[%s]", file, err.Error())
+				return fmt.Errorf("open file-[%s] err-[%w]", file, err)
 			}
 			defer f.Close()
 
 			if _, err := f.Write(v.Data); err != nil {
-				return fmt.Errorf("write file-[%s] err-[%s]", file, err.Error())
+				return fmt.Errorf("write file-[%s] err-[%w]", file, err)
 			}
 			return nil
 		}(filepath.Join(tfDir, fileBaseName)); err != nil {
@@ -111,7 +116,12 @@ func (m *templateFileMapper) Close() error {
 	return nil
 }
 
-func (m *templateFileMapper) monitor(interval time.Duration) {
+// SetInterval sets the interval of the scans, from the next one.
+func (m *templateFileMapper) SetInterval(interval time.Duration) {
+	atomic.StoreInt64(&m.interval, int64(interval))
+}
+
+func (m *templateFileMapper) monitor() {
 	for {
 		select {
 		case <-m.done:
@@ -126,7 +136,7 @@ func (m *templateFileMapper) monitor(interval time.Duration) {
 		select {
 		case <-m.done:
 			return
-		case <-time.After(interval):
+		case <-time.After(time.Duration(atomic.LoadInt64(&m.interval))):
 		}
 	}
 }
@@ -143,13 +153,13 @@ func (m *templateFileMapper) doMonitor() error {
 		if err := func(file string) error {
 			f, err := os.Open(file)
 			if err != nil {
-				return fmt.Errorf("open file-[%s] err-[%s]", file, err.Error())
+				return fmt.Errorf("open file-[%s] err-[%w]", file, err)
 			}
 			defer f.Close()
 
 			data, err := ioutil.ReadAll(f)
 			if err != nil {
-				return fmt.Errorf("read file-[%s] err-[%s]", file, err.Error())
+				return fmt.Errorf("read file-[%s] err-[%w]", file, err)
 			}
 
 			md5Calcer.Reset()
@@ -221,7 +231,7 @@ func (m *templateFileMapper) update(fileName string, tf *dao.TemplateFile) error
 
 	if err := m.client.Update(coordinate.TemplateFilePath(fileName), tf.Data); err != nil {
 		log.Errorln("templateFileMapper::update update fail. err:", err)
-		return fmt.Errorf("templateFileMapper::update update fail. fileName-[%s] err-[%s]", fileName, err.Error())
+		return fmt.Errorf("templateFileMapper::update update fail. fileName-[%s] err-[%w]", fileName, err)
 	}
 	return nil
 }
@@ -231,7 +241,7 @@ func (m *templateFileMapper) delete(fileName string) error {
 
 	if err := m.client.Delete(coordinate.TemplateFilePath(fileName)); err != nil {
 		log.Errorln("templateFileMapper::delete update fail. err:", err)
-		return fmt.Errorf("templateFileMapper::delete update fail. fileName-[%s] err-[%s]", fileName, err.Error())
+		return fmt.Errorf("templateFileMapper::delete update fail. fileName-[%s] err-[%w]", fileName, err)
 	}
 	return nil
 }
@@ -63,7 +63,7 @@ func (m *topomMapper) Create() error {
 
 	if err := m.client.Create(coordinate.TopomPath(m.product), data); err != nil {
 		log.Errorln("topomMapper::Create create fail. err:", err)
-		return fmt.Errorf("topomMapper::Create create fail. err-[%s]", err.Error())
+		return fmt.Errorf("topomMapper::Create create fail. err-[%w]", err)
 	}
 
 	log.Infof("topomMapper::Create Suc. \n%s\n", string(data))
@@ -76,7 +76,7 @@ func (m *topomMapper) Delete() error {
 
 	if err := m.client.Delete(coordinate.TopomPath(m.product)); err != nil {
 		log.Errorln("topomMapper::Delete delete fail. err:", err)
-		return fmt.Errorf("topomMapper::Delete delete fail. err-[%s]", err.Error())
+		return fmt.Errorf("topomMapper::Delete delete fail. err-[%w]", err)
 	}
 
 	log.Infof("topomMapper::Delete Suc. \n%s\n", string(data))
@@ -0,0 +1,154 @@
+package mapper
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// txnMapper commits a dao.Txn with one coordinator transaction, every node is
+// checked against the version cached by its mapper.
+type txnMapper struct {
+	client Client
+	gm     *groupMapper
+	sm     *sentinelMapper
+	lm     *gslbMapper
+}
+
+func NewTxnMapper(client Client, gm *groupMapper, sm *sentinelMapper, lm *gslbMapper) *txnMapper {
+	return &txnMapper{
+		client: client,
+		gm:     gm,
+		sm:     sm,
+		lm:     lm,
+	}
+}
+
+func (m *txnMapper) Commit(txn *dao.Txn) error {
+	if txn.Empty() {
+		return nil
+	}
+
+	// the caches are locked until the transaction is done, so no local write
+	// can slip in between the versions read and the commit.
+	m.gm.mutex.Lock()
+	m.sm.mutex.Lock()
+	m.lm.mutex.Lock()
+
+	var ops []coordinate.Op
+	var groups, gslbs []string
+	for name := range txn.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		op := coordinate.Op{
+			Type:    coordinate.OpUpdate,
+			Path:    coordinate.GroupPath(m.gm.product, name),
+			Version: m.gm.versions[name],
+		}
+		if g := txn.Groups[name]; g != nil {
+			op.Data = g.Encode()
+		} else {
+			op.Type = coordinate.OpDelete
+		}
+		ops = append(ops, op)
+	}
+	if txn.Sentinel != nil {
+		ops = append(ops, coordinate.Op{
+			Type:    coordinate.OpUpdate,
+			Path:    coordinate.SentinelPath(m.sm.product),
+			Data:    txn.Sentinel.Encode(),
+			Version: m.sm.version,
+		})
+	}
+	for name, g := range txn.GSLBs {
+		if old, ok := m.lm.gslbs[name]; ok && g != nil && reflect.DeepEqual(old, g) {
+			continue
+		}
+		if _, ok := m.lm.versions[name]; !ok && g == nil {
+			continue
+		}
+		gslbs = append(gslbs, name)
+	}
+	sort.Strings(gslbs)
+	for _, name := range gslbs {
+		op := coordinate.Op{
+			Type:    coordinate.OpUpdate,
+			Path:    coordinate.GSLBPath(name, m.lm.product),
+			Version: m.lm.versions[name],
+		}
+		if g := txn.GSLBs[name]; g != nil {
+			op.Data = g.Encode()
+		} else {
+			op.Type = coordinate.OpDelete
+		}
+		ops = append(ops, op)
+	}
+
+	for _, op := range ops {
+		log.Infof("txnMapper::Commit %s:\n%s\n", op.String(), string(op.Data))
+	}
+	versions, err := m.client.Txn(ops)
+	if err == nil {
+		i := 0
+		m.gm.gen++
+		for _, name := range groups {
+			if g := txn.Groups[name]; g != nil {
+				m.gm.groups[name] = g
+				m.gm.versions[name] = versions[i]
+			} else {
+				delete(m.gm.groups, name)
+				delete(m.gm.versions, name)
+			}
+			i++
+		}
+		if txn.Sentinel != nil {
+			m.sm.gen++
+			m.sm.sentinel = txn.Sentinel
+			m.sm.version = versions[i]
+			i++
+		}
+		m.lm.gen++
+		for _, name := range gslbs {
+			if g := txn.GSLBs[name]; g != nil {
+				m.lm.gslbs[name] = g
+				m.lm.versions[name] = versions[i]
+			} else {
+				delete(m.lm.gslbs, name)
+				delete(m.lm.versions, name)
+			}
+			i++
+		}
+	}
+
+	m.lm.mutex.Unlock()
+	m.sm.mutex.Unlock()
+	m.gm.mutex.Unlock()
+
+	if err != nil {
+		log.Errorln("txnMapper::Commit txn fail. err:", err)
+		if err == coordinate.ErrVersionConflict {
+			m.refresh(groups, txn.Sentinel != nil)
+		}
+		return fmt.Errorf("txnMapper::Commit txn fail. err-[%w]", err)
+	}
+	return nil
+}
+
+// refresh reloads the nodes of a conflicting transaction, the gslbs are left
+// to the watcher like in gslbMapper.Update.
+func (m *txnMapper) refresh(groups []string, sentinel bool) {
+	for _, name := range groups {
+		m.gm.refresh(name)
+	}
+	if sentinel {
+		if err := m.sm.init(); err != nil && err != errStaleReload {
+			log.Warnf("txnMapper::Commit reload sentinel fail. err-[%s]", err.Error())
+		}
+	}
+}
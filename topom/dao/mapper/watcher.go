@@ -0,0 +1,132 @@
+package mapper
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+var WatchRetryInterval = time.Second * 3
+
+// errStaleReload is returned by a reload which raced a local write and whose
+// result was dropped, the watcher reloads again so that the changes which
+// fired the reload aren't missed.
+var errStaleReload = errors.New("reload raced a local write")
+
+// watcher keeps the cache of a mapper in sync with the coordinator, so that
+// edits made by hand or by another tool are picked up without a restart.
+//
+// list returns the nodes backing the cache and a signal which fires once the
+// set of nodes changes, every node is watched on its own. reload is called
+// after every change, and again as long as it returns errStaleReload.
+type watcher struct {
+	name   string
+	client Client
+	list   func() (<-chan struct{}, []string, error)
+	reload func() error
+
+	done   chan struct{}
+	exited chan struct{}
+}
+
+func newWatcher(name string, client Client, list func() (<-chan struct{}, []string, error), reload func() error) *watcher {
+	w := &watcher{
+		name:   name,
+		client: client,
+		list:   list,
+		reload: reload,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *watcher) Close() error {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	<-w.exited
+	return nil
+}
+
+func (w *watcher) run() {
+	defer close(w.exited)
+
+	var (
+		relist  = true
+		listed  <-chan struct{}
+		paths   = make(map[string]bool)
+		armed   = make(map[string]bool)
+		changed = make(chan string)
+		retry   <-chan time.Time
+	)
+	arm := func(path string) {
+		signal, err := w.client.Watch(path)
+		if err != nil {
+			log.Warnf("%s::watch watch node-[%s] fail. err-[%s]", w.name, path, err.Error())
+			retry = time.After(WatchRetryInterval)
+			return
+		}
+		armed[path] = true
+		go func() {
+			select {
+			case <-signal:
+				select {
+				case changed <- path:
+				case <-w.done:
+				}
+			case <-w.done:
+			}
+		}()
+	}
+
+	for {
+		if relist {
+			signal, list, err := w.list()
+			if err != nil {
+				log.Warnf("%s::watch list fail. err-[%s]", w.name, err.Error())
+				retry = time.After(WatchRetryInterval)
+			} else {
+				relist, listed = false, signal
+				paths = make(map[string]bool)
+				for _, path := range list {
+					paths[path] = true
+				}
+			}
+		}
+		for path := range paths {
+			if !armed[path] {
+				arm(path)
+			}
+		}
+
+		err := w.reload()
+		for err == errStaleReload {
+			select {
+			case <-w.done:
+				return
+			default:
+			}
+			err = w.reload()
+		}
+		if err != nil {
+			log.Warnf("%s::watch reload fail. err-[%s]", w.name, err.Error())
+			retry = time.After(WatchRetryInterval)
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-listed:
+			relist, listed = true, nil
+		case path := <-changed:
+			delete(armed, path)
+		case <-retry:
+			retry = nil
+		}
+	}
+}
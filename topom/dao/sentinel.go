@@ -5,10 +5,54 @@ type Sentinel struct {
 	OutOfSync bool     `json:"outOfSync"`
 }
 
+func (s *Sentinel) Clone() *Sentinel {
+	c := *s
+	c.Servers = append([]string(nil), s.Servers...)
+	return &c
+}
+
 func (s *Sentinel) Encode() []byte {
 	return jsonEncode("sentinel", s)
 }
 
 func (s *Sentinel) Decode(data []byte) error {
 	return jsonDecode("sentinel", s, data)
-}
\ No newline at end of file
+}
+
+// MaxSentinelEvents caps the events kept by the timeline of the product, the
+// oldest are dropped.
+const MaxSentinelEvents = 512
+
+// SentinelEvent is an event published by a sentinel about an instance of a
+// group, Instance is "master", "slave" or "sentinel". Master is the master of
+// the group, the new one of a +switch-master whose Addr is the old one.
+type SentinelEvent struct {
+	Time     string `json:"time"`
+	Sentinel string `json:"sentinel"`
+	Type     string `json:"type"`
+	Group    string `json:"group"`
+	Instance string `json:"instance"`
+	Addr     string `json:"addr"`
+	Master   string `json:"master,omitempty"`
+	Details  string `json:"details,omitempty"`
+}
+
+// SentinelEvents is the timeline of the product, the oldest event first.
+type SentinelEvents []*SentinelEvent
+
+func (e SentinelEvents) Clone() SentinelEvents {
+	c := make(SentinelEvents, len(e))
+	for i, v := range e {
+		event := *v
+		c[i] = &event
+	}
+	return c
+}
+
+func (e *SentinelEvents) Encode() []byte {
+	return jsonEncode("sentinel-events", e)
+}
+
+func (e *SentinelEvents) Decode(data []byte) error {
+	return jsonDecode("sentinel-events", e, data)
+}
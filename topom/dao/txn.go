@@ -0,0 +1,64 @@
+package dao
+
+// Txn collects the changes of one operation, the mappers commit them to the
+// coordinator together or not at all.
+type Txn struct {
+	// a nil group is removed.
+	Groups   map[string]*Group
+	Sentinel *Sentinel
+	// a nil gslb is deleted.
+	GSLBs map[string]*GSLB
+}
+
+func NewTxn() *Txn {
+	return &Txn{
+		Groups: make(map[string]*Group),
+		GSLBs:  make(map[string]*GSLB),
+	}
+}
+
+func (t *Txn) UpdateGroup(g *Group) {
+	t.Groups[g.Name] = g
+}
+
+func (t *Txn) RemoveGroup(name string) {
+	t.Groups[name] = nil
+}
+
+func (t *Txn) UpdateSentinel(s *Sentinel) {
+	t.Sentinel = s
+}
+
+func (t *Txn) UpdateGSLB(g *GSLB) {
+	t.GSLBs[g.Name] = g
+}
+
+func (t *Txn) DeleteGSLB(name string) {
+	t.GSLBs[name] = nil
+}
+
+func (t *Txn) Empty() bool {
+	return len(t.Groups) == 0 && t.Sentinel == nil && len(t.GSLBs) == 0
+}
+
+// ApplyGroups applies the pending group changes to groups.
+func (t *Txn) ApplyGroups(groups Groups) {
+	for name, g := range t.Groups {
+		if g == nil {
+			delete(groups, name)
+		} else {
+			groups[name] = g
+		}
+	}
+}
+
+// ApplyGSLBs applies the pending gslb changes to gslbs.
+func (t *Txn) ApplyGSLBs(gslbs GSLBs) {
+	for name, g := range t.GSLBs {
+		if g == nil {
+			delete(gslbs, name)
+		} else {
+			gslbs[name] = g
+		}
+	}
+}
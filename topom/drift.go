@@ -0,0 +1,339 @@
+package topom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// expectedSentinels is the state every sentinel of the product should be in,
+// the masters of the groups monitored with their configs, the other
+// sentinels as peers.
+type expectedSentinels struct {
+	servers []string
+	masters map[string]string
+	configs map[string]*redis.MonitorConfig
+}
+
+// expectedSentinels must be called with s.mutex held.
+func (s *service) expectedSentinels() (*expectedSentinels, error) {
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	masters := groups.GetMasters()
+	return &expectedSentinels{
+		servers: sentinel.Servers,
+		masters: masters,
+		configs: s.monitorConfigs(groups, masters),
+	}, nil
+}
+
+// resolveAddr returns the address as reported by the sentinels, the address
+// itself if it can't be resolved.
+func resolveAddr(addr string) string {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return addr
+	}
+	return tcpAddr.String()
+}
+
+// drifts compares the masters monitored by the sentinel with the expected
+// ones, by group.
+func (e *expectedSentinels) drifts(sentinel string, monitored map[string]*redis.MonitoredMaster) []*protocol.SentinelDrift {
+	var names []string
+	for groupName := range e.masters {
+		names = append(names, groupName)
+	}
+	for groupName := range monitored {
+		if _, ok := e.masters[groupName]; !ok {
+			names = append(names, groupName)
+		}
+	}
+	sort.Strings(names)
+
+	peers := make(map[string]bool)
+	for _, addr := range e.servers {
+		if addr != sentinel {
+			peers[resolveAddr(addr)] = true
+		}
+	}
+
+	drifts := []*protocol.SentinelDrift{}
+	for _, groupName := range names {
+		addr, expected := e.masters[groupName]
+		m := monitored[groupName]
+		switch {
+		case m == nil:
+			drifts = append(drifts, &protocol.SentinelDrift{
+				Group: groupName, Kind: protocol.SentinelDriftMissing, Expected: addr,
+			})
+			continue
+		case !expected:
+			drifts = append(drifts, &protocol.SentinelDrift{
+				Group: groupName, Kind: protocol.SentinelDriftUnexpected, Actual: m.Addr,
+			})
+			continue
+		case resolveAddr(addr) != m.Addr:
+			drifts = append(drifts, &protocol.SentinelDrift{
+				Group: groupName, Kind: protocol.SentinelDriftMaster, Expected: addr, Actual: m.Addr,
+			})
+			continue
+		}
+		drifts = append(drifts, configDrifts(groupName, e.configs[groupName], m)...)
+
+		known := make(map[string]bool)
+		for _, peer := range m.Peers {
+			known[peer] = true
+			if !peers[peer] {
+				drifts = append(drifts, &protocol.SentinelDrift{
+					Group: groupName, Kind: protocol.SentinelDriftUnknownPeer, Actual: peer,
+				})
+			}
+		}
+		for _, peer := range sortedKeys(peers) {
+			if !known[peer] {
+				drifts = append(drifts, &protocol.SentinelDrift{
+					Group: groupName, Kind: protocol.SentinelDriftMissingPeer, Expected: peer,
+				})
+			}
+		}
+	}
+	return drifts
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configDrifts compares the params of the master, the ones left to the
+// defaults of the sentinel aren't compared, nor the scripts the sentinel
+// doesn't report.
+func configDrifts(groupName string, expected *redis.MonitorConfig, m *redis.MonitoredMaster) []*protocol.SentinelDrift {
+	var drifts []*protocol.SentinelDrift
+	diff := func(key, expected, actual string) {
+		if expected != actual {
+			drifts = append(drifts, &protocol.SentinelDrift{
+				Group: groupName, Kind: protocol.SentinelDriftConfig,
+				Key: key, Expected: expected, Actual: actual,
+			})
+		}
+	}
+	ms := func(d time.Duration) string {
+		return strconv.FormatInt(int64(d/time.Millisecond), 10)
+	}
+	actual := m.Config
+	diff("quorum", strconv.Itoa(expected.Quorum), strconv.Itoa(actual.Quorum))
+	if expected.ParallelSyncs != 0 {
+		diff("parallel-syncs", strconv.Itoa(expected.ParallelSyncs), strconv.Itoa(actual.ParallelSyncs))
+	}
+	if expected.DownAfter != 0 {
+		diff("down-after-milliseconds", ms(expected.DownAfter), ms(actual.DownAfter))
+	}
+	if expected.FailoverTimeout != 0 {
+		diff("failover-timeout", ms(expected.FailoverTimeout), ms(actual.FailoverTimeout))
+	}
+	if _, ok := m.Info["notification-script"]; ok {
+		diff("notification-script", expected.NotificationScript, actual.NotificationScript)
+	}
+	if _, ok := m.Info["client-reconfig-script"]; ok {
+		diff("client-reconfig-script", expected.ClientReconfigScript, actual.ClientReconfigScript)
+	}
+	return drifts
+}
+
+func formatDrift(d *protocol.SentinelDrift) string {
+	var text = fmt.Sprintf("group-[%s] %s", d.Group, d.Kind)
+	if d.Key != "" {
+		text += " " + d.Key
+	}
+	if d.Expected != "" {
+		text += fmt.Sprintf(" expected '%s'", d.Expected)
+	}
+	if d.Actual != "" {
+		text += fmt.Sprintf(" actual '%s'", d.Actual)
+	}
+	return text
+}
+
+// VerifySentinels compares the masters monitored by every sentinel with the
+// groups of the product, nothing is changed.
+func (s *service) VerifySentinels() ([]*protocol.SentinelVerification, error) {
+	s.mutex.Lock()
+	expected, err := s.expectedSentinels()
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := s.config().SentinelClientTimeout.Duration()
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+
+	results := make([]*protocol.SentinelVerification, len(expected.servers))
+	var wg sync.WaitGroup
+	for i, addr := range expected.servers {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			v := &protocol.SentinelVerification{Sentinel: addr, Drifts: []*protocol.SentinelDrift{}}
+			if monitored, err := sentinelClient.MonitoredMasters(addr, timeout); err != nil {
+				log.Warnln("service::VerifySentinels verify sentinel", addr, "failed. err:", err)
+				v.Error = err.Error()
+			} else {
+				v.Drifts = expected.drifts(addr, monitored)
+			}
+			results[i] = v
+		}(i, addr)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// RepairSentinels repairs the drifted entries of every sentinel and waits.
+func (s *service) RepairSentinels() error {
+	r, err := s.submitRepairSentinels()
+	if err != nil {
+		return err
+	}
+	return r.wait()
+}
+
+func (s *service) SubmitRepairSentinels() (*dao.Job, error) {
+	r, err := s.submitRepairSentinels()
+	if err != nil {
+		return nil, err
+	}
+	return s.Job(r.job.ID)
+}
+
+func (s *service) submitRepairSentinels() (*jobRunner, error) {
+	s.ha.mutex.Lock()
+	defer s.ha.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expected, err := s.expectedSentinels()
+	if err != nil {
+		return nil, err
+	}
+	timeout := s.config().SentinelClientTimeout.Duration()
+
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	return s.submitJob(&jobTask{
+		typ:      JobRepairSentinels,
+		targets:  expected.servers,
+		parallel: true,
+		run: func(ctx context.Context, r *jobRunner, addr string) error {
+			stop := context.AfterFunc(ctx, sentinelClient.Cancel)
+			defer stop()
+
+			if err := s.repairSentinel(sentinelClient, r, addr, expected, timeout); err != nil {
+				log.Errorln("service::RepairSentinels repair sentinel", addr, "failed. err:", err)
+				return err
+			}
+			return nil
+		},
+		finish: func(ctx context.Context, r *jobRunner, err error) error {
+			if err != nil {
+				return err
+			}
+
+			s.ha.mutex.Lock()
+			defer s.ha.mutex.Unlock()
+			defer s.dirtyStats()
+
+			return s.updateSentinel(func(current *dao.Sentinel) error {
+				if !reflect.DeepEqual(current.Servers, expected.servers) {
+					return errors.New("sentinels changed while repairing")
+				}
+				current.OutOfSync = false
+				return nil
+			})
+		},
+	})
+}
+
+// repairSentinel repairs the entries of the sentinel which drifted, the
+// others are left alone. A master at another address, or without a script
+// it shouldn't have, is monitored again, the other params are set in place.
+// The missing peers aren't repaired, the sentinels discover each other.
+func (s *service) repairSentinel(p *redis.Sentinel, r *jobRunner, addr string, expected *expectedSentinels, timeout time.Duration) error {
+	monitored, err := p.MonitoredMasters(addr, timeout)
+	if err != nil {
+		return err
+	}
+	drifts := expected.drifts(addr, monitored)
+	if len(drifts) == 0 {
+		r.logf("%s in sync", addr)
+		return nil
+	}
+
+	remove := make(map[string]bool)
+	masters := make(map[string]string)
+	configs := make(map[string]*redis.MonitorConfig)
+	reset := make(map[string]bool)
+	for _, d := range drifts {
+		r.logf("%s %s", addr, formatDrift(d))
+		switch d.Kind {
+		case protocol.SentinelDriftUnexpected:
+			remove[d.Group] = true
+		case protocol.SentinelDriftMissing, protocol.SentinelDriftMaster:
+			masters[d.Group] = expected.masters[d.Group]
+		case protocol.SentinelDriftConfig:
+			if d.Expected == "" {
+				masters[d.Group] = expected.masters[d.Group]
+			} else {
+				configs[d.Group] = expected.configs[d.Group]
+			}
+		case protocol.SentinelDriftUnknownPeer:
+			reset[d.Group] = true
+		}
+	}
+	for groupName := range masters {
+		delete(configs, groupName)
+		delete(reset, groupName)
+	}
+
+	if len(remove) != 0 {
+		if err := p.RemoveGroups([]string{addr}, timeout, remove); err != nil {
+			return err
+		}
+	}
+	if len(masters) != 0 {
+		if err := p.MonitorGroups([]string{addr}, timeout, expected.configs, masters); err != nil {
+			return err
+		}
+	}
+	if len(configs) != 0 {
+		if err := p.ConfigureGroups(addr, timeout, configs); err != nil {
+			return err
+		}
+	}
+	if len(reset) != 0 {
+		if err := p.ResetGroups(addr, timeout, sortedKeys(reset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
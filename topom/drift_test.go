@@ -0,0 +1,113 @@
+package topom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/client/redis"
+)
+
+// driftKinds returns the kinds of the drifts of every sentinel, by group.
+func (e *testEnv) driftKinds(t *testing.T) map[string][]string {
+	verifications, err := e.VerifySentinels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string][]string)
+	for _, v := range verifications {
+		if v.Error != "" {
+			t.Fatalf("sentinel %s: %s", v.Sentinel, v.Error)
+		}
+		for _, d := range v.Drifts {
+			kinds[v.Sentinel] = append(kinds[v.Sentinel], d.Group+" "+d.Kind)
+		}
+	}
+	return kinds
+}
+
+func TestRepairSentinels(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 3)
+	defer c.close()
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := e.driftKinds(t); len(kinds) != 0 {
+		t.Fatalf("drifts after resync %v", kinds)
+	}
+
+	name := testProduct + "-g1"
+	s0, s1, s2 := c.sentinels[0], c.sentinels[1], c.sentinels[2]
+	s0.Monitor(testProduct+"-g9", c.servers[1].Addr(), 2)
+	if err := redis.NewSentinel(testProduct, nil).ConfigureGroups(s1.Addr(), time.Second, map[string]*redis.MonitorConfig{
+		"g1": {Quorum: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s2.Monitor(name, c.servers[1].Addr(), 2)
+
+	verifications, err := e.VerifySentinels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]protocol.SentinelDrift{
+		s0.Addr(): {Group: "g9", Kind: protocol.SentinelDriftUnexpected, Actual: c.servers[1].Addr()},
+		s1.Addr(): {Group: "g1", Kind: protocol.SentinelDriftConfig, Key: "quorum", Expected: "2", Actual: "1"},
+		s2.Addr(): {Group: "g1", Kind: protocol.SentinelDriftMaster, Expected: c.servers[0].Addr(), Actual: c.servers[1].Addr()},
+	}
+	for _, v := range verifications {
+		if len(v.Drifts) != 1 || *v.Drifts[0] != want[v.Sentinel] {
+			t.Fatalf("sentinel %s drifts %+v", v.Sentinel, v.Drifts)
+		}
+	}
+
+	// only the drifted entries are repaired.
+	for _, s := range c.sentinels {
+		s.ResetCalls()
+	}
+	if err := e.RepairSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := e.driftKinds(t); len(kinds) != 0 {
+		t.Fatalf("drifts after repair %v", kinds)
+	}
+	if s0.CountCalls("SENTINEL MONITOR") != 0 || s0.CountCalls("SENTINEL REMOVE") != 1 {
+		t.Fatalf("sentinel %s calls %v", s0.Addr(), s0.Calls())
+	}
+	if s1.CountCalls("SENTINEL MONITOR") != 0 || s1.CountCalls("SENTINEL SET") != 1 {
+		t.Fatalf("sentinel %s calls %v", s1.Addr(), s1.Calls())
+	}
+	if s2.Monitored()[name] != c.servers[0].Addr() || s2.CountCalls("SENTINEL MONITOR") != 1 {
+		t.Fatalf("sentinel %s calls %v", s2.Addr(), s2.Calls())
+	}
+	if s := e.storedSentinel(t); s.OutOfSync {
+		t.Fatal("sentinel out of sync after repair")
+	}
+
+	// the peers remember a removed sentinel until they're reset.
+	if err := e.DelSentinel(s2.Addr(), false); err != nil {
+		t.Fatal(err)
+	}
+	kinds := e.driftKinds(t)
+	if len(kinds) != 2 || kinds[s0.Addr()][0] != "g1 "+protocol.SentinelDriftUnknownPeer {
+		t.Fatalf("drifts after delete %v", kinds)
+	}
+	if err := e.RepairSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := e.driftKinds(t); len(kinds) != 0 {
+		t.Fatalf("drifts after repair %v", kinds)
+	}
+
+	s1.Close()
+	verifications, err = e.VerifySentinels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 2 || verifications[1].Error == "" {
+		t.Fatalf("unexpected verifications %+v", verifications)
+	}
+}
@@ -0,0 +1,266 @@
+package topom
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+// MaxEvents is the number of events kept for the streams which resume after
+// a reconnect, a stream which resumes from an older event starts over with a
+// snapshot.
+var MaxEvents = 1024
+
+// EventBufferSize is the buffer of a subscription, a subscriber which falls
+// further behind is dropped and resumes on reconnect.
+var EventBufferSize = 256
+
+type eventSub struct {
+	ch chan *protocol.Event
+}
+
+// eventHub publishes the changes of the stats refreshed by doStats. It keeps
+// the encoded entities of the last stats and publishes an event for every
+// entity which changed, the last stats are the snapshot of a new stream.
+type eventHub struct {
+	mutex  sync.Mutex
+	seq    int64
+	buffer []*protocol.Event
+	subs   map[*eventSub]struct{}
+	closed bool
+
+	last     *protocol.Stats
+	entities map[string]map[string][]byte
+	masters  map[string]string
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		// the ids of a restarted dashboard don't overlap, a stream resuming
+		// from the previous one gets a snapshot.
+		seq:      time.Now().UnixNano(),
+		subs:     make(map[*eventSub]struct{}),
+		entities: make(map[string]map[string][]byte),
+		masters:  make(map[string]string),
+	}
+}
+
+// subscribe returns the events after lastID followed by a channel of the next
+// ones, a snapshot replaces the events if they're no longer kept. The channel
+// is closed once the subscriber is dropped or the hub closed.
+func (h *eventHub) subscribe(lastID int64) ([]*protocol.Event, <-chan *protocol.Event, func(), error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil, nil, nil, ErrClosedTopom
+	}
+
+	var events []*protocol.Event
+	switch {
+	case lastID == h.seq:
+	case lastID > 0 && len(h.buffer) != 0 && lastID >= h.buffer[0].ID-1 && lastID < h.seq:
+		for _, e := range h.buffer {
+			if e.ID > lastID {
+				events = append(events, e)
+			}
+		}
+	case h.last != nil:
+		events = append(events, h.snapshot())
+	}
+	// before the first stats, the snapshot is sent once they're published.
+
+	sub := &eventSub{ch: make(chan *protocol.Event, EventBufferSize)}
+	h.subs[sub] = struct{}{}
+	cancel := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if _, ok := h.subs[sub]; ok {
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+	return events, sub.ch, cancel, nil
+}
+
+func (h *eventHub) snapshot() *protocol.Event {
+	stats := *h.last
+	stats.HA.Masters = make(map[string]string, len(h.masters))
+	for name, addr := range h.masters {
+		stats.HA.Masters[name] = addr
+	}
+	return &protocol.Event{
+		ID:       h.seq,
+		Type:     protocol.EventSnapshot,
+		UnixTime: time.Now().Unix(),
+		Snapshot: &stats,
+	}
+}
+
+// publish must be called with the mutex held.
+func (h *eventHub) publish(e *protocol.Event) {
+	h.seq++
+	e.ID, e.UnixTime = h.seq, time.Now().Unix()
+	h.buffer = append(h.buffer, e)
+	if n := len(h.buffer) - MaxEvents; n > 0 {
+		h.buffer = append(h.buffer[:0:0], h.buffer[n:]...)
+	}
+	h.send(e)
+}
+
+func (h *eventHub) send(e *protocol.Event) {
+	for sub := range h.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// publishStats publishes the entities of the stats which changed since the
+// last ones. The stats must not be modified afterwards.
+func (h *eventHub) publishStats(stats *protocol.Stats) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return
+	}
+
+	first := h.last == nil
+	h.last = stats
+
+	groups := make(map[string]interface{})
+	for _, g := range stats.Group.Models {
+		groups[g.Name] = g
+	}
+	gslbs := make(map[string]interface{})
+	for name, g := range stats.GSLB.Models {
+		gslbs[name] = g
+	}
+	servers := make(map[string]interface{})
+	for addr, st := range stats.Group.Stats {
+		servers[addr] = st
+	}
+	sentinels := make(map[string]interface{})
+	for addr, st := range stats.HA.Stats {
+		sentinels[addr] = st
+	}
+	gslbServers := make(map[string]interface{})
+	for addr, st := range stats.GSLB.Stats {
+		gslbServers[addr] = st
+	}
+
+	var events []*protocol.Event
+	events = h.diff(events, protocol.EventGroup, groups, func(e *protocol.Event, v interface{}) {
+		e.Group, _ = v.(*protocol.Group)
+	})
+	events = h.diff(events, protocol.EventSentinel, map[string]interface{}{"": stats.HA.Model}, func(e *protocol.Event, v interface{}) {
+		e.Sentinel, _ = v.(*protocol.Sentinel)
+	})
+	events = h.diff(events, protocol.EventGSLB, gslbs, func(e *protocol.Event, v interface{}) {
+		e.GSLB, _ = v.(*protocol.GSLB)
+	})
+	events = h.diff(events, protocol.EventServerStats, servers, func(e *protocol.Event, v interface{}) {
+		e.Server, _ = v.(*protocol.RedisStats)
+	})
+	events = h.diff(events, protocol.EventSentinelStats, sentinels, func(e *protocol.Event, v interface{}) {
+		e.Server, _ = v.(*protocol.RedisStats)
+	})
+	events = h.diff(events, protocol.EventGSLBStats, gslbServers, func(e *protocol.Event, v interface{}) {
+		e.GSLBServer, _ = v.(*protocol.GSLBStats)
+	})
+	names := make([]string, 0, len(stats.HA.Masters))
+	for name := range stats.HA.Masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if addr := stats.HA.Masters[name]; h.masters[name] != addr {
+			h.masters[name] = addr
+			events = append(events, &protocol.Event{Type: protocol.EventSwitchMaster, Name: name, Master: addr})
+		}
+	}
+
+	if first {
+		// the subscribers which came before the first stats get them as the
+		// snapshot.
+		h.send(h.snapshot())
+		return
+	}
+	for _, e := range events {
+		h.publish(e)
+	}
+}
+
+// diff appends an event for every entity of typ which changed, set fills the
+// entity in, a removed one is nil.
+func (h *eventHub) diff(events []*protocol.Event, typ string, entities map[string]interface{}, set func(e *protocol.Event, v interface{})) []*protocol.Event {
+	last := h.entities[typ]
+	names := make([]string, 0, len(entities))
+	for name := range entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoded := make(map[string][]byte, len(entities))
+	for _, name := range names {
+		v := entities[name]
+		data, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		encoded[name] = data
+		if old, ok := last[name]; !ok || string(old) != string(data) {
+			e := &protocol.Event{Type: typ, Name: name}
+			set(e, v)
+			events = append(events, e)
+		}
+	}
+	names = names[:0]
+	for name := range last {
+		if _, ok := encoded[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := &protocol.Event{Type: typ, Name: name}
+		set(e, nil)
+		events = append(events, e)
+	}
+	h.entities[typ] = encoded
+	return events
+}
+
+// switchMaster publishes a master switched by the sentinels.
+func (h *eventHub) switchMaster(group, master string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed || h.masters[group] == master {
+		return
+	}
+	h.masters[group] = master
+	if h.last != nil {
+		h.publish(&protocol.Event{Type: protocol.EventSwitchMaster, Name: group, Master: master})
+	}
+}
+
+func (h *eventHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// SubscribeEvents subscribes the changes of the product, see eventHub. The
+// changes are published once per round of the stats.
+func (s *service) SubscribeEvents(lastID int64) ([]*protocol.Event, <-chan *protocol.Event, func(), error) {
+	return s.events.subscribe(lastID)
+}
@@ -0,0 +1,127 @@
+package topom
+
+import (
+	"testing"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+func newEventStats(groups ...string) *protocol.Stats {
+	stats := &protocol.Stats{}
+	for _, name := range groups {
+		stats.Group.Models = append(stats.Group.Models, &protocol.Group{Name: name})
+	}
+	stats.Group.Stats = map[string]*protocol.RedisStats{}
+	stats.HA.Model = &protocol.Sentinel{}
+	stats.HA.Masters = map[string]string{}
+	return stats
+}
+
+func recvEvents(t *testing.T, ch <-chan *protocol.Event, n int) []*protocol.Event {
+	t.Helper()
+	var events []*protocol.Event
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		default:
+			t.Fatalf("got %d events, want %d", len(events), n)
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+	return events
+}
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub()
+
+	// a subscriber before the first stats gets them as the snapshot.
+	events, ch, cancel, err := h.subscribe(0)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("subscribe = %v, %v", events, err)
+	}
+	defer cancel()
+	h.publishStats(newEventStats("g1"))
+	snapshot := recvEvents(t, ch, 1)[0]
+	if snapshot.Type != protocol.EventSnapshot || len(snapshot.Snapshot.Group.Models) != 1 {
+		t.Fatalf("snapshot = %+v", snapshot)
+	}
+
+	// then the changed entities.
+	stats := newEventStats("g2")
+	stats.Group.Stats["127.0.0.1:9221"] = &protocol.RedisStats{Stats: map[string]string{"role": "master"}}
+	h.publishStats(stats)
+	events = recvEvents(t, ch, 3)
+	for i, want := range []struct{ typ, name string }{
+		{protocol.EventGroup, "g2"},
+		{protocol.EventGroup, "g1"},
+		{protocol.EventServerStats, "127.0.0.1:9221"},
+	} {
+		if e := events[i]; e.Type != want.typ || e.Name != want.name || e.ID != snapshot.ID+int64(i)+1 {
+			t.Fatalf("event %d = %+v, want %s %s", i, e, want.typ, want.name)
+		}
+	}
+	if events[0].Group == nil || events[1].Group != nil {
+		t.Fatalf("group events = %+v %+v", events[0], events[1])
+	}
+
+	// nothing changed, nothing published.
+	h.publishStats(stats)
+	recvEvents(t, ch, 0)
+
+	h.switchMaster("g2", "127.0.0.1:9221")
+	h.switchMaster("g2", "127.0.0.1:9221")
+	if e := recvEvents(t, ch, 1)[0]; e.Type != protocol.EventSwitchMaster || e.Master != "127.0.0.1:9221" {
+		t.Fatalf("switch master = %+v", e)
+	}
+
+	// a stream resumes after the last event it got.
+	resumed, _, cancel2, err := h.subscribe(events[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel2()
+	if len(resumed) != 2 || resumed[0].ID != events[2].ID || resumed[1].Type != protocol.EventSwitchMaster {
+		t.Fatalf("resumed = %+v", resumed)
+	}
+
+	// or starts over if the event isn't kept.
+	resumed, _, cancel3, err := h.subscribe(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel3()
+	if len(resumed) != 1 || resumed[0].Type != protocol.EventSnapshot ||
+		len(resumed[0].Snapshot.Group.Models) != 1 || resumed[0].Snapshot.HA.Masters["g2"] != "127.0.0.1:9221" {
+		t.Fatalf("resumed from an unknown id = %+v", resumed)
+	}
+}
+
+func TestEventHubDropSlowSubscriber(t *testing.T) {
+	defer func(n int) { EventBufferSize = n }(EventBufferSize)
+	EventBufferSize = 1
+
+	h := newEventHub()
+	h.publishStats(newEventStats())
+	_, ch, cancel, err := h.subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	h.publishStats(newEventStats("g1"))
+	h.publishStats(newEventStats("g1", "g2"))
+	<-ch
+	if _, ok := <-ch; ok {
+		t.Fatal("slow subscriber isn't dropped")
+	}
+
+	h.close()
+	if _, _, _, err := h.subscribe(0); err != ErrClosedTopom {
+		t.Fatalf("subscribe to a closed hub = %v", err)
+	}
+}
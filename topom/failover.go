@@ -0,0 +1,143 @@
+package topom
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// failoverWaiters are the failovers triggered by the dashboard, by group,
+// waiting for their end among the events of the subscription to the
+// sentinels.
+type failoverWaiters struct {
+	mutex   sync.Mutex
+	waiters map[string]chan *redis.SentinelEvent
+}
+
+// add registers the failover of the group, only one failover of a group is
+// tracked at once. done unregisters it.
+func (w *failoverWaiters) add(groupName string) (events <-chan *redis.SentinelEvent, done func(), err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.waiters == nil {
+		w.waiters = make(map[string]chan *redis.SentinelEvent)
+	}
+	if w.waiters[groupName] != nil {
+		return nil, nil, fmt.Errorf("group-[%s] failover already in progress", groupName)
+	}
+	ch := make(chan *redis.SentinelEvent, 16)
+	w.waiters[groupName] = ch
+	return ch, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		delete(w.waiters, groupName)
+	}, nil
+}
+
+// notify passes the end of a failover, or its abort, to its waiter.
+func (w *failoverWaiters) notify(e *redis.SentinelEvent) {
+	if e.Type != "+switch-master" && !strings.HasPrefix(e.Type, "-failover-abort-") {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	select {
+	case w.waiters[e.Group] <- e:
+	default:
+	}
+}
+
+// FailoverGroup fails the master of the group over through the first sentinel
+// which reaches the quorum, and waits for +switch-master up to the failover
+// timeout of the group. The group is switched to the new master by the
+// subscription, see reWatchSentinels.
+func (s *service) FailoverGroup(groupName string) (*protocol.Failover, error) {
+	s.mutex.Lock()
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	s.mutex.Unlock()
+
+	g, ok := groups[groupName]
+	if !ok {
+		return nil, fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
+	}
+	if len(g.Servers) < 2 {
+		return nil, fmt.Errorf("group-[%s] has no slave to promote", groupName)
+	}
+	if len(sentinel.Servers) == 0 {
+		return nil, errors.New("no sentinel to fail over through")
+	}
+	s.ha.mutex.Lock()
+	watched := s.ha.monitor != nil
+	s.ha.mutex.Unlock()
+	if !watched {
+		return nil, errors.New("the sentinels aren't watched")
+	}
+
+	timeout := s.config().SentinelClientTimeout.Duration()
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+
+	var addr string
+	for _, v := range sentinel.Servers {
+		if err = sentinelClient.CheckQuorum(v, timeout, groupName); err != nil {
+			log.Warnf("service::FailoverGroup group-[%s] sentinel-[%s] ckquorum failed. err:%s", groupName, v, err)
+			continue
+		}
+		addr = v
+		break
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("group-[%s] no sentinel reaches the quorum. err-[%s]", groupName, err.Error())
+	}
+
+	events, done, err := s.failovers.add(groupName)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	log.Warnf("group-[%s] will fail over through sentinel-[%s]", groupName, addr)
+	start := time.Now()
+	if err := sentinelClient.Failover(addr, timeout, groupName); err != nil {
+		return nil, fmt.Errorf("group-[%s] failover through sentinel-[%s] failed. err-[%s]", groupName, addr, err.Error())
+	}
+
+	failoverTimeout := s.monitorConfig(g).FailoverTimeout
+	deadline := time.NewTimer(failoverTimeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case e := <-events:
+			if e.Type == "+switch-master" {
+				return &protocol.Failover{
+					Group:     groupName,
+					Sentinel:  addr,
+					OldMaster: e.Addr,
+					NewMaster: e.Master,
+					Elapsed:   int64(time.Since(start) / time.Millisecond),
+				}, nil
+			}
+			if e.Sentinel == addr {
+				return nil, fmt.Errorf("group-[%s] failover aborted by sentinel-[%s], %s", groupName, addr, e.Type)
+			}
+		case <-deadline.C:
+			return nil, fmt.Errorf("group-[%s] failover not completed in %s", groupName, failoverTimeout)
+		case <-s.done:
+			return nil, ErrClosedTopom
+		}
+	}
+}
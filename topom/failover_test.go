@@ -0,0 +1,65 @@
+package topom
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailoverGroup(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 3)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if _, err := e.FailoverGroup("g1"); err == nil {
+		t.Fatal("failover while the sentinels aren't watched")
+	}
+
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "sentinel subscriptions", func() bool {
+		for _, s := range c.sentinels {
+			if s.Subscribers("+switch-master") == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	// the first sentinel can't reach the quorum on its own.
+	name := testProduct + "-g1"
+	c.sentinels[0].Monitor(name, c.servers[0].Addr(), 4)
+
+	master, slave := c.servers[0], c.servers[1]
+	failover, err := e.FailoverGroup("g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failover.Group != "g1" || failover.Sentinel != c.sentinels[1].Addr() ||
+		failover.OldMaster != master.Addr() || failover.NewMaster != slave.Addr() {
+		t.Fatalf("unexpected failover %+v", failover)
+	}
+	if c.sentinels[0].CountCalls("SENTINEL FAILOVER") != 0 || c.sentinels[1].CountCalls("SENTINEL FAILOVER") != 1 {
+		t.Fatal("failover not issued through the sentinel which reached the quorum")
+	}
+	// the masters are fetched twice, 5 seconds apart, once the subscription
+	// returned, the switch may wait for the fetches of the resync.
+	waitFor(t, 15*time.Second, "the group switched", func() bool {
+		return e.storedGroup(t, "g1").GetMaster() == slave.Addr()
+	})
+
+	if _, err := e.FailoverGroup("g2"); err == nil {
+		t.Fatal("failover of a missing group")
+	}
+	for _, s := range c.sentinels {
+		s.Close()
+	}
+	if _, err := e.FailoverGroup("g1"); err == nil {
+		t.Fatal("failover without a sentinel")
+	}
+}
@@ -1,6 +1,7 @@
 package topom
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -10,8 +11,6 @@ import (
 	"github.com/pourer/pikamgr/topom/dao"
 	swerror "github.com/pourer/pikamgr/utils/error"
 	"github.com/pourer/pikamgr/utils/log"
-
-	"github.com/CodisLabs/codis/pkg/utils/sync2"
 )
 
 func (s *service) CreateGroup(groupName string, rPort, wPort int) error {
@@ -22,8 +21,10 @@ func (s *service) CreateGroup(groupName string, rPort, wPort int) error {
 		return errors.New("Proxy-Read-Port and Proxy-Write-Port must be not equal")
 	}
 
+	defer s.lockGroup(groupName)()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
@@ -31,7 +32,7 @@ func (s *service) CreateGroup(groupName string, rPort, wPort int) error {
 	}
 
 	if groups[groupName] != nil {
-		return fmt.Errorf("group-[%s] already exists", groupName)
+		return fmt.Errorf("group-[%s] %w", groupName, ErrExists)
 	}
 
 	for _, g := range groups {
@@ -52,8 +53,10 @@ func (s *service) CreateGroup(groupName string, rPort, wPort int) error {
 }
 
 func (s *service) RemoveGroup(groupName string) error {
+	defer s.lockGroup(groupName)()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
@@ -62,7 +65,7 @@ func (s *service) RemoveGroup(groupName string) error {
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 	if len(g.Servers) != 0 {
 		return fmt.Errorf("group-[%s] isn't empty", groupName)
@@ -72,61 +75,84 @@ func (s *service) RemoveGroup(groupName string) error {
 }
 
 func (s *service) ResyncGroup(groupName string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	return s.resyncGroupByName(context.Background(), groupName)
+}
 
-	groups, err := s.groupMapper.Info()
+// ResyncGroupAll resyncs every group and waits, the error reports every
+// group which failed.
+func (s *service) ResyncGroupAll() error {
+	r, err := s.submitResyncGroupAll()
 	if err != nil {
 		return err
 	}
+	return r.wait()
+}
 
-	g, ok := groups[groupName]
-	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+func (s *service) SubmitResyncGroupAll() (*dao.Job, error) {
+	r, err := s.submitResyncGroupAll()
+	if err != nil {
+		return nil, err
 	}
+	return s.Job(r.job.ID)
+}
 
-	g.OutOfSync = false
-	if err := s.groupMapper.Update(g); err != nil {
-		return err
-	}
-	if err := s.resyncGroup(g); err != nil {
-		return err
+func (s *service) submitResyncGroupAll() (*jobRunner, error) {
+	s.mutex.Lock()
+	groups, err := s.groupMapper.Info()
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var names []string
+	for _, g := range sortGroups(groups) {
+		names = append(names, g.Name)
+	}
+	return s.submitJob(&jobTask{
+		typ:      JobResyncGroupAll,
+		targets:  names,
+		parallel: true,
+		run: func(ctx context.Context, r *jobRunner, groupName string) error {
+			return s.resyncGroupByName(ctx, groupName)
+		},
+	})
 }
 
-func (s *service) ResyncGroupAll() error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// resyncGroupByName resyncs the group, only the group is locked while the
+// servers are synced.
+func (s *service) resyncGroupByName(ctx context.Context, groupName string) error {
+	defer s.lockGroup(groupName)()
+	defer s.dirtyStats()
 
+	s.mutex.Lock()
 	groups, err := s.groupMapper.Info()
 	if err != nil {
+		s.mutex.Unlock()
 		return err
 	}
-
-	var fut sync2.Future
-	for _, g := range groups {
-		fut.Add()
-		go func(g *dao.Group) {
-			g.OutOfSync = false
-			if err := s.groupMapper.Update(g); err != nil {
-				fut.Done(g.Name, fmt.Errorf("resync group-[%s] failed.err:%s", g.Name, err.Error()))
-			} else {
-				if err := s.resyncGroup(g); err != nil {
-					fut.Done(g.Name, err)
-				}
-			}
-		}(g)
+	g, ok := groups[groupName]
+	if !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
+	}
+	g.OutOfSync = false
+	err = s.groupMapper.Update(g)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
 	}
 
-	for _, v := range fut.Wait() {
-		switch err := v.(type) {
-		case error:
-			if err != nil {
-				return err
+	if err := s.syncGroupServers(ctx, g); err != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if groups, e := s.groupMapper.Info(); e == nil && groups[groupName] != nil {
+			g = groups[groupName]
+			g.OutOfSync = true
+			if e := s.groupMapper.Update(g); e != nil {
+				log.Errorln("service::resyncGroupByName update fail. err:", e)
 			}
 		}
+		return err
 	}
 	return nil
 }
@@ -136,8 +162,10 @@ func (s *service) AddGroupServer(groupName, addr string) error {
 		return errors.New("invalid server address")
 	}
 
+	defer s.lockGroup(groupName)()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
@@ -147,30 +175,33 @@ func (s *service) AddGroupServer(groupName, addr string) error {
 	for _, g := range groups {
 		for _, v := range g.Servers {
 			if v.Addr == addr {
-				return fmt.Errorf("server-[%s] already exists", addr)
+				return fmt.Errorf("server-[%s] %w", addr, ErrExists)
 			}
 		}
 	}
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 
-	if err := s.outOfSyncBySentinel(); err != nil {
-		return err
-	}
+	txn := dao.NewTxn()
+	s.outOfSyncBySentinel(txn)
 
 	g.Servers = append(g.Servers, &dao.GroupServer{Addr: addr})
-	if err := s.groupMapper.Update(g); err != nil {
+	txn.UpdateGroup(g)
+	refreshErr := s.refreshGSLBBackendInfo(txn)
+	if err := s.txnMapper.Commit(txn); err != nil {
 		return err
 	}
-	return s.refreshGSLBBackendInfo()
+	return refreshErr
 }
 
 func (s *service) DelGroupServer(groupName, addr string) error {
+	defer s.lockGroup(groupName)()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
@@ -179,7 +210,7 @@ func (s *service) DelGroupServer(groupName, addr string) error {
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 
 	var index = g.GetServerIndex(addr)
@@ -190,9 +221,8 @@ func (s *service) DelGroupServer(groupName, addr string) error {
 		return fmt.Errorf("group-[%s] can't remove master, still in use", groupName)
 	}
 
-	if err := s.outOfSyncBySentinel(); err != nil {
-		return err
-	}
+	txn := dao.NewTxn()
+	s.outOfSyncBySentinel(txn)
 
 	if index != 0 {
 		g.OutOfSync = true
@@ -202,43 +232,143 @@ func (s *service) DelGroupServer(groupName, addr string) error {
 		g.OutOfSync = false
 	}
 
-	if err := s.groupMapper.Update(g); err != nil {
+	txn.UpdateGroup(g)
+	refreshErr := s.refreshGSLBBackendInfo(txn)
+	if err := s.txnMapper.Commit(txn); err != nil {
+		return err
+	}
+	return refreshErr
+}
+
+// SetGroupSentinelParams sets the sentinel params of the group, empty params
+// clear them. The sentinels are marked out of sync, the params take effect
+// once they're resynced.
+func (s *service) SetGroupSentinelParams(groupName string, params *dao.SentinelParams) error {
+	if params != nil {
+		if err := params.Validate(); err != nil {
+			return err
+		}
+	}
+
+	defer s.lockGroup(groupName)()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.dirtyStats()
+
+	groups, err := s.groupMapper.Info()
+	if err != nil {
 		return err
 	}
-	return s.refreshGSLBBackendInfo()
+
+	g, ok := groups[groupName]
+	if !ok {
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
+	}
+
+	txn := dao.NewTxn()
+	s.outOfSyncBySentinel(txn)
+
+	g = g.Clone()
+	g.SentinelParams = nil
+	if !params.Empty() {
+		p := *params
+		g.SentinelParams = &p
+	}
+	txn.UpdateGroup(g)
+	return s.txnMapper.Commit(txn)
 }
 
 func (s *service) GroupPromoteServer(groupName, addr string) error {
+	defer s.lockGroup(groupName)()
+	defer s.dirtyStats()
+
+	s.mutex.Lock()
+	g, swapped, err := s.swapGroupMaster(groupName, addr)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// g is the group stored by the swap, it's changed on a copy.
+	g = g.Clone()
+	if swapped {
+		s.unmonitorGroup(g.Name)
+
+		g.OutOfSync = false
+		if err := s.syncGroupServers(context.Background(), g); err != nil {
+			log.Errorln("service::GroupPromoteServer doSyncAction failed. err:", err)
+			g.OutOfSync = true
+		}
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	g.Promoting.Index, g.Promoting.State = 0, dao.ActionNothing
+	return s.groupMapper.Update(g)
+}
+
+// unmonitorGroup removes the group whose master was swapped from the
+// sentinels, under s.ha.mutex a resync of the sentinels can't monitor the
+// old master in between. The sentinels are watched again, the masters fetched
+// before the swap are dropped, see trySwitchGroupMaster.
+func (s *service) unmonitorGroup(groupName string) {
+	s.ha.mutex.Lock()
+	defer s.ha.mutex.Unlock()
 
+	// the servers of the sentinel change under s.ha.mutex only.
 	sentinel, err := s.sentinelMapper.Info()
 	if err != nil {
-		return err
+		log.Warnln("service::GroupPromoteServer get sentinel failed. groupName:", groupName, "err:", err)
+		return
+	}
+	if len(sentinel.Servers) == 0 {
+		return
+	}
+
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	if err := sentinelClient.RemoveGroups(sentinel.Servers, s.config().SentinelClientTimeout.Duration(), map[string]bool{groupName: true}); err != nil {
+		log.Warnln("service::GroupPromoteServer sentinel RemoveGroups failed. sentinel-addrs:", sentinel.Servers, "groupName:", groupName, "err:", err)
+	}
+	s.stats.mutex.Lock()
+	delete(s.ha.masters, groupName)
+	s.stats.mutex.Unlock()
+
+	if s.ha.monitor != nil {
+		s.reWatchSentinels(sentinel.Servers)
+	}
+}
+
+// swapGroupMaster steps the promotion of the group up to the swap of the
+// master, swapped tells if the swap is done by this call rather than an
+// earlier one. It must be called with s.mutex held.
+func (s *service) swapGroupMaster(groupName, addr string) (*dao.Group, bool, error) {
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return nil, false, err
 	}
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return nil, false, fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 
 	var index = g.GetServerIndex(addr)
 	if index == -1 {
-		return fmt.Errorf("group-[%s] doesn't have server-[%s]", groupName, addr)
+		return nil, false, fmt.Errorf("group-[%s] doesn't have server-[%s]", groupName, addr)
 	}
 
 	if g.Promoting.State != dao.ActionNothing {
 		if index != g.Promoting.Index {
-			return fmt.Errorf("group-[%s] is promoting index = %d", g.Name, g.Promoting.Index)
+			return nil, false, fmt.Errorf("group-[%s] is promoting index = %d", g.Name, g.Promoting.Index)
 		}
 	} else {
 		if index == 0 {
-			return fmt.Errorf("group-[%s] can't promote master", g.Name)
+			return nil, false, fmt.Errorf("group-[%s] can't promote master", g.Name)
 		}
 	}
 
@@ -248,7 +378,7 @@ func (s *service) GroupPromoteServer(groupName, addr string) error {
 			g.Promoting.Index = index
 			g.Promoting.State = dao.ActionPreparing
 			if err := s.groupMapper.Update(g); err != nil {
-				return err
+				return nil, false, err
 			}
 		}
 		fallthrough
@@ -256,90 +386,102 @@ func (s *service) GroupPromoteServer(groupName, addr string) error {
 		{
 			g.Promoting.State = dao.ActionPrepared
 			if err := s.groupMapper.Update(g); err != nil {
-				return err
+				return nil, false, err
 			}
 		}
 		fallthrough
 	case dao.ActionPrepared:
 		{
+			// the sentinels are marked out of sync together with the swap,
+			// a failure in between can't leave one without the other.
+			txn := dao.NewTxn()
 			if len(sentinel.Servers) > 0 {
 				sentinel.OutOfSync = true
-				if err := s.sentinelMapper.Update(sentinel); err != nil {
-					return err
-				}
-
-				sentinelClient := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-				if err := sentinelClient.RemoveGroups(sentinel.Servers, s.config.SentinelClientTimeout.Duration(), map[string]bool{g.Name: true}); err != nil {
-					log.Warnln("service::GroupPromoteServer sentinel RemoveGroups failed. sentinel-addrs:", sentinel.Servers, "groupName:", g.Name, "err:", err)
-				}
-				if s.ha.masters != nil {
-					delete(s.ha.masters, g.Name)
-				}
+				txn.UpdateSentinel(sentinel)
 			}
-
 			g.Servers[0], g.Servers[g.Promoting.Index] = g.Servers[g.Promoting.Index], g.Servers[0]
 			g.Promoting.Index = 0
 			g.Promoting.State = dao.ActionFinished
-			if err := s.groupMapper.Update(g); err != nil {
-				return err
-			}
-
-			if err := s.resyncGroup(g); err != nil {
-				log.Errorln("service::GroupPromoteServer doSyncAction failed. err:", err)
+			txn.UpdateGroup(g)
+			if err := s.txnMapper.Commit(txn); err != nil {
+				return nil, false, err
 			}
+			return g, true, nil
 		}
-		fallthrough
 	case dao.ActionFinished:
-		{
-			g = &dao.Group{
-				Name:           g.Name,
-				Servers:        g.Servers,
-				ProxyReadPort:  g.ProxyReadPort,
-				ProxyWritePort: g.ProxyWritePort,
-				CreateTime:     g.CreateTime,
-			}
-			return s.groupMapper.Update(g)
-		}
+		return g, false, nil
 	default:
-		return fmt.Errorf("group-[%s] action state is invalid", g.Name)
+		return nil, false, fmt.Errorf("group-[%s] action state is invalid", g.Name)
 	}
 }
 
 func (s *service) GroupForceFullSyncServer(groupName, addr string) error {
+	r, err := s.submitGroupForceFullSyncServer(groupName, addr)
+	if err != nil {
+		return err
+	}
+	return r.wait()
+}
+
+func (s *service) SubmitGroupForceFullSyncServer(groupName, addr string) (*dao.Job, error) {
+	r, err := s.submitGroupForceFullSyncServer(groupName, addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Job(r.job.ID)
+}
+
+func (s *service) submitGroupForceFullSyncServer(groupName, addr string) (*jobRunner, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return nil, fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 
 	var index = g.GetServerIndex(addr)
 	if index == -1 {
-		return fmt.Errorf("group-[%s] doesn't have server-[%s]", groupName, addr)
+		return nil, fmt.Errorf("group-[%s] doesn't have server-[%s]", groupName, addr)
 	} else if index == 0 {
-		return fmt.Errorf("group-[%s] master server-[%s] not allowed this operation", groupName, addr)
+		return nil, fmt.Errorf("group-[%s] master server-[%s] not allowed this operation", groupName, addr)
 	}
 
 	if g.Promoting.State != dao.ActionNothing {
-		return fmt.Errorf("group-[%s] is promoting", g.Name)
-	}
-
-	return s.doForceFullSyncAction(g.Servers[index].Addr, g.Servers[0].Addr)
+		return nil, fmt.Errorf("group-[%s] is promoting", g.Name)
+	}
+
+	master := g.Servers[0].Addr
+	return s.submitJob(&jobTask{
+		typ:     JobForceFullSync,
+		targets: []string{g.Servers[index].Addr},
+		run: func(ctx context.Context, r *jobRunner, addr string) error {
+			defer s.lockGroup(groupName)()
+			r.logf("%s full sync from %s", addr, master)
+			return s.doForceFullSyncAction(addr, master)
+		},
+	})
 }
 
-func (s *service) resyncGroup(g *dao.Group) error {
+// syncGroupServers points every server of the group to its master, it stops
+// once ctx is cancelled.
+func (s *service) syncGroupServers(ctx context.Context, g *dao.Group) error {
 	if len(g.Servers) == 0 {
 		return nil
 	}
 
 	var multiErr swerror.MultiError
 	for index, server := range g.Servers {
+		if err := ctx.Err(); err != nil {
+			multiErr.Append(err)
+			break
+		}
+
 		master := g.Servers[0].Addr
 		if index == 0 {
 			master = "NO:ONE"
@@ -349,18 +491,11 @@ func (s *service) resyncGroup(g *dao.Group) error {
 			multiErr.Append(fmt.Errorf("service::resyncGroup doSyncAction failed. groupName:%s addr:%s master:%s error:%s", g.Name, server.Addr, master, err.Error()))
 		}
 	}
-
-	if multiErr.ErrorOrNil() != nil {
-		g.OutOfSync = true
-		if err := s.groupMapper.Update(g); err != nil {
-			multiErr.Append(err)
-		}
-	}
 	return multiErr.ErrorOrNil()
 }
 
 func (s *service) doSyncAction(addr, master string) error {
-	c, err := redis.NewClient(addr, s.config.ProductAuth, 10*time.Second)
+	c, err := redis.NewClientOptions(addr, s.redisOptions(), 10*time.Second)
 	if err != nil {
 		return err
 	}
@@ -373,7 +508,7 @@ func (s *service) doSyncAction(addr, master string) error {
 }
 
 func (s *service) doForceFullSyncAction(addr, master string) error {
-	c, err := redis.NewClient(addr, s.config.ProductAuth, 10*time.Second)
+	c, err := redis.NewClientOptions(addr, s.redisOptions(), 10*time.Second)
 	if err != nil {
 		return err
 	}
@@ -385,23 +520,6 @@ func (s *service) doForceFullSyncAction(addr, master string) error {
 	return nil
 }
 
-func (s *service) ServerInfo(addr string) ([]byte, error) {
-	c, err := redis.NewClient(addr, s.config.ProductAuth, 3*time.Second)
-	if err != nil {
-		return nil, err
-	}
-	defer c.Close()
-
-	if err := c.EnableKeySpace(); err != nil {
-		return nil, err
-	}
-	if text, _, err := c.InfoFull(); err != nil {
-		return nil, err
-	} else {
-		return []byte(text), nil
-	}
-}
-
 func (s *service) Info() (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
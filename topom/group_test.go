@@ -0,0 +1,156 @@
+package topom
+
+import (
+	"testing"
+
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func TestCreateRemoveGroup(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("", 6001, 6002); err == nil {
+		t.Fatal("create group with empty name")
+	}
+	if err := e.CreateGroup("g1", 6001, 6001); err == nil {
+		t.Fatal("create group with same read and write port")
+	}
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CreateGroup("g1", 6003, 6004); err == nil {
+		t.Fatal("create group twice")
+	}
+	if err := e.CreateGroup("g2", 6002, 6003); err == nil {
+		t.Fatal("create group with conflict port")
+	}
+
+	g := e.storedGroup(t, "g1")
+	if g == nil || g.ProxyReadPort != 6001 || g.ProxyWritePort != 6002 {
+		t.Fatalf("unexpected stored group %+v", g)
+	}
+
+	if err := e.AddGroupServer("g1", testServer1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveGroup("g1"); err == nil {
+		t.Fatal("remove non-empty group")
+	}
+	if err := e.DelGroupServer("g1", testServer1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveGroup("g1"); err != nil {
+		t.Fatal(err)
+	}
+	if g := e.storedGroup(t, "g1"); g != nil {
+		t.Fatalf("group still stored %+v", g)
+	}
+	if err := e.RemoveGroup("g1"); err == nil {
+		t.Fatal("remove missing group")
+	}
+}
+
+func TestGroupServers(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.AddGroupServer("g1", testServer1); err == nil {
+		t.Fatal("add server to missing group")
+	}
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CreateGroup("g2", 6003, 6004); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{testServer1, testServer2} {
+		if err := e.AddGroupServer("g1", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.AddGroupServer("g2", testServer1); err == nil {
+		t.Fatal("add server owned by another group")
+	}
+
+	if err := e.DelGroupServer("g1", testServer1); err == nil {
+		t.Fatal("remove master still in use")
+	}
+	if err := e.DelGroupServer("g1", testServer3); err == nil {
+		t.Fatal("remove missing server")
+	}
+	if err := e.DelGroupServer("g1", testServer2); err != nil {
+		t.Fatal(err)
+	}
+
+	g := e.storedGroup(t, "g1")
+	if len(g.Servers) != 1 || g.Servers[0].Addr != testServer1 {
+		t.Fatalf("unexpected servers %+v", g.Servers)
+	}
+	if !g.OutOfSync {
+		t.Fatal("group should be out of sync after removing a slave")
+	}
+}
+
+func TestGroupPromoteServer(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{testServer1, testServer2} {
+		if err := e.AddGroupServer("g1", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params := &dao.SentinelParams{Quorum: 2, ParallelSyncs: 1}
+	if err := e.SetGroupSentinelParams("g1", params); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.GroupPromoteServer("g1", testServer1); err == nil {
+		t.Fatal("promote master")
+	}
+	if err := e.GroupPromoteServer("g1", testServer3); err == nil {
+		t.Fatal("promote missing server")
+	}
+
+	// the servers are unreachable, resync fails but the promotion is
+	// still recorded.
+	if err := e.GroupPromoteServer("g1", testServer2); err != nil {
+		t.Fatal(err)
+	}
+	g := e.storedGroup(t, "g1")
+	if g.GetMaster() != testServer2 || g.Servers[1].Addr != testServer1 {
+		t.Fatalf("unexpected servers after promote %+v", g.Servers)
+	}
+	if g.Promoting.State != dao.ActionNothing {
+		t.Fatalf("promoting state = %q", g.Promoting.State)
+	}
+	if g.SentinelParams == nil || *g.SentinelParams != *params {
+		t.Fatalf("sentinel params after promote %+v", g.SentinelParams)
+	}
+}
+
+func TestResyncGroupUnreachable(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGroupServer("g1", testServer1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ResyncGroup("g1"); err == nil {
+		t.Fatal("resync unreachable group")
+	}
+	if g := e.storedGroup(t, "g1"); !g.OutOfSync {
+		t.Fatal("group should be out of sync after failed resync")
+	}
+	if err := e.ResyncGroup("g2"); err == nil {
+		t.Fatal("resync missing group")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"time"
 
@@ -17,8 +18,11 @@ func (s *service) AddGSLB(gslbName, addr string) error {
 		return errors.New("invalid gslb address")
 	}
 
+	s.gslbs.mutex.Lock()
+	defer s.gslbs.mutex.Unlock()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	gslbs, err := s.gslbMapper.Info()
 	if err != nil {
@@ -32,7 +36,7 @@ func (s *service) AddGSLB(gslbName, addr string) error {
 	}
 	for _, v := range g.Servers {
 		if v == addr {
-			return fmt.Errorf("gslbName-[%s] server-[%s] already exists", gslbName, addr)
+			return fmt.Errorf("gslbName-[%s] server-[%s] %w", gslbName, addr, ErrExists)
 		}
 	}
 
@@ -42,10 +46,13 @@ func (s *service) AddGSLB(gslbName, addr string) error {
 	//}
 
 	g.Servers = append(g.Servers, addr)
-	if err := s.gslbMapper.Update(g); err != nil {
+	txn := dao.NewTxn()
+	txn.UpdateGSLB(g)
+	refreshErr := s.refreshGSLBBackendInfo(txn)
+	if err := s.txnMapper.Commit(txn); err != nil {
 		return err
 	}
-	return s.refreshGSLBBackendInfo()
+	return refreshErr
 }
 
 func (s *service) DelGSLB(gslbName, addr string) error {
@@ -53,8 +60,11 @@ func (s *service) DelGSLB(gslbName, addr string) error {
 		return errors.New("invalid gslb address")
 	}
 
+	s.gslbs.mutex.Lock()
+	defer s.gslbs.mutex.Unlock()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	defer s.dirtyStats()
 
 	gslbs, err := s.gslbMapper.Info()
 	if err != nil {
@@ -63,7 +73,7 @@ func (s *service) DelGSLB(gslbName, addr string) error {
 
 	g, ok := gslbs[gslbName]
 	if !ok {
-		return fmt.Errorf("gslbName-[%s] not found", gslbName)
+		return fmt.Errorf("gslbName-[%s] %w", gslbName, ErrNotFound)
 	}
 
 	index := -1
@@ -74,19 +84,21 @@ func (s *service) DelGSLB(gslbName, addr string) error {
 		}
 	}
 	if index < 0 {
-		return fmt.Errorf("gslbName-[%s] server-[%s] not found", gslbName, addr)
+		return fmt.Errorf("gslbName-[%s] server-[%s] %w", gslbName, addr, ErrNotFound)
 	}
 	g.Servers = append(g.Servers[:index], g.Servers[index+1:]...)
 
-	if err := s.gslbMapper.Update(g); err != nil {
-		return err
-	}
+	txn := dao.NewTxn()
 	if len(g.Servers) == 0 {
-		if err := s.gslbMapper.Delete(g); err != nil {
-			return err
-		}
+		txn.DeleteGSLB(g.Name)
+	} else {
+		txn.UpdateGSLB(g)
 	}
-	return s.refreshGSLBBackendInfo()
+	refreshErr := s.refreshGSLBBackendInfo(txn)
+	if err := s.txnMapper.Commit(txn); err != nil {
+		return err
+	}
+	return refreshErr
 }
 
 func (s *service) GSLBMonitorInfo(addr string) ([]byte, error) {
@@ -98,54 +110,78 @@ func (s *service) GSLBMonitorInfo(addr string) ([]byte, error) {
 	}
 }
 
-func (s *service) refreshGSLBBackendInfo() error {
+// refreshGSLBBackendInfo adds the gslb backends, computed from the groups and
+// gslbs with the pending changes of txn applied, to txn. A gslb whose backends
+// can't be computed is left as it is, the first such error is returned once
+// every gslb is done.
+func (s *service) refreshGSLBBackendInfo(txn *dao.Txn) error {
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return err
+	}
+	txn.ApplyGroups(groups)
+
 	gslbs, err := s.gslbMapper.Info()
 	if err != nil {
 		return err
 	}
+	txn.ApplyGSLBs(gslbs)
 
-	for name, v := range gslbs {
-		backends, monitors, err := s.getGSLBBackends(name)
+	// lvs is backed by haproxy, haproxy goes first.
+	names := make([]string, 0, len(gslbs))
+	for name := range gslbs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "haproxy" || names[j] == "haproxy" {
+			return names[i] == "haproxy"
+		}
+		return names[i] < names[j]
+	})
+
+	var firstErr error
+	for _, name := range names {
+		backends, monitors, err := s.getGSLBBackends(name, groups, gslbs)
 		if err != nil {
 			log.Errorf("service::refreshGSLBBackendInfo getGSLBBackends fail. gslbname-[%s] err-[%s]", name, err)
-			return err
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 		g := &dao.GSLB{
 			Name:     name,
-			Servers:  v.Servers,
+			Servers:  gslbs[name].Servers,
 			Monitors: monitors,
 			Backends: backends,
 		}
 
-		if err := s.gslbMapper.Update(g); err != nil {
-			log.Errorln("service::refreshGSLBBackendInfo update fail. err:", err)
-		}
+		gslbs[name] = g
+		txn.UpdateGSLB(g)
 	}
 
-	return nil
+	return firstErr
 }
 
-func (s *service) getGSLBBackends(gslbName string) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
+func (s *service) getGSLBBackends(gslbName string, groups dao.Groups, gslbs dao.GSLBs) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
 	switch gslbName {
 	case "haproxy":
-		return s.haproxyBackends("")
+		return s.haproxyBackends(groups)
 	case "lvs":
-		return s.lvsBackends("haproxy")
+		return s.lvsBackends(gslbs, "haproxy")
 	default:
 		return nil, nil, fmt.Errorf("unsupported gslb type. gslbName:%s", gslbName)
 	}
 }
 
-func (s *service) haproxyBackends(backendName string) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
-	if len(s.stats.servers) == 0 {
+func (s *service) haproxyBackends(groups dao.Groups) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
+	s.stats.mutex.Lock()
+	servers := s.stats.servers
+	s.stats.mutex.Unlock()
+	if len(servers) == 0 {
 		return nil, nil, errors.New("redis stats empty")
 	}
 
-	groups, err := s.groupMapper.Info()
-	if err != nil {
-		return nil, nil, err
-	}
-
 	var backends dao.GSLBBackendGroups
 	for _, v := range sortGroups(groups) {
 		if len(v.Servers) == 0 {
@@ -159,7 +195,7 @@ func (s *service) haproxyBackends(backendName string) (dao.GSLBBackendGroups, da
 
 		bValid := true
 		for i, server := range v.Servers {
-			rs, ok := s.stats.servers[server.Addr]
+			rs, ok := servers[server.Addr]
 			if !ok || rs == nil || rs.Error != nil || rs.Timeout {
 				continue
 			}
@@ -200,12 +236,7 @@ func (s *service) haproxyBackends(backendName string) (dao.GSLBBackendGroups, da
 	return backends, nil, nil
 }
 
-func (s *service) lvsBackends(backendName string) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
-	gslbs, err := s.gslbMapper.Info()
-	if err != nil {
-		return nil, nil, err
-	}
-
+func (s *service) lvsBackends(gslbs dao.GSLBs, backendName string) (dao.GSLBBackendGroups, dao.GSLBMonitors, error) {
 	g, ok := gslbs[backendName]
 	if !ok {
 		log.Warnln("service::lvsBackends not found backend type. backendName:", backendName)
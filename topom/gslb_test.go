@@ -0,0 +1,92 @@
+package topom
+
+import (
+	"testing"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func (e *testEnv) storedGSLB(t *testing.T, name string) *dao.GSLB {
+	data, err := e.client.Read(coordinate.GSLBPath(name, testProduct), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil {
+		return nil
+	}
+	g := &dao.GSLB{}
+	if err := g.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGSLBBackends(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{testServer1, testServer2} {
+		if err := e.AddGroupServer("g1", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// no stats collected yet, the backends can't be computed.
+	if err := e.AddGSLB("haproxy", "127.0.0.1:9001"); err == nil {
+		t.Fatal("add gslb without redis stats")
+	}
+
+	e.stats.servers = map[string]*RedisStats{
+		testServer1: {Stats: map[string]string{"role": "master"}},
+		testServer2: {Stats: map[string]string{
+			"master_addr":        testServer1,
+			"master_link_status": MasterLinkStatusUp,
+		}},
+	}
+	if err := e.AddGSLB("haproxy", "127.0.0.1:9002"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGSLB("haproxy", "127.0.0.1:9002"); err == nil {
+		t.Fatal("add gslb server twice")
+	}
+
+	g := e.storedGSLB(t, "haproxy")
+	if g == nil || len(g.Servers) != 2 || len(g.Backends) != 1 {
+		t.Fatalf("unexpected gslb %+v", g)
+	}
+	bg := g.Backends[0]
+	if bg.Name != "g1" {
+		t.Fatalf("unexpected backend group %s", bg.Name)
+	}
+	if r := bg.ServerGroup[dao.ServeStateRead.String()]; r == nil || r.Port != 6001 || len(r.Servers) != 2 {
+		t.Fatalf("unexpected read backend %+v", r)
+	}
+	if w := bg.ServerGroup[dao.ServerStateWrite.String()]; w == nil || w.Port != 6002 || len(w.Servers) != 1 || w.Servers[0] != testServer1 {
+		t.Fatalf("unexpected write backend %+v", w)
+	}
+
+	// a slave following another master breaks the group.
+	e.stats.servers[testServer2].Stats["master_addr"] = testServer3
+	if err := e.DelGroupServer("g1", testServer2); err != nil {
+		t.Fatal(err)
+	}
+	if g := e.storedGSLB(t, "haproxy"); len(g.Backends) != 1 || len(g.Backends[0].ServerGroup) != 2 {
+		t.Fatalf("unexpected gslb after delete server %+v", g)
+	}
+
+	if err := e.DelGSLB("haproxy", "127.0.0.1:9003"); err == nil {
+		t.Fatal("delete missing gslb server")
+	}
+	for _, addr := range []string{"127.0.0.1:9001", "127.0.0.1:9002"} {
+		if err := e.DelGSLB("haproxy", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g := e.storedGSLB(t, "haproxy"); g != nil {
+		t.Fatalf("gslb still stored %+v", g)
+	}
+}
@@ -0,0 +1,384 @@
+package topom
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/client/redis/redistest"
+	"github.com/pourer/pikamgr/topom/dao"
+
+	"github.com/CodisLabs/codis/pkg/utils/timesize"
+)
+
+type testCluster struct {
+	servers   []*redistest.Server
+	sentinels []*redistest.Server
+}
+
+func newTestCluster(t *testing.T, servers, sentinels int) *testCluster {
+	c := &testCluster{}
+	for i := 0; i < servers; i++ {
+		s, err := redistest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.servers = append(c.servers, s)
+	}
+	for i := 0; i < sentinels; i++ {
+		s, err := redistest.NewSentinel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.sentinels = append(c.sentinels, s)
+	}
+	return c
+}
+
+func (c *testCluster) close() {
+	for _, s := range append(c.servers, c.sentinels...) {
+		s.Close()
+	}
+}
+
+// setupGroup creates the group g1 of every server of the cluster, the first
+// one is the master, and adds the sentinels.
+func (e *testEnv) setupGroup(t *testing.T, c *testCluster) {
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.servers {
+		if err := e.AddGroupServer("g1", s.Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.ResyncGroup("g1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.sentinels {
+		if err := e.AddSentinel(s.Addr(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResyncGroup(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	master := c.servers[0]
+	if master.Master() != "" {
+		t.Fatalf("master replicates from %s", master.Master())
+	}
+	for _, s := range c.servers[1:] {
+		if s.Master() != master.Addr() || s.Rewrites() == 0 {
+			t.Fatalf("slave %s replicates from %q, rewrites %d", s.Addr(), s.Master(), s.Rewrites())
+		}
+	}
+	if g := e.storedGroup(t, "g1"); g.OutOfSync {
+		t.Fatal("group out of sync after resync")
+	}
+
+	c.servers[2].Fail("SLAVEOF", "ERR injected")
+	c.servers[2].SetMaster("")
+	if err := e.ResyncGroup("g1"); err == nil {
+		t.Fatal("resync with a failing server")
+	}
+	if g := e.storedGroup(t, "g1"); !g.OutOfSync {
+		t.Fatal("group should be out of sync")
+	}
+	if c.servers[1].Master() != master.Addr() {
+		t.Fatal("healthy slave not resynced")
+	}
+
+	c.servers[2].Fail("SLAVEOF", "")
+	if err := e.ResyncGroupAll(); err != nil {
+		t.Fatal(err)
+	}
+	if c.servers[2].Master() != master.Addr() {
+		t.Fatal("slave not resynced after failure cleared")
+	}
+}
+
+func TestForceFullSync(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 0)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if err := e.GroupForceFullSyncServer("g1", c.servers[0].Addr()); err == nil {
+		t.Fatal("force full sync of master")
+	}
+	if err := e.GroupForceFullSyncServer("g1", c.servers[1].Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if c.servers[1].FullSyncs() != 1 || c.servers[1].Master() != c.servers[0].Addr() {
+		t.Fatalf("full syncs = %d, master = %s", c.servers[1].FullSyncs(), c.servers[1].Master())
+	}
+}
+
+func TestResyncSentinels(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 3)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if s := e.storedSentinel(t); len(s.Servers) != 3 || !s.OutOfSync {
+		t.Fatalf("unexpected sentinel %+v", s)
+	}
+	for _, s := range c.sentinels {
+		if s.Flushes() != 1 {
+			t.Fatalf("sentinel %s flushes = %d", s.Addr(), s.Flushes())
+		}
+	}
+
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	name := testProduct + "-g1"
+	for _, s := range c.sentinels {
+		if addr := s.Monitored()[name]; addr != c.servers[0].Addr() {
+			t.Fatalf("sentinel %s monitors %s at %q", s.Addr(), name, addr)
+		}
+		if q := s.Quorum(name); q != e.config().SentinelQuorum {
+			t.Fatalf("sentinel %s quorum = %d", s.Addr(), q)
+		}
+	}
+	if s := e.storedSentinel(t); s.OutOfSync {
+		t.Fatal("sentinel out of sync after resync")
+	}
+
+	c.sentinels[1].Fail("SENTINEL MONITOR", "ERR injected")
+	if err := e.ResyncSentinels(); err == nil {
+		t.Fatal("resync with a failing sentinel")
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel should be out of sync")
+	}
+}
+
+func TestResyncSentinelsDuringPromote(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 1)
+	defer c.close()
+
+	e.setupGroup(t, c)
+
+	// the group is swapped while the resync monitors the old master.
+	swapped := make(chan error, 1)
+	var once sync.Once
+	c.sentinels[0].SetHook(func(args []string) error {
+		if args[0] == "SENTINEL" && strings.EqualFold(args[1], "MONITOR") {
+			once.Do(func() {
+				e.mutex.Lock()
+				defer e.mutex.Unlock()
+				_, _, err := e.swapGroupMaster("g1", c.servers[1].Addr())
+				swapped <- err
+			})
+		}
+		return nil
+	})
+	if err := e.ResyncSentinels(); err == nil {
+		t.Fatal("resync of the old master succeeded")
+	}
+	if err := <-swapped; err != nil {
+		t.Fatal(err)
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel should be out of sync after a resync of the old master")
+	}
+}
+
+func TestGroupSentinelParams(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 1, 2)
+	defer c.close()
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+
+	params := &dao.SentinelParams{Quorum: 1, DownAfter: timesize.Duration(5 * time.Second)}
+	if err := e.SetGroupSentinelParams("g1", params); err != nil {
+		t.Fatal(err)
+	}
+	if g := e.storedGroup(t, "g1"); g.SentinelParams == nil || *g.SentinelParams != *params {
+		t.Fatalf("unexpected params %+v", g.SentinelParams)
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel in sync after a change of the params")
+	}
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	name := testProduct + "-g1"
+	for _, s := range c.sentinels {
+		opts := s.MasterOptions(name)
+		if s.Quorum(name) != 1 || opts["down-after-milliseconds"] != "5000" || opts["parallel-syncs"] != "1" {
+			t.Fatalf("sentinel %s quorum = %d, options %v", s.Addr(), s.Quorum(name), opts)
+		}
+	}
+
+	// the dashboard config applies once they're cleared.
+	if err := e.SetGroupSentinelParams("g1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if g := e.storedGroup(t, "g1"); g.SentinelParams != nil {
+		t.Fatalf("unexpected params %+v", g.SentinelParams)
+	}
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.sentinels {
+		if opts := s.MasterOptions(name); s.Quorum(name) != e.config().SentinelQuorum || opts["down-after-milliseconds"] != "30000" {
+			t.Fatalf("sentinel %s quorum = %d, options %v", s.Addr(), s.Quorum(name), opts)
+		}
+	}
+
+	if err := e.SetGroupSentinelParams("g1", &dao.SentinelParams{Quorum: -1}); err == nil {
+		t.Fatal("set invalid params")
+	}
+	if err := e.SetGroupSentinelParams("g2", params); err == nil {
+		t.Fatal("set params of a missing group")
+	}
+}
+
+func TestPromoteWithSentinels(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 3, 3)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+
+	master, promoted := c.servers[0], c.servers[2]
+	if err := e.GroupPromoteServer("g1", promoted.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := e.storedGroup(t, "g1")
+	if g.GetMaster() != promoted.Addr() || g.OutOfSync {
+		t.Fatalf("unexpected group after promote %+v", g)
+	}
+	if promoted.Master() != "" {
+		t.Fatalf("promoted server replicates from %s", promoted.Master())
+	}
+	for _, s := range []*redistest.Server{master, c.servers[1]} {
+		if s.Master() != promoted.Addr() {
+			t.Fatalf("server %s replicates from %q", s.Addr(), s.Master())
+		}
+	}
+
+	// the group is removed from the sentinels until they are resynced.
+	name := testProduct + "-g1"
+	for _, s := range c.sentinels {
+		if _, ok := s.Monitored()[name]; ok {
+			t.Fatalf("sentinel %s still monitors %s", s.Addr(), name)
+		}
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel should be out of sync after promote")
+	}
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.sentinels {
+		if addr := s.Monitored()[name]; addr != promoted.Addr() {
+			t.Fatalf("sentinel %s monitors %s at %q", s.Addr(), name, addr)
+		}
+	}
+	// the masters fetched by the watch before the promotion don't switch
+	// the group back.
+	if g := e.storedGroup(t, "g1"); g.GetMaster() != promoted.Addr() {
+		t.Fatalf("group switched back to %s", g.GetMaster())
+	}
+}
+
+func TestPromoteOutOfSyncGroup(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 0)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	c.servers[1].Fail("SLAVEOF", "ERR injected")
+	if err := e.ResyncGroup("g1"); err == nil {
+		t.Fatal("resync with a failing server")
+	}
+	if g := e.storedGroup(t, "g1"); !g.OutOfSync {
+		t.Fatal("group should be out of sync")
+	}
+
+	// the promotion resyncs the servers, the group is back in sync.
+	c.servers[1].Fail("SLAVEOF", "")
+	if err := e.GroupPromoteServer("g1", c.servers[1].Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if g := e.storedGroup(t, "g1"); g.GetMaster() != c.servers[1].Addr() || g.OutOfSync {
+		t.Fatalf("unexpected group after promote %+v", g)
+	}
+}
+
+func TestSentinelFailover(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 3)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "sentinel subscriptions", func() bool {
+		for _, s := range c.sentinels {
+			if s.Subscribers("+switch-master") == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	master, slave := c.servers[0], c.servers[1]
+	master.Close()
+	if err := c.sentinels[0].Failover(testProduct + "-g1"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, 5*time.Second, "group master switch", func() bool {
+		g := e.storedGroup(t, "g1")
+		return g.GetMaster() == slave.Addr()
+	})
+	g := e.storedGroup(t, "g1")
+	if !g.OutOfSync || g.Servers[1].Addr != master.Addr() {
+		t.Fatalf("unexpected group after failover %+v", g)
+	}
+
+	stats, err := e.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.HA.Masters["g1"] != slave.Addr() {
+		t.Fatalf("unexpected ha masters %v", stats.HA.Masters)
+	}
+}
@@ -0,0 +1,165 @@
+package topom
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+// hostOf returns the IP of the host of the address, its host if it can't be
+// resolved.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(resolveAddr(addr))
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// sentinelsDown returns the sentinels found down by the last round of the
+// stats, the ones not probed yet are taken as up.
+func sentinelsDown(servers []string, stats map[string]*RedisStats) map[string]bool {
+	down := make(map[string]bool)
+	for _, addr := range servers {
+		if st := stats[addr]; st != nil && (st.Error != nil || st.Timeout) {
+			down[addr] = true
+		}
+	}
+	return down
+}
+
+// quorumIssues returns, by group, why the sentinels can't fail its master
+// over. A failover needs the quorum of the group and the majority of the
+// sentinels, neither the sentinels down nor the ones on the host of the
+// master, lost along with it, are counted.
+func (s *service) quorumIssues(groups dao.Groups, servers []string, down map[string]bool) map[string]string {
+	issues := make(map[string]string)
+	for _, g := range groups {
+		if len(g.Servers) == 0 {
+			continue
+		}
+		needed := len(servers)/2 + 1
+		if quorum := s.monitorConfig(g).Quorum; quorum > needed {
+			needed = quorum
+		}
+		master := hostOf(g.Servers[0].Addr)
+		var available, stopped, colocated int
+		for _, addr := range servers {
+			switch {
+			case down[addr]:
+				stopped++
+			case hostOf(addr) == master:
+				colocated++
+			default:
+				available++
+			}
+		}
+		if available < needed {
+			issues[g.Name] = fmt.Sprintf("%d of the %d sentinels needed are available, %d down and %d on the host of the master",
+				available, needed, stopped, colocated)
+		}
+	}
+	return issues
+}
+
+// checkSentinelQuorum refuses to change the sentinels from before to after if
+// a group whose master can be failed over by the sentinels before can't be by
+// the ones after, see quorumIssues. It's called under s.ha.mutex.
+func (s *service) checkSentinelQuorum(before, after []string) error {
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return err
+	}
+	s.stats.mutex.Lock()
+	stats := s.stats.servers
+	s.stats.mutex.Unlock()
+
+	down := sentinelsDown(append(append([]string(nil), before...), after...), stats)
+	was := s.quorumIssues(groups, before, down)
+	var broken []string
+	for groupName, issue := range s.quorumIssues(groups, after, down) {
+		if _, ok := was[groupName]; !ok {
+			broken = append(broken, fmt.Sprintf("group-[%s] %s", groupName, issue))
+		}
+	}
+	if len(broken) != 0 {
+		sort.Strings(broken)
+		return fmt.Errorf("the change breaks the quorum of the sentinels, %s", strings.Join(broken, "; "))
+	}
+	return nil
+}
+
+// sentinelWarnings checks the health and the placement of the sentinels with
+// the last round of the stats, see protocol.SentinelWarning.
+func (s *service) sentinelWarnings(groups dao.Groups, servers []string, stats map[string]*RedisStats) []*protocol.SentinelWarning {
+	if len(servers) == 0 {
+		return nil
+	}
+	var warnings []*protocol.SentinelWarning
+	if len(servers) < 3 {
+		warnings = append(warnings, &protocol.SentinelWarning{
+			Kind:    protocol.SentinelWarningMajority,
+			Message: fmt.Sprintf("%d sentinels can't authorize a failover once one of them is down", len(servers)),
+		})
+	}
+	for groupName, issue := range s.quorumIssues(groups, servers, sentinelsDown(servers, stats)) {
+		warnings = append(warnings, &protocol.SentinelWarning{
+			Kind:    protocol.SentinelWarningQuorum,
+			Group:   groupName,
+			Message: issue,
+		})
+	}
+
+	hosts := make(map[string]string, len(servers))
+	for _, addr := range servers {
+		host := hostOf(addr)
+		if other, ok := hosts[host]; ok {
+			warnings = append(warnings, &protocol.SentinelWarning{
+				Kind:     protocol.SentinelWarningColocated,
+				Sentinel: addr,
+				Message:  fmt.Sprintf("on the host of sentinel-[%s]", other),
+			})
+		} else {
+			hosts[host] = addr
+		}
+		for _, g := range groups {
+			if len(g.Servers) != 0 && hostOf(g.Servers[0].Addr) == host {
+				warnings = append(warnings, &protocol.SentinelWarning{
+					Kind:     protocol.SentinelWarningColocated,
+					Group:    g.Name,
+					Sentinel: addr,
+					Message:  fmt.Sprintf("on the host of the master %s", g.Servers[0].Addr),
+				})
+			}
+		}
+
+		if st := stats[addr]; st != nil {
+			for groupName, reply := range st.Quorum {
+				if !strings.HasPrefix(reply, "OK") {
+					warnings = append(warnings, &protocol.SentinelWarning{
+						Kind:     protocol.SentinelWarningCheckQuorum,
+						Group:    groupName,
+						Sentinel: addr,
+						Message:  reply,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		a, b := warnings[i], warnings[j]
+		switch {
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.Group != b.Group:
+			return a.Group < b.Group
+		}
+		return a.Sentinel < b.Sentinel
+	})
+	return warnings
+}
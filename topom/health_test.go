@@ -0,0 +1,126 @@
+package topom
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func TestSentinelWarnings(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	groups := dao.Groups{
+		"g1": {Name: "g1", Servers: []*dao.GroupServer{{Addr: "10.0.0.1:9221"}, {Addr: "10.0.0.2:9221"}}},
+		"g2": {Name: "g2"},
+	}
+	servers := []string{"10.0.0.1:26379", "10.0.0.2:26379", "10.0.0.3:26379"}
+	stats := map[string]*RedisStats{
+		"10.0.0.3:26379": {Quorum: map[string]string{"g1": "NOQUORUM 1 usable Sentinels"}},
+	}
+	if w := e.sentinelWarnings(groups, nil, stats); len(w) != 0 {
+		t.Fatalf("warnings without sentinels %v", w)
+	}
+
+	want := []protocol.SentinelWarning{
+		{Kind: protocol.SentinelWarningCheckQuorum, Group: "g1", Sentinel: "10.0.0.3:26379", Message: "NOQUORUM 1 usable Sentinels"},
+		{Kind: protocol.SentinelWarningColocated, Group: "g1", Sentinel: "10.0.0.1:26379", Message: "on the host of the master 10.0.0.1:9221"},
+	}
+	warnings := e.sentinelWarnings(groups, servers, stats)
+	if len(warnings) != len(want) {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+	for i := range want {
+		if *warnings[i] != want[i] {
+			t.Fatalf("warning %d = %+v", i, warnings[i])
+		}
+	}
+
+	// a sentinel down leaves one sentinel to fail the master of g1 over.
+	stats["10.0.0.2:26379"] = &RedisStats{Error: errors.New("connection refused")}
+	servers = append(servers, "10.0.0.3:26380")
+	var kinds []string
+	for _, w := range e.sentinelWarnings(groups, servers, stats) {
+		kinds = append(kinds, w.Kind+" "+w.Group+" "+w.Sentinel)
+	}
+	if strings.Join(kinds, ",") != "ckquorum g1 10.0.0.3:26379,colocated  10.0.0.3:26380,colocated g1 10.0.0.1:26379,quorum g1 " {
+		t.Fatalf("unexpected warnings %v", kinds)
+	}
+
+	if w := e.sentinelWarnings(groups, servers[2:3], nil); len(w) != 2 || w[0].Kind != protocol.SentinelWarningMajority {
+		t.Fatalf("unexpected warnings %v", w)
+	}
+}
+
+func TestDelSentinelQuorum(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 0, 3)
+	defer c.close()
+
+	// the master isn't on the host of the sentinels.
+	if err := e.groupMapper.Create(&dao.Group{Name: "g1", Servers: []*dao.GroupServer{{Addr: "10.0.0.1:9221"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.sentinels {
+		if err := e.AddSentinel(s.Addr(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s0, s1, s2 := c.sentinels[0].Addr(), c.sentinels[1].Addr(), c.sentinels[2].Addr()
+	e.stats.mutex.Lock()
+	e.stats.servers = map[string]*RedisStats{s0: {Error: errors.New("connection refused")}}
+	e.stats.mutex.Unlock()
+	if err := e.DelSentinel(s1, false); err == nil {
+		t.Fatal("delete a sentinel needed by the quorum")
+	}
+	if err := e.DelSentinel(s0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DelSentinel(s1, false); err == nil {
+		t.Fatal("delete a sentinel needed by the majority")
+	}
+	if err := e.DelSentinel(s1, true); err != nil {
+		t.Fatal(err)
+	}
+	if s := e.storedSentinel(t); len(s.Servers) != 1 || s.Servers[0] != s2 {
+		t.Fatalf("unexpected sentinels %v", s.Servers)
+	}
+
+	// the sentinels which can't fail the master over can be added to.
+	if err := e.AddSentinel(s1, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbeSentinelQuorum(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 2)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := e.probeSentinel(context.Background(), c.sentinels[0].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Quorum) != 1 || !strings.HasPrefix(st.Quorum["g1"], "OK") {
+		t.Fatalf("unexpected quorum %v", st.Quorum)
+	}
+
+	c.sentinels[1].Close()
+	if st, err = e.probeSentinel(context.Background(), c.sentinels[0].Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(st.Quorum["g1"], "NOQUORUM") {
+		t.Fatalf("unexpected quorum %v", st.Quorum)
+	}
+}
@@ -0,0 +1,324 @@
+package topom
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/dao"
+	swerror "github.com/pourer/pikamgr/utils/error"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+const (
+	JobResyncGroupAll  = protocol.JobTypeResyncGroupAll
+	JobResyncSentinels = protocol.JobTypeResyncSentinels
+	JobForceFullSync   = protocol.JobTypeForceFullSync
+	JobRotateAuth      = protocol.JobTypeRotateAuth
+	JobRepairSentinels = protocol.JobTypeRepairSentinels
+)
+
+// MaxFinishedJobs is the number of finished jobs kept in the coordinator, the
+// oldest are removed when a new job is submitted.
+var MaxFinishedJobs = 50
+
+// jobTask describes the work of a job, run is called once for every target.
+// finish is optional, it's called once every target is done with the errors
+// of the targets and returns the error of the job. A sequential job with
+// stopOnError cancels the targets after the first one which fails. rollback
+// is optional, it's called instead of finish once a target failed or the job
+// was cancelled.
+type jobTask struct {
+	typ         string
+	targets     []string
+	parallel    bool
+	stopOnError bool
+	run         func(ctx context.Context, r *jobRunner, target string) error
+	finish      func(ctx context.Context, r *jobRunner, err error) error
+	rollback    func(r *jobRunner, err error)
+}
+
+// jobRunner runs a job of this dashboard, every change of its progress is
+// persisted so that other dashboards can show it.
+type jobRunner struct {
+	s      *service
+	mutex  sync.Mutex
+	job    *dao.Job
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func (s *service) newJobID() string {
+	s.jobs.mutex.Lock()
+	defer s.jobs.mutex.Unlock()
+	id := time.Now().UnixNano()
+	if id <= s.jobs.lastID {
+		id = s.jobs.lastID + 1
+	}
+	s.jobs.lastID = id
+	return strconv.FormatInt(id, 10)
+}
+
+func (s *service) submitJob(task *jobTask) (*jobRunner, error) {
+	job := &dao.Job{
+		ID:         s.newJobID(),
+		Type:       task.typ,
+		Owner:      s.config().AdminAddr,
+		State:      dao.JobRunning,
+		Targets:    make([]*dao.JobTarget, 0, len(task.targets)),
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for _, t := range task.targets {
+		job.Targets = append(job.Targets, &dao.JobTarget{Name: t, State: dao.TargetPending})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &jobRunner{
+		s:      s,
+		job:    job,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	s.jobs.mutex.Lock()
+	if s.jobs.closed {
+		s.jobs.mutex.Unlock()
+		cancel()
+		return nil, ErrClosedTopom
+	}
+	if err := s.jobMapper.Update(job.Clone()); err != nil {
+		s.jobs.mutex.Unlock()
+		cancel()
+		return nil, err
+	}
+	s.jobs.running[job.ID] = r
+	s.jobs.wg.Add(1)
+	s.jobs.mutex.Unlock()
+
+	log.Infof("service::submitJob job-[%s] type-[%s] targets-%v", job.ID, job.Type, task.targets)
+	s.pruneJobs()
+
+	go r.run(ctx, task)
+	return r, nil
+}
+
+// pruneJobs removes the oldest finished jobs beyond MaxFinishedJobs.
+func (s *service) pruneJobs() {
+	jobs, err := s.jobMapper.Info()
+	if err != nil {
+		return
+	}
+	var finished int
+	for _, j := range jobs.Sorted() {
+		if !j.Finished() {
+			continue
+		}
+		if finished++; finished <= MaxFinishedJobs {
+			continue
+		}
+		if err := s.jobMapper.Remove(j.ID); err != nil {
+			log.Warnf("service::pruneJobs remove job-[%s] fail. err-[%s]", j.ID, err.Error())
+		}
+	}
+}
+
+// abandonJobs marks the jobs left running by a previous dashboard as failed,
+// only the dashboard which holds the topom node runs jobs.
+func (s *service) abandonJobs() {
+	jobs, err := s.jobMapper.Info()
+	if err != nil {
+		return
+	}
+	s.jobs.mutex.Lock()
+	defer s.jobs.mutex.Unlock()
+	for _, j := range jobs {
+		if j.Finished() || s.jobs.running[j.ID] != nil {
+			continue
+		}
+		j.State = dao.JobFailed
+		j.Error = fmt.Sprintf("abandoned by dashboard-[%s]", j.Owner)
+		j.FinishTime = time.Now().Format("2006-01-02 15:04:05")
+		for _, t := range j.Targets {
+			if t.State == dao.TargetPending || t.State == dao.TargetRunning {
+				t.State = dao.TargetCancelled
+			}
+		}
+		if err := s.jobMapper.Update(j); err != nil {
+			log.Warnf("service::abandonJobs update job-[%s] fail. err-[%s]", j.ID, err.Error())
+		}
+	}
+}
+
+func (r *jobRunner) run(ctx context.Context, task *jobTask) {
+	defer r.s.jobs.wg.Done()
+	defer close(r.done)
+	defer r.cancel()
+
+	errs := make([]error, len(task.targets))
+	if task.parallel {
+		var wg sync.WaitGroup
+		for i, target := range task.targets {
+			wg.Add(1)
+			go func(i int, target string) {
+				defer wg.Done()
+				errs[i] = r.runTarget(ctx, task, target)
+			}(i, target)
+		}
+		wg.Wait()
+	} else {
+		var failed bool
+		for i, target := range task.targets {
+			if failed {
+				r.setTarget(target, dao.TargetCancelled, nil)
+				continue
+			}
+			errs[i] = r.runTarget(ctx, task, target)
+			failed = errs[i] != nil && task.stopOnError
+		}
+	}
+
+	var multiErr swerror.MultiError
+	for _, err := range errs {
+		multiErr.Append(err)
+	}
+	err := multiErr.ErrorOrNil()
+	if task.rollback != nil && (err != nil || ctx.Err() != nil) {
+		r.logf("rolling back")
+		task.rollback(r, err)
+	} else if task.finish != nil && ctx.Err() == nil {
+		err = task.finish(ctx, r, err)
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	r.mutex.Lock()
+	switch {
+	case ctx.Err() != nil:
+		r.job.State = dao.JobCancelled
+	case err != nil:
+		r.job.State = dao.JobFailed
+	default:
+		r.job.State = dao.JobFinished
+	}
+	if err != nil {
+		r.job.Error = log.Redact(err.Error())
+	}
+	r.job.FinishTime = time.Now().Format("2006-01-02 15:04:05")
+	r.err = err
+	r.save()
+	r.mutex.Unlock()
+
+	r.s.jobs.mutex.Lock()
+	delete(r.s.jobs.running, r.job.ID)
+	r.s.jobs.mutex.Unlock()
+
+	log.Infof("service::runJob job-[%s] %s", r.job.ID, r.job.State)
+}
+
+func (r *jobRunner) runTarget(ctx context.Context, task *jobTask, target string) error {
+	if err := ctx.Err(); err != nil {
+		r.setTarget(target, dao.TargetCancelled, nil)
+		return err
+	}
+	r.setTarget(target, dao.TargetRunning, nil)
+	err := task.run(ctx, r, target)
+	if err != nil {
+		r.setTarget(target, dao.TargetFailed, err)
+		return fmt.Errorf("%s: %s", target, err.Error())
+	}
+	r.setTarget(target, dao.TargetDone, nil)
+	return nil
+}
+
+func (r *jobRunner) setTarget(name, state string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.job.Target(name)
+	t.State = state
+	if err != nil {
+		t.Error = log.Redact(err.Error())
+		r.appendLog("%s %s: %s", name, state, err.Error())
+	} else {
+		r.appendLog("%s %s", name, state)
+	}
+	r.save()
+}
+
+// logf adds a line to the logs of the job.
+func (r *jobRunner) logf(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.appendLog(format, args...)
+	r.save()
+}
+
+func (r *jobRunner) appendLog(format string, args ...interface{}) {
+	line := time.Now().Format("15:04:05 ") + log.Redact(fmt.Sprintf(format, args...))
+	r.job.Logs = append(r.job.Logs, line)
+	if n := len(r.job.Logs) - dao.MaxJobLogs; n > 0 {
+		r.job.Logs = r.job.Logs[n:]
+	}
+}
+
+func (r *jobRunner) save() {
+	if err := r.s.jobMapper.Update(r.job.Clone()); err != nil {
+		log.Warnf("service::runJob save job-[%s] fail. err-[%s]", r.job.ID, err.Error())
+	}
+}
+
+// wait returns the error of the job once it's done.
+func (r *jobRunner) wait() error {
+	<-r.done
+	return r.err
+}
+
+func (s *service) Jobs() ([]*dao.Job, error) {
+	jobs, err := s.jobMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	return jobs.Sorted(), nil
+}
+
+func (s *service) Job(id string) (*dao.Job, error) {
+	jobs, err := s.jobMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	j, ok := jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job-[%s] %w", id, ErrNotFound)
+	}
+	return j, nil
+}
+
+func (s *service) CancelJob(id string) error {
+	s.jobs.mutex.Lock()
+	r, ok := s.jobs.running[id]
+	s.jobs.mutex.Unlock()
+	if !ok {
+		if _, err := s.Job(id); err != nil {
+			return err
+		}
+		return fmt.Errorf("job-[%s] isn't running on this dashboard", id)
+	}
+	log.Infof("service::CancelJob job-[%s]", id)
+	r.cancel()
+	return nil
+}
+
+// closeJobs cancels the running jobs and waits for them.
+func (s *service) closeJobs() {
+	s.jobs.mutex.Lock()
+	s.jobs.closed = true
+	for _, r := range s.jobs.running {
+		r.cancel()
+	}
+	s.jobs.mutex.Unlock()
+	s.jobs.wg.Wait()
+}
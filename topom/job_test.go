@@ -0,0 +1,135 @@
+package topom
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func (e *testEnv) storedJob(t *testing.T, id string) *dao.Job {
+	data, err := e.client.Read(coordinate.JobPath(testProduct, id), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil {
+		return nil
+	}
+	j := &dao.Job{}
+	if err := j.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestResyncGroupAllJob(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 0)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	if err := e.CreateGroup("g2", 6003, 6004); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGroupServer("g2", testServer1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CreateGroup("g3", 6005, 6006); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGroupServer("g3", testServer2); err != nil {
+		t.Fatal(err)
+	}
+
+	// every failing group is reported, not only the first one.
+	err := e.ResyncGroupAll()
+	if err == nil || !strings.Contains(err.Error(), "g2") || !strings.Contains(err.Error(), "g3") {
+		t.Fatalf("resync all, err = %v", err)
+	}
+
+	jobs, err := e.Jobs()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("jobs = %v, %v", jobs, err)
+	}
+	j := e.storedJob(t, jobs[0].ID)
+	if j == nil || j.Type != JobResyncGroupAll || j.State != dao.JobFailed {
+		t.Fatalf("unexpected job %+v", j)
+	}
+	if done, total := j.Progress(); done != 3 || total != 3 {
+		t.Fatalf("progress = %d/%d", done, total)
+	}
+	for name, state := range map[string]string{"g1": dao.TargetDone, "g2": dao.TargetFailed, "g3": dao.TargetFailed} {
+		if target := j.Target(name); target == nil || target.State != state {
+			t.Fatalf("target %s = %+v, expect %s", name, target, state)
+		}
+	}
+	if len(j.Logs) == 0 {
+		t.Fatal("job without logs")
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	started := make(chan struct{})
+	r, err := e.submitJob(&jobTask{
+		typ:     "test",
+		targets: []string{"t1", "t2"},
+		run: func(ctx context.Context, r *jobRunner, target string) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if j := e.storedJob(t, r.job.ID); j.State != dao.JobRunning || j.Target("t1").State != dao.TargetRunning {
+		t.Fatalf("unexpected running job %+v", j)
+	}
+
+	if err := e.CancelJob(r.job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.wait(); err == nil {
+		t.Fatal("cancelled job without error")
+	}
+	j := e.storedJob(t, r.job.ID)
+	if j.State != dao.JobCancelled || j.Target("t2").State != dao.TargetCancelled {
+		t.Fatalf("unexpected cancelled job %+v", j)
+	}
+	if err := e.CancelJob(r.job.ID); err == nil {
+		t.Fatal("cancel finished job")
+	}
+	if err := e.CancelJob("missing"); err == nil {
+		t.Fatal("cancel missing job")
+	}
+}
+
+func TestAbandonJobs(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	j := &dao.Job{ID: "1", Type: "test", Owner: "127.0.0.1:1", State: dao.JobRunning,
+		Targets: []*dao.JobTarget{{Name: "t1", State: dao.TargetRunning}}}
+	if err := e.client.Update(coordinate.JobPath(testProduct, j.ID), j.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 3*time.Second, "job loaded", func() bool {
+		_, err := e.Job(j.ID)
+		return err == nil
+	})
+
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if j := e.storedJob(t, j.ID); j.State != dao.JobFailed || j.Target("t1").State != dao.TargetCancelled {
+		t.Fatalf("unexpected abandoned job %+v", j)
+	}
+}
@@ -0,0 +1,222 @@
+package topom
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// keyspaceScans throttles the keyspace scans of the server info views,
+// "INFO keyspace 1" makes Pika scan every key. A server is scanned once per
+// window at most, the keyspace section of the last view of every server is
+// kept with its time.
+type keyspaceScans struct {
+	mutex sync.Mutex
+	scans map[string]*keyspaceScan
+}
+
+type keyspaceScan struct {
+	// last is the last scan triggered on the server.
+	last time.Time
+
+	// the state of the last view of the server.
+	skipped   string
+	scannedOn string
+	result    []string
+	resultAt  time.Time
+}
+
+func (k *keyspaceScans) get(addr string) *keyspaceScan {
+	if k.scans == nil {
+		k.scans = make(map[string]*keyspaceScan)
+	}
+	scan, ok := k.scans[addr]
+	if !ok {
+		scan = &keyspaceScan{}
+		k.scans[addr] = scan
+	}
+	return scan
+}
+
+// trigger tells if target may be scanned now, the scan is accounted for.
+func (k *keyspaceScans) trigger(target string, window time.Duration, now time.Time) (bool, time.Duration) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	scan := k.get(target)
+	if since := now.Sub(scan.last); since < window {
+		return false, since
+	}
+	scan.last = now
+	return true, 0
+}
+
+// untrigger gives the scan back after a failed trigger.
+func (k *keyspaceScans) untrigger(target string, last time.Time) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if scan := k.get(target); scan.last.Equal(last) {
+		scan.last = time.Time{}
+	}
+}
+
+func (k *keyspaceScans) view(addr, scannedOn, skipped string, result []string, now time.Time) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	scan := k.get(addr)
+	scan.scannedOn, scan.skipped = scannedOn, skipped
+	if result != nil {
+		scan.result, scan.resultAt = result, now
+	}
+}
+
+// state returns the scans of addr for the stats, nil if it was neither viewed
+// nor scanned. The scans of a master viewed through a slave are the slave's.
+func (k *keyspaceScans) state(addr string, window time.Duration) *protocol.KeySpaceScan {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	scan, ok := k.scans[addr]
+	if !ok {
+		return nil
+	}
+	p := &protocol.KeySpaceScan{
+		Skipped:   scan.skipped,
+		ScannedOn: scan.scannedOn,
+		Result:    scan.result,
+	}
+	last := scan.last
+	if on, ok := k.scans[scan.scannedOn]; ok {
+		last = on.last
+	}
+	if !last.IsZero() {
+		p.LastScan = last.Unix()
+		p.NextScan = last.Add(window).Unix()
+	}
+	if !scan.resultAt.IsZero() {
+		p.ResultTime = scan.resultAt.Unix()
+	}
+	return p
+}
+
+// retain forgets the servers which are gone.
+func (k *keyspaceScans) retain(servers map[string]collectKind) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for addr := range k.scans {
+		if _, ok := servers[addr]; !ok {
+			delete(k.scans, addr)
+		}
+	}
+}
+
+// keyspaceTarget picks the server to scan for a view of addr: a slave is
+// scanned itself, a master through one of its slaves whose link is up, else
+// only while it isn't under load. skipped tells why nothing is scanned.
+func (s *service) keyspaceTarget(addr string) (target, skipped string) {
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return addr, ""
+	}
+	s.stats.mutex.Lock()
+	servers := s.stats.servers
+	s.stats.mutex.Unlock()
+
+	for _, g := range groups {
+		index := g.GetServerIndex(addr)
+		if index == -1 {
+			continue
+		}
+		if index != 0 {
+			return addr, ""
+		}
+		for _, slave := range g.Servers[1:] {
+			rs := servers[slave.Addr]
+			if rs != nil && rs.Error == nil && !rs.Timeout &&
+				rs.MasterAddr() == addr && rs.MasterLinkStatus() == MasterLinkStatusUp {
+				return slave.Addr, ""
+			}
+		}
+		break
+	}
+
+	maxOps := s.config().KeyspaceScanMaxOps
+	if maxOps == 0 {
+		return "", "master without a slave up"
+	}
+	if rs := servers[addr]; rs != nil && rs.Stats != nil {
+		if ops, err := strconv.Atoi(rs.Stats["instantaneous_ops_per_sec"]); err == nil && ops >= maxOps {
+			return "", fmt.Sprintf("master under load, %d ops/sec", ops)
+		}
+	}
+	return addr, ""
+}
+
+// ServerInfo returns the INFO of the server, its keyspace is scanned as
+// keyspaceScans allows. The keyspace of a master scanned through a slave is
+// the one of the slave.
+func (s *service) ServerInfo(addr string) ([]byte, error) {
+	now := time.Now()
+	window := s.config().KeyspaceScanWindow.Duration()
+
+	target, skipped := s.keyspaceTarget(addr)
+	if target != "" {
+		if ok, since := s.keyspace.trigger(target, window, now); !ok {
+			skipped = fmt.Sprintf("%s scanned %s ago", target, since.Truncate(time.Second))
+		} else if err := s.scanKeyspace(target); err != nil {
+			log.Warnf("service::ServerInfo scan keyspace of server-[%s] fail. err-[%s]", target, err.Error())
+			s.keyspace.untrigger(target, now)
+			skipped = fmt.Sprintf("%s scan failed", target)
+		}
+	}
+
+	c, err := redis.NewClientOptions(addr, s.redisOptions(), 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	text, _, err := c.InfoFull()
+	if err != nil {
+		return nil, err
+	}
+
+	scannedOn := ""
+	result := redis.InfoSection(text, "Keyspace")
+	if target != "" && target != addr {
+		if lines, err := s.keyspaceOf(target); err != nil {
+			log.Warnf("service::ServerInfo keyspace of server-[%s] fail. err-[%s]", target, err.Error())
+		} else {
+			scannedOn, result = target, lines
+			text = redis.ReplaceInfoSection(text, "Keyspace", result)
+		}
+	}
+	s.keyspace.view(addr, scannedOn, skipped, result, now)
+	s.dirtyStats()
+	return []byte(text), nil
+}
+
+func (s *service) scanKeyspace(addr string) error {
+	c, err := redis.NewClientOptions(addr, s.redisOptions(), 3*time.Second)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.EnableKeySpace()
+}
+
+func (s *service) keyspaceOf(addr string) ([]string, error) {
+	c, err := redis.NewClientOptions(addr, s.redisOptions(), 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	text, err := c.InfoText("keyspace")
+	if err != nil {
+		return nil, err
+	}
+	return redis.InfoSection(text, "Keyspace"), nil
+}
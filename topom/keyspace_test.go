@@ -0,0 +1,108 @@
+package topom
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/client/redis/redistest"
+
+	"github.com/CodisLabs/codis/pkg/utils/timesize"
+)
+
+func countKeyspaceScans(s *redistest.Server) int {
+	var n int
+	for _, call := range s.Calls() {
+		if len(call) == 3 && call[0] == "INFO" && strings.EqualFold(call[1], "keyspace") && call[2] == "1" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestServerInfoKeyspaceScans(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 0)
+	defer c.close()
+
+	e.setupGroup(t, c)
+	e.config().KeyspaceScanWindow = timesize.Duration(time.Hour)
+	e.config().KeyspaceScanMaxOps = 1000
+	ctx := context.Background()
+	if err := e.refreshRedisStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	master, slave := c.servers[0], c.servers[1]
+
+	// a master is scanned through its slave, once per window.
+	for i := 0; i < 3; i++ {
+		text, err := e.ServerInfo(master.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(text), "# Keyspace") || !strings.Contains(string(text), "run_id:"+master.RunID()) {
+			t.Fatalf("info = %s", text)
+		}
+	}
+	if _, err := e.ServerInfo(slave.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := countKeyspaceScans(slave), countKeyspaceScans(master); n != 1 || m != 0 {
+		t.Fatalf("slave scanned %d times, master %d times", n, m)
+	}
+
+	stats, err := e.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := stats.Group.Stats[master.Addr()].KeySpace
+	if ks == nil || ks.ScannedOn != slave.Addr() || ks.LastScan == 0 || ks.NextScan-ks.LastScan != 3600 ||
+		len(ks.Result) == 0 || ks.ResultTime == 0 || !strings.Contains(ks.Skipped, "scanned") {
+		t.Fatalf("keyspace of master = %+v", ks)
+	}
+	if ks := stats.Group.Stats[slave.Addr()].KeySpace; ks == nil || ks.ScannedOn != "" || ks.LastScan == 0 {
+		t.Fatalf("keyspace of slave = %+v", ks)
+	}
+
+	// without a slave up, a master under load isn't scanned.
+	slave.SetMaster("")
+	master.SetInfo("instantaneous_ops_per_sec", "5000")
+	if err := e.refreshRedisStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ServerInfo(master.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeyspaceScans(master); n != 0 {
+		t.Fatalf("master under load scanned %d times", n)
+	}
+	if stats, _ := e.Stats(); !strings.Contains(stats.Group.Stats[master.Addr()].KeySpace.Skipped, "under load") {
+		t.Fatalf("keyspace of master = %+v", stats.Group.Stats[master.Addr()].KeySpace)
+	}
+
+	master.SetInfo("instantaneous_ops_per_sec", "10")
+	if err := e.refreshRedisStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := e.ServerInfo(master.Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countKeyspaceScans(master); n != 1 {
+		t.Fatalf("master scanned %d times", n)
+	}
+
+	// the servers which are gone are forgotten.
+	if err := e.DelGroupServer("g1", slave.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.refreshRedisStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ks := e.keyspace.state(slave.Addr(), time.Hour); ks != nil {
+		t.Fatalf("keyspace of a removed server = %+v", ks)
+	}
+}
@@ -0,0 +1,70 @@
+package topom
+
+import "sync"
+
+// The locks of the service are taken in this order, a lock is never taken
+// while holding one which comes after it:
+//
+//   - s.configMutex, a change of the config,
+//   - the group locks, one group at a time,
+//   - s.ha.mutex, the sentinel state,
+//   - s.gslbs.mutex, the gslb state,
+//   - s.mutex, the read-modify-commit of the models,
+//   - s.stats.mutex, the runtime stats.
+//
+// The Pika servers and the sentinels are called under the group or the
+// sentinel lock only, an operation waiting for a slow server doesn't hold
+// back those on the other groups. s.mutex is held for the short sections
+// which read the models and commit the changes to the coordinator.
+
+// groupLocks serializes the operations of a group, the operations of
+// different groups run concurrently. A lock is dropped once nobody holds or
+// waits for it.
+type groupLocks struct {
+	mutex sync.Mutex
+	locks map[string]*groupLock
+}
+
+type groupLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the group and returns the unlock.
+func (l *groupLocks) lock(groupName string) func() {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*groupLock)
+	}
+	gl, ok := l.locks[groupName]
+	if !ok {
+		gl = &groupLock{}
+		l.locks[groupName] = gl
+	}
+	gl.refs++
+	l.mutex.Unlock()
+
+	gl.Lock()
+	return func() {
+		gl.Unlock()
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if gl.refs--; gl.refs == 0 {
+			delete(l.locks, groupName)
+		}
+	}
+}
+
+func (l *groupLocks) len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.locks)
+}
+
+// lockGroup locks the group for an operation, it's meant to be deferred:
+//
+//	defer s.lockGroup(groupName)()
+func (s *service) lockGroup(groupName string) func() {
+	return s.groupLocks.lock(groupName)
+}
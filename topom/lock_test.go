@@ -0,0 +1,176 @@
+package topom
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/client/redis/redistest"
+)
+
+func TestGroupLocks(t *testing.T) {
+	var l groupLocks
+
+	unlock1 := l.lock("g1")
+	unlock2 := l.lock("g2")
+	unlock2()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := l.lock("g1")
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("g1 locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock1()
+	<-locked
+
+	waitFor(t, time.Second, "the locks dropped", func() bool {
+		return l.len() == 0
+	})
+}
+
+// blockSlaveOf makes the server block every SLAVEOF until release is called,
+// blocked is closed on the first one.
+func blockSlaveOf(s *redistest.Server) (blocked chan struct{}, release func()) {
+	blocked, released := make(chan struct{}), make(chan struct{})
+	var once, releaseOnce sync.Once
+	s.SetHook(func(args []string) error {
+		if args[0] == "SLAVEOF" {
+			once.Do(func() { close(blocked) })
+			<-released
+		}
+		return nil
+	})
+	return blocked, func() { releaseOnce.Do(func() { close(released) }) }
+}
+
+func TestPromoteGroupsConcurrently(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 4, 0)
+	defer c.close()
+
+	for i, name := range []string{"g1", "g2"} {
+		if err := e.CreateGroup(name, 6001+i*2, 6002+i*2); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range c.servers[i*2 : i*2+2] {
+			if err := e.AddGroupServer(name, s.Addr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.ResyncGroup(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the promotion of g1 hangs on its new master.
+	blocked, release := blockSlaveOf(c.servers[1])
+	defer release()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.GroupPromoteServer("g1", c.servers[1].Addr())
+	}()
+	<-blocked
+
+	finished := make(chan error, 1)
+	go func() {
+		if err := e.GroupPromoteServer("g2", c.servers[3].Addr()); err != nil {
+			finished <- err
+			return
+		}
+		if _, err := e.Stats(); err != nil {
+			finished <- err
+			return
+		}
+		finished <- e.ResyncGroup("g2")
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("g2 is held back by the promotion of g1")
+	}
+	if g := e.storedGroup(t, "g2"); g.GetMaster() != c.servers[3].Addr() || g.OutOfSync {
+		t.Fatalf("unexpected g2 after promote %+v", g)
+	}
+
+	// another operation on g1 waits for the promotion.
+	resynced := make(chan error, 1)
+	go func() {
+		resynced <- e.ResyncGroup("g1")
+	}()
+	select {
+	case err := <-resynced:
+		t.Fatalf("g1 resynced during its promotion, err = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-resynced; err != nil {
+		t.Fatal(err)
+	}
+	g := e.storedGroup(t, "g1")
+	if g.GetMaster() != c.servers[1].Addr() || g.OutOfSync {
+		t.Fatalf("unexpected g1 after promote %+v", g)
+	}
+	if c.servers[0].Master() != c.servers[1].Addr() {
+		t.Fatalf("old master replicates from %q", c.servers[0].Master())
+	}
+}
+
+func TestPromoteConcurrently(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 8, 0)
+	defer c.close()
+
+	names := []string{"g1", "g2", "g3", "g4"}
+	for i, name := range names {
+		if err := e.CreateGroup(name, 6001+i*2, 6002+i*2); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range c.servers[i*2 : i*2+2] {
+			if err := e.AddGroupServer(name, s.Addr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(names)*2)
+	for i, name := range names {
+		wg.Add(2)
+		go func(name string, addr string) {
+			defer wg.Done()
+			errs <- e.GroupPromoteServer(name, addr)
+		}(name, c.servers[i*2+1].Addr())
+		go func() {
+			defer wg.Done()
+			_, err := e.Stats()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range names {
+		if g := e.storedGroup(t, name); g.GetMaster() != c.servers[i*2+1].Addr() {
+			t.Fatalf("unexpected %s after promote %+v", name, g)
+		}
+	}
+}
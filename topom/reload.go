@@ -0,0 +1,71 @@
+package topom
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pourer/pikamgr/config"
+	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// config returns the config in use, it's never changed in place.
+func (s *service) config() *config.DashboardConfig {
+	return s.configs.Load().(*config.DashboardConfig)
+}
+
+// updateConfig replaces the config in use by a copy changed by fn.
+func (s *service) updateConfig(fn func(c *config.DashboardConfig)) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	c := *s.config()
+	fn(&c)
+	s.configs.Store(&c)
+}
+
+// ReloadConfig loads the config file again. The live keys take effect at
+// once, the others are reported and kept until a restart. Once a sentinel key
+// changed, the sentinels are resynced by a job if resyncSentinels or the
+// reload_resync_sentinels of the file is set.
+func (s *service) ReloadConfig(resyncSentinels bool) (*protocol.ConfigReload, error) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	n, err := s.config().Reload()
+	if err != nil {
+		return nil, fmt.Errorf("reload config fail. err-[%s]", err.Error())
+	}
+	log.AddSecrets(n.Secrets()...)
+	c, changes := s.config().Apply(n)
+	s.configs.Store(c)
+
+	reload := &protocol.ConfigReload{Changes: []*protocol.ConfigChange{}}
+	sentinelChanged := false
+	for _, change := range changes {
+		reload.Changes = append(reload.Changes, &protocol.ConfigChange{
+			Key: change.Key, Old: change.Old, New: change.New, Restart: !change.Live,
+		})
+		if !change.Live {
+			log.Warnf("service::ReloadConfig %s changed from [%s] to [%s], it takes effect at a restart", change.Key, change.Old, change.New)
+			continue
+		}
+		log.Infof("service::ReloadConfig %s changed from [%s] to [%s]", change.Key, change.Old, change.New)
+		switch {
+		case change.Key == "log_level":
+			log.SetLevel(log.StringToLevel(c.LogLevel))
+		case change.Key == "template_file_scan_interval":
+			s.tfMapper.SetInterval(c.TemplateFileScanInterval.Duration())
+		case strings.HasPrefix(change.Key, "sentinel_"):
+			sentinelChanged = true
+		}
+	}
+
+	if sentinelChanged && (resyncSentinels || c.ReloadResyncSentinels) {
+		job, err := s.SubmitResyncSentinels()
+		if err != nil {
+			return nil, fmt.Errorf("config reloaded, resync sentinels fail. err-[%s]", err.Error())
+		}
+		reload.ResyncJob = job.ID
+	}
+	return reload, nil
+}
@@ -0,0 +1,80 @@
+package topom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/protocol"
+)
+
+// writeConfig writes the dashboard config file of the env, the keys set by
+// the env are kept.
+func (e *testEnv) writeConfig(t *testing.T, text string) string {
+	file := filepath.Join(e.dir, "dashboard.toml")
+	text = fmt.Sprintf("product_name = %q\ntemplate_file_scan_dir = %q\n", testProduct, e.config().TemplateFileScanDir) + text
+	if err := ioutil.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReloadConfig(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 1, 1)
+	defer c.close()
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.config().LoadFromFile(e.writeConfig(t, "sentinel_quorum = 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	e.writeConfig(t, "sentinel_quorum = 1\nadmin_addr = \"0.0.0.0:18081\"\nproduct_auth = \"s3cret-reload\"\n")
+	r, err := e.ReloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]protocol.ConfigChange{
+		"sentinel_quorum": {Key: "sentinel_quorum", Old: "2", New: "1"},
+		"admin_addr":      {Key: "admin_addr", Old: "0.0.0.0:18080", New: "0.0.0.0:18081", Restart: true},
+		"product_auth":    {Key: "product_auth", Old: "", New: "******", Restart: true},
+	}
+	if len(r.Changes) != len(want) || r.ResyncJob != "" {
+		t.Fatalf("unexpected reload %+v", r)
+	}
+	for _, change := range r.Changes {
+		if *change != want[change.Key] {
+			t.Fatalf("unexpected change %+v", change)
+		}
+	}
+	if conf := e.config(); conf.SentinelQuorum != 1 || conf.AdminAddr != "0.0.0.0:18080" || conf.ProductAuth != "" {
+		t.Fatalf("quorum %d admin_addr %s product_auth %q", conf.SentinelQuorum, conf.AdminAddr, conf.ProductAuth)
+	}
+
+	// the sentinels are resynced with the new quorum on request.
+	name := testProduct + "-g1"
+	e.writeConfig(t, "sentinel_quorum = 3\n")
+	if r, err = e.ReloadConfig(true); err != nil {
+		t.Fatal(err)
+	}
+	if r.ResyncJob == "" {
+		t.Fatalf("unexpected reload %+v", r)
+	}
+	waitFor(t, 5*time.Second, "the quorum of the sentinel", func() bool {
+		return c.sentinels[0].Quorum(name) == 3
+	})
+
+	// an invalid file is rejected and the config kept.
+	e.writeConfig(t, "sentinel_quorum = 0\n")
+	if _, err := e.ReloadConfig(false); err == nil {
+		t.Fatal("reload of an invalid config")
+	}
+	if e.config().SentinelQuorum != 3 {
+		t.Fatalf("quorum %d", e.config().SentinelQuorum)
+	}
+}
@@ -1,25 +1,32 @@
 package topom
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"sync/atomic"
 	"time"
 
 	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
 	"github.com/pourer/pikamgr/utils/log"
 
 	"github.com/CodisLabs/codis/pkg/utils/math2"
 )
 
-func (s *service) AddSentinel(addr string) error {
+// AddSentinel adds the sentinel, unless it breaks the quorum of a group, see
+// checkSentinelQuorum, or force is set.
+func (s *service) AddSentinel(addr string, force bool) error {
 	if len(addr) == 0 {
 		return errors.New("invalid sentinel address")
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.ha.mutex.Lock()
+	defer s.ha.mutex.Unlock()
+	defer s.dirtyStats()
 
+	// the servers of the sentinel change under s.ha.mutex only.
 	sentinel, err := s.sentinelMapper.Info()
 	if err != nil {
 		return err
@@ -27,104 +34,234 @@ func (s *service) AddSentinel(addr string) error {
 
 	for _, v := range sentinel.Servers {
 		if v == addr {
-			return fmt.Errorf("sentinel-[%s] already exists", addr)
+			return fmt.Errorf("sentinel-[%s] %w", addr, ErrExists)
+		}
+	}
+	if !force {
+		after := append(append([]string(nil), sentinel.Servers...), addr)
+		if err := s.checkSentinelQuorum(sentinel.Servers, after); err != nil {
+			return err
 		}
 	}
 
-	sentinelClient := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-	if err := sentinelClient.FlushConfig(addr, s.config.SentinelClientTimeout.Duration()); err != nil {
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	if err := sentinelClient.FlushConfig(addr, s.config().SentinelClientTimeout.Duration()); err != nil {
 		return err
 	}
 
-	sentinel.Servers = append(sentinel.Servers, addr)
-	sentinel.OutOfSync = true
-	return s.sentinelMapper.Update(sentinel)
+	return s.updateSentinel(func(sentinel *dao.Sentinel) error {
+		sentinel.Servers = append(sentinel.Servers, addr)
+		sentinel.OutOfSync = true
+		return nil
+	})
 }
 
+// DelSentinel removes the sentinel, force removes it even if it can't be reset
+// or it breaks the quorum of a group, see checkSentinelQuorum.
 func (s *service) DelSentinel(addr string, force bool) error {
 	if len(addr) == 0 {
 		return errors.New("invalid sentinel address")
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	sentinel, err := s.sentinelMapper.Info()
-	if err != nil {
-		return err
-	}
+	s.ha.mutex.Lock()
+	defer s.ha.mutex.Unlock()
+	defer s.dirtyStats()
 
-	index := -1
-	for i, v := range sentinel.Servers {
-		if v == addr {
-			index = i
-			break
+	if err := s.updateSentinel(func(sentinel *dao.Sentinel) error {
+		for i, v := range sentinel.Servers {
+			if v != addr {
+				continue
+			}
+			if !force {
+				after := append(append([]string(nil), sentinel.Servers[:i]...), sentinel.Servers[i+1:]...)
+				if err := s.checkSentinelQuorum(sentinel.Servers, after); err != nil {
+					return err
+				}
+			}
+			sentinel.OutOfSync = true
+			return nil
 		}
-	}
-	if index < 0 {
-		return fmt.Errorf("sentinel-[%s] not found", addr)
-	}
-
-	sentinel.OutOfSync = true
-	if err := s.sentinelMapper.Update(sentinel); err != nil {
+		return fmt.Errorf("sentinel-[%s] %w", addr, ErrNotFound)
+	}); err != nil {
 		return err
 	}
 
-	sentinelClient := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-	if err := sentinelClient.RemoveGroupsAll([]string{addr}, s.config.SentinelClientTimeout.Duration()); err != nil {
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	if err := sentinelClient.RemoveGroupsAll([]string{addr}, s.config().SentinelClientTimeout.Duration()); err != nil {
 		log.Warnln("service::DelSentinel remove sentinel", addr, "failed. err:", err)
 		if !force {
 			return fmt.Errorf("remove sentinel %s failed", addr)
 		}
 	}
 
-	sentinel.Servers = append(sentinel.Servers[:index], sentinel.Servers[index+1:]...)
+	return s.updateSentinel(func(sentinel *dao.Sentinel) error {
+		for i, v := range sentinel.Servers {
+			if v == addr {
+				sentinel.Servers = append(sentinel.Servers[:i], sentinel.Servers[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// updateSentinel stores the sentinel changed by fn, the sentinel is read and
+// stored under s.mutex.
+func (s *service) updateSentinel(fn func(sentinel *dao.Sentinel) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return err
+	}
+	if err := fn(sentinel); err != nil {
+		return err
+	}
 	return s.sentinelMapper.Update(sentinel)
 }
 
+// ResyncSentinels resyncs every sentinel and waits, the error reports every
+// sentinel which failed.
 func (s *service) ResyncSentinels() error {
+	r, err := s.submitResyncSentinels()
+	if err != nil {
+		return err
+	}
+	return r.wait()
+}
+
+func (s *service) SubmitResyncSentinels() (*dao.Job, error) {
+	r, err := s.submitResyncSentinels()
+	if err != nil {
+		return nil, err
+	}
+	return s.Job(r.job.ID)
+}
+
+func (s *service) submitResyncSentinels() (*jobRunner, error) {
+	s.ha.mutex.Lock()
+	defer s.ha.mutex.Unlock()
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	sentinel, err := s.sentinelMapper.Info()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sentinel.OutOfSync = true
 	if err := s.sentinelMapper.Update(sentinel); err != nil {
-		return err
+		return nil, err
 	}
 
-	config := &redis.MonitorConfig{
-		Quorum:               s.config.SentinelQuorum,
-		ParallelSyncs:        s.config.SentinelParallelSyncs,
-		DownAfter:            s.config.SentinelDownAfter.Duration(),
-		FailoverTimeout:      s.config.SentinelFailoverTimeout.Duration(),
-		NotificationScript:   s.config.SentinelNotificationScript,
-		ClientReconfigScript: s.config.SentinelClientReconfigScript,
+	masters := groups.GetMasters()
+	configs := s.monitorConfigs(groups, masters)
+	timeout := s.config().SentinelClientTimeout.Duration()
+
+	sentinelClient := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	return s.submitJob(&jobTask{
+		typ:      JobResyncSentinels,
+		targets:  sentinel.Servers,
+		parallel: true,
+		run: func(ctx context.Context, r *jobRunner, addr string) error {
+			// a cancelled job interrupts the sentinel commands.
+			stop := context.AfterFunc(ctx, sentinelClient.Cancel)
+			defer stop()
+
+			if err := sentinelClient.RemoveGroupsAll([]string{addr}, timeout); err != nil {
+				log.Errorln("service::ResyncSentinels remove sentinels failed. err:", err)
+				r.logf("%s remove groups failed: %s", addr, err.Error())
+			}
+			if err := sentinelClient.MonitorGroups([]string{addr}, timeout, configs, masters); err != nil {
+				log.Errorln("service::ResyncSentinels resync sentinels failed. err:", err)
+				return err
+			}
+			return nil
+		},
+		finish: func(ctx context.Context, r *jobRunner, err error) error {
+			if err != nil {
+				return err
+			}
+
+			s.ha.mutex.Lock()
+			defer s.ha.mutex.Unlock()
+			defer s.dirtyStats()
+
+			s.reWatchSentinels(sentinel.Servers)
+
+			return s.updateSentinel(func(current *dao.Sentinel) error {
+				if !reflect.DeepEqual(current.Servers, sentinel.Servers) {
+					return errors.New("sentinels changed while resyncing")
+				}
+				// a group promoted meanwhile is monitored with its old
+				// master, the sentinels stay out of sync.
+				groups, err := s.groupMapper.Info()
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(groups.GetMasters(), masters) {
+					return errors.New("masters changed while resyncing")
+				}
+				current.OutOfSync = false
+				return nil
+			})
+		},
+	})
+}
+
+// monitorConfigs returns the monitor configs of the groups of the masters.
+func (s *service) monitorConfigs(groups dao.Groups, masters map[string]string) map[string]*redis.MonitorConfig {
+	configs := make(map[string]*redis.MonitorConfig, len(masters))
+	for groupName := range masters {
+		configs[groupName] = s.monitorConfig(groups[groupName])
 	}
+	return configs
+}
 
-	sentinelClient := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-	if err := sentinelClient.RemoveGroupsAll(sentinel.Servers, s.config.SentinelClientTimeout.Duration()); err != nil {
-		log.Errorln("service::ResyncSentinels remove sentinels failed. err:", err)
+// monitorConfig returns the config of the master of the group monitored by
+// the sentinels, the sentinel params of the group override the dashboard ones.
+func (s *service) monitorConfig(g *dao.Group) *redis.MonitorConfig {
+	c := s.config()
+	config := &redis.MonitorConfig{
+		Quorum:               c.SentinelQuorum,
+		ParallelSyncs:        c.SentinelParallelSyncs,
+		DownAfter:            c.SentinelDownAfter.Duration(),
+		FailoverTimeout:      c.SentinelFailoverTimeout.Duration(),
+		NotificationScript:   c.SentinelNotificationScript,
+		ClientReconfigScript: c.SentinelClientReconfigScript,
 	}
-	if err := sentinelClient.MonitorGroups(sentinel.Servers, s.config.SentinelClientTimeout.Duration(), config, groups.GetMasters()); err != nil {
-		log.Errorln("service::ResyncSentinels resync sentinels failed. err:", err)
-		return err
+	p := g.SentinelParams
+	if p == nil {
+		return config
 	}
-	s.reWatchSentinels(sentinel.Servers)
-
-	sentinel.OutOfSync = false
-	return s.sentinelMapper.Update(sentinel)
+	if p.Quorum != 0 {
+		config.Quorum = p.Quorum
+	}
+	if p.ParallelSyncs != 0 {
+		config.ParallelSyncs = p.ParallelSyncs
+	}
+	if p.DownAfter != 0 {
+		config.DownAfter = p.DownAfter.Duration()
+	}
+	if p.FailoverTimeout != 0 {
+		config.FailoverTimeout = p.FailoverTimeout.Duration()
+	}
+	if p.NotificationScript != "" {
+		config.NotificationScript = p.NotificationScript
+	}
+	if p.ClientReconfigScript != "" {
+		config.ClientReconfigScript = p.ClientReconfigScript
+	}
+	return config
 }
 
 func (s *service) SentinelInfo(addr string) ([]byte, error) {
-	c, err := redis.NewClientNoAuth(addr, time.Second)
+	c, err := redis.NewClientOptions(addr, s.redisOptions().Sentinel(), time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -138,14 +275,15 @@ func (s *service) SentinelInfo(addr string) ([]byte, error) {
 }
 
 func (s *service) SentinelMonitoredInfo(addr string) (interface{}, error) {
-	sentinel := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-	if info, err := sentinel.MastersAndSlaves(addr, s.config.SentinelClientTimeout.Duration()); err != nil {
+	sentinel := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	if info, err := sentinel.MastersAndSlaves(addr, s.config().SentinelClientTimeout.Duration()); err != nil {
 		return nil, err
 	} else {
 		return info, nil
 	}
 }
 
+// reWatchSentinels must be called with s.ha.mutex held.
 func (s *service) reWatchSentinels(servers []string) {
 	if s.ha.monitor != nil {
 		s.ha.monitor.Cancel()
@@ -153,11 +291,17 @@ func (s *service) reWatchSentinels(servers []string) {
 	}
 
 	if len(servers) == 0 {
+		s.stats.mutex.Lock()
 		s.ha.masters = nil
+		s.stats.mutex.Unlock()
 	} else {
-		s.ha.monitor = redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
+		s.ha.monitor = redis.NewSentinel(s.config().ProductName, s.redisOptions())
 		s.ha.monitor.LogFunc = log.Warnf
 		s.ha.monitor.ErrFunc = log.Errorf
+		s.ha.monitor.EventFunc = func(e *redis.SentinelEvent) {
+			s.recordSentinelEvent(e)
+			s.failovers.notify(e)
+		}
 
 		go func(p *redis.Sentinel) {
 			var trigger = make(chan struct{}, 1)
@@ -171,8 +315,9 @@ func (s *service) reWatchSentinels(servers []string) {
 				}
 			}
 
+			// trigger is never closed, a late callback of a subscription may
+			// still fire after the loop below exits.
 			go func() {
-				defer close(trigger)
 				callback := func() {
 					select {
 					case trigger <- struct{}{}:
@@ -191,7 +336,12 @@ func (s *service) reWatchSentinels(servers []string) {
 			}()
 
 			go func() {
-				for range trigger {
+				for {
+					select {
+					case <-p.Context.Done():
+						return
+					case <-trigger:
+					}
 					var success int
 					for i := 0; i != 10 && !p.IsCanceled() && success != 2; i++ {
 						timeout := time.Second * 5
@@ -200,7 +350,7 @@ func (s *service) reWatchSentinels(servers []string) {
 							log.Errorln("service::reWatchSentinels fetch group masters failed. err:", err)
 						} else {
 							if !p.IsCanceled() {
-								s.switchMasters(masters)
+								s.switchMasters(p, masters)
 							}
 							success += 1
 						}
@@ -214,32 +364,48 @@ func (s *service) reWatchSentinels(servers []string) {
 	log.Infoln("service::reWatchSentinels reWatch sentinels:", servers)
 }
 
-func (s *service) switchMasters(masters map[string]string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
+func (s *service) switchMasters(p *redis.Sentinel, masters map[string]string) error {
 	if atomic.LoadInt32(&s.closed) == 1 {
 		return ErrClosedTopom
 	}
-
-	s.ha.masters = masters
+	defer s.dirtyStats()
+
+	// the groups promoted meanwhile are deleted from ha.masters, the loop
+	// below ranges over masters without the lock.
+	s.stats.mutex.Lock()
+	s.ha.masters = make(map[string]string, len(masters))
+	for groupName, masterAddr := range masters {
+		s.ha.masters[groupName] = masterAddr
+	}
+	s.stats.mutex.Unlock()
 	if len(masters) > 0 {
 		cache := &redis.InfoCache{
-			Auth:    s.config.ProductAuth,
+			Options: s.redisOptions(),
 			Timeout: 100 * time.Millisecond,
 		}
 
 		for groupName, masterAddr := range masters {
-			if err := s.trySwitchGroupMaster(groupName, masterAddr, cache); err != nil {
+			if err := s.trySwitchGroupMaster(p, groupName, masterAddr, cache); err != nil {
 				log.Errorln("service::SwitchMasters sentinel switch group master failed. err:", err)
 			}
+			s.events.switchMaster(groupName, masterAddr)
 		}
 	}
 
 	return nil
 }
 
-func (s *service) trySwitchGroupMaster(groupName, masterAddr string, cache *redis.InfoCache) error {
+// trySwitchGroupMaster looks the master up among the servers of the group
+// with only the group locked, the run ids may take a call to every server.
+// The masters fetched by a watch cancelled meanwhile are dropped, a promotion
+// cancels the watch before it unlocks the group.
+func (s *service) trySwitchGroupMaster(p *redis.Sentinel, groupName, masterAddr string, cache *redis.InfoCache) error {
+	defer s.lockGroup(groupName)()
+
+	if p.IsCanceled() {
+		return nil
+	}
+
 	groups, err := s.groupMapper.Info()
 	if err != nil {
 		return err
@@ -247,7 +413,7 @@ func (s *service) trySwitchGroupMaster(groupName, masterAddr string, cache *redi
 
 	g, ok := groups[groupName]
 	if !ok {
-		return fmt.Errorf("group-[%s] not found", groupName)
+		return fmt.Errorf("group-[%s] %w", groupName, ErrNotFound)
 	}
 
 	var index = func() int {
@@ -274,22 +440,24 @@ func (s *service) trySwitchGroupMaster(groupName, masterAddr string, cache *redi
 
 	log.Warnf("group-[%s] will switch master to server[%d] = %s", groupName, index, g.Servers[index].Addr)
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	g.Servers[0], g.Servers[index] = g.Servers[index], g.Servers[0]
 	g.OutOfSync = true
-	if err := s.groupMapper.Update(g); err != nil {
+	txn := dao.NewTxn()
+	txn.UpdateGroup(g)
+	refreshErr := s.refreshGSLBBackendInfo(txn)
+	if err := s.txnMapper.Commit(txn); err != nil {
 		return err
 	}
-	return s.refreshGSLBBackendInfo()
+	return refreshErr
 }
 
-func (s *service) outOfSyncBySentinel() error {
+func (s *service) outOfSyncBySentinel(txn *dao.Txn) {
 	sentinel, err := s.sentinelMapper.Info()
 	if err == nil && len(sentinel.Servers) != 0 {
 		sentinel.OutOfSync = true
-		if err := s.sentinelMapper.Update(sentinel); err != nil {
-			return fmt.Errorf("update sentinel fail. err:%s", err.Error())
-		}
+		txn.UpdateSentinel(sentinel)
 	}
-
-	return nil
 }
@@ -0,0 +1,108 @@
+package topom
+
+import (
+	"testing"
+
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func TestAddSentinelUnreachable(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.AddSentinel("", false); err == nil {
+		t.Fatal("add empty sentinel address")
+	}
+	if err := e.AddSentinel(testServer1, false); err == nil {
+		t.Fatal("add unreachable sentinel")
+	}
+	if s := e.storedSentinel(t); len(s.Servers) != 0 {
+		t.Fatalf("unexpected sentinels %v", s.Servers)
+	}
+}
+
+func TestDelSentinel(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.sentinelMapper.Update(&dao.Sentinel{Servers: []string{testServer1, testServer2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.DelSentinel(testServer3, true); err == nil {
+		t.Fatal("delete missing sentinel")
+	}
+	if err := e.DelSentinel(testServer1, false); err == nil {
+		t.Fatal("delete unreachable sentinel without force")
+	}
+	if err := e.DelSentinel(testServer1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	s := e.storedSentinel(t)
+	if len(s.Servers) != 1 || s.Servers[0] != testServer2 {
+		t.Fatalf("unexpected sentinels %v", s.Servers)
+	}
+	if !s.OutOfSync {
+		t.Fatal("sentinel should be out of sync after delete")
+	}
+}
+
+func TestResyncSentinelsUnreachable(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.sentinelMapper.Update(&dao.Sentinel{Servers: []string{testServer1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGroupServer("g1", testServer2); err != nil {
+		t.Fatal(err)
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel should be out of sync after group changed")
+	}
+
+	if err := e.ResyncSentinels(); err == nil {
+		t.Fatal("resync unreachable sentinels")
+	}
+	if s := e.storedSentinel(t); !s.OutOfSync {
+		t.Fatal("sentinel should stay out of sync after failed resync")
+	}
+}
+
+func TestSentinelAuth(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 1)
+	defer c.close()
+
+	for _, s := range c.servers {
+		s.SetUser("pikamgr", "secret")
+	}
+	c.sentinels[0].SetUser("sentinel", "hidden")
+	e.setRedisOptions(&redis.Options{Username: "pikamgr", Password: "secret"})
+
+	if err := e.AddSentinel(c.sentinels[0].Addr(), false); err == nil {
+		t.Fatal("add sentinel without its auth")
+	}
+	e.setRedisOptions(&redis.Options{
+		Username: "pikamgr", Password: "secret",
+		SentinelUsername: "sentinel", SentinelPassword: "hidden",
+	})
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := c.sentinels[0].MasterOptions(testProduct + "-g1")
+	if opts["auth-pass"] != "secret" || opts["auth-user"] != "pikamgr" {
+		t.Fatalf("unexpected options %v", opts)
+	}
+	if _, err := e.SentinelInfo(c.sentinels[0].Addr()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,9 @@
 package topom
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
@@ -9,13 +12,22 @@ import (
 
 	"github.com/pourer/pikamgr/config"
 	"github.com/pourer/pikamgr/protocol"
+	"github.com/pourer/pikamgr/topom/archive"
 	"github.com/pourer/pikamgr/topom/client/redis"
 	"github.com/pourer/pikamgr/topom/dao"
 	"github.com/pourer/pikamgr/utils/log"
+	"github.com/pourer/pikamgr/utils/tlsconfig"
 )
 
 var ErrClosedTopom = errors.New("use of closed topom")
 
+// ErrNotFound and ErrExists are wrapped by the errors of a missing model and
+// of a model which already exists.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
 type TopomMapper interface {
 	Create() error
 	Delete() error
@@ -40,33 +52,104 @@ type GSLBMapper interface {
 	Info() (dao.GSLBs, error)
 }
 
+type JobMapper interface {
+	Update(j *dao.Job) error
+	Remove(id string) error
+	Info() (dao.Jobs, error)
+}
+
+type SentinelEventMapper interface {
+	Update(events dao.SentinelEvents) error
+	Info() (dao.SentinelEvents, error)
+}
+
 type TemplateFileMapper interface {
 	Info() (dao.TemplateFiles, error)
+	SetInterval(interval time.Duration)
+}
+
+// TxnMapper commits the group, sentinel and gslb changes of one operation
+// atomically.
+type TxnMapper interface {
+	Commit(txn *dao.Txn) error
 }
 
 type service struct {
-	config         *config.DashboardConfig
+	// configs holds the *config.DashboardConfig in use, it's replaced as a
+	// whole under configMutex once the config is reloaded, see reload.go.
+	configs        atomic.Value
+	configMutex    sync.Mutex
 	topomMapper    TopomMapper
 	groupMapper    GroupMapper
 	sentinelMapper SentinelMapper
 	gslbMapper     GSLBMapper
 	tfMapper       TemplateFileMapper
+	txnMapper      TxnMapper
+	jobMapper      JobMapper
+	// sentinelEventMapper persists the timeline, see timeline.go.
+	sentinelEventMapper SentinelEventMapper
 
 	stats struct {
-		redisp  *redis.Pool
-		servers map[string]*RedisStats
+		redisp    *redis.Pool
+		collector *collector
+		servers   map[string]*RedisStats
+
+		// mutex guards servers, gslbs.Stats and ha.masters.
+		mutex    sync.Mutex
+		publish  sync.Mutex
+		snapshot atomic.Value
+		version  int64
+		encoded  []byte
+		dirty    int32
 	}
 
 	ha struct {
+		// mutex serializes the sentinel operations and guards monitor.
+		mutex   sync.Mutex
 		redisp  *redis.Pool
 		monitor *redis.Sentinel
 		masters map[string]string
 	}
 
 	gslbs struct {
+		// mutex serializes the gslb operations.
+		mutex sync.Mutex
 		Stats map[string]*GSLBStats
 	}
 
+	// groupLocks serializes the operations of each group, see lock.go for
+	// the order of the locks.
+	groupLocks groupLocks
+
+	events   *eventHub
+	keyspace keyspaceScans
+
+	failovers failoverWaiters
+
+	timeline struct {
+		// mutex guards events and dirty, events is the timeline of the
+		// sentinel events, the oldest first.
+		mutex  sync.Mutex
+		events dao.SentinelEvents
+		dirty  bool
+	}
+
+	auth struct {
+		// options are the *redis.Options of the connections to the servers.
+		options  atomic.Value
+		rotating int32
+	}
+
+	jobs struct {
+		mutex   sync.Mutex
+		running map[string]*jobRunner
+		lastID  int64
+		closed  bool
+		wg      sync.WaitGroup
+	}
+
+	// mutex guards the read-modify-commit of the models, it's never held
+	// while calling a Pika server or a sentinel.
 	mutex                   *sync.Mutex
 	started, closed, online int32
 	done                    chan struct{}
@@ -74,31 +157,78 @@ type service struct {
 }
 
 func NewService(config *config.DashboardConfig, topomMapper TopomMapper, groupMapper GroupMapper, sentinelMapper SentinelMapper,
-	gslbMapper GSLBMapper, tfMapper TemplateFileMapper) (*service, error) {
+	gslbMapper GSLBMapper, tfMapper TemplateFileMapper, txnMapper TxnMapper, jobMapper JobMapper,
+	sentinelEventMapper SentinelEventMapper) (*service, error) {
 	s := &service{
-		config:         config,
-		topomMapper:    topomMapper,
-		groupMapper:    groupMapper,
-		sentinelMapper: sentinelMapper,
-		gslbMapper:     gslbMapper,
-		tfMapper:       tfMapper,
-		mutex:          new(sync.Mutex),
-		done:           make(chan struct{}),
-	}
-
-	s.ha.redisp = redis.NewPool("", time.Second*5)
-	s.stats.redisp = redis.NewPool(config.ProductAuth, time.Second*5)
+		topomMapper:         topomMapper,
+		groupMapper:         groupMapper,
+		sentinelMapper:      sentinelMapper,
+		gslbMapper:          gslbMapper,
+		tfMapper:            tfMapper,
+		txnMapper:           txnMapper,
+		jobMapper:           jobMapper,
+		sentinelEventMapper: sentinelEventMapper,
+		mutex:               new(sync.Mutex),
+		done:                make(chan struct{}),
+	}
+	s.configs.Store(config)
+
+	options, err := newRedisOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	s.auth.options.Store(options)
+	s.ha.redisp = redis.NewPool(options.Sentinel(), time.Second*5)
+	s.stats.redisp = redis.NewPool(options, time.Second*5)
 	s.stats.servers = make(map[string]*RedisStats)
+	s.stats.collector = newCollector(config.StatsWorkers, config.StatsInterval.Duration(), config.StatsFullInterval.Duration(),
+		config.StatsTimeout.Duration(), config.StatsMaxBackoff.Duration(), s.probeStats)
+	s.jobs.running = make(map[string]*jobRunner)
+	s.events = newEventHub()
 
 	return s, nil
 }
 
+// newRedisOptions returns the options of the connections to the Pika servers
+// and the sentinels.
+func newRedisOptions(config *config.DashboardConfig) (*redis.Options, error) {
+	options := &redis.Options{
+		Username:         config.ProductUser,
+		Password:         config.ProductAuth,
+		SentinelUsername: config.SentinelUser,
+		SentinelPassword: config.SentinelAuth,
+	}
+	if config.RedisTLS {
+		tlsConfig, err := tlsconfig.ClientConfig(config.RedisTLSCAFile, config.RedisTLSCertFile, config.RedisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls config of redis fail. err-[%s]", err.Error())
+		}
+		tlsConfig.ServerName = config.RedisTLSServerName
+		options.TLS = tlsConfig
+	}
+	return options, nil
+}
+
 func (s *service) Close() error {
 	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
 		return nil
 	}
+	s.closeJobs()
 	close(s.done)
 	s.wg.Wait()
+	s.dirtyStats()
+	s.events.close()
+
+	s.ha.mutex.Lock()
+	if s.ha.monitor != nil {
+		s.ha.monitor.Cancel()
+	}
+	s.ha.mutex.Unlock()
+	if atomic.LoadInt32(&s.online) == 1 {
+		if err := s.flushTimeline(); err != nil {
+			log.Errorln("service::Close flush sentinel events fail. err:", err)
+		}
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -111,8 +241,8 @@ func (s *service) Close() error {
 	}
 
 	if err := s.topomMapper.Delete(); err != nil {
-		log.Errorln(err, "service::Close delete topom faild. productName:", s.config.ProductName, "err:", err)
-		return fmt.Errorf("delete topom faild. productName-[%s]", s.config.ProductName)
+		log.Errorln(err, "service::Close delete topom faild. productName:", s.config().ProductName, "err:", err)
+		return fmt.Errorf("delete topom faild. productName-[%s]", s.config().ProductName)
 	}
 	atomic.StoreInt32(&s.online, 0)
 
@@ -152,16 +282,22 @@ LOOP:
 		case <-time.After(2 * time.Second):
 		}
 	}
+	if err := s.loadTimeline(); err != nil {
+		return err
+	}
 	atomic.StoreInt32(&s.online, 1)
+	s.abandonJobs()
 
 	sentinel, err := s.sentinelMapper.Info()
 	if err != nil {
 		return err
 	}
+	s.ha.mutex.Lock()
 	s.reWatchSentinels(sentinel.Servers)
+	s.ha.mutex.Unlock()
 
 	s.wg.Add(1)
-	go s.doStats(time.Second)
+	go s.doStats()
 	return nil
 }
 
@@ -177,11 +313,12 @@ func (s *service) Overview() (*protocol.Overview, error) {
 	}
 
 	return &protocol.Overview{
-		Version: config.Version,
-		Compile: config.Compile,
-		Config:  s.config,
-		Model:   topom,
-		Stats:   stats,
+		Version:   config.Version,
+		Compile:   config.Compile,
+		Config:    s.config().Redacted(),
+		Model:     topom,
+		Stats:     stats,
+		Collector: s.CollectorStats(),
 	}, nil
 }
 
@@ -204,9 +341,71 @@ func (s *service) Topom() (*protocol.Topom, error) {
 	}, nil
 }
 
+// Stats returns the stats of the last snapshot, they're shared and must not
+// be modified.
 func (s *service) Stats() (*protocol.Stats, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	snap, err := s.StatsSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap.Stats, nil
+}
+
+// StatsSnapshot returns the last snapshot of the stats, it's published by
+// doStats once per round and by the first call after a change of the models.
+func (s *service) StatsSnapshot() (*protocol.StatsSnapshot, error) {
+	if snap, ok := s.stats.snapshot.Load().(*protocol.StatsSnapshot); ok && atomic.LoadInt32(&s.stats.dirty) == 0 {
+		return snap, nil
+	}
+	return s.publishStats()
+}
+
+// dirtyStats makes the next StatsSnapshot publish a new snapshot, it's called
+// once the models are changed.
+func (s *service) dirtyStats() {
+	atomic.StoreInt32(&s.stats.dirty, 1)
+}
+
+// publishStats builds the stats and publishes them as a new snapshot if they
+// changed, the version of the snapshot is bumped then. Neither the mappers
+// nor the stats are read under s.mutex, an operation in progress doesn't
+// hold the stats back.
+func (s *service) publishStats() (*protocol.StatsSnapshot, error) {
+	s.stats.publish.Lock()
+	defer s.stats.publish.Unlock()
+
+	atomic.StoreInt32(&s.stats.dirty, 0)
+	stats, err := s.buildStats()
+	if err != nil {
+		atomic.StoreInt32(&s.stats.dirty, 1)
+		return nil, err
+	}
+
+	last, _ := s.stats.snapshot.Load().(*protocol.StatsSnapshot)
+	encoded, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil && bytes.Equal(encoded, s.stats.encoded) {
+		return last, nil
+	}
+
+	s.stats.version++
+	s.stats.encoded = encoded
+	snap := &protocol.StatsSnapshot{Version: s.stats.version, Stats: stats}
+	s.stats.snapshot.Store(snap)
+	s.events.publishStats(stats)
+	return snap, nil
+}
+
+func (s *service) buildStats() (*protocol.Stats, error) {
+	s.stats.mutex.Lock()
+	servers, gslbStats := s.stats.servers, s.gslbs.Stats
+	masters := make(map[string]string, len(s.ha.masters))
+	for gName, addr := range s.ha.masters {
+		masters[gName] = addr
+	}
+	s.stats.mutex.Unlock()
 
 	groups, err := s.groupMapper.Info()
 	if err != nil {
@@ -246,13 +445,17 @@ func (s *service) Stats() (*protocol.Stats, error) {
 		}
 		pg.Promoting.Index = g.Promoting.Index
 		pg.Promoting.State = g.Promoting.State
+		if g.SentinelParams != nil {
+			p := protocol.SentinelParams(*g.SentinelParams)
+			pg.SentinelParams = &p
+		}
 		stats.Group.Models = append(stats.Group.Models, pg)
 	}
 
 	stats.Group.Stats = make(map[string]*protocol.RedisStats)
 	for _, g := range groups {
 		for _, v := range g.Servers {
-			if vv := s.stats.servers[v.Addr]; vv != nil {
+			if vv := servers[v.Addr]; vv != nil {
 				pr := &protocol.RedisStats{
 					Error:    vv.Error,
 					Stats:    vv.Stats,
@@ -266,6 +469,7 @@ func (s *service) Stats() (*protocol.Stats, error) {
 						Slaves: v.Slaves,
 					}
 				}
+				pr.KeySpace = s.keyspace.state(v.Addr, s.config().KeyspaceScanWindow.Duration())
 				stats.Group.Stats[v.Addr] = pr
 			}
 		}
@@ -277,7 +481,7 @@ func (s *service) Stats() (*protocol.Stats, error) {
 	}
 	stats.HA.Stats = make(map[string]*protocol.RedisStats)
 	for _, server := range sentinel.Servers {
-		if vv, ok := s.stats.servers[server]; ok && vv != nil {
+		if vv, ok := servers[server]; ok && vv != nil {
 			pr := &protocol.RedisStats{
 				Error:    vv.Error,
 				Stats:    vv.Stats,
@@ -291,22 +495,19 @@ func (s *service) Stats() (*protocol.Stats, error) {
 					Slaves: v.Slaves,
 				}
 			}
+			pr.Quorum = vv.Quorum
 			stats.HA.Stats[server] = pr
 		}
 	}
-	stats.HA.Masters = make(map[string]string)
-	if s.ha.masters != nil {
-		for gName, addr := range s.ha.masters {
-			stats.HA.Masters[gName] = addr
-		}
-	}
+	stats.HA.Masters = masters
+	stats.HA.Warnings = s.sentinelWarnings(groups, sentinel.Servers, servers)
 
 	stats.GSLB.Models = make(map[string]*protocol.GSLB)
 	stats.GSLB.Stats = make(map[string]*protocol.GSLBStats)
 	for _, v := range gslbs {
 		stats.GSLB.Models[v.Name] = &protocol.GSLB{Servers: v.Servers}
 		for _, server := range v.Servers {
-			if vv, ok := s.gslbs.Stats[server]; ok && vv != nil {
+			if vv, ok := gslbStats[server]; ok && vv != nil {
 				stats.GSLB.Stats[server] = &protocol.GSLBStats{
 					Error:    vv.Error,
 					UnixTime: vv.UnixTime,
@@ -332,39 +533,71 @@ func (s *service) ViewTemplateFile(fileName string) ([]byte, error) {
 
 	tf, ok := tfs[fileName]
 	if !ok {
-		return nil, fmt.Errorf("templateFile-[%s] not found", fileName)
+		return nil, fmt.Errorf("templateFile-[%s] %w", fileName, ErrNotFound)
 	}
 
 	return tf.Data, nil
 }
 
-func (s *service) doStats(timeout time.Duration) {
+func (s *service) Export() (*archive.Archive, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	gslbs, err := s.gslbMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	tfs, err := s.tfMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.New(s.config().ProductName, groups, sentinel, gslbs, tfs), nil
+}
+
+func (s *service) doStats() {
 	defer s.wg.Done()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	interval := s.config().StatsInterval.Duration()
 	for {
 		select {
 		case <-s.done:
 			return
 		default:
 		}
+		start := time.Now()
 
 		var wg sync.WaitGroup
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
 
-			w, err := s.refreshRedisStats(timeout)
-			if err != nil {
+			if err := s.refreshRedisStats(ctx); err != nil {
 				log.Errorln("service::doStats refreshRedisStats fail. err:", err)
 			}
-			if w != nil {
-				w.Wait()
-			}
 		}()
 		go func() {
 			defer wg.Done()
 
-			w, err := s.refreshGSLBStats(timeout)
+			w, err := s.refreshGSLBStats(s.config().StatsTimeout.Duration())
 			if err != nil {
 				log.Errorln("service::doStats refreshGSLBStats fail. err:", err)
 			}
@@ -376,15 +609,27 @@ func (s *service) doStats(timeout time.Duration) {
 		wg.Wait()
 
 		s.mutex.Lock()
-		if err := s.refreshGSLBBackendInfo(); err != nil {
+		txn := dao.NewTxn()
+		if err := s.refreshGSLBBackendInfo(txn); err != nil {
 			log.Errorln("service::doStats refreshGSLBBackendInfo fail. err:", err)
 		}
+		if err := s.txnMapper.Commit(txn); err != nil {
+			log.Errorln("service::doStats commit gslb backends fail. err:", err)
+		}
 		s.mutex.Unlock()
 
+		if _, err := s.publishStats(); err != nil {
+			log.Errorln("service::doStats publishStats fail. err:", err)
+		}
+		if err := s.flushTimeline(); err != nil {
+			log.Errorln("service::doStats flush sentinel events fail. err:", err)
+		}
+
+		// a round starts every interval, or right after a longer one.
 		select {
 		case <-s.done:
 			return
-		case <-time.After(timeout):
+		case <-time.After(interval - time.Since(start)):
 		}
 	}
 }
@@ -0,0 +1,239 @@
+package topom
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pourer/pikamgr/config"
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/coordinate/memory"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/topom/dao/mapper"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+const testProduct = "test-product"
+
+// unreachable addresses, every redis or sentinel call to them fails fast.
+const (
+	testServer1 = "127.0.0.1:1"
+	testServer2 = "127.0.0.1:2"
+	testServer3 = "127.0.0.1:3"
+)
+
+type testEnv struct {
+	*service
+	client *memory.Client
+	dir    string
+}
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.Lerror)
+	os.Exit(m.Run())
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	dir, err := ioutil.TempDir("", "pikamgr-topom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.NewDashboardDefaultConfig()
+	c.ProductName = testProduct
+	c.TemplateFileScanDir = filepath.Join(dir, "*.conf")
+
+	client := memory.New()
+	topomMapper, err := mapper.NewTopomMapper(testProduct, "127.0.0.1:18080", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupMapper, err := mapper.NewGroupMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sentinelMapper, err := mapper.NewSentinelMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gslbMapper, err := mapper.NewGSLBMapper(testProduct, client, groupMapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfMapper, err := mapper.NewTemplateFileMapper(client, c.TemplateFileScanDir, c.TemplateFileScanInterval.Duration())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txnMapper := mapper.NewTxnMapper(client, groupMapper, sentinelMapper, gslbMapper)
+	jobMapper, err := mapper.NewJobMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentinelEventMapper, err := mapper.NewSentinelEventMapper(testProduct, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewService(c, topomMapper, groupMapper, sentinelMapper, gslbMapper, tfMapper, txnMapper, jobMapper, sentinelEventMapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{service: s, client: client, dir: dir}
+}
+
+func (e *testEnv) close() {
+	e.service.Close()
+	for _, m := range []interface{}{e.groupMapper, e.sentinelMapper, e.gslbMapper, e.tfMapper, e.jobMapper, e.sentinelEventMapper} {
+		m.(io.Closer).Close()
+	}
+	e.client.Close()
+	os.RemoveAll(e.dir)
+}
+
+// storedGroup reads the group back from the coordinator, not the mapper cache.
+func (e *testEnv) storedGroup(t *testing.T, name string) *dao.Group {
+	data, err := e.client.Read(coordinate.GroupPath(testProduct, name), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil {
+		return nil
+	}
+	g := &dao.Group{}
+	if err := g.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func (e *testEnv) storedSentinel(t *testing.T) *dao.Sentinel {
+	data, err := e.client.Read(coordinate.SentinelPath(testProduct), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dao.Sentinel{}
+	if data != nil {
+		if err := s.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestStartClose(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !e.IsOnline() {
+		t.Fatal("service not online after start")
+	}
+	if data, _ := e.client.Read(coordinate.TopomPath(testProduct), false); data == nil {
+		t.Fatal("topom node not created")
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e.IsOnline() {
+		t.Fatal("service still online after close")
+	}
+	if data, _ := e.client.Read(coordinate.TopomPath(testProduct), false); data != nil {
+		t.Fatal("topom node not deleted")
+	}
+	if err := e.Start(); err != ErrClosedTopom {
+		t.Fatalf("start closed service, err = %v", err)
+	}
+}
+
+func TestStatsAndExport(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddGroupServer("g1", testServer1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := e.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Group.Models) != 1 || stats.Group.Models[0].Name != "g1" {
+		t.Fatalf("unexpected group models %+v", stats.Group.Models)
+	}
+	if len(stats.Group.Models[0].Servers) != 1 || stats.Group.Models[0].Servers[0].Addr != testServer1 {
+		t.Fatalf("unexpected group servers %+v", stats.Group.Models[0].Servers)
+	}
+
+	a, err := e.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ProductName != testProduct || len(a.Groups) != 1 || a.Groups[0].Name != "g1" {
+		t.Fatalf("unexpected archive %+v", a)
+	}
+}
+
+func TestOverviewRedacted(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	e.config().CoordinatorAuth, e.config().ProductAuth, e.config().SentinelAuth = "leaked-1", "leaked-2", "leaked-3"
+
+	o, err := e.Overview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "leaked-") {
+		t.Fatalf("a secret is shown: %s", b)
+	}
+	if e.config().CoordinatorAuth != "leaked-1" {
+		t.Fatal("the config is redacted")
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	s1, err := e.StatsSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2, err := e.StatsSnapshot(); err != nil || s2 != s1 {
+		t.Fatalf("unchanged snapshot = %+v, %v", s2, err)
+	}
+
+	if err := e.CreateGroup("g1", 6001, 6002); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := e.StatsSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.Version <= s1.Version || len(s2.Stats.Group.Models) != 1 {
+		t.Fatalf("snapshot after create group = %+v", s2)
+	}
+
+	// an operation in progress doesn't hold the snapshot back.
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.dirtyStats()
+	if _, err := e.StatsSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+}
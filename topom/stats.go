@@ -1,8 +1,10 @@
 package topom
 
 import (
+	"context"
 	"time"
 
+	"github.com/pourer/pikamgr/protocol"
 	"github.com/pourer/pikamgr/topom/client/gslb"
 	"github.com/pourer/pikamgr/topom/client/redis"
 
@@ -13,6 +15,8 @@ type RedisStats struct {
 	Error    error
 	Stats    map[string]string
 	Sentinel map[string]*redis.SentinelGroup
+	// Quorum is the reply of SENTINEL CKQUORUM by group, see probeSentinel.
+	Quorum   map[string]string
 	UnixTime int64
 	Timeout  bool
 }
@@ -44,94 +48,122 @@ type GSLBStats struct {
 	Timeout  bool
 }
 
-func (s *service) newRedisStats(addr string, timeout time.Duration, do func(addr string) (*RedisStats, error)) *RedisStats {
-	var ch = make(chan struct{})
-	stats := &RedisStats{}
+// refreshRedisStats runs a round of the collector over the servers of the
+// groups and the sentinels.
+func (s *service) refreshRedisStats(ctx context.Context) error {
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return err
+	}
+	sentinel, err := s.sentinelMapper.Info()
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		defer close(ch)
-		p, err := do(addr)
-		if err != nil {
-			stats.Error = err
-		} else {
-			stats.Stats, stats.Sentinel = p.Stats, p.Sentinel
+	servers := make(map[string]collectKind)
+	for _, g := range groups {
+		for _, x := range g.Servers {
+			servers[x.Addr] = collectPika
 		}
-	}()
-
-	select {
-	case <-ch:
-		return stats
-	case <-time.After(timeout):
-		return &RedisStats{Timeout: true}
 	}
+	for _, addr := range sentinel.Servers {
+		servers[addr] = collectSentinel
+	}
+
+	s.keyspace.retain(servers)
+	stats := s.stats.collector.collect(ctx, servers)
+	s.stats.mutex.Lock()
+	s.stats.servers = stats
+	s.stats.mutex.Unlock()
+	return nil
 }
 
-func (s *service) refreshRedisStats(timeout time.Duration) (*sync2.Future, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *service) probeStats(ctx context.Context, t *collectTarget, full bool) (*RedisStats, error) {
+	if t.kind == collectSentinel {
+		return s.probeSentinel(ctx, t.addr)
+	}
+	return s.probePika(ctx, t, full)
+}
 
-	groups, err := s.groupMapper.Info()
+// probePika probes the full INFO of the server, or its replication merged
+// into the last full one.
+func (s *service) probePika(ctx context.Context, t *collectTarget, full bool) (*RedisStats, error) {
+	c, err := s.stats.redisp.GetClientContext(ctx, t.addr)
 	if err != nil {
 		return nil, err
 	}
+	defer s.stats.redisp.PutClient(c)
+	defer context.AfterFunc(ctx, func() { c.Close() })()
 
-	sentinel, err := s.sentinelMapper.Info()
+	if full {
+		text, info, err := c.InfoFull()
+		if err != nil {
+			return nil, err
+		}
+		repl := redis.InfoSectionKeys(text, "Replication")
+		t.base = make(map[string]string, len(info))
+		for k, v := range info {
+			if !repl[k] && k != "master_addr" {
+				t.base[k] = v
+			}
+		}
+		return &RedisStats{Stats: info}, nil
+	}
+
+	repl, err := c.InfoReplication()
 	if err != nil {
 		return nil, err
 	}
+	info := make(map[string]string, len(t.base)+len(repl))
+	for k, v := range t.base {
+		info[k] = v
+	}
+	for k, v := range repl {
+		info[k] = v
+	}
+	return &RedisStats{Stats: info}, nil
+}
 
-	var fut sync2.Future
-	goStats := func(addr string, do func(addr string) (*RedisStats, error)) {
-		fut.Add()
-		go func() {
-			stats := s.newRedisStats(addr, timeout, do)
-			stats.UnixTime = time.Now().Unix()
-			fut.Done(addr, stats)
-		}()
+func (s *service) probeSentinel(ctx context.Context, addr string) (*RedisStats, error) {
+	c, err := s.ha.redisp.GetClientContext(ctx, addr)
+	if err != nil {
+		return nil, err
 	}
-	for _, g := range groups {
-		for _, x := range g.Servers {
-			goStats(x.Addr, func(addr string) (*RedisStats, error) {
-				_, m, err := s.stats.redisp.InfoFull(addr)
-				if err != nil {
-					return nil, err
-				}
-				return &RedisStats{Stats: m}, nil
-			})
-		}
+	defer s.ha.redisp.PutClient(c)
+	defer context.AfterFunc(ctx, func() { c.Close() })()
+
+	_, m, err := c.Info()
+	if err != nil {
+		return nil, err
 	}
-	for _, server := range sentinel.Servers {
-		goStats(server, func(addr string) (*RedisStats, error) {
-			c, err := s.ha.redisp.GetClient(addr)
-			if err != nil {
-				return nil, err
-			}
-			defer s.ha.redisp.PutClient(c)
-			_, m, err := c.Info()
-			if err != nil {
-				return nil, err
-			}
-			sentinel := redis.NewSentinel(s.config.ProductName, s.config.ProductAuth)
-			p, err := sentinel.MastersAndSlavesClient(c)
-			if err != nil {
-				return nil, err
-			}
-			return &RedisStats{Stats: m, Sentinel: p}, nil
-		})
+	sentinel := redis.NewSentinel(s.config().ProductName, s.redisOptions())
+	p, err := sentinel.MastersAndSlavesClient(c)
+	if err != nil {
+		return nil, err
 	}
 
-	go func() {
-		stats := make(map[string]*RedisStats)
-		for k, v := range fut.Wait() {
-			stats[k] = v.(*RedisStats)
+	// the quorum is checked for the groups the sentinel monitors, the other
+	// ones are reported by VerifySentinels.
+	groups, err := s.groupMapper.Info()
+	if err != nil {
+		return nil, err
+	}
+	var monitored []string
+	for groupName := range groups {
+		if p[sentinel.NodeName(groupName)] != nil {
+			monitored = append(monitored, groupName)
 		}
+	}
+	quorum, err := sentinel.CheckQuorumClient(c, monitored)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStats{Stats: m, Sentinel: p, Quorum: quorum}, nil
+}
 
-		s.mutex.Lock()
-		s.stats.servers = stats
-		s.mutex.Unlock()
-	}()
-
-	return &fut, nil
+// CollectorStats returns the timings of the last round of the collector.
+func (s *service) CollectorStats() *protocol.CollectorStats {
+	return s.stats.collector.stats()
 }
 
 func (s *service) newGSLBStats(addr string, timeout time.Duration, do func(addr string) (*GSLBStats, error)) *GSLBStats {
@@ -191,9 +223,9 @@ func (s *service) refreshGSLBStats(timeout time.Duration) (*sync2.Future, error)
 			stats[k] = v.(*GSLBStats)
 		}
 
-		s.mutex.Lock()
+		s.stats.mutex.Lock()
 		s.gslbs.Stats = stats
-		s.mutex.Unlock()
+		s.stats.mutex.Unlock()
 	}()
 
 	return &fut, nil
@@ -0,0 +1,98 @@
+package topom
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// The dashboard which owns the product records the events received by the
+// subscription to the sentinels, see reWatchSentinels, in the timeline. The
+// timeline is persisted by doStats once it changed, and kept to the last
+// dao.MaxSentinelEvents events.
+
+// loadTimeline takes over the timeline persisted by the previous owner.
+func (s *service) loadTimeline() error {
+	events, err := s.sentinelEventMapper.Info()
+	if err != nil {
+		return err
+	}
+	s.timeline.mutex.Lock()
+	defer s.timeline.mutex.Unlock()
+	s.timeline.events = events
+	return nil
+}
+
+func (s *service) recordSentinelEvent(e *redis.SentinelEvent) {
+	log.Infof("service::recordSentinelEvent sentinel-[%s] %s group-[%s] %s %s %s",
+		e.Sentinel, e.Type, e.Group, e.Instance, e.Addr, e.Details)
+
+	s.timeline.mutex.Lock()
+	defer s.timeline.mutex.Unlock()
+	s.timeline.events = append(s.timeline.events, &dao.SentinelEvent{
+		Time:     time.Now().Format("2006-01-02 15:04:05.000"),
+		Sentinel: e.Sentinel,
+		Type:     e.Type,
+		Group:    e.Group,
+		Instance: e.Instance,
+		Addr:     e.Addr,
+		Master:   e.Master,
+		Details:  e.Details,
+	})
+	if n := len(s.timeline.events) - dao.MaxSentinelEvents; n > 0 {
+		s.timeline.events = append(dao.SentinelEvents(nil), s.timeline.events[n:]...)
+	}
+	s.timeline.dirty = true
+}
+
+// flushTimeline persists the timeline if it changed, it's never called
+// concurrently.
+func (s *service) flushTimeline() error {
+	s.timeline.mutex.Lock()
+	if !s.timeline.dirty {
+		s.timeline.mutex.Unlock()
+		return nil
+	}
+	events := s.timeline.events.Clone()
+	s.timeline.dirty = false
+	s.timeline.mutex.Unlock()
+
+	if err := s.sentinelEventMapper.Update(events); err != nil {
+		s.timeline.mutex.Lock()
+		s.timeline.dirty = true
+		s.timeline.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// SentinelEvents returns the events of the timeline, the newest first, only
+// the ones of the group if it's set. limit caps the events unless it's 0. A
+// dashboard which doesn't own the product returns the persisted timeline.
+func (s *service) SentinelEvents(groupName string, limit int) ([]*dao.SentinelEvent, error) {
+	var events dao.SentinelEvents
+	if atomic.LoadInt32(&s.online) == 1 {
+		s.timeline.mutex.Lock()
+		events = s.timeline.events.Clone()
+		s.timeline.mutex.Unlock()
+	} else {
+		var err error
+		if events, err = s.sentinelEventMapper.Info(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []*dao.SentinelEvent{}
+	for i := len(events) - 1; i >= 0; i-- {
+		if limit != 0 && len(results) == limit {
+			break
+		}
+		if groupName == "" || events[i].Group == groupName {
+			results = append(results, events[i])
+		}
+	}
+	return results, nil
+}
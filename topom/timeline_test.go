@@ -0,0 +1,133 @@
+package topom
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pourer/pikamgr/coordinate"
+	"github.com/pourer/pikamgr/topom/client/redis"
+	"github.com/pourer/pikamgr/topom/dao"
+)
+
+func TestSentinelEvents(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	c := newTestCluster(t, 2, 3)
+	defer c.close()
+
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	e.setupGroup(t, c)
+	if err := e.ResyncSentinels(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "sentinel subscriptions", func() bool {
+		for _, s := range c.sentinels {
+			if s.Subscribers("+odown") == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	master, slave := c.servers[0], c.servers[1]
+	name := testProduct + "-g1"
+	c.sentinels[1].Publish("+sdown", "master other-g1 127.0.0.1 7000")
+	c.sentinels[1].Publish("+odown", "master "+name+" "+strings.Replace(master.Addr(), ":", " ", 1)+" #quorum 2/2")
+	waitFor(t, 5*time.Second, "the +odown event", func() bool {
+		events, _ := e.SentinelEvents("g1", 0)
+		return len(events) == 1
+	})
+	if err := c.sentinels[0].Failover(name); err != nil {
+		t.Fatal(err)
+	}
+
+	var switches int
+	waitFor(t, 5*time.Second, "the events of the failover", func() bool {
+		events, err := e.SentinelEvents("g1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switches = 0
+		for _, ev := range events {
+			if ev.Type == "+switch-master" {
+				switches++
+			}
+		}
+		return switches == len(c.sentinels)
+	})
+
+	events, err := e.SentinelEvents("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var odown, promoted *dao.SentinelEvent
+	for _, ev := range events {
+		switch ev.Type {
+		case "+odown":
+			odown = ev
+		case "+promoted-slave":
+			promoted = ev
+		}
+	}
+	if len(events) != 6+len(c.sentinels) || odown == nil || promoted == nil {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if odown.Sentinel != c.sentinels[1].Addr() || odown.Addr != master.Addr() || odown.Details != "#quorum 2/2" {
+		t.Fatalf("unexpected event %+v", odown)
+	}
+	if promoted.Sentinel != c.sentinels[0].Addr() || promoted.Instance != "slave" || promoted.Addr != slave.Addr() {
+		t.Fatalf("unexpected event %+v", promoted)
+	}
+	if events[len(events)-1] != odown {
+		t.Fatalf("the events aren't the newest first %v", events)
+	}
+
+	if events, err := e.SentinelEvents("g2", 0); err != nil || len(events) != 0 {
+		t.Fatalf("events of another group %v, %v", events, err)
+	}
+	if events, err := e.SentinelEvents("g1", 2); err != nil || len(events) != 2 {
+		t.Fatalf("events beyond the limit %v, %v", events, err)
+	}
+
+	// the timeline is persisted in the coordinator.
+	if err := e.flushTimeline(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := e.client.Read(coordinate.SentinelEventsPath(testProduct), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored dao.SentinelEvents
+	if err := stored.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(events) || stored[0].Type != "+odown" {
+		t.Fatalf("unexpected stored events %v", stored)
+	}
+}
+
+func TestSentinelEventsBounded(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+
+	for i := 0; i < dao.MaxSentinelEvents+10; i++ {
+		group := "g1"
+		if i%2 == 1 {
+			group = "g2"
+		}
+		e.recordSentinelEvent(&redis.SentinelEvent{Type: "+sdown", Group: group, Instance: "master"})
+	}
+	if err := e.flushTimeline(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := e.sentinelEventMapper.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != dao.MaxSentinelEvents || stored[0].Group != "g1" {
+		t.Fatalf("%d events stored", len(stored))
+	}
+}
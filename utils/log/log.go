@@ -247,6 +247,8 @@ func (l *Logger) output(level int, callDepth int, s string) error {
 		}
 	}
 
+	s = Redact(s)
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.buf = l.buf[:0]
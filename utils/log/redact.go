@@ -0,0 +1,65 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets in the log lines and in the strings passed
+// to Redact.
+const Redacted = "******"
+
+var secrets struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// AddSecrets makes the values masked in every line of every logger from now
+// on, the empty ones are ignored.
+func AddSecrets(values ...string) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secrets.values == nil {
+		secrets.values = make(map[string]bool)
+	}
+	added := false
+	for _, v := range values {
+		if v != "" && !secrets.values[v] {
+			secrets.values[v] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// the longer secrets first, one which contains another is masked whole.
+	var sorted []string
+	for v := range secrets.values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	var pairs []string
+	for _, v := range sorted {
+		pairs = append(pairs, v, Redacted)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with the secrets masked.
+func Redact(s string) string {
+	secrets.mu.RLock()
+	r := secrets.replacer
+	secrets.mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
@@ -0,0 +1,21 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Linfo, 0)
+
+	AddSecrets("", "s3cret", "s3cret-longer")
+	l.Infof("auth %s then %s", "s3cret", "s3cret-longer")
+	if got, want := b.String(), "[INFO] auth ****** then ******\n"; !strings.HasSuffix(got, want) || strings.Contains(got, "s3cret") {
+		t.Fatalf("got %q", got)
+	}
+	if got := Redact("plain"); got != "plain" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,172 @@
+// Package tlsconfig builds the tls configs of the admin api and the fe. The
+// certificates and the client CAs are reloaded from their files once they
+// change, a renewed certificate is served without a restart.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pourer/pikamgr/utils/log"
+)
+
+// CheckInterval is how often the files are checked for a change, at a
+// handshake.
+var CheckInterval = 5 * time.Second
+
+// watch holds the value loaded from files, it's loaded again once the
+// modification time or the size of one of the files changes. A failed
+// reload keeps the last value.
+type watch struct {
+	files []string
+	load  func() (interface{}, error)
+
+	mutex     sync.Mutex
+	value     interface{}
+	stamp     string
+	checkedAt time.Time
+}
+
+func newWatch(load func() (interface{}, error), files ...string) (*watch, error) {
+	w := &watch{files: files, load: load}
+	stamp, err := w.stampFiles()
+	if err != nil {
+		return nil, err
+	}
+	if w.value, err = load(); err != nil {
+		return nil, err
+	}
+	w.stamp, w.checkedAt = stamp, time.Now()
+	return w, nil
+}
+
+func (w *watch) stampFiles() (string, error) {
+	var stamps []string
+	for _, file := range w.files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamps = append(stamps, fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()))
+	}
+	return strings.Join(stamps, ","), nil
+}
+
+func (w *watch) get() interface{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(w.checkedAt) >= CheckInterval {
+		w.checkedAt = now
+		if stamp, err := w.stampFiles(); err != nil {
+			log.Warnf("tlsconfig::watch stat files-%v fail. err-[%s]", w.files, err.Error())
+		} else if stamp != w.stamp {
+			if value, err := w.load(); err != nil {
+				log.Warnf("tlsconfig::watch reload files-%v fail, the last one is kept. err-[%s]", w.files, err.Error())
+			} else {
+				log.Infof("tlsconfig::watch reloaded files-%v", w.files)
+				w.value, w.stamp = value, stamp
+			}
+		}
+	}
+	return w.value
+}
+
+func watchKeyPair(certFile, keyFile string) (*watch, error) {
+	return newWatch(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+}
+
+func watchCertPool(caFile string) (*watch, error) {
+	return newWatch(func() (interface{}, error) {
+		return loadCertPool(caFile)
+	}, caFile)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// ServerConfig returns the config of a listener serving the key pair. With a
+// clientCAFile the clients must present a certificate signed by one of its
+// CAs. The key pair and the CAs are reloaded once their files change.
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("missing certificate or key file")
+	}
+	pair, err := watchKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return pair.get().(*tls.Certificate), nil
+		},
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	cas, err := watchCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = cas.get().(*x509.CertPool)
+		return c, nil
+	}
+	return config, nil
+}
+
+// ClientConfig returns the config of a client verifying the servers against
+// the CAs of caFile, the system ones if it's empty. The key pair, if any, is
+// presented to the servers which ask for a client certificate, it's reloaded
+// once its files change.
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile == "" && keyFile == "" {
+		return config, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("missing certificate or key file")
+	}
+	pair, err := watchKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return pair.get().(*tls.Certificate), nil
+	}
+	return config, nil
+}
@@ -0,0 +1,216 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+func (ca *testCA) writeCA(t *testing.T, file string) {
+	writePEM(t, file, "CERTIFICATE", ca.cert.Raw)
+}
+
+// issue writes a key pair signed by the ca, it returns the serial number.
+func (ca *testCA) issue(t *testing.T, certFile, keyFile string, usage x509.ExtKeyUsage) int64 {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", b)
+	writePEM(t, certFile, "CERTIFICATE", der)
+	return ca.serial
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve accepts tls connections until the listener is closed, every
+// connection is handshaked and closed.
+func serve(t *testing.T, config *tls.Config) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	return ln
+}
+
+// dial returns the serial number of the server certificate.
+func dial(addr string, config *tls.Config) (int64, error) {
+	c, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	// the server verifies the client certificate after our handshake is done,
+	// a rejection shows up on the first read.
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return c.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestServerConfigReload(t *testing.T) {
+	defer func(d time.Duration) { CheckInterval = d }(CheckInterval)
+	CheckInterval = 0
+
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	ca := newTestCA(t)
+	ca.writeCA(t, file("ca.pem"))
+	serial := ca.issue(t, file("server.pem"), file("server.key"), x509.ExtKeyUsageServerAuth)
+	ca.issue(t, file("client.pem"), file("client.key"), x509.ExtKeyUsageClientAuth)
+
+	server, err := ServerConfig(file("server.pem"), file("server.key"), file("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := serve(t, server)
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	client, err := ClientConfig(file("ca.pem"), file("client.pem"), file("client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dial(addr, client); err != nil || got != serial {
+		t.Fatalf("serial = %d, err = %v, want %d", got, err, serial)
+	}
+
+	// a client without a certificate is rejected.
+	anonymous, err := ClientConfig(file("ca.pem"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(addr, anonymous); err == nil {
+		t.Fatal("client without a certificate accepted")
+	}
+
+	// the server is verified against the given CAs only.
+	other := newTestCA(t)
+	other.writeCA(t, file("other.pem"))
+	untrusting, err := ClientConfig(file("other.pem"), file("client.pem"), file("client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(addr, untrusting); err == nil {
+		t.Fatal("server of an unknown CA accepted")
+	}
+
+	// a renewed certificate is served without a restart, a broken one isn't.
+	serial = ca.issue(t, file("server.pem"), file("server.key"), x509.ExtKeyUsageServerAuth)
+	if got, err := dial(addr, client); err != nil || got != serial {
+		t.Fatalf("serial = %d, err = %v, want %d", got, err, serial)
+	}
+	if err := ioutil.WriteFile(file("server.pem"), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dial(addr, client); err != nil || got != serial {
+		t.Fatalf("serial = %d, err = %v, want %d", got, err, serial)
+	}
+
+	// so are the client CAs, and the client certificate.
+	other.issue(t, file("client.pem"), file("client.key"), x509.ExtKeyUsageClientAuth)
+	if _, err := dial(addr, client); err == nil {
+		t.Fatal("client of an unknown CA accepted")
+	}
+	other.writeCA(t, file("ca.pem"))
+	if _, err := dial(addr, client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	if _, err := ServerConfig("", "", ""); err == nil {
+		t.Fatal("server config without a certificate")
+	}
+	if _, err := ServerConfig("/nonexistent/cert.pem", "/nonexistent/key.pem", ""); err == nil {
+		t.Fatal("server config of missing files")
+	}
+	if _, err := ClientConfig("", "cert.pem", ""); err == nil {
+		t.Fatal("client config without a key")
+	}
+	if _, err := ClientConfig("", "", ""); err != nil {
+		t.Fatal(err)
+	}
+}